@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"proxy-api-server/config"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat is the format of the time of a backup in its id, sortable as text.
+const timeFormat = "20060102T150405Z"
+
+// hashLength is the number of hex digits of the content hash kept in the id of a backup.
+const hashLength = 16
+
+var (
+	defaultStore     Store
+	defaultStoreOnce sync.Once
+)
+
+// Default returns the store described by the global configuration, or nil
+// when backups are not configured or the store cannot be initialized.
+func Default() Store {
+	defaultStoreOnce.Do(func() {
+		store, err := NewStore(config.Get().Backup)
+		if err != nil {
+			log.Errorf("Unable to initialize the backup store: %v", err)
+			return
+		}
+		defaultStore = store
+	})
+	return defaultStore
+}
+
+// NewID returns the id of a backup taken at a time of a content hash.
+func NewID(t time.Time, hash string) string {
+	return t.UTC().Format(timeFormat) + "-" + hash[:hashLength]
+}
+
+// ParseID returns the time and the content hash of the id of a backup, which
+// is <time>-<hash> with hashLength lowercase hex digits of hash.
+func ParseID(id string) (time.Time, string, bool) {
+	stamp, hash, ok := strings.Cut(id, "-")
+	if !ok || len(hash) != hashLength || strings.Trim(hash, "0123456789abcdef") != "" {
+		return time.Time{}, "", false
+	}
+	t, err := time.Parse(timeFormat, stamp)
+	return t, hash, err == nil
+}
+
+// DashboardPrefix returns the prefix of the keys of the backups of the
+// dashboards of an instance, or of one dashboard when uid is set.
+func DashboardPrefix(instance, uid string) string {
+	prefix := url.PathEscape(instance) + "/dashboards/"
+	if uid != "" {
+		prefix += url.PathEscape(uid) + "/"
+	}
+	return prefix
+}
+
+// DashboardKey returns the key of a backup of a dashboard.
+func DashboardKey(instance, uid, id string) string {
+	return DashboardPrefix(instance, uid) + id + ".json"
+}
+
+// FolderPrefix returns the prefix of the keys of the backups of the folders of an instance.
+func FolderPrefix(instance string) string {
+	return url.PathEscape(instance) + "/folders/"
+}
+
+// FolderKey returns the key of a backup of the folders of an instance.
+func FolderKey(instance, id string) string {
+	return FolderPrefix(instance) + id + ".json"
+}
+
+// Hash returns the content hash of a value encoded as JSON, whose objects
+// are encoded with sorted keys.
+func Hash(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// DashboardHash returns the content hash of a dashboard in a folder, leaving
+// out the id and version that change with every save.
+func DashboardHash(dashboard map[string]interface{}, folderUID string) (string, error) {
+	content := make(map[string]interface{}, len(dashboard))
+	for key, value := range dashboard {
+		if key != "id" && key != "version" {
+			content[key] = value
+		}
+	}
+	return Hash(map[string]interface{}{"dashboard": content, "folderUid": folderUID})
+}
+
+// Entries returns the backups of the keys, latest first.
+func Entries(keys []string) []*models.Backup {
+	entries := []*models.Backup{}
+	for _, key := range keys {
+		id := strings.TrimSuffix(path.Base(key), ".json")
+		created, hash, ok := ParseID(id)
+		if !ok {
+			continue
+		}
+		entries = append(entries, &models.Backup{ID: id, Created: created, Hash: hash})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+	return entries
+}
+
+// ListDashboards returns the backups of the dashboards of an instance by uid.
+func ListDashboards(ctx context.Context, store Store, instance string) ([]*models.DashboardBackups, error) {
+	prefix := DashboardPrefix(instance, "")
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	byUID := map[string][]string{}
+	uids := []string{}
+	for _, key := range keys {
+		escaped, _, ok := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		if !ok {
+			continue
+		}
+		uid, err := url.PathUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		if _, seen := byUID[uid]; !seen {
+			uids = append(uids, uid)
+		}
+		byUID[uid] = append(byUID[uid], key)
+	}
+	result := make([]*models.DashboardBackups, 0, len(uids))
+	for _, uid := range uids {
+		result = append(result, &models.DashboardBackups{UID: uid, Backups: Entries(byUID[uid])})
+	}
+	return result, nil
+}
+
+// Latest returns the latest backup under a prefix, nil when there is none.
+func Latest(ctx context.Context, store Store, prefix string) (*models.Backup, error) {
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	entries := Entries(keys)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return entries[0], nil
+}
+
+// Prune deletes the backups under a prefix beyond the retention, the oldest
+// ones, and returns how many were deleted. A retention of zero keeps all.
+func Prune(ctx context.Context, store Store, prefix string, retention int) (int, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	entries := Entries(keys)
+	pruned := 0
+	for _, entry := range entries[min(retention, len(entries)):] {
+		if err := store.Delete(ctx, prefix+entry.ID+".json"); err != nil {
+			return pruned, fmt.Errorf("unable to delete backup %s%s: %w", prefix, entry.ID, err)
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseID(t *testing.T) {
+	created := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		id   string
+		hash string
+		ok   bool
+	}{
+		{"20240131T120000Z-0123456789abcdef", "0123456789abcdef", true},
+		{NewID(created, "0123456789abcdef0123"), "0123456789abcdef", true},
+		{"20240131T120000Z-0123456789ABCDEF", "", false},
+		{"20240131T120000Z-0123456789abcde", "", false},
+		{"20240131T120000Z-0123456789abcdefa", "", false},
+		{"20240131T120000Z-../../../etc/pass", "", false},
+		{"20240131T120000Z", "", false},
+		{"latest-0123456789abcdef", "", false},
+		{"../20240131T120000Z-0123456789abcdef", "", false},
+	}
+	for _, tt := range tests {
+		got, hash, ok := ParseID(tt.id)
+		if ok != tt.ok || hash != tt.hash && tt.ok {
+			t.Errorf("ParseID(%q) = %s, %q, %t, want %q, %t", tt.id, got, hash, ok, tt.hash, tt.ok)
+		}
+		if ok && !got.Equal(created) {
+			t.Errorf("ParseID(%q) time = %s, want %s", tt.id, got, created)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"fmt"
+	"proxy-api-server/config"
+	"proxy-api-server/log"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduler runs the backups of the instances on their cron schedules.
+type Scheduler struct {
+	cron *cron.Cron
+}
+
+// NewScheduler schedules run for every instance with a backup schedule. A
+// run still going when the next one is due makes the next one skipped.
+func NewScheduler(instances []config.Instance, run func(instance string)) (*Scheduler, error) {
+	c := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger)))
+	for _, instance := range instances {
+		if instance.BackupSchedule == "" {
+			continue
+		}
+		name := instance.Name
+		if _, err := c.AddFunc(instance.BackupSchedule, func() { run(name) }); err != nil {
+			return nil, fmt.Errorf("invalid backup schedule of instance %q: %w", name, err)
+		}
+		log.Infof("Dashboards of instance %s are backed up on schedule %q", name, instance.BackupSchedule)
+	}
+	return &Scheduler{cron: c}, nil
+}
+
+// Start runs the scheduled backups in the background.
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops the scheduling of backups and waits for the running ones.
+func (s *Scheduler) Stop() {
+	<-s.cron.Stop().Done()
+}
@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"proxy-api-server/config"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a missing key.
+var ErrNotFound = errors.New("backup not found")
+
+// Store keeps the backups by key, slash separated paths such as
+// prod/dashboards/<uid>/<id>.json.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns the keys starting with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStore creates the store of the configuration, the S3 bucket when one
+// is configured, otherwise the directory. It returns nil when neither is.
+func NewStore(conf config.Backup) (Store, error) {
+	if conf.S3 != nil {
+		return newS3Store(*conf.S3)
+	}
+	if conf.Directory != "" {
+		if err := os.MkdirAll(conf.Directory, 0o755); err != nil {
+			return nil, err
+		}
+		return &dirStore{dir: conf.Directory}, nil
+	}
+	return nil, nil
+}
+
+// dirStore keeps one file per key in a directory.
+type dirStore struct {
+	dir string
+}
+
+// path returns the file of a key. Keys with . or .. segments are rejected,
+// so that no key resolves outside of the directory.
+func (s *dirStore) path(key string) (string, error) {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid backup key %q", key)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *dirStore) Put(ctx context.Context, key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *dirStore) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *dirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	// only the directory holding the prefix is walked
+	root, dir := path.Dir(prefix+"x"), s.dir
+	if root != "." {
+		var err error
+		if dir, err = s.path(root); err != nil {
+			return nil, err
+		}
+	}
+	keys := []string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (s *dirStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *dirStore {
+	t.Helper()
+	return &dirStore{dir: t.TempDir()}
+}
+
+// putBackups saves n dashboard backups one hour apart and returns their ids, oldest first.
+func putBackups(t *testing.T, store Store, instance, uid string, n int) []string {
+	t.Helper()
+	ids := []string{}
+	start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		id := NewID(start.Add(time.Duration(i)*time.Hour), "0123456789abcdef"[i:]+"0123456789abcdef"[:i])
+		if err := store.Put(context.Background(), DashboardKey(instance, uid, id), []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestDirStore(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	key := DashboardKey("prod", "abc", "20240131T120000Z-0123456789abcdef")
+	if err := store.Put(ctx, key, []byte(`{"uid":"abc"}`)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.Get(ctx, key)
+	if err != nil || string(data) != `{"uid":"abc"}` {
+		t.Fatalf("Get() = %s, %v", data, err)
+	}
+	if _, err := store.Get(ctx, DashboardKey("prod", "abc", "20240131T130000Z-0123456789abcdef")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing key error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete() of a missing key error = %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDirStoreList(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	a := putBackups(t, store, "prod", "a", 2)
+	b := putBackups(t, store, "prod", "ab", 1)
+	putBackups(t, store, "dev", "a", 1)
+	// leftovers of an interrupted Put are not backups
+	if err := os.WriteFile(filepath.Join(store.dir, "prod", "dashboards", "a", "x.json.tmp"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.List(ctx, DashboardPrefix("prod", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{DashboardKey("prod", "a", a[0]), DashboardKey("prod", "a", a[1])}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+
+	keys, err = store.List(ctx, DashboardPrefix("prod", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want = append(want, DashboardKey("prod", "ab", b[0])); !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+
+	if keys, err = store.List(ctx, "missing/"); err != nil || len(keys) != 0 {
+		t.Errorf("List() of a missing prefix = %v, %v, want none", keys, err)
+	}
+	if keys, err = store.List(ctx, ""); err != nil || len(keys) != 4 {
+		t.Errorf("List() of all keys = %v, %v, want 4 keys", keys, err)
+	}
+}
+
+func TestDirStoreRejectsTraversal(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	store := &dirStore{dir: filepath.Join(parent, "backups")}
+	for _, key := range []string{
+		"../outside.json",
+		DashboardKey("prod", "abc", "../../../../outside"),
+		DashboardKey("prod", "..", "20240131T120000Z-0123456789abcdef"),
+		"prod/./dashboards/x.json",
+	} {
+		if err := store.Put(ctx, key, []byte(`{}`)); err == nil {
+			t.Errorf("Put(%q) succeeded, want an error", key)
+		}
+		if _, err := store.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) error = %v, want an invalid key", key, err)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded, want an error", key)
+		}
+	}
+	if _, err := store.List(ctx, "../"); err == nil {
+		t.Error("List(../) succeeded, want an error")
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 0 {
+		t.Errorf("files were written outside of the store: %v", entries)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		retention int
+		pruned    int
+	}{
+		{retention: 0, pruned: 0},
+		{retention: 2, pruned: 3},
+		{retention: 5, pruned: 0},
+		{retention: 10, pruned: 0},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		store := newTestStore(t)
+		ids := putBackups(t, store, "prod", "a", 5)
+		other := putBackups(t, store, "prod", "b", 3)
+		prefix := DashboardPrefix("prod", "a")
+
+		pruned, err := Prune(ctx, store, prefix, tt.retention)
+		if err != nil || pruned != tt.pruned {
+			t.Errorf("Prune(%d) = %d, %v, want %d", tt.retention, pruned, err, tt.pruned)
+		}
+		// the latest backups are kept
+		latest, err := Latest(ctx, store, prefix)
+		if err != nil || latest == nil || latest.ID != ids[len(ids)-1] {
+			t.Errorf("Latest() after Prune(%d) = %v, %v, want %s", tt.retention, latest, err, ids[len(ids)-1])
+		}
+		keys, _ := store.List(ctx, prefix)
+		if want := len(ids) - tt.pruned; len(keys) != want {
+			t.Errorf("Prune(%d) kept %d backups, want %d", tt.retention, len(keys), want)
+		}
+		// the backups of other dashboards are untouched
+		if keys, _ := store.List(ctx, DashboardPrefix("prod", "b")); len(keys) != len(other) {
+			t.Errorf("Prune(%d) deleted backups of another dashboard", tt.retention)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatVersion is the version of the bundle format in the manifest
+	FormatVersion = 1
+	// ManifestFile is the path of the manifest in a bundle
+	ManifestFile = "manifest.json"
+
+	FormatZip = "zip"
+	// FormatTar is a gzipped tar
+	FormatTar = "tar"
+)
+
+const (
+	// maxFileSize bounds the size of a file read from a bundle
+	maxFileSize = 32 << 20
+	// maxTotalSize bounds the size of all the files read from a bundle, once decompressed
+	maxTotalSize = 256 << 20
+	// maxEntries bounds the number of entries of a bundle, directories and skipped files included
+	maxEntries = 10000
+)
+
+// File is a file of a bundle.
+type File struct {
+	Path string
+	Data []byte
+}
+
+// ContentType returns the media type and file extension of a bundle format.
+func ContentType(format string) (string, string) {
+	if format == FormatTar {
+		return "application/gzip", ".tar.gz"
+	}
+	return "application/zip", ".zip"
+}
+
+// Write writes the files as a bundle of the format, zip or tar.
+func Write(w io.Writer, format string, files []File) error {
+	switch format {
+	case FormatZip, "":
+		return writeZip(w, files)
+	case FormatTar:
+		return writeTar(w, files)
+	}
+	return fmt.Errorf("unknown bundle format %q, must be zip or tar", format)
+}
+
+func writeZip(w io.Writer, files []File) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Path, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTar(w io.Writer, files []File) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		header := &tar.Header{Name: f.Path, Mode: 0644, Size: int64(len(f.Data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Read returns the files of a bundle, a zip or a gzipped tar told apart by
+// their content. Directories and paths leaving the bundle are skipped.
+func Read(data []byte) ([]File, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return readZip(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return readTar(data)
+	}
+	return nil, fmt.Errorf("not a zip or gzipped tar bundle")
+}
+
+func readZip(data []byte) ([]File, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) > maxEntries {
+		return nil, fmt.Errorf("bundle has more than %d entries", maxEntries)
+	}
+	files := []File{}
+	budget := &readBudget{remaining: maxTotalSize}
+	for _, zf := range zr.File {
+		name, ok := cleanPath(zf.Name)
+		if !ok || zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := budget.read(rc, name)
+		_ = rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Path: name, Data: content})
+	}
+	return files, nil
+}
+
+func readTar(data []byte) ([]File, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gr)
+	files := []File{}
+	budget := &readBudget{remaining: maxTotalSize}
+	for entries := 0; ; entries++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entries == maxEntries {
+			return nil, fmt.Errorf("bundle has more than %d entries", maxEntries)
+		}
+		name, ok := cleanPath(header.Name)
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := budget.read(tr, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Path: name, Data: content})
+	}
+}
+
+// readBudget bounds the size of the files read from a bundle, so that a
+// small archive cannot decompress to more than the memory it is allowed.
+type readBudget struct {
+	remaining int64
+}
+
+func (b *readBudget) read(r io.Reader, name string) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxFileSize {
+		return nil, fmt.Errorf("file %s of the bundle is larger than %d bytes", name, maxFileSize)
+	}
+	if b.remaining -= int64(len(content)); b.remaining < 0 {
+		return nil, fmt.Errorf("files of the bundle are larger than %d bytes", maxTotalSize)
+	}
+	return content, nil
+}
+
+func cleanPath(name string) (string, bool) {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if name == "." || path.IsAbs(name) || strings.HasPrefix(name, "../") || name == ".." {
+		return "", false
+	}
+	return name, true
+}
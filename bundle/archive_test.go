@@ -0,0 +1,174 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	files := []File{
+		{Path: ManifestFile, Data: []byte(`{"version":1}`)},
+		{Path: "general/cpu.json", Data: []byte(`{"uid":"cpu"}`)},
+		{Path: "team a/nested/mem.json", Data: []byte(`{"uid":"mem"}`)},
+		{Path: "empty.json", Data: []byte{}},
+	}
+	for _, format := range []string{FormatZip, FormatTar, ""} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, format, files); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Read(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, files) {
+				t.Errorf("Read(Write()) = %q, want %q", got, files)
+			}
+		})
+	}
+	if err := Write(&bytes.Buffer{}, "rar", files); err == nil {
+		t.Error("Write() of an unknown format succeeded, want an error")
+	}
+	if _, err := Read([]byte(`{"uid":"cpu"}`)); err == nil {
+		t.Error("Read() of a JSON file succeeded, want an error")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{name: "a.json", want: "a.json", ok: true},
+		{name: "./a/b.json", want: "a/b.json", ok: true},
+		{name: "a//b/../c.json", want: "a/c.json", ok: true},
+		{name: "a/../../b.json"},
+		{name: "../b.json"},
+		{name: ".."},
+		{name: "/etc/passwd"},
+		{name: "."},
+		{name: ""},
+	}
+	for _, tt := range tests {
+		if got, ok := cleanPath(tt.name); got != tt.want || ok != tt.ok {
+			t.Errorf("cleanPath(%q) = %q, %t, want %q, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// entry is an entry of an archive built by the tests, a directory when its name ends with a slash.
+type entry struct {
+	name    string
+	data    string
+	symlink bool
+}
+
+func zipOf(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(e.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		switch {
+		case e.symlink:
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.data, 0
+		case strings.HasSuffix(e.name, "/"):
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			_, _ = tw.Write([]byte(e.data))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadSkipsUnsafeEntries(t *testing.T) {
+	entries := []entry{
+		{name: "general/"},
+		{name: "./general/cpu.json", data: "cpu"},
+		{name: "../evil.json", data: "evil"},
+		{name: "general/../../evil.json", data: "evil"},
+		{name: "/etc/evil.json", data: "evil"},
+	}
+	want := []File{{Path: "general/cpu.json", Data: []byte("cpu")}}
+	for format, data := range map[string][]byte{
+		"zip": zipOf(t, entries),
+		"tar": tarOf(t, append(entries, entry{name: "general/link.json", data: "/etc/passwd", symlink: true})),
+	} {
+		got, err := Read(data)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Read() = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestReadLimits(t *testing.T) {
+	many := make([]entry, maxEntries+1)
+	for i := range many {
+		many[i] = entry{name: fmt.Sprintf("d/%d/", i)}
+	}
+	large := []entry{{name: "large.json", data: strings.Repeat(" ", maxFileSize+1)}}
+	tests := []struct {
+		name    string
+		entries []entry
+		wantErr string
+	}{
+		{name: "entries", entries: many, wantErr: "more than 10000 entries"},
+		{name: "file size", entries: large, wantErr: "larger than 33554432 bytes"},
+	}
+	for _, tt := range tests {
+		for format, data := range map[string][]byte{"zip": zipOf(t, tt.entries), "tar": tarOf(t, tt.entries)} {
+			if _, err := Read(data); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: Read() of a %s bundle error = %v, want %q", tt.name, format, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestReadBudget(t *testing.T) {
+	budget := &readBudget{remaining: 10}
+	for i, want := range []bool{true, true, false} {
+		_, err := budget.read(strings.NewReader("1234"), "f")
+		if ok := err == nil; ok != want {
+			t.Errorf("read %d of 4 bytes with a budget of 10: error = %v, want success %t", i, err, want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package bundle
+
+import (
+	"fmt"
+	"proxy-api-server/helpers"
+	"proxy-api-server/models"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/grafana-tools/sdk"
+)
+
+// InputName returns the name of the input of a datasource, as Grafana names
+// them: DS_ and the upper-case name of the datasource.
+func InputName(datasourceName string) string {
+	var b strings.Builder
+	b.WriteString("DS_")
+	for _, r := range strings.ToUpper(datasourceName) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// Externalize replaces the datasource references of a dashboard JSON model
+// with ${DS_*} inputs, the way Grafana exports dashboards for sharing, and
+// lists them in its __inputs. References to unknown datasources are kept.
+// It returns the inputs sorted by name.
+func Externalize(dashboard map[string]interface{}, datasources []sdk.Datasource) []*models.BundleInput {
+	inputs := map[string]*models.BundleInput{}
+	names := map[string]string{}
+	helpers.ReplaceDatasourceRefs(dashboard, func(ref interface{}) interface{} {
+		id, ok := helpers.DatasourceRef(ref)
+		if !ok {
+			return ref
+		}
+		ds, ok := FindDatasource(datasources, id)
+		if !ok {
+			return ref
+		}
+		name, ok := names[ds.UID]
+		if !ok {
+			name = InputName(ds.Name)
+			// distinct datasources may have the same input name, such as prom-1 and prom 1
+			for i := 2; inputs[name] != nil; i++ {
+				name = fmt.Sprintf("%s_%d", InputName(ds.Name), i)
+			}
+			names[ds.UID] = name
+			inputs[name] = &models.BundleInput{Name: name, Label: ds.Name, Type: "datasource", PluginID: ds.Type}
+		}
+		if _, byName := ref.(string); byName {
+			return "${" + name + "}"
+		}
+		return map[string]interface{}{"type": ds.Type, "uid": "${" + name + "}"}
+	})
+
+	if templating, ok := dashboard["templating"].(map[string]interface{}); ok {
+		list, _ := templating["list"].([]interface{})
+		for _, item := range list {
+			// datasource variables select a datasource of the instance, the importing one picks its own
+			if variable, ok := item.(map[string]interface{}); ok && variable["type"] == "datasource" {
+				variable["current"] = map[string]interface{}{}
+			}
+		}
+	}
+
+	list := make([]*models.BundleInput, 0, len(inputs))
+	requires := []interface{}{}
+	plugins := map[string]bool{}
+	for _, input := range inputs {
+		list = append(list, input)
+		if !plugins[input.PluginID] {
+			plugins[input.PluginID] = true
+			requires = append(requires, map[string]interface{}{"type": "datasource", "id": input.PluginID, "name": input.PluginID})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	dashboard["__inputs"] = list
+	dashboard["__requires"] = requires
+	// the id is the one of the exporting instance
+	dashboard["id"] = nil
+	return list
+}
+
+// ResolveInputs maps the inputs of a bundle to datasources of an instance:
+// to the datasource named or identified by the uid of mapping, otherwise to
+// the datasource of the same name, otherwise to the default or only
+// datasource of the plugin. Inputs that cannot be mapped are left out.
+func ResolveInputs(inputs []*models.BundleInput, datasources []sdk.Datasource, mapping map[string]string) (map[string]sdk.Datasource, error) {
+	known := map[string]bool{}
+	for _, input := range inputs {
+		known[input.Name] = true
+	}
+	for name := range mapping {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown input %s", name)
+		}
+	}
+	resolved := map[string]sdk.Datasource{}
+	for _, input := range inputs {
+		if target, ok := mapping[input.Name]; ok {
+			ds, ok := FindDatasource(datasources, target)
+			if !ok {
+				return nil, fmt.Errorf("datasource %q of input %s not found", target, input.Name)
+			}
+			resolved[input.Name] = ds
+			continue
+		}
+		if ds, ok := FindDatasource(datasources, input.Label); ok && ds.Type == input.PluginID {
+			resolved[input.Name] = ds
+			continue
+		}
+		var candidates []sdk.Datasource
+		for _, ds := range datasources {
+			if ds.Type == input.PluginID {
+				candidates = append(candidates, ds)
+			}
+		}
+		for _, ds := range candidates {
+			if ds.IsDefault || len(candidates) == 1 {
+				resolved[input.Name] = ds
+				break
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// Internalize replaces the ${DS_*} inputs of a dashboard JSON model with the
+// datasources they are mapped to, and removes its __inputs and __requires.
+// Every input of the dashboard must be mapped.
+func Internalize(dashboard map[string]interface{}, mapping map[string]sdk.Datasource) error {
+	for _, name := range Inputs(dashboard) {
+		if _, ok := mapping[name]; !ok {
+			return fmt.Errorf("input %s is not mapped to a datasource", name)
+		}
+	}
+	helpers.ReplaceDatasourceRefs(dashboard, func(ref interface{}) interface{} {
+		switch r := ref.(type) {
+		case string:
+			if ds, ok := mapping[inputOf(r)]; ok {
+				return ds.Name
+			}
+		case map[string]interface{}:
+			uid, _ := r["uid"].(string)
+			if ds, ok := mapping[inputOf(uid)]; ok {
+				return map[string]interface{}{"type": ds.Type, "uid": ds.UID}
+			}
+		}
+		return ref
+	})
+	delete(dashboard, "__inputs")
+	delete(dashboard, "__requires")
+	// inputs may also be used outside of datasource references, such as in queries
+	for key, value := range dashboard {
+		dashboard[key] = replaceInputs(value, mapping)
+	}
+	return nil
+}
+
+// Inputs returns the names of the datasource inputs listed in the __inputs of a dashboard JSON model.
+func Inputs(dashboard map[string]interface{}) []string {
+	names := []string{}
+	switch inputs := dashboard["__inputs"].(type) {
+	case []interface{}:
+		for _, item := range inputs {
+			if input, ok := item.(map[string]interface{}); ok && input["type"] == "datasource" {
+				if name, ok := input["name"].(string); ok {
+					names = append(names, name)
+				}
+			}
+		}
+	case []*models.BundleInput:
+		for _, input := range inputs {
+			names = append(names, input.Name)
+		}
+	}
+	return names
+}
+
+func replaceInputs(value interface{}, mapping map[string]sdk.Datasource) interface{} {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "${DS_") {
+			return v
+		}
+		for name, ds := range mapping {
+			v = strings.ReplaceAll(v, "${"+name+"}", ds.Name)
+		}
+		return v
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = replaceInputs(item, mapping)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = replaceInputs(item, mapping)
+		}
+	}
+	return value
+}
+
+func inputOf(ref string) string {
+	if strings.HasPrefix(ref, "${") && strings.HasSuffix(ref, "}") {
+		return ref[2 : len(ref)-1]
+	}
+	return ""
+}
+
+// FindDatasource returns the datasource of a uid or name.
+func FindDatasource(datasources []sdk.Datasource, id string) (sdk.Datasource, bool) {
+	for _, ds := range datasources {
+		if ds.UID == id {
+			return ds, true
+		}
+	}
+	for _, ds := range datasources {
+		if ds.Name == id {
+			return ds, true
+		}
+	}
+	return sdk.Datasource{}, false
+}
@@ -0,0 +1,188 @@
+package bundle
+
+import (
+	"encoding/json"
+	"proxy-api-server/models"
+	"reflect"
+	"testing"
+
+	"github.com/grafana-tools/sdk"
+)
+
+var testDatasources = []sdk.Datasource{
+	{UID: "prom1", Name: "prom-1", Type: "prometheus", IsDefault: true},
+	{UID: "prom1b", Name: "prom 1", Type: "prometheus"},
+	{UID: "loki", Name: "Loki", Type: "loki"},
+}
+
+func decodeDashboard(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	dashboard := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(data), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	return dashboard
+}
+
+// dashboardJSON returns the JSON of a dashboard without its __inputs and __requires.
+func dashboardJSON(t *testing.T, dashboard map[string]interface{}) string {
+	t.Helper()
+	copied := map[string]interface{}{}
+	for k, v := range dashboard {
+		if k != "__inputs" && k != "__requires" {
+			copied[k] = v
+		}
+	}
+	data, err := json.Marshal(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestInputName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Prometheus": "DS_PROMETHEUS",
+		"prom-1":     "DS_PROM_1",
+		"Loki (EU)":  "DS_LOKI__EU_",
+		"données":    "DS_DONNÉES",
+	} {
+		if got := InputName(name); got != want {
+			t.Errorf("InputName(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+const exportedDashboard = `{"id":7,"uid":"d","panels":[
+	{"datasource":{"type":"prometheus","uid":"prom1"},"targets":[{"datasource":{"type":"prometheus","uid":"prom1"},"expr":"up"}]},
+	{"datasource":"prom 1","targets":[{"expr":"up"}]},
+	{"datasource":{"type":"loki","uid":"unknown"}},
+	{"datasource":{"type":"prometheus","uid":"$ds"}}
+],"templating":{"list":[
+	{"type":"datasource","name":"ds","query":"prometheus","current":{"text":"prom-1","value":"prom1"}},
+	{"type":"query","name":"job","datasource":{"type":"loki","uid":"loki"}}
+]}}`
+
+func TestExternalize(t *testing.T) {
+	dashboard := decodeDashboard(t, exportedDashboard)
+	inputs := Externalize(dashboard, testDatasources)
+
+	wantInputs := []*models.BundleInput{
+		{Name: "DS_LOKI", Label: "Loki", Type: "datasource", PluginID: "loki"},
+		{Name: "DS_PROM_1", Label: "prom-1", Type: "datasource", PluginID: "prometheus"},
+		{Name: "DS_PROM_1_2", Label: "prom 1", Type: "datasource", PluginID: "prometheus"},
+	}
+	if !reflect.DeepEqual(inputs, wantInputs) {
+		t.Errorf("Externalize() inputs = %+v, want %+v", inputs, wantInputs)
+	}
+	// references to unknown datasources and datasource variables are kept, the current datasource is cleared
+	want := `{"id":null,"panels":[` +
+		`{"datasource":{"type":"prometheus","uid":"${DS_PROM_1}"},"targets":[{"datasource":{"type":"prometheus","uid":"${DS_PROM_1}"},"expr":"up"}]},` +
+		`{"datasource":"${DS_PROM_1_2}","targets":[{"expr":"up"}]},` +
+		`{"datasource":{"type":"loki","uid":"unknown"}},` +
+		`{"datasource":{"type":"prometheus","uid":"$ds"}}` +
+		`],"templating":{"list":[` +
+		`{"current":{},"name":"ds","query":"prometheus","type":"datasource"},` +
+		`{"datasource":{"type":"loki","uid":"${DS_LOKI}"},"name":"job","type":"query"}` +
+		`]},"uid":"d"}`
+	if got := dashboardJSON(t, dashboard); got != want {
+		t.Errorf("Externalize() =\n%s\nwant\n%s", got, want)
+	}
+	if got := Inputs(dashboard); !reflect.DeepEqual(got, []string{"DS_LOKI", "DS_PROM_1", "DS_PROM_1_2"}) {
+		t.Errorf("Inputs() = %v", got)
+	}
+	requires, _ := dashboard["__requires"].([]interface{})
+	if len(requires) != 2 {
+		t.Errorf("__requires = %v, want the loki and prometheus plugins", requires)
+	}
+}
+
+func TestResolveInputs(t *testing.T) {
+	inputs := []*models.BundleInput{
+		{Name: "DS_MIMIR", Label: "Mimir", PluginID: "prometheus"},
+		{Name: "DS_LOKI", Label: "Loki", PluginID: "loki"},
+		{Name: "DS_TEMPO", Label: "Tempo", PluginID: "tempo"},
+	}
+	tests := []struct {
+		name    string
+		mapping map[string]string
+		// want are the uids of the datasources of the inputs
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "same name, default and missing plugin",
+			want: map[string]string{"DS_MIMIR": "prom1", "DS_LOKI": "loki"},
+		},
+		{
+			name:    "mapped by name and uid",
+			mapping: map[string]string{"DS_MIMIR": "prom 1", "DS_LOKI": "loki"},
+			want:    map[string]string{"DS_MIMIR": "prom1b", "DS_LOKI": "loki"},
+		},
+		{name: "unknown input", mapping: map[string]string{"DS_OTHER": "loki"}, wantErr: true},
+		{name: "unknown datasource", mapping: map[string]string{"DS_MIMIR": "mimir"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := ResolveInputs(inputs, testDatasources, tt.mapping)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ResolveInputs() = %v, want an error", resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for name, ds := range resolved {
+				got[name] = ds.UID
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveInputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInternalize(t *testing.T) {
+	dashboard := decodeDashboard(t, `{"__inputs":[{"name":"DS_PROM","type":"datasource","pluginId":"prometheus"}],"__requires":[],
+		"panels":[{"datasource":{"type":"prometheus","uid":"${DS_PROM}"},"targets":[{"expr":"up","legendFormat":"${DS_PROM} ${job}"}]},
+		{"datasource":"${DS_PROM}"},{"datasource":{"type":"prometheus","uid":"$ds"}}]}`)
+	mapping := map[string]sdk.Datasource{"DS_PROM": {UID: "mimir", Name: "Mimir", Type: "prometheus"}}
+	if err := Internalize(dashboard, mapping); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"panels":[{"datasource":{"type":"prometheus","uid":"mimir"},"targets":[{"expr":"up","legendFormat":"Mimir ${job}"}]},` +
+		`{"datasource":"Mimir"},{"datasource":{"type":"prometheus","uid":"$ds"}}]}`
+	if got := dashboardJSON(t, dashboard); got != want {
+		t.Errorf("Internalize() =\n%s\nwant\n%s", got, want)
+	}
+	if _, ok := dashboard["__inputs"]; ok {
+		t.Error("Internalize() kept __inputs")
+	}
+
+	unmapped := decodeDashboard(t, `{"__inputs":[{"name":"DS_PROM","type":"datasource"}]}`)
+	if err := Internalize(unmapped, map[string]sdk.Datasource{}); err == nil {
+		t.Error("Internalize() of an unmapped input succeeded, want an error")
+	}
+}
+
+func TestExternalizeInternalize(t *testing.T) {
+	dashboard := decodeDashboard(t, exportedDashboard)
+	inputs := Externalize(dashboard, testDatasources)
+	resolved, err := ResolveInputs(inputs, testDatasources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Internalize(dashboard, resolved); err != nil {
+		t.Fatal(err)
+	}
+	// the references come back to the same datasources, by name for the ones by name
+	want := decodeDashboard(t, exportedDashboard)
+	want["id"] = nil
+	want["templating"].(map[string]interface{})["list"].([]interface{})[0].(map[string]interface{})["current"] = map[string]interface{}{}
+	if got, want := dashboardJSON(t, dashboard), dashboardJSON(t, want); got != want {
+		t.Errorf("Internalize(Externalize()) =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"proxy-api-server/config"
+	"proxy-api-server/handlers"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"strings"
+)
+
+// Run runs the command given after the flags of the server against the
+// instances of the configuration, such as
+//
+//	export -instance prod -folder app -format tar -o app.tar.gz
+//	import -instance staging -input DS_PROMETHEUS=Mimir app.tar.gz
+func Run(args []string) error {
+	switch args[0] {
+	case "export":
+		return runExport(args[1:])
+	case "import":
+		return runImport(args[1:])
+	}
+	return fmt.Errorf("unknown command %q, must be export or import", args[0])
+}
+
+// listFlag is a flag that can be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	instanceName := fs.String("instance", "", "Name of the instance to export from")
+	format := fs.String("format", "zip", "Format of the bundle, zip or tar for a gzipped tar")
+	out := fs.String("o", "", "Path of the bundle, <instance>-dashboards.zip or .tar.gz by default")
+	var dashboards, folders listFlag
+	fs.Var(&dashboards, "dashboard", "Uid of a dashboard to export, can be repeated")
+	fs.Var(&folders, "folder", "Uid of a folder to export with its subfolders, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	instance, err := lookupInstance(*instanceName)
+	if err != nil {
+		return err
+	}
+	req := &models.BundleExportRequest{DashboardUIDs: dashboards, FolderUIDs: folders, Format: *format}
+	data, err := handlers.ExportBundle(util.NewGrafanaClient(), context.Background(), instance.URL, instance.APIKey, instance.Name, req)
+	if err != nil {
+		return err
+	}
+	path := *out
+	if path == "" {
+		path = handlers.ExportFileName(instance.Name, *format)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	fmt.Println(path)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	instanceName := fs.String("instance", "", "Name of the instance to import to")
+	overwrite := fs.Bool("overwrite", false, "Replace the dashboards of the same uid or title")
+	var inputs listFlag
+	fs.Var(&inputs, "input", "Datasource of an input of the bundle as NAME=datasource, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import takes the path of a bundle")
+	}
+	instance, err := lookupInstance(*instanceName)
+	if err != nil {
+		return err
+	}
+	mapping, err := handlers.InputMapping(inputs)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	result, err := handlers.ImportBundle(util.NewGrafanaClient(), context.Background(), instance.URL, instance.APIKey, data, mapping, *overwrite)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(os.Stdout, result); err != nil {
+		return err
+	}
+	failed := 0
+	for _, d := range result.Dashboards {
+		if d.Status != "imported" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dashboards not imported", failed, len(result.Dashboards))
+	}
+	return nil
+}
+
+func lookupInstance(name string) (*config.Instance, error) {
+	if name == "" {
+		return nil, fmt.Errorf("-instance is required")
+	}
+	instance, ok := config.Get().GetInstance(name)
+	if !ok {
+		return nil, fmt.Errorf("instance %q not found in the configuration", name)
+	}
+	resolved := *instance
+	resolved.URL = strings.TrimSuffix(resolved.URL, "/")
+	return &resolved, nil
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
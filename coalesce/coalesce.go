@@ -0,0 +1,161 @@
+package coalesce
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"proxy-api-server/internalmetrics"
+	"proxy-api-server/log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+type call struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	val     interface{}
+	err     error
+}
+
+// Group coalesces identical concurrent calls: while a call for a key is in
+// flight, callers asking for the same key wait for it and share its result.
+// Results are not cached once the call returns.
+//
+// The call runs detached from the caller that started it, so that its
+// cancellation does not fail the other callers. It is cancelled once no
+// caller waits for it anymore, or when the timeout of the group expires.
+type Group struct {
+	operation string
+	timeout   func() time.Duration
+	mu        sync.Mutex
+	calls     map[string]*call
+}
+
+// NewGroup creates a group. The operation name labels the group's metrics.
+// timeout, when not nil, bounds every call of the group.
+func NewGroup(operation string, timeout func() time.Duration) *Group {
+	return &Group{
+		operation: operation,
+		timeout:   timeout,
+		calls:     map[string]*call{},
+	}
+}
+
+// Do runs fn once for all concurrent callers with the same key. The metrics
+// are labelled with the route template stored in ctx by WithRoute. A caller
+// stops waiting when its ctx is done. The returned bool reports whether the
+// result was shared with another caller.
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error, bool) {
+	route := RouteFromContext(ctx)
+	g.mu.Lock()
+	c, shared := g.calls[key]
+	if shared {
+		c.waiters++
+		g.mu.Unlock()
+		internalmetrics.GetCoalescedRequestsMetric(g.operation, route).Inc()
+	} else {
+		var callCtx context.Context
+		callCtx, c = g.newCall(ctx)
+		g.calls[key] = c
+		g.mu.Unlock()
+		internalmetrics.GetCoalesceUpstreamCallsMetric(g.operation, route).Inc()
+		go g.run(callCtx, key, c, fn)
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody waits for the result anymore, the next caller starts a new call
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err(), shared
+	}
+}
+
+// newCall returns a call and its context, holding the values of ctx but not
+// its deadline nor cancellation.
+func (g *Group) newCall(ctx context.Context) (context.Context, *call) {
+	var timeout time.Duration
+	if g.timeout != nil {
+		timeout = g.timeout()
+	}
+	callCtx, cancel := context.WithCancel(detached{ctx})
+	if timeout > 0 {
+		callCtx, cancel = context.WithTimeout(detached{ctx}, timeout)
+	}
+	return callCtx, &call{done: make(chan struct{}), cancel: cancel, waiters: 1}
+}
+
+func (g *Group) run(ctx context.Context, key string, c *call, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		// a panic is the error of every caller instead of crashing the server
+		if r := recover(); r != nil {
+			log.Errorf("coalesced %s call panicked: %v\n%s", g.operation, r, debug.Stack())
+			c.val, c.err = nil, fmt.Errorf("%s call failed: %v", g.operation, r)
+		}
+		c.cancel()
+		g.mu.Lock()
+		g.forget(key, c)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn(ctx)
+}
+
+// forget removes the call of key, unless it was already replaced by another one.
+func (g *Group) forget(key string, c *call) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
+
+// detached is a context with the values of its parent, never cancelled.
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+type routeKey struct{}
+
+// WithRoute returns a context carrying the route template of the request, such
+// as /api/v1/instances/{name}/query, which labels the metrics of its calls.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route template stored by WithRoute.
+func RouteFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
+
+// Key builds a coalescing key from its parts.
+func Key(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+// CredentialIdentity returns a stable identity for a credential without keeping the credential itself.
+func CredentialIdentity(credential string) string {
+	if credential == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(h[:8])
+}
@@ -0,0 +1,152 @@
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoSharesResult(t *testing.T) {
+	g := NewGroup("test", nil)
+	release := make(chan struct{})
+	var calls int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	results := make(chan interface{}, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			v, _, _ := g.Do(context.Background(), "key", fn)
+			results <- v
+		}()
+	}
+	waitForWaiters(t, g, "key", 3)
+	close(release)
+	for i := 0; i < 3; i++ {
+		if v := <-results; v != "value" {
+			t.Errorf("Do() = %v, want value", v)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestDoLeaderCancelled(t *testing.T) {
+	g := NewGroup("test", nil)
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-release:
+			return "value", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do(leaderCtx, "key", fn)
+		leader <- err
+	}()
+	waitForWaiters(t, g, "key", 1)
+	follower := make(chan interface{}, 1)
+	go func() {
+		v, _, _ := g.Do(context.Background(), "key", fn)
+		follower <- v
+	}()
+	waitForWaiters(t, g, "key", 2)
+
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if v := <-follower; v != "value" {
+		t.Errorf("follower result = %v, want value", v)
+	}
+}
+
+func TestDoAbandonedCallCancelled(t *testing.T) {
+	g := NewGroup("test", nil)
+	cancelled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		g.Do(ctx, "key", func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		})
+	}()
+	waitForWaiters(t, g, "key", 1)
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the call was not cancelled once nobody waited for it")
+	}
+}
+
+func TestDoTimeout(t *testing.T) {
+	g := NewGroup("test", func() time.Duration { return 10 * time.Millisecond })
+	_, err, _ := g.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	g := NewGroup("test", nil)
+	v, err, _ := g.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		panic("boom")
+	})
+	if v != nil || err == nil {
+		t.Errorf("Do() = %v, %v, want an error", v, err)
+	}
+	// the key is usable again
+	v, err, _ = g.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		return "value", nil
+	})
+	if v != "value" || err != nil {
+		t.Errorf("Do() after a panic = %v, %v, want value", v, err)
+	}
+}
+
+func TestDetachedKeepsValues(t *testing.T) {
+	g := NewGroup("test", nil)
+	ctx, cancel := context.WithTimeout(WithRoute(context.Background(), "/api/v1/query"), time.Minute)
+	defer cancel()
+	g.Do(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("the call has the deadline of its caller")
+		}
+		if route := RouteFromContext(ctx); route != "/api/v1/query" {
+			t.Errorf("RouteFromContext() = %q, want /api/v1/query", route)
+		}
+		return nil, nil
+	})
+}
+
+// waitForWaiters waits until n callers wait for the call of key.
+func waitForWaiters(t *testing.T, g *Group, key string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		waiting := ok && c.waiters == n
+		g.mu.Unlock()
+		if waiting {
+			return
+		}
+	}
+	t.Fatalf("%d callers are not waiting for %s", n, key)
+}
@@ -1,11 +1,15 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
+	"net"
 	"proxy-api-server/log"
+	"proxy-api-server/promql"
 	"sync"
+	"time"
 )
 
 // Global configuration for the application.
@@ -26,10 +30,241 @@ type Server struct {
 	WebHistoryMode             string `yaml:"web_history_mode,omitempty"`
 	WebSchema                  string `yaml:"web_schema,omitempty"`
 	WhiteListUrls              string `yaml:"white_list_urls,omitempty"`
+	LegacySunset               string `yaml:"legacy_sunset,omitempty"` // Date, such as 2027-06-30, after which the unversioned routes are retired
+	// TrustedProxies are the addresses, or CIDR ranges, of the authenticating
+	// proxies whose caller role and tenant headers are trusted. The headers
+	// of any other client are ignored.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+}
+
+// IsTrustedProxy reports whether a remote address, host and port as in
+// http.Request.RemoteAddr, is one of the trusted proxies.
+func (s *Server) IsTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range s.TrustedProxies {
+		if network, err := parseProxy(proxy); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxy parses a trusted proxy, a single address being a network of its own.
+func parseProxy(proxy string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(proxy); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid trusted proxy %q, expected an address or a CIDR range", proxy)
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// QueryLimits bounds the cost of a single query. Zero values disable the corresponding limit.
+type QueryLimits struct {
+	MaxRange           time.Duration `yaml:"max_range,omitempty"`
+	MaxPoints          int64         `yaml:"max_points,omitempty"`
+	MinStep            time.Duration `yaml:"min_step,omitempty"`
+	MaxSeries          int           `yaml:"max_series,omitempty"`
+	Timeout            time.Duration `yaml:"timeout,omitempty"`
+	RejectExcessPoints bool          `yaml:"reject_excess_points,omitempty"` // When true, reject queries over max_points instead of increasing the step
+}
+
+// Limits configuration. The top level limits apply to every query and are
+// overridden by the limits of the queried instance (keyed by its URL) and then
+// by the limits of the caller role, read from RoleHeader when set by a trusted
+// proxy. Callers without a role get the top level limits, which should thus
+// be the most restrictive ones.
+type Limits struct {
+	QueryLimits `yaml:",inline"`
+	RoleHeader  string                 `yaml:"role_header,omitempty"`
+	Roles       map[string]QueryLimits `yaml:"roles,omitempty"`
+	Instances   map[string]QueryLimits `yaml:"instances,omitempty"`
+}
+
+// QueryCache configuration of the range query result cache
+type QueryCache struct {
+	Enabled       bool          `yaml:"enabled,omitempty"`
+	Directory     string        `yaml:"directory,omitempty"` // When set, cached blocks are also kept on disk
+	MaxEntries    int           `yaml:"max_entries,omitempty"`
+	MaxBytes      int64         `yaml:"max_bytes,omitempty"`      // Bounds the total size of the blocks kept in memory, the directory is not bounded
+	SplitInterval time.Duration `yaml:"split_interval,omitempty"` // When not set, hour blocks are used for ranges up to two days and day blocks beyond
+	MaxFreshness  time.Duration `yaml:"max_freshness,omitempty"`  // Blocks newer than this are never cached
+}
+
+// Batch configuration of the batch query endpoint
+type Batch struct {
+	MaxQueries     int           `yaml:"max_queries,omitempty"`
+	MaxConcurrency int           `yaml:"max_concurrency,omitempty"`
+	QueryTimeout   time.Duration `yaml:"query_timeout,omitempty"` // Upper bound of the timeout of each query in a batch
+}
+
+// Upstream is a backend queried directly instead of through Grafana's datasource proxy
+type Upstream struct {
+	Name string `yaml:"name"`
+	// Type is loki, or prometheus for any Prometheus compatible API such as Thanos Query, Mimir or VictoriaMetrics
+	Type        string     `yaml:"type"`
+	URL         string     `yaml:"url"`               // Including any path prefix, such as http://mimir/prometheus
+	APIKey      string     `yaml:"api_key,omitempty"` // Sent like Grafana credentials: "user:password" as basic auth, anything else as a bearer token
+	BasicAuth   *BasicAuth `yaml:"basic_auth,omitempty"`
+	BearerToken string     `yaml:"bearer_token,omitempty"`
+	TenantID    string     `yaml:"tenant_id,omitempty"` // Sent in the X-Scope-OrgID header
+}
+
+// BasicAuth holds the basic authentication credentials of an upstream
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Credential returns the value of the Authorization header of the upstream,
+// in the form understood by models.SetAuthorization.
+func (u *Upstream) Credential() string {
+	switch {
+	case u.BasicAuth != nil:
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(u.BasicAuth.Username+":"+u.BasicAuth.Password))
+	case u.BearerToken != "":
+		return "Bearer " + u.BearerToken
+	}
+	return u.APIKey
+}
+
+// Instance is a Grafana instance registered by name, served under
+// /api/v1/instances/{name} without callers passing its credential
+type Instance struct {
+	Name   string `yaml:"name"`
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"` // "user:password" or an api key
+	// BackupSchedule is the cron expression of the backups of the dashboards of the instance, such as "0 3 * * *"
+	BackupSchedule string `yaml:"backup_schedule,omitempty"`
+}
+
+// Sync is a git repository whose dashboards are synchronized with a registered instance. The
+// dashboards of a directory of the repository go to the folder of the uid of the directory name.
+type Sync struct {
+	Name        string `yaml:"name"`
+	Instance    string `yaml:"instance"`
+	Repository  string `yaml:"repository"`             // Path of a local working tree or bare repository
+	Branch      string `yaml:"branch,omitempty"`       // The current branch of the repository when not set
+	Path        string `yaml:"path,omitempty"`         // Directory of the dashboards in the repository, the root when not set
+	AuthorName  string `yaml:"author_name,omitempty"`  // Author of the commits of Grafana changes
+	AuthorEmail string `yaml:"author_email,omitempty"` // Author of the commits of Grafana changes
+}
+
+// Backup configuration of the dashboard backups, kept in a local directory or an S3 compatible bucket
+type Backup struct {
+	Directory string `yaml:"directory,omitempty"`
+	S3        *S3    `yaml:"s3,omitempty"`
+	Retention int    `yaml:"retention,omitempty"` // Backups kept per dashboard, all of them when not set
+}
+
+// S3 is a bucket of an S3 compatible object storage, such as AWS S3 or MinIO, addressed path-style
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"` // Such as https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Region    string `yaml:"region,omitempty"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix,omitempty"` // Prefix of the keys of the backups in the bucket
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// Loki configuration of the log endpoints
+type Loki struct {
+	MaxLimit     int           `yaml:"max_limit,omitempty"`     // Upper bound of the number of log lines returned by a query
+	TailInterval time.Duration `yaml:"tail_interval,omitempty"` // How often a live tail polls for new log lines
+}
+
+// Tenancy configuration. The PromQL of a caller whose tenant, read from
+// TenantHeader when set by a trusted proxy, has matchers is rewritten to only
+// select series matching them. Tenancy is enabled by configuring tenants.
+type Tenancy struct {
+	TenantHeader  string              `yaml:"tenant_header,omitempty"`
+	Tenants       map[string][]string `yaml:"tenants,omitempty"` // Label matchers enforced per tenant, such as namespace=~"team-a-.*"
+	RequireTenant bool                `yaml:"require_tenant"`    // When true, the default, PromQL of callers without a tenant is rejected
+}
+
+// Access controls the routes using the credentials of the configuration: the
+// routes of the instances and upstreams, migrations and syncs. They are only
+// served to callers authenticated by a trusted proxy, which sends their role
+// in the role header of the limits.
+type Access struct {
+	Roles      []string `yaml:"roles,omitempty"`       // Roles allowed to read through the routes, any role when empty
+	WriteRoles []string `yaml:"write_roles,omitempty"` // Roles allowed to change Grafana, backups and repositories, none when empty
+}
+
+// CanRead reports whether a caller of the role may read through the routes using the configured credentials.
+func (a *Access) CanRead(role string) bool {
+	return role != "" && (len(a.Roles) == 0 || contains(a.Roles, role) || a.CanWrite(role))
+}
+
+// CanWrite reports whether a caller of the role may change Grafana, backups and repositories through them.
+func (a *Access) CanWrite(role string) bool {
+	return role != "" && contains(a.WriteRoles, role)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 type Config struct {
-	Server Server `yaml:",omitempty"`
+	Server     Server     `yaml:",omitempty"`
+	Access     Access     `yaml:"access,omitempty"`
+	Limits     Limits     `yaml:"limits,omitempty"`
+	QueryCache QueryCache `yaml:"query_cache,omitempty"`
+	Batch      Batch      `yaml:"batch,omitempty"`
+	Loki       Loki       `yaml:"loki,omitempty"`
+	Upstreams  []Upstream `yaml:"upstreams,omitempty"`
+	Tenancy    Tenancy    `yaml:"tenancy,omitempty"`
+	Instances  []Instance `yaml:"instances,omitempty"`
+	Backup     Backup     `yaml:"backup,omitempty"`
+	Syncs      []Sync     `yaml:"syncs,omitempty"`
+}
+
+// GetInstance returns the Grafana instance with the given name.
+func (c *Config) GetInstance(name string) (*Instance, bool) {
+	for i := range c.Instances {
+		if c.Instances[i].Name == name {
+			return &c.Instances[i], true
+		}
+	}
+	return nil, false
+}
+
+// GetSync returns the git sync with the given name.
+func (c *Config) GetSync(name string) (*Sync, bool) {
+	for i := range c.Syncs {
+		if c.Syncs[i].Name == name {
+			return &c.Syncs[i], true
+		}
+	}
+	return nil, false
+}
+
+// GetUpstream returns the upstream with the given name and type.
+func (c *Config) GetUpstream(name, upstreamType string) (*Upstream, bool) {
+	for i := range c.Upstreams {
+		if c.Upstreams[i].Name == name && c.Upstreams[i].Type == upstreamType {
+			return &c.Upstreams[i], true
+		}
+	}
+	return nil, false
 }
 
 func LoadFromFile(filename string) (conf *Config, err error) {
@@ -53,9 +288,78 @@ func Unmarshal(yamlString string) (conf *Config, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse yaml data. error=%v", err)
 	}
+	for _, proxy := range conf.Server.TrustedProxies {
+		if _, err = parseProxy(proxy); err != nil {
+			return nil, err
+		}
+	}
+	if err = validateUpstreams(conf.Upstreams); err != nil {
+		return nil, err
+	}
+	if err = validateTenancy(conf.Tenancy); err != nil {
+		return nil, err
+	}
+	if err = validateInstances(conf.Instances); err != nil {
+		return nil, err
+	}
+	if sunset := conf.Server.LegacySunset; sunset != "" {
+		if _, err := time.Parse("2006-01-02", sunset); err != nil {
+			return nil, fmt.Errorf("invalid legacy_sunset %q, expected a date such as 2027-06-30", sunset)
+		}
+	}
 	return
 }
 
+// upstreamTypes are the supported upstream types.
+var upstreamTypes = map[string]bool{"loki": true, "prometheus": true}
+
+func validateUpstreams(upstreams []Upstream) error {
+	names := map[string]bool{}
+	for i, u := range upstreams {
+		switch {
+		case u.Name == "":
+			return fmt.Errorf("upstream %d has no name", i)
+		case !upstreamTypes[u.Type]:
+			return fmt.Errorf("upstream %q has unknown type %q, expected loki or prometheus", u.Name, u.Type)
+		case u.URL == "":
+			return fmt.Errorf("upstream %q has no url", u.Name)
+		case u.BasicAuth != nil && u.BearerToken != "":
+			return fmt.Errorf("upstream %q sets both basic_auth and bearer_token", u.Name)
+		case names[u.Type+"/"+u.Name]:
+			return fmt.Errorf("duplicate %s upstream %q", u.Type, u.Name)
+		}
+		names[u.Type+"/"+u.Name] = true
+	}
+	return nil
+}
+
+func validateInstances(instances []Instance) error {
+	names := map[string]bool{}
+	for i, instance := range instances {
+		switch {
+		case instance.Name == "":
+			return fmt.Errorf("instance %d has no name", i)
+		case instance.URL == "":
+			return fmt.Errorf("instance %q has no url", instance.Name)
+		case names[instance.Name]:
+			return fmt.Errorf("duplicate instance %q", instance.Name)
+		}
+		names[instance.Name] = true
+	}
+	return nil
+}
+
+func validateTenancy(t Tenancy) error {
+	for tenant, matchers := range t.Tenants {
+		for _, m := range matchers {
+			if _, err := promql.ParseMatchers(m); err != nil {
+				return fmt.Errorf("tenant %q has invalid matcher %q: %v", tenant, m, err)
+			}
+		}
+	}
+	return nil
+}
+
 func NewConfig() (c *Config) {
 	c = &Config{
 		Server: Server{
@@ -67,6 +371,32 @@ func NewConfig() (c *Config) {
 			WebRoot:                    "/",
 			WebHistoryMode:             "browser",
 			WebSchema:                  "",
+			LegacySunset:               "2027-06-30",
+		},
+		Limits: Limits{
+			QueryLimits: QueryLimits{
+				MaxPoints: 11000,
+			},
+			RoleHeader: "X-Proxy-Role",
+		},
+		QueryCache: QueryCache{
+			Enabled:      true,
+			MaxEntries:   10000,
+			MaxBytes:     256 << 20,
+			MaxFreshness: 10 * time.Minute,
+		},
+		Batch: Batch{
+			MaxQueries:     100,
+			MaxConcurrency: 8,
+			QueryTimeout:   30 * time.Second,
+		},
+		Loki: Loki{
+			MaxLimit:     5000,
+			TailInterval: 2 * time.Second,
+		},
+		Tenancy: Tenancy{
+			TenantHeader:  "X-Proxy-Tenant",
+			RequireTenant: true,
 		},
 	}
 
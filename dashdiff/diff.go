@@ -0,0 +1,187 @@
+package dashdiff
+
+import (
+	"fmt"
+	"proxy-api-server/models"
+	"reflect"
+	"sort"
+)
+
+// queryFields are the fields holding the query text of a target, by
+// datasource: PromQL and LogQL, TraceQL and others, SQL, expressions and Graphite.
+var queryFields = []string{"expr", "query", "rawSql", "expression", "target"}
+
+// Diff returns the structural difference between two JSON models of a
+// dashboard: its changed fields, and the panels added, removed or changed
+// with the changes of their queries.
+func Diff(before, after map[string]interface{}) *models.DashboardDiff {
+	diff := &models.DashboardDiff{
+		Fields:        changedFields(before, after, "id", "version", "panels", "rows"),
+		PanelsAdded:   []*models.PanelSummary{},
+		PanelsRemoved: []*models.PanelSummary{},
+		PanelsChanged: []*models.PanelChange{},
+	}
+	beforePanels, afterPanels := panelsByKey(before), panelsByKey(after)
+	for _, key := range union(objectKeys(beforePanels), objectKeys(afterPanels)) {
+		oldPanel, inBefore := beforePanels[key]
+		newPanel, inAfter := afterPanels[key]
+		switch {
+		case !inBefore:
+			diff.PanelsAdded = append(diff.PanelsAdded, summary(newPanel))
+		case !inAfter:
+			diff.PanelsRemoved = append(diff.PanelsRemoved, summary(oldPanel))
+		default:
+			change := &models.PanelChange{
+				PanelSummary: *summary(newPanel),
+				Fields:       changedFields(oldPanel, newPanel, "targets", "panels"),
+				Queries:      queryChanges(oldPanel, newPanel),
+			}
+			if len(change.Fields) > 0 || len(change.Queries) > 0 {
+				diff.PanelsChanged = append(diff.PanelsChanged, change)
+			}
+		}
+	}
+	return diff
+}
+
+// panelsByKey returns the panels of a dashboard, including the panels of
+// rows, by id or by title for panels without id.
+func panelsByKey(dashboard map[string]interface{}) map[string]map[string]interface{} {
+	panels := map[string]map[string]interface{}{}
+	var add func(list interface{})
+	add = func(list interface{}) {
+		items, _ := list.([]interface{})
+		for _, item := range items {
+			panel, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if id, ok := panel["id"].(float64); ok {
+				panels[fmt.Sprintf("%012.0f", id)] = panel
+			} else {
+				panels["title:"+str(panel["title"])] = panel
+			}
+			// collapsed rows hold their panels
+			add(panel["panels"])
+		}
+	}
+	add(dashboard["panels"])
+	// rows of the dashboards of Grafana before 5.0
+	rows, _ := dashboard["rows"].([]interface{})
+	for _, row := range rows {
+		if row, ok := row.(map[string]interface{}); ok {
+			add(row["panels"])
+		}
+	}
+	return panels
+}
+
+func queryChanges(before, after map[string]interface{}) []*models.QueryChange {
+	beforeTargets, afterTargets := targetsByRefID(before), targetsByRefID(after)
+	changes := []*models.QueryChange{}
+	for _, refID := range union(objectKeys(beforeTargets), objectKeys(afterTargets)) {
+		oldTarget, inBefore := beforeTargets[refID]
+		newTarget, inAfter := afterTargets[refID]
+		switch {
+		case !inBefore:
+			changes = append(changes, &models.QueryChange{RefID: refID, Change: "added", After: queryOf(newTarget)})
+		case !inAfter:
+			changes = append(changes, &models.QueryChange{RefID: refID, Change: "removed", Before: queryOf(oldTarget)})
+		default:
+			if fields := changedFields(oldTarget, newTarget); len(fields) > 0 {
+				changes = append(changes, &models.QueryChange{
+					RefID:  refID,
+					Change: "changed",
+					Before: queryOf(oldTarget),
+					After:  queryOf(newTarget),
+					Fields: fields,
+				})
+			}
+		}
+	}
+	return changes
+}
+
+// targetsByRefID returns the queries of a panel by refId, by position for
+// queries without refId.
+func targetsByRefID(panel map[string]interface{}) map[string]map[string]interface{} {
+	targets := map[string]map[string]interface{}{}
+	items, _ := panel["targets"].([]interface{})
+	for i, item := range items {
+		target, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		refID := str(target["refId"])
+		if refID == "" {
+			refID = fmt.Sprintf("#%d", i)
+		}
+		targets[refID] = target
+	}
+	return targets
+}
+
+func queryOf(target map[string]interface{}) string {
+	for _, field := range queryFields {
+		if query := str(target[field]); query != "" {
+			return query
+		}
+	}
+	return ""
+}
+
+// changedFields returns the sorted fields of two objects whose values
+// differ, other than the ignored ones.
+func changedFields(before, after map[string]interface{}, ignored ...string) []string {
+	skip := map[string]bool{}
+	for _, field := range ignored {
+		skip[field] = true
+	}
+	fields := []string{}
+	for _, field := range union(fieldNames(before), fieldNames(after)) {
+		if !skip[field] && !reflect.DeepEqual(before[field], after[field]) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func summary(panel map[string]interface{}) *models.PanelSummary {
+	id, _ := panel["id"].(float64)
+	return &models.PanelSummary{ID: int(id), Title: str(panel["title"]), Type: str(panel["type"])}
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// union returns the sorted keys of two sets.
+func union(a, b []string) []string {
+	set := map[string]bool{}
+	for _, k := range append(a, b...) {
+		set[k] = true
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func fieldNames(object map[string]interface{}) []string {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	return names
+}
+
+func objectKeys(objects map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,148 @@
+package dashdiff
+
+import (
+	"encoding/json"
+	"proxy-api-server/models"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          models.DashboardDiff
+	}{
+		{
+			name:   "identical",
+			before: `{"title":"a","panels":[{"id":1,"title":"p","targets":[{"refId":"A","expr":"up"}]}]}`,
+			after:  `{"title":"a","panels":[{"id":1,"title":"p","targets":[{"refId":"A","expr":"up"}]}]}`,
+		},
+		{
+			name:   "dashboard fields",
+			before: `{"id":1,"version":3,"title":"a","tags":["x"],"time":{"from":"now-1h"}}`,
+			after:  `{"id":2,"version":4,"title":"b","tags":["x"],"refresh":"5s","time":{"from":"now-6h"}}`,
+			want:   models.DashboardDiff{Fields: []string{"refresh", "time", "title"}},
+		},
+		{
+			name:   "panels by id",
+			before: `{"panels":[{"id":1,"title":"cpu","type":"timeseries","gridPos":{"x":0}},{"id":2,"title":"mem","type":"stat"}]}`,
+			after:  `{"panels":[{"id":3,"title":"disk","type":"gauge"},{"id":1,"title":"CPU","type":"timeseries","gridPos":{"x":12}}]}`,
+			want: models.DashboardDiff{
+				PanelsAdded:   []*models.PanelSummary{{ID: 3, Title: "disk", Type: "gauge"}},
+				PanelsRemoved: []*models.PanelSummary{{ID: 2, Title: "mem", Type: "stat"}},
+				PanelsChanged: []*models.PanelChange{{PanelSummary: models.PanelSummary{ID: 1, Title: "CPU", Type: "timeseries"}, Fields: []string{"gridPos", "title"}}},
+			},
+		},
+		{
+			name:   "panels without id by title",
+			before: `{"panels":[{"title":"cpu","type":"graph"},{"title":"mem","type":"graph"}]}`,
+			after:  `{"panels":[{"title":"cpu","type":"timeseries"},{"title":"memory","type":"graph"}]}`,
+			want: models.DashboardDiff{
+				PanelsAdded:   []*models.PanelSummary{{Title: "memory", Type: "graph"}},
+				PanelsRemoved: []*models.PanelSummary{{Title: "mem", Type: "graph"}},
+				PanelsChanged: []*models.PanelChange{{PanelSummary: models.PanelSummary{Title: "cpu", Type: "timeseries"}, Fields: []string{"type"}}},
+			},
+		},
+		{
+			name: "panels nested in collapsed rows",
+			before: `{"panels":[{"id":1,"type":"row","title":"r","collapsed":true,"panels":[
+				{"id":2,"title":"cpu","targets":[{"refId":"A","expr":"up"}]}]}]}`,
+			after: `{"panels":[{"id":1,"type":"row","title":"r","collapsed":true,"panels":[
+				{"id":2,"title":"cpu","targets":[{"refId":"A","expr":"up == 1"}]},{"id":3,"title":"mem"}]}]}`,
+			want: models.DashboardDiff{
+				PanelsAdded: []*models.PanelSummary{{ID: 3, Title: "mem"}},
+				PanelsChanged: []*models.PanelChange{{
+					PanelSummary: models.PanelSummary{ID: 2, Title: "cpu"},
+					Queries:      []*models.QueryChange{{RefID: "A", Change: "changed", Before: "up", After: "up == 1", Fields: []string{"expr"}}},
+				}},
+			},
+		},
+		{
+			name: "legacy rows",
+			before: `{"rows":[{"title":"r1","panels":[{"id":1,"title":"cpu","span":6}]},
+				{"title":"r2","panels":[{"id":2,"title":"mem"}]}]}`,
+			after: `{"rows":[{"title":"r1","panels":[{"id":1,"title":"cpu","span":12}]}]}`,
+			want: models.DashboardDiff{
+				PanelsRemoved: []*models.PanelSummary{{ID: 2, Title: "mem"}},
+				PanelsChanged: []*models.PanelChange{{PanelSummary: models.PanelSummary{ID: 1, Title: "cpu"}, Fields: []string{"span"}}},
+			},
+		},
+		{
+			name: "queries by refId",
+			before: `{"panels":[{"id":1,"title":"p","targets":[
+				{"refId":"A","expr":"up"},{"refId":"B","expr":"rate(x[5m])","legendFormat":"x"},{"refId":"C","rawSql":"select 1"}]}]}`,
+			after: `{"panels":[{"id":1,"title":"p","targets":[
+				{"refId":"D","query":"{job=\"a\"}"},{"refId":"B","expr":"rate(x[1m])","legendFormat":"y"},{"refId":"A","expr":"up"}]}]}`,
+			want: models.DashboardDiff{
+				PanelsChanged: []*models.PanelChange{{
+					PanelSummary: models.PanelSummary{ID: 1, Title: "p"},
+					Queries: []*models.QueryChange{
+						{RefID: "B", Change: "changed", Before: "rate(x[5m])", After: "rate(x[1m])", Fields: []string{"expr", "legendFormat"}},
+						{RefID: "C", Change: "removed", Before: "select 1"},
+						{RefID: "D", Change: "added", After: `{job="a"}`},
+					},
+				}},
+			},
+		},
+		{
+			name:   "queries without refId by position",
+			before: `{"panels":[{"id":1,"title":"p","targets":[{"target":"a.b"},{"target":"a.c"}]}]}`,
+			after:  `{"panels":[{"id":1,"title":"p","targets":[{"target":"a.c"},{"target":"a.c"},{"expression":"$A + 1"}]}]}`,
+			want: models.DashboardDiff{
+				PanelsChanged: []*models.PanelChange{{
+					PanelSummary: models.PanelSummary{ID: 1, Title: "p"},
+					Queries: []*models.QueryChange{
+						{RefID: "#0", Change: "changed", Before: "a.b", After: "a.c", Fields: []string{"target"}},
+						{RefID: "#2", Change: "added", After: "$A + 1"},
+					},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(decode(t, tt.before), decode(t, tt.after))
+			want := tt.want
+			for _, list := range []*[]*models.PanelSummary{&want.PanelsAdded, &want.PanelsRemoved} {
+				if *list == nil {
+					*list = []*models.PanelSummary{}
+				}
+			}
+			if want.Fields == nil {
+				want.Fields = []string{}
+			}
+			if want.PanelsChanged == nil {
+				want.PanelsChanged = []*models.PanelChange{}
+			}
+			for _, change := range want.PanelsChanged {
+				if change.Fields == nil {
+					change.Fields = []string{}
+				}
+				if change.Queries == nil {
+					change.Queries = []*models.QueryChange{}
+				}
+			}
+			if gotJSON, wantJSON := encode(t, got), encode(t, &want); gotJSON != wantJSON {
+				t.Errorf("Diff() =\n%s\nwant\n%s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func decode(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	dashboard := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(data), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	return dashboard
+}
+
+func encode(t *testing.T, diff *models.DashboardDiff) string {
+	t.Helper()
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
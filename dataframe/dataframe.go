@@ -0,0 +1,118 @@
+package dataframe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"proxy-api-server/models"
+)
+
+// queryResponse mirrors the response of Grafana's /api/ds/query API.
+type queryResponse struct {
+	Results map[string]queryResult `json:"results"`
+}
+
+type queryResult struct {
+	Status int         `json:"status,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Frames []frameJSON `json:"frames,omitempty"`
+}
+
+type frameJSON struct {
+	Schema struct {
+		Name   string          `json:"name,omitempty"`
+		Meta   json.RawMessage `json:"meta,omitempty"`
+		Fields []struct {
+			Name   string            `json:"name"`
+			Type   string            `json:"type,omitempty"`
+			Labels map[string]string `json:"labels,omitempty"`
+			Config json.RawMessage   `json:"config,omitempty"`
+		} `json:"fields"`
+	} `json:"schema"`
+	Data struct {
+		Values   [][]interface{}    `json:"values"`
+		Entities []map[string][]int `json:"entities,omitempty"`
+	} `json:"data"`
+}
+
+// entityValues replace the special float values that JSON cannot carry, the
+// same way Prometheus prints them.
+var entityValues = map[string]string{
+	"NaN":    "NaN",
+	"Inf":    "+Inf",
+	"NegInf": "-Inf",
+}
+
+// Decode converts an /api/ds/query response into one result per refId, in
+// the order given by refIDs. Results Grafana returned for other refIds are
+// appended at the end.
+func Decode(data []byte, refIDs []string) ([]*models.DataSourceQueryResult, error) {
+	resp := queryResponse{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode datasource query response: %v", err)
+	}
+	results := []*models.DataSourceQueryResult{}
+	seen := map[string]bool{}
+	for _, refID := range refIDs {
+		if r, ok := resp.Results[refID]; ok {
+			results = append(results, decodeResult(refID, r))
+			seen[refID] = true
+		}
+	}
+	for refID, r := range resp.Results {
+		if !seen[refID] {
+			results = append(results, decodeResult(refID, r))
+		}
+	}
+	return results, nil
+}
+
+func decodeResult(refID string, r queryResult) *models.DataSourceQueryResult {
+	result := &models.DataSourceQueryResult{
+		RefID:  refID,
+		Status: r.Status,
+		Error:  r.Error,
+		Frames: []*models.DataFrame{},
+	}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+		if r.Error != "" {
+			result.Status = http.StatusBadRequest
+		}
+	}
+	for _, f := range r.Frames {
+		result.Frames = append(result.Frames, decodeFrame(f))
+	}
+	return result
+}
+
+func decodeFrame(f frameJSON) *models.DataFrame {
+	frame := &models.DataFrame{
+		Name:   f.Schema.Name,
+		Meta:   f.Schema.Meta,
+		Fields: []*models.DataFrameField{},
+	}
+	for i, sf := range f.Schema.Fields {
+		field := &models.DataFrameField{
+			Name:   sf.Name,
+			Type:   sf.Type,
+			Labels: sf.Labels,
+			Config: sf.Config,
+			Values: []interface{}{},
+		}
+		if i < len(f.Data.Values) && f.Data.Values[i] != nil {
+			field.Values = f.Data.Values[i]
+		}
+		if i < len(f.Data.Entities) {
+			for entity, indexes := range f.Data.Entities[i] {
+				for _, idx := range indexes {
+					if idx >= 0 && idx < len(field.Values) {
+						field.Values[idx] = entityValues[entity]
+					}
+				}
+			}
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+	return frame
+}
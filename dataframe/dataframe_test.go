@@ -0,0 +1,99 @@
+package dataframe
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		refIDs []string
+		// want is the JSON of the decoded results
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "several frames",
+			data: `{"results":{"A":{"status":200,"frames":[
+				{"schema":{"name":"up","fields":[{"name":"Time","type":"time"},{"name":"Value","type":"number","labels":{"job":"a"}}]},"data":{"values":[[1000,2000],[1,0]]}},
+				{"schema":{"name":"up","fields":[{"name":"Time","type":"time"},{"name":"Value","type":"number","labels":{"job":"b"}}]},"data":{"values":[[1000],[1]]}}
+			]}}}`,
+			refIDs: []string{"A"},
+			want: `[{"refId":"A","status":200,"frames":[
+				{"name":"up","fields":[{"name":"Time","type":"time","values":[1000,2000]},{"name":"Value","type":"number","labels":{"job":"a"},"values":[1,0]}]},
+				{"name":"up","fields":[{"name":"Time","type":"time","values":[1000]},{"name":"Value","type":"number","labels":{"job":"b"},"values":[1]}]}
+			]}]`,
+		},
+		{
+			name: "fields without values",
+			data: `{"results":{"A":{"frames":[{"schema":{"fields":[{"name":"Time","type":"time"},{"name":"Value","type":"number"}]},"data":{"values":[[1000]]}}]}}}`,
+			want: `[{"refId":"A","status":200,"frames":[{"fields":[{"name":"Time","type":"time","values":[1000]},{"name":"Value","type":"number","values":[]}]}]}]`,
+		},
+		{
+			name: "special values",
+			data: `{"results":{"A":{"frames":[{"schema":{"fields":[{"name":"Value","type":"number"}]},"data":{"values":[[1,null,null,null]],"entities":[{"NaN":[1],"Inf":[2],"NegInf":[3,9]}]}}]}}}`,
+			want: `[{"refId":"A","status":200,"frames":[{"fields":[{"name":"Value","type":"number","values":[1,"NaN","+Inf","-Inf"]}]}]}]`,
+		},
+		{
+			name:   "error of a query",
+			data:   `{"results":{"A":{"error":"parse error","frames":[{"schema":{"fields":[]},"data":{"values":[]}}]},"B":{"status":500,"error":"timeout"}}}`,
+			refIDs: []string{"A", "B"},
+			want: `[{"refId":"A","status":400,"error":"parse error","frames":[{"fields":[]}]},
+				{"refId":"B","status":500,"error":"timeout","frames":[]}]`,
+		},
+		{
+			name:   "order of the refIds",
+			data:   `{"results":{"A":{},"B":{},"C":{}}}`,
+			refIDs: []string{"C", "A"},
+			want:   `[{"refId":"C","status":200,"frames":[]},{"refId":"A","status":200,"frames":[]},{"refId":"B","status":200,"frames":[]}]`,
+		},
+		{
+			name:   "missing result",
+			data:   `{"results":{}}`,
+			refIDs: []string{"A"},
+			want:   `[]`,
+		},
+		{
+			name:    "not a query response",
+			data:    `<html>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := Decode([]byte(tt.data), tt.refIDs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Decode() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(results)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := normalize(t, got), normalize(t, []byte(tt.want)); got != want {
+				t.Errorf("Decode() = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+// normalize returns data without its whitespace and with sorted keys.
+func normalize(t *testing.T, data []byte) string {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(normalized)
+}
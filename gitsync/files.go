@@ -0,0 +1,155 @@
+package gitsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"proxy-api-server/models"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dashboard is a dashboard file of a repository.
+type Dashboard struct {
+	Path      string
+	UID       string
+	Dashboard map[string]interface{}
+	// Folders are the folders of the directories of the file, parents
+	// first, the last one holding the dashboard, none for the General folder.
+	Folders []*models.Folder
+}
+
+// FolderUID returns the uid of the folder of the dashboard, empty for the General folder.
+func (d *Dashboard) FolderUID() string {
+	if len(d.Folders) == 0 {
+		return ""
+	}
+	return d.Folders[len(d.Folders)-1].UID
+}
+
+// IsDashboardFile reports whether a path is a dashboard file, JSON or YAML.
+func IsDashboardFile(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// ParseDashboards parses the dashboard files under a directory of a
+// repository. It returns the errors of the files that are not dashboards,
+// that are in a directory whose name is not a folder uid, or whose uid is
+// the one of another file, by path.
+func ParseDashboards(files []File, dir string) ([]*Dashboard, map[string]string) {
+	dashboards := []*Dashboard{}
+	invalid := map[string]string{}
+	byUID := map[string]string{}
+	for _, f := range files {
+		if !IsDashboardFile(f.Path) {
+			continue
+		}
+		dirFolders, err := folders(f.Path, dir)
+		if err != nil {
+			invalid[f.Path] = err.Error()
+			continue
+		}
+		dashboard, err := decode(f.Path, f.Data)
+		if err != nil {
+			invalid[f.Path] = err.Error()
+			continue
+		}
+		uid, _ := dashboard["uid"].(string)
+		if uid == "" {
+			invalid[f.Path] = "the dashboard has no uid"
+			continue
+		}
+		if other, ok := byUID[uid]; ok {
+			invalid[f.Path] = fmt.Sprintf("the uid %q is the one of %s", uid, other)
+			continue
+		}
+		byUID[uid] = f.Path
+		dashboards = append(dashboards, &Dashboard{Path: f.Path, UID: uid, Dashboard: dashboard, Folders: dirFolders})
+	}
+	return dashboards, invalid
+}
+
+// folderNameRegex matches the directory names usable as folder uids.
+var folderNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,40}$`)
+
+// folders returns the folders of the directories of a file under dir, the
+// uid and title of a folder being the name of its directory. Directory
+// names must be valid folder uids, at most 40 letters, digits, - or _.
+func folders(p, dir string) ([]*models.Folder, error) {
+	rel := strings.TrimPrefix(path.Dir(p), dir)
+	result := []*models.Folder{}
+	parent := ""
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		if !folderNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("the directory name %q is not a valid folder uid, of at most 40 letters, digits, - or _", name)
+		}
+		result = append(result, &models.Folder{UID: name, Title: name, ParentUID: parent})
+		parent = name
+	}
+	return result, nil
+}
+
+// FolderDir returns the directory of the dashboards of a folder under dir,
+// the uids of the folder and its parents, given by parent uid.
+func FolderDir(dir, folderUID string, parents map[string]string) string {
+	parts := []string{}
+	for uid, depth := folderUID, 0; uid != "" && depth <= len(parents); uid, depth = parents[uid], depth+1 {
+		parts = append([]string{uid}, parts...)
+	}
+	return path.Join(append([]string{dir}, parts...)...)
+}
+
+func decode(p string, data []byte) (map[string]interface{}, error) {
+	dashboard := map[string]interface{}{}
+	if strings.ToLower(path.Ext(p)) == ".json" {
+		if err := json.Unmarshal(data, &dashboard); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return dashboard, nil
+	}
+	if err := yaml.Unmarshal(data, &dashboard); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	// the JSON model of Grafana only has string keys, which YAML does not ensure
+	normalized, err := json.Marshal(dashboard)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dashboard: %w", err)
+	}
+	dashboard = map[string]interface{}{}
+	if err := json.Unmarshal(normalized, &dashboard); err != nil {
+		return nil, err
+	}
+	return dashboard, nil
+}
+
+// Encode encodes a dashboard for a file of the format of its extension,
+// without the id and version of the instance it was read from.
+func Encode(p string, dashboard map[string]interface{}) ([]byte, error) {
+	content := make(map[string]interface{}, len(dashboard))
+	for key, value := range dashboard {
+		if key != "id" && key != "version" {
+			content[key] = value
+		}
+	}
+	if strings.ToLower(path.Ext(p)) == ".json" {
+		data, err := json.MarshalIndent(content, "", "  ")
+		return append(data, '\n'), err
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(content); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
@@ -0,0 +1,46 @@
+package gitsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDashboardsFolders(t *testing.T) {
+	dashboard := func(uid string) []byte { return []byte(`{"uid": "` + uid + `", "title": "` + uid + `"}`) }
+	files := []File{
+		{Path: "dashboards/general.json", Data: dashboard("a")},
+		{Path: "dashboards/team-a/sub_1/nested.json", Data: dashboard("b")},
+		{Path: "dashboards/Team A/spaces.json", Data: dashboard("c")},
+		{Path: "dashboards/" + strings.Repeat("x", 41) + "/long.json", Data: dashboard("d")},
+		{Path: "dashboards/" + strings.Repeat("x", 40) + "/max.json", Data: dashboard("e")},
+		{Path: "dashboards/équipe/accent.json", Data: dashboard("f")},
+		{Path: "dashboards/team.a/dot.json", Data: dashboard("g")},
+	}
+	dashboards, invalid := ParseDashboards(files, "dashboards")
+
+	folders := map[string][]string{}
+	for _, d := range dashboards {
+		uids := []string{}
+		for _, f := range d.Folders {
+			uids = append(uids, f.UID)
+		}
+		folders[d.UID] = uids
+	}
+	want := map[string][]string{"a": {}, "b": {"team-a", "sub_1"}, "e": {strings.Repeat("x", 40)}}
+	if len(folders) != len(want) {
+		t.Errorf("ParseDashboards() folders = %v, want %v", folders, want)
+	}
+	for uid, uids := range want {
+		if strings.Join(folders[uid], "/") != strings.Join(uids, "/") {
+			t.Errorf("folders of %s = %v, want %v", uid, folders[uid], uids)
+		}
+	}
+	for _, p := range []string{"dashboards/Team A/spaces.json", "dashboards/" + strings.Repeat("x", 41) + "/long.json", "dashboards/équipe/accent.json", "dashboards/team.a/dot.json"} {
+		if !strings.Contains(invalid[p], "not a valid folder uid") {
+			t.Errorf("invalid[%s] = %q, want an invalid folder", p, invalid[p])
+		}
+	}
+	if len(invalid) != 4 {
+		t.Errorf("ParseDashboards() invalid = %v, want 4 files", invalid)
+	}
+}
@@ -0,0 +1,239 @@
+package gitsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// File is a file of a commit of a repository.
+type File struct {
+	Path string
+	Data []byte
+}
+
+// Repo is a local git repository, a working tree or a bare repository,
+// read and written with the git command through plumbing commands, so
+// that the working tree of a non-bare repository is left alone.
+type Repo struct {
+	dir    string
+	branch string
+	author []string
+}
+
+// Open opens the repository of a directory for a branch, the current
+// branch of the repository when branch is empty.
+func Open(ctx context.Context, dir, branch, authorName, authorEmail string) (*Repo, error) {
+	r := &Repo{dir: dir}
+	if _, err := r.git(ctx, nil, nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository: %w", dir, err)
+	}
+	if branch == "" {
+		head, err := r.git(ctx, nil, nil, "symbolic-ref", "--short", "HEAD")
+		if err != nil {
+			return nil, fmt.Errorf("the HEAD of %s is not a branch, set the branch of the sync: %w", dir, err)
+		}
+		branch = head
+	}
+	r.branch = branch
+	if authorName == "" {
+		authorName = "proxy-api-server"
+	}
+	if authorEmail == "" {
+		authorEmail = "proxy-api-server@localhost"
+	}
+	r.author = []string{
+		"GIT_AUTHOR_NAME=" + authorName, "GIT_AUTHOR_EMAIL=" + authorEmail,
+		"GIT_COMMITTER_NAME=" + authorName, "GIT_COMMITTER_EMAIL=" + authorEmail,
+	}
+	return r, nil
+}
+
+// Branch returns the branch of the repository.
+func (r *Repo) Branch() string {
+	return r.branch
+}
+
+// Head returns the commit of the branch, empty when the branch has no commit yet.
+func (r *Repo) Head(ctx context.Context) (string, error) {
+	ref := "refs/heads/" + r.branch
+	if _, err := r.git(ctx, nil, nil, "show-ref", "--verify", "--quiet", ref); err != nil {
+		return "", nil
+	}
+	return r.git(ctx, nil, nil, "rev-parse", "--verify", ref+"^{commit}")
+}
+
+// Files returns the files of a commit under a directory, recursively.
+func (r *Repo) Files(ctx context.Context, commit, dir string) ([]File, error) {
+	if commit == "" {
+		return []File{}, nil
+	}
+	args := []string{"ls-tree", "-r", "-z", commit}
+	if dir != "" {
+		args = append(args, "--", dir)
+	}
+	out, err := r.git(ctx, nil, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	var paths, objects []string
+	for _, entry := range strings.Split(out, "\x00") {
+		// <mode> SP <type> SP <object> TAB <path>
+		info, p, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		paths = append(paths, p)
+		objects = append(objects, fields[2])
+	}
+	if len(objects) == 0 {
+		return []File{}, nil
+	}
+
+	// the blobs are read by a single git process
+	var stdout bytes.Buffer
+	stdin := strings.NewReader(strings.Join(objects, "\n") + "\n")
+	if _, err := r.git(ctx, stdin, &stdout, "cat-file", "--batch"); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(&stdout)
+	files := make([]File, 0, len(objects))
+	for i := range objects {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", paths[i], err)
+		}
+		// <object> SP <type> SP <size>
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unable to read %s: %s", paths[i], strings.TrimSpace(header))
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", paths[i], err)
+		}
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", paths[i], err)
+		}
+		files = append(files, File{Path: paths[i], Data: data[:size]})
+	}
+	return files, nil
+}
+
+// Commit commits changes to the files of the branch on top of parent, its
+// commit the changes were computed from, and returns the new commit, or
+// an empty commit when nothing changed. A file of nil data is removed. A
+// branch moved since parent is an error. When the branch is checked out in
+// a working tree, the working tree is fast-forwarded to the new commit.
+func (r *Repo) Commit(ctx context.Context, parent string, changes map[string][]byte, message string) (string, error) {
+	index, err := os.CreateTemp("", "gitsync-index-")
+	if err != nil {
+		return "", err
+	}
+	index.Close()
+	os.Remove(index.Name())
+	defer os.Remove(index.Name())
+	env := append([]string{"GIT_INDEX_FILE=" + index.Name()}, r.author...)
+	git := func(stdin io.Reader, args ...string) (string, error) {
+		return r.gitEnv(ctx, env, stdin, nil, args...)
+	}
+
+	if parent != "" {
+		_, err = git(nil, "read-tree", parent)
+	} else {
+		_, err = git(nil, "read-tree", "--empty")
+	}
+	if err != nil {
+		return "", err
+	}
+	// update-index --index-info does not need a working tree, a mode of 0 removes the file
+	var info strings.Builder
+	for p, data := range changes {
+		if data == nil {
+			fmt.Fprintf(&info, "0 %s\t%s\n", strings.Repeat("0", 40), p)
+			continue
+		}
+		object, err := git(bytes.NewReader(data), "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&info, "100644 %s\t%s\n", object, p)
+	}
+	if _, err := git(strings.NewReader(info.String()), "update-index", "--index-info"); err != nil {
+		return "", err
+	}
+	tree, err := git(nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	args := []string{"commit-tree", tree, "-m", message}
+	if parent != "" {
+		parentTree, err := git(nil, "rev-parse", parent+"^{tree}")
+		if err != nil {
+			return "", err
+		}
+		if parentTree == tree {
+			return "", nil
+		}
+		args = append(args, "-p", parent)
+	}
+	commit, err := git(nil, args...)
+	if err != nil {
+		return "", err
+	}
+
+	ref := "refs/heads/" + r.branch
+	if r.checkedOut(ctx, ref) {
+		// moves the branch, its index and working tree, or fails on local changes to the files
+		_, err = r.gitEnv(ctx, r.author, nil, nil, "merge", "--ff-only", "--quiet", commit)
+	} else {
+		old := parent
+		if old == "" {
+			old = strings.Repeat("0", len(commit))
+		}
+		_, err = git(nil, "update-ref", "-m", message, ref, commit, old)
+	}
+	if err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// checkedOut reports whether ref is the branch of the working tree of the repository.
+func (r *Repo) checkedOut(ctx context.Context, ref string) bool {
+	if bare, err := r.git(ctx, nil, nil, "rev-parse", "--is-bare-repository"); err != nil || bare == "true" {
+		return false
+	}
+	head, err := r.git(ctx, nil, nil, "symbolic-ref", "-q", "HEAD")
+	return err == nil && head == ref
+}
+
+func (r *Repo) git(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) (string, error) {
+	return r.gitEnv(ctx, nil, stdin, stdout, args...)
+}
+
+// gitEnv runs git in the repository and returns its trimmed output, unless
+// stdout is given.
+func (r *Repo) gitEnv(ctx context.Context, env []string, stdin io.Reader, stdout io.Writer, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", r.dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(out.String()), nil
+}
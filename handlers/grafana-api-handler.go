@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"fmt"
 	"io"
 	"net/http"
 	"proxy-api-server/log"
@@ -15,25 +14,25 @@ func GrafanaApiHandler(w http.ResponseWriter, r *http.Request) {
 	apiKey := r.URL.Query().Get("apiKey")
 	if grafanaUrl == "" {
 		log.Error("Grafana url not provided")
-		http.Error(w, fmt.Sprintf("Grafana url not provided"), http.StatusBadRequest)
+		util.WriteError(w, r, util.MissingParameterError("grafanaUrl", "Grafana url not provided"))
 		return
 	} else if apiKey == "" {
 		log.Error("Grafana api key (userId:password) not provided")
-		http.Error(w, fmt.Sprintf("Grafana api key (userId:password) not provided"), http.StatusBadRequest)
+		util.WriteError(w, r, util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided"))
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		util.Error("Cannot read request body.", err)
-		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
+		util.WriteError(w, r, util.BadRequestError("cannot read request body: %s", err))
 		return
 	}
 	payload := strings.NewReader(string(body))
-	resPbody, statusCode, err := util.HandleHttpRequest("POST", grafanaUrl, apiKey, payload)
+	resPbody, _, err := util.HandleHttpRequest("POST", grafanaUrl, apiKey, payload)
 	if err != nil {
 		util.Error("Http request failed: ", err)
-		http.Error(w, fmt.Sprintf("%s", err), statusCode)
+		util.WriteError(w, r, err)
 		return
 	}
 	//fmt.Println(string(resPbody))
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"proxy-api-server/backup"
+	"proxy-api-server/config"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// backupMu serializes the backups, scheduled or requested, so that two
+// backups of an instance do not save the same changes.
+var backupMu sync.Mutex
+
+func backupStore() (backup.Store, error) {
+	store := backup.Default()
+	if store == nil {
+		return nil, util.NewError(util.CodeNotFound, "dashboard backups are not configured")
+	}
+	return store, nil
+}
+
+// GrafanaBackupHandler backs up the dashboards of the instance now, see BackupInstance.
+func GrafanaBackupHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	store, err := backupStore()
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	result, err := BackupInstance(util.NewGrafanaClient(), r.Context(), store, mux.Vars(r)["name"], grafanaUrl, apiKey, config.Get().Backup.Retention)
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaBackupsHandler lists the backed up dashboards of the instance with their backups.
+func GrafanaBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := backupStore()
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	result, err := backup.ListDashboards(r.Context(), store, mux.Vars(r)["name"])
+	if err != nil {
+		err = util.Error("Unable to list backups", err)
+	}
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaDashboardBackupsHandler lists the backups of the dashboard of the uid query parameter, latest first.
+func GrafanaDashboardBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := backupStore()
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	result, err := DashboardBackups(r.Context(), store, mux.Vars(r)["name"], r.URL.Query().Get("uid"))
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaDashboardBackupHandler gets the backup query parameter backup of the dashboard of the uid query parameter.
+func GrafanaDashboardBackupHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := backupStore()
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	result, err := GetDashboardBackup(r.Context(), store, mux.Vars(r)["name"], r.URL.Query().Get("uid"), r.URL.Query().Get("backup"))
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaBackupRestoreHandler restores a backup of the dashboard of the uid query parameter, see RestoreBackup.
+func GrafanaBackupRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	store, err := backupStore()
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	restoreReq := &models.BackupRestoreRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(restoreReq); err != nil {
+			util.WriteError(w, r, util.BadRequestError("invalid restore request: %s", err))
+			return
+		}
+	}
+	result, err := RestoreBackup(util.NewGrafanaClient(), r.Context(), store, mux.Vars(r)["name"], grafanaUrl, apiKey, r.URL.Query().Get("uid"), restoreReq)
+	respondJSON(w, r, result, err)
+}
+
+// StartBackups schedules the backups of the instances with a backup
+// schedule. It returns nil when no instance has one.
+func StartBackups() (*backup.Scheduler, error) {
+	conf := config.Get()
+	scheduled := false
+	for _, instance := range conf.Instances {
+		scheduled = scheduled || instance.BackupSchedule != ""
+	}
+	if !scheduled {
+		return nil, nil
+	}
+	if backup.Default() == nil {
+		return nil, fmt.Errorf("backup schedules require backup.directory or backup.s3")
+	}
+	scheduler, err := backup.NewScheduler(conf.Instances, runScheduledBackup)
+	if err != nil {
+		return nil, err
+	}
+	scheduler.Start()
+	return scheduler, nil
+}
+
+func runScheduledBackup(instance string) {
+	BaseURL, APIKey, err := instanceCredentials(instance)
+	if err != nil {
+		log.Errorf("Scheduled backup of instance %s: %v", instance, err)
+		return
+	}
+	if _, err := BackupInstance(util.NewGrafanaClient(), context.Background(), backup.Default(), instance, BaseURL, APIKey, config.Get().Backup.Retention); err != nil {
+		log.Errorf("Scheduled backup of instance %s: %v", instance, err)
+	}
+}
+
+// BackupInstance saves the dashboards and folders of an instance that
+// changed since their latest backup, told by their content hash, and
+// deletes the backups of each beyond the retention. A dashboard that
+// cannot be backed up is reported in the result and does not stop the
+// others. Backups of deleted dashboards are kept.
+func BackupInstance(g *models.GrafanaClient, ctx context.Context, store backup.Store, instance, BaseURL, APIKey string, retention int) (*models.BackupResult, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	result := &models.BackupResult{Instance: instance, Started: time.Now().UTC(), Saved: []string{}, Failed: map[string]string{}}
+	tree, err := FolderTree(g, ctx, BaseURL, APIKey)
+	if err != nil {
+		return nil, err
+	}
+	folders := []*models.Folder{}
+	dashboards := []*models.FoundBoard{}
+	for pending := []*models.FolderNode{tree}; len(pending) > 0; pending = pending[1:] {
+		node := pending[0]
+		if node != tree {
+			folder := node.Folder
+			folders = append(folders, &folder)
+		}
+		dashboards = append(dashboards, node.Dashboards...)
+		pending = append(pending, node.Folders...)
+	}
+
+	saved, pruned, err := backupFolders(ctx, store, instance, folders, result.Started, retention)
+	if err != nil {
+		return nil, err
+	}
+	result.FoldersSaved, result.Pruned = saved, pruned
+	for _, board := range dashboards {
+		saved, pruned, err := backupDashboard(g, ctx, store, instance, BaseURL, APIKey, board.UID, result.Started, retention)
+		result.Pruned += pruned
+		switch {
+		case err != nil:
+			result.Failed[board.UID] = err.Error()
+		case saved:
+			result.Saved = append(result.Saved, board.UID)
+		default:
+			result.Unchanged++
+		}
+	}
+	log.Infof("Backed up %d dashboards of instance %s, %d unchanged, %d failed, %d backups pruned",
+		len(result.Saved), instance, result.Unchanged, len(result.Failed), result.Pruned)
+	return result, nil
+}
+
+func backupFolders(ctx context.Context, store backup.Store, instance string, folders []*models.Folder, now time.Time, retention int) (bool, int, error) {
+	hash, err := backup.Hash(folders)
+	if err != nil {
+		return false, 0, util.Error("Unable to encode folders", err)
+	}
+	prefix := backup.FolderPrefix(instance)
+	if saved, err := saveBackup(ctx, store, prefix, hash, now, func(id string) string { return backup.FolderKey(instance, id) }, func(b models.Backup) interface{} {
+		return &models.FolderBackup{Backup: b, Folders: folders}
+	}); err != nil || !saved {
+		return false, 0, err
+	}
+	pruned, err := backup.Prune(ctx, store, prefix, retention)
+	return true, pruned, err
+}
+
+func backupDashboard(g *models.GrafanaClient, ctx context.Context, store backup.Store, instance, BaseURL, APIKey, uid string, now time.Time, retention int) (bool, int, error) {
+	stored, err := getStoredDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return false, 0, err
+	}
+	hash, err := backup.DashboardHash(stored.Dashboard, stored.Meta.FolderUID)
+	if err != nil {
+		return false, 0, err
+	}
+	prefix := backup.DashboardPrefix(instance, uid)
+	if saved, err := saveBackup(ctx, store, prefix, hash, now, func(id string) string { return backup.DashboardKey(instance, uid, id) }, func(b models.Backup) interface{} {
+		return &models.DashboardBackup{Backup: b, UID: uid, FolderUID: stored.Meta.FolderUID, Version: stored.Meta.Version, Dashboard: stored.Dashboard}
+	}); err != nil || !saved {
+		return false, 0, err
+	}
+	pruned, err := backup.Prune(ctx, store, prefix, retention)
+	return true, pruned, err
+}
+
+// saveBackup saves the record of a backup under prefix unless the latest
+// backup there has the same content hash, and reports whether it did.
+func saveBackup(ctx context.Context, store backup.Store, prefix, hash string, now time.Time, key func(id string) string, record func(b models.Backup) interface{}) (bool, error) {
+	latest, err := backup.Latest(ctx, store, prefix)
+	if err != nil {
+		return false, err
+	}
+	id := backup.NewID(now, hash)
+	_, shortHash, _ := backup.ParseID(id)
+	if latest != nil && latest.Hash == shortHash {
+		return false, nil
+	}
+	data, err := json.Marshal(record(models.Backup{ID: id, Created: now, Hash: shortHash}))
+	if err != nil {
+		return false, err
+	}
+	return true, store.Put(ctx, key(id), data)
+}
+
+// DashboardBackups returns the backups of a dashboard, latest first.
+func DashboardBackups(ctx context.Context, store backup.Store, instance, uid string) (*models.DashboardBackups, error) {
+	if err := checkBackupUID(uid); err != nil {
+		return nil, err
+	}
+	keys, err := store.List(ctx, backup.DashboardPrefix(instance, uid))
+	if err != nil {
+		return nil, util.Error("Unable to list backups", err)
+	}
+	entries := backup.Entries(keys)
+	if len(entries) == 0 {
+		return nil, util.NotFoundError("no backup of dashboard %q", uid)
+	}
+	return &models.DashboardBackups{UID: uid, Backups: entries}, nil
+}
+
+// checkBackupUID rejects the uids that would not name a directory of backups.
+func checkBackupUID(uid string) error {
+	if uid == "" || uid == "." || uid == ".." {
+		return util.BadRequestError("invalid dashboard uid %q", uid)
+	}
+	return nil
+}
+
+// GetDashboardBackup returns a backup of a dashboard, the latest one when id is empty or latest.
+func GetDashboardBackup(ctx context.Context, store backup.Store, instance, uid, id string) (*models.DashboardBackup, error) {
+	if err := checkBackupUID(uid); err != nil {
+		return nil, err
+	}
+	if id == "" || id == "latest" {
+		backups, err := DashboardBackups(ctx, store, instance, uid)
+		if err != nil {
+			return nil, err
+		}
+		id = backups.Backups[0].ID
+	}
+	if _, _, ok := backup.ParseID(id); !ok {
+		return nil, util.BadRequestError("invalid backup id %q, expected <time>-<hash> such as 20240131T120000Z-0123456789abcdef", id)
+	}
+	data, err := store.Get(ctx, backup.DashboardKey(instance, uid, id))
+	if errors.Is(err, backup.ErrNotFound) {
+		return nil, util.NotFoundError("backup %q of dashboard %q not found", id, uid)
+	}
+	if err != nil {
+		return nil, util.Error("Unable to read backup", err)
+	}
+	record := &models.DashboardBackup{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, util.Error("Unable to decode backup", err)
+	}
+	return record, nil
+}
+
+// RestoreBackup saves a backup of a dashboard to the instance, recreating
+// its folder, and the parents of the folder, from the latest backup of the
+// folders when it was deleted. A dashboard of the same uid is a conflict
+// unless overwrite is set.
+func RestoreBackup(g *models.GrafanaClient, ctx context.Context, store backup.Store, instance, BaseURL, APIKey, uid string, req *models.BackupRestoreRequest) (*models.DashboardSaveResult, error) {
+	record, err := GetDashboardBackup(ctx, store, instance, uid, req.Backup)
+	if err != nil {
+		return nil, err
+	}
+	if record.FolderUID != "" {
+		if err := restoreFolder(g, ctx, store, instance, BaseURL, APIKey, record.FolderUID); err != nil {
+			return nil, err
+		}
+	}
+	message := req.Message
+	if message == "" {
+		message = "Restored from backup " + record.ID
+	}
+	return CreateDashboard(g, ctx, BaseURL, APIKey, &models.DashboardSaveRequest{
+		Dashboard: record.Dashboard,
+		FolderUID: record.FolderUID,
+		Overwrite: req.Overwrite,
+		Message:   message,
+	})
+}
+
+func restoreFolder(g *models.GrafanaClient, ctx context.Context, store backup.Store, instance, BaseURL, APIKey, uid string) error {
+	_, err := GetFolder(g, ctx, BaseURL, APIKey, uid)
+	if err == nil || util.ToAPIError(err).Code != util.CodeNotFound {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, backup.FolderPrefix(instance))
+	if err != nil {
+		return util.Error("Unable to list folder backups", err)
+	}
+	if latest == nil {
+		return util.NotFoundError("folder %q of the backup not found and has no backup", uid)
+	}
+	data, err := store.Get(ctx, backup.FolderKey(instance, latest.ID))
+	if err != nil {
+		return util.Error("Unable to read folder backup", err)
+	}
+	record := &models.FolderBackup{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return util.Error("Unable to decode folder backup", err)
+	}
+	byUID := map[string]*models.Folder{}
+	for _, folder := range record.Folders {
+		byUID[folder.UID] = folder
+	}
+	// the folder after its parents
+	chain := []*models.Folder{}
+	for folder := byUID[uid]; folder != nil && len(chain) < len(byUID); folder = byUID[folder.ParentUID] {
+		chain = append([]*models.Folder{folder}, chain...)
+	}
+	if len(chain) == 0 {
+		return util.NotFoundError("folder %q of the backup not found and has no backup", uid)
+	}
+	created, folderErrors := createFolders(g, ctx, BaseURL, APIKey, chain, false)
+	if err := folderErrors[uid]; err != nil {
+		return err
+	}
+	log.Infof("Restored folders %v of instance %s", created, instance)
+	return nil
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"proxy-api-server/backup"
+	"proxy-api-server/config"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"testing"
+)
+
+func TestGetDashboardBackupRejectsInvalidIDs(t *testing.T) {
+	ctx := context.Background()
+	store, err := backup.NewStore(config.Backup{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := "20240131T120000Z-0123456789abcdef"
+	if err := store.Put(ctx, backup.DashboardKey("prod", "abc", id), []byte(`{"id":"`+id+`","uid":"abc"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"latest", id} {
+		if record, err := GetDashboardBackup(ctx, store, "prod", "abc", id); err != nil || record.UID != "abc" {
+			t.Errorf("GetDashboardBackup(%q) = %v, %v, want the backup", id, record, err)
+		}
+	}
+
+	tests := []struct{ uid, id string }{
+		{"abc", "../../../etc/passwd"},
+		{"abc", "20240131T120000Z-../../x"},
+		{"abc", "20240131T120000Z-0123456789ABCDEF"},
+		{"..", id},
+	}
+	for _, tt := range tests {
+		_, err := GetDashboardBackup(ctx, store, "prod", tt.uid, tt.id)
+		if apiErr := util.ToAPIError(err); apiErr.Code != util.CodeBadRequest {
+			t.Errorf("GetDashboardBackup(%q, %q) error = %v, want a bad request", tt.uid, tt.id, err)
+		}
+		_, err = RestoreBackup(util.NewGrafanaClient(), ctx, store, "prod", "http://grafana", "", tt.uid, &models.BackupRestoreRequest{Backup: tt.id})
+		if apiErr := util.ToAPIError(err); apiErr.Code != util.CodeBadRequest {
+			t.Errorf("RestoreBackup(%q, %q) error = %v, want a bad request", tt.uid, tt.id, err)
+		}
+	}
+}
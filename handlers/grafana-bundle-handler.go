@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"proxy-api-server/bundle"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gosimple/slug"
+	"github.com/grafana-tools/sdk"
+)
+
+// maxBundleSize bounds the size of an imported bundle.
+const maxBundleSize = 64 << 20
+
+// GrafanaExportHandler replies with a bundle of the dashboards selected by the request body.
+func GrafanaExportHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	exportReq := &models.BundleExportRequest{}
+	if err := json.NewDecoder(r.Body).Decode(exportReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid export request: %s", err))
+		return
+	}
+	instance := mux.Vars(r)["name"]
+	data, err := ExportBundle(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, instance, exportReq)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	contentType, _ := bundle.ContentType(exportReq.Format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, ExportFileName(instance, exportReq.Format)))
+	_, _ = w.Write(data)
+}
+
+// GrafanaImportHandler imports the bundle of the request body. The input
+// query parameters map the inputs of the bundle to datasources, as
+// NAME=datasource with the name or uid of the datasource.
+func GrafanaImportHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	mapping, err := InputMapping(r.URL.Query()["input"])
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	overwrite := false
+	if v := r.URL.Query().Get("overwrite"); v != "" {
+		if overwrite, err = strconv.ParseBool(v); err != nil {
+			util.WriteError(w, r, util.BadRequestError("invalid overwrite %q", v))
+			return
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleSize+1))
+	if err != nil {
+		util.WriteError(w, r, util.BadRequestError("cannot read bundle: %s", err))
+		return
+	}
+	if len(data) > maxBundleSize {
+		util.WriteError(w, r, util.BadRequestError("bundle is larger than %d bytes", maxBundleSize))
+		return
+	}
+	result, err := ImportBundle(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, data, mapping, overwrite)
+	respondJSON(w, r, result, err)
+}
+
+// InputMapping parses input mappings given as NAME=datasource.
+func InputMapping(values []string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, v := range values {
+		name, datasource, ok := strings.Cut(v, "=")
+		if !ok || name == "" || datasource == "" {
+			return nil, util.BadRequestError("invalid input %q, must be NAME=datasource", v)
+		}
+		mapping[name] = datasource
+	}
+	return mapping, nil
+}
+
+// ExportBundle packages dashboards into a bundle: the dashboards with their
+// datasources replaced by ${DS_*} inputs, in the folders of the instance,
+// and a manifest listing the folders, dashboards and inputs.
+func ExportBundle(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, source string, req *models.BundleExportRequest) ([]byte, error) {
+	if req.Format != "" && req.Format != bundle.FormatZip && req.Format != bundle.FormatTar {
+		return nil, util.BadRequestError("invalid format %q, must be zip or tar", req.Format)
+	}
+	grafanaSdkClient, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, util.BadRequestError("invalid grafanaUrl: %s", err)
+	}
+	datasources, err := grafanaSdkClient.GetAllDatasources(ctx)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	uids, err := exportedDashboards(g, ctx, grafanaSdkClient, BaseURL, APIKey, req)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &models.BundleManifest{
+		Version:    bundle.FormatVersion,
+		Source:     source,
+		Created:    time.Now().UTC(),
+		Inputs:     []*models.BundleInput{},
+		Folders:    []*models.Folder{},
+		Dashboards: []*models.BundleDashboard{},
+	}
+	folders := map[string]*models.Folder{}
+	inputs := map[string]*models.BundleInput{}
+	files := []bundle.File{}
+	for _, uid := range uids {
+		stored, err := getStoredDashboard(g, ctx, BaseURL, APIKey, uid)
+		if err != nil {
+			return nil, err
+		}
+		folderUID := stored.Meta.FolderUID
+		if folderUID != "" {
+			if err := addFolder(g, ctx, BaseURL, APIKey, folders, &manifest.Folders, folderUID); err != nil {
+				return nil, err
+			}
+		}
+		entry := &models.BundleDashboard{UID: uid, FolderUID: folderUID, Inputs: []string{}}
+		entry.Title, _ = stored.Dashboard["title"].(string)
+		for _, input := range bundle.Externalize(stored.Dashboard, datasources) {
+			inputs[input.Name] = input
+			entry.Inputs = append(entry.Inputs, input.Name)
+		}
+		entry.Path = path.Join("dashboards", folderPath(folders, folderUID), uid+".json")
+		data, err := json.MarshalIndent(stored.Dashboard, "", "  ")
+		if err != nil {
+			return nil, util.Error("Unable to encode dashboard", err)
+		}
+		files = append(files, bundle.File{Path: entry.Path, Data: data})
+		manifest.Dashboards = append(manifest.Dashboards, entry)
+	}
+	for _, input := range inputs {
+		manifest.Inputs = append(manifest.Inputs, input)
+	}
+	sort.Slice(manifest.Inputs, func(i, j int) bool { return manifest.Inputs[i].Name < manifest.Inputs[j].Name })
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, util.Error("Unable to encode manifest", err)
+	}
+	files = append([]bundle.File{{Path: bundle.ManifestFile, Data: manifestData}}, files...)
+	buf := &bytes.Buffer{}
+	if err := bundle.Write(buf, req.Format, files); err != nil {
+		return nil, err
+	}
+	log.Infof("Exported %d dashboards in %d folders", len(manifest.Dashboards), len(manifest.Folders))
+	return buf.Bytes(), nil
+}
+
+// exportedDashboards returns the uids of the dashboards selected by the
+// request, every dashboard when none is selected.
+func exportedDashboards(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, BaseURL, APIKey string, req *models.BundleExportRequest) ([]string, error) {
+	uids := []string{}
+	seen := map[string]bool{}
+	add := func(uid string) {
+		if !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+	if len(req.DashboardUIDs) == 0 && len(req.FolderUIDs) == 0 {
+		found, err := c.Search(ctx, sdk.SearchType(sdk.SearchTypeDashboard), sdk.SearchLimit(folderLimit))
+		if err != nil {
+			return nil, util.UpstreamError(err)
+		}
+		for _, board := range found {
+			add(board.UID)
+		}
+		return uids, nil
+	}
+	for _, uid := range req.DashboardUIDs {
+		add(uid)
+	}
+	pending := append([]string{}, req.FolderUIDs...)
+	visited := map[string]bool{}
+	for len(pending) > 0 {
+		uid := pending[0]
+		pending = pending[1:]
+		if visited[uid] {
+			continue
+		}
+		visited[uid] = true
+		folder, err := GetFolder(g, ctx, BaseURL, APIKey, uid)
+		if err != nil {
+			return nil, err
+		}
+		boards, err := folderDashboards(g, ctx, BaseURL, APIKey, folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, board := range boards {
+			add(board.UID)
+		}
+		subfolders, err := ListFolders(g, ctx, BaseURL, APIKey, uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, subfolder := range subfolders {
+			pending = append(pending, subfolder.UID)
+		}
+	}
+	return uids, nil
+}
+
+// addFolder adds a folder to the folders of a bundle after its parents, so
+// that an import creates the parents first.
+func addFolder(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, folders map[string]*models.Folder, list *[]*models.Folder, uid string) error {
+	if _, ok := folders[uid]; ok {
+		return nil
+	}
+	folder, err := GetFolder(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return err
+	}
+	folders[uid] = folder
+	if folder.ParentUID != "" {
+		if err := addFolder(g, ctx, BaseURL, APIKey, folders, list, folder.ParentUID); err != nil {
+			return err
+		}
+	}
+	*list = append(*list, folder)
+	return nil
+}
+
+// folderPath returns the path of the dashboards of a folder in a bundle,
+// the slugs of the titles of the folder and its parents.
+func folderPath(folders map[string]*models.Folder, uid string) string {
+	if uid == "" {
+		return "general"
+	}
+	parts := []string{}
+	for folder, depth := folders[uid], 0; folder != nil && depth < len(folders); folder, depth = folders[folder.ParentUID], depth+1 {
+		parts = append([]string{slug.Make(folder.Title)}, parts...)
+	}
+	return path.Join(parts...)
+}
+
+// ImportBundle imports the dashboards of a bundle with their inputs mapped
+// to datasources of the instance, see bundle.ResolveInputs, and creates the
+// missing folders. A dashboard that cannot be imported is reported in the
+// result and does not stop the import of the others.
+func ImportBundle(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, data []byte, mapping map[string]string, overwrite bool) (*models.BundleImportResult, error) {
+	files, err := bundle.Read(data)
+	if err != nil {
+		return nil, util.BadRequestError("invalid bundle: %s", err)
+	}
+	byPath := map[string][]byte{}
+	for _, f := range files {
+		byPath[f.Path] = f.Data
+	}
+	manifestData, ok := byPath[bundle.ManifestFile]
+	if !ok {
+		return nil, util.BadRequestError("invalid bundle: no %s", bundle.ManifestFile)
+	}
+	manifest := &models.BundleManifest{}
+	if err := json.Unmarshal(manifestData, manifest); err != nil {
+		return nil, util.BadRequestError("invalid bundle manifest: %s", err)
+	}
+	if manifest.Version > bundle.FormatVersion {
+		return nil, util.BadRequestError("unsupported bundle version %d", manifest.Version)
+	}
+
+	grafanaSdkClient, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, util.BadRequestError("invalid grafanaUrl: %s", err)
+	}
+	datasources, err := grafanaSdkClient.GetAllDatasources(ctx)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	resolved, err := bundle.ResolveInputs(manifest.Inputs, datasources, mapping)
+	if err != nil {
+		return nil, util.BadRequestError("%s", err)
+	}
+
+	result := &models.BundleImportResult{
+		Inputs:         map[string]string{},
+		FoldersCreated: []string{},
+		Dashboards:     []*models.BundleDashboardResult{},
+	}
+	for name, ds := range resolved {
+		result.Inputs[name] = ds.Name
+	}
+	var folderErrors map[string]error
+	result.FoldersCreated, folderErrors = createFolders(g, ctx, BaseURL, APIKey, manifest.Folders, false)
+
+	imported := 0
+	for _, entry := range manifest.Dashboards {
+		dashboardResult := &models.BundleDashboardResult{UID: entry.UID, Title: entry.Title}
+		result.Dashboards = append(result.Dashboards, dashboardResult)
+		saved, err := importDashboard(g, ctx, BaseURL, APIKey, byPath, entry, resolved, folderErrors, overwrite)
+		if err != nil {
+			dashboardResult.Status, dashboardResult.Error = "failed", err.Error()
+			continue
+		}
+		imported++
+		dashboardResult.Status, dashboardResult.Version, dashboardResult.URL = "imported", saved.Version, saved.URL
+	}
+	log.Infof("Imported %d of %d dashboards, created %d folders", imported, len(manifest.Dashboards), len(result.FoldersCreated))
+	return result, nil
+}
+
+// createFolders creates the folders missing in an instance, the parents
+// before their subfolders, and returns the uids of the folders created and
+// the errors of the folders that could not be, or of their subfolders. On a
+// dry run the folders are not created.
+func createFolders(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, folders []*models.Folder, dryRun bool) ([]string, map[string]error) {
+	created := []string{}
+	folderErrors := map[string]error{}
+	for _, folder := range folders {
+		if err := folderErrors[folder.ParentUID]; err != nil {
+			folderErrors[folder.UID] = err
+			continue
+		}
+		_, err := GetFolder(g, ctx, BaseURL, APIKey, folder.UID)
+		if err == nil {
+			continue
+		}
+		if util.ToAPIError(err).Code == util.CodeNotFound {
+			if dryRun {
+				created = append(created, folder.UID)
+				continue
+			}
+			_, err = CreateFolder(g, ctx, BaseURL, APIKey, &models.FolderCreateRequest{UID: folder.UID, Title: folder.Title, ParentUID: folder.ParentUID})
+			if err == nil {
+				created = append(created, folder.UID)
+				continue
+			}
+		}
+		folderErrors[folder.UID] = fmt.Errorf("folder %q: %w", folder.Title, err)
+	}
+	return created, folderErrors
+}
+
+func importDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, files map[string][]byte, entry *models.BundleDashboard, inputs map[string]sdk.Datasource, folderErrors map[string]error, overwrite bool) (*models.DashboardSaveResult, error) {
+	if err := folderErrors[entry.FolderUID]; err != nil {
+		return nil, err
+	}
+	data, ok := files[entry.Path]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found in the bundle", entry.Path)
+	}
+	dashboard := map[string]interface{}{}
+	if err := json.Unmarshal(data, &dashboard); err != nil {
+		return nil, fmt.Errorf("invalid dashboard %s: %w", entry.Path, err)
+	}
+	if err := bundle.Internalize(dashboard, inputs); err != nil {
+		return nil, err
+	}
+	return CreateDashboard(g, ctx, BaseURL, APIKey, &models.DashboardSaveRequest{
+		Dashboard: dashboard,
+		FolderUID: entry.FolderUID,
+		Overwrite: overwrite,
+		Message:   "Imported from bundle",
+	})
+}
+
+// ExportFileName returns the file name of a bundle exported from an instance.
+func ExportFileName(instance, format string) string {
+	_, extension := bundle.ContentType(format)
+	return url.PathEscape(instance) + "-dashboards" + extension
+}
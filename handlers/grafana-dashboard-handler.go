@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"github.com/grafana-tools/sdk"
 	"net/http"
+	"proxy-api-server/coalesce"
 	"proxy-api-server/helpers"
 	"proxy-api-server/log"
 	"proxy-api-server/models"
 	"proxy-api-server/util"
+	"strconv"
 	"strings"
 )
 
@@ -21,11 +23,15 @@ func GetGrafanaDashbordByUidHandler(w http.ResponseWriter, r *http.Request) {
 	uid := r.URL.Query().Get("uid")
 	if grafanaUrl == "" {
 		log.Error("Grafana url not provided")
-		http.Error(w, fmt.Sprintf("Grafana url not provided"), http.StatusBadRequest)
+		util.WriteError(w, r, util.MissingParameterError("grafanaUrl", "Grafana url not provided"))
 		return
 	} else if apiKey == "" {
 		log.Error("Grafana api key (userId:password) not provided")
-		http.Error(w, fmt.Sprintf("Grafana api key (userId:password) not provided"), http.StatusBadRequest)
+		util.WriteError(w, r, util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided"))
+		return
+	} else if uid == "" {
+		log.Error("Dashboard uid not provided")
+		util.WriteError(w, r, util.MissingParameterError("uid", "Dashboard uid not provided"))
 		return
 	}
 
@@ -39,14 +45,13 @@ func GetGrafanaDashbordByUidHandler(w http.ResponseWriter, r *http.Request) {
 	grafanaSdkClient, err := sdk.NewClient(grafanaUrl, apiKey, client.HttpClient)
 	if err != nil {
 		log.Error("Error in grafana sdk client: ", err)
-		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
+		util.WriteError(w, r, util.BadRequestError("invalid grafanaUrl: %s", err))
 		return
 	}
 
 	br, brProp, err := grafanaSdkClient.GetDashboardByUID(req.Context(), uid)
 	if err != nil {
-		log.Errorf("Error in getting dashboard by UID: %s, Error : %s", uid, err)
-		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
+		util.WriteError(w, r, util.ResourceError(err, "dashboard", uid))
 		return
 	}
 	grafBoard := &models.GrafanaDashboard{
@@ -55,9 +60,9 @@ func GetGrafanaDashbordByUidHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	//json.NewEncoder(w).Encode(grafBoard)
 
+	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(grafBoard); err != nil {
 		util.Error("Http request failed: ", err)
-		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -71,9 +76,11 @@ func GrafanaDashboardHandler(w http.ResponseWriter, r *http.Request) {
 	apiKey := r.URL.Query().Get("apiKey")
 	if grafanaUrl == "" {
 		log.Error("Grafana url not provided")
+		util.WriteError(w, r, util.MissingParameterError("grafanaUrl", "Grafana url not provided"))
 		return
 	} else if apiKey == "" {
 		log.Error("Grafana api key (userId:password) not provided")
+		util.WriteError(w, r, util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided"))
 		return
 	}
 	pref := &models.Preference{
@@ -87,12 +94,20 @@ func GrafanaDashboardHandler(w http.ResponseWriter, r *http.Request) {
 		FirstName: "admin",
 	}
 
-	b := GrafanaBoardsHandler(pref, user)
-	json.NewEncoder(w).Encode(b)
+	b, err := GrafanaBoardsHandler(pref, user)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		util.Error("Http request failed: ", err)
+		return
+	}
 	log.Info("GrafanaDashboardHandler completed")
 }
 
-func GrafanaBoardsHandler(prefObj *models.Preference, user *models.User) []*models.GrafanaBoard {
+func GrafanaBoardsHandler(prefObj *models.Preference, user *models.User) ([]*models.GrafanaBoard, error) {
 	// if req.Method != http.MethodGet && req.Method != http.MethodPost {
 	// 	w.WriteHeader(http.StatusNotFound)
 	// 	return
@@ -109,7 +124,7 @@ func GrafanaBoardsHandler(prefObj *models.Preference, user *models.User) []*mode
 		// h.log.Error(ErrGrafanaConfig)
 		// http.Error(w, "Invalid grafana endpoint", http.StatusBadRequest)
 		log.Error("Grafana url not provided")
-		return nil
+		return nil, util.MissingParameterError("grafanaUrl", "Grafana url not provided")
 	}
 	req := &http.Request{
 		Method: "GET",
@@ -119,7 +134,7 @@ func GrafanaBoardsHandler(prefObj *models.Preference, user *models.User) []*mode
 		// h.log.Error(ErrGrafanaScan(err))
 		// http.Error(w, "Unable to connect to grafana", http.StatusInternalServerError)
 		log.Error("Unable to connect to grafana")
-		return nil
+		return nil, err
 	}
 
 	var dashboardSearch = "" //req.URL.Query().Get("dashboardSearch")
@@ -127,7 +142,7 @@ func GrafanaBoardsHandler(prefObj *models.Preference, user *models.User) []*mode
 	if err != nil {
 		// h.log.Error(ErrGrafanaBoards(err))
 		// http.Error(w, "unable to get grafana boards", http.StatusInternalServerError)
-		return nil
+		return nil, err
 	}
 	// fmt.Println(boards)
 	w := new(bytes.Buffer)
@@ -138,25 +153,42 @@ func GrafanaBoardsHandler(prefObj *models.Preference, user *models.User) []*mode
 		// h.log.Error(ErrMarshal(err, obj))
 		// http.Error(w, "Unable to marshal the boards payload", http.StatusInternalServerError)
 		log.Error("Unable to marshal the boards payload: ", err)
-		return nil
+		return nil, err
 	}
 	log.Info("GrafanaBoardsHandler completed: ")
-	return boards
+	return boards, nil
 }
 
+// dashboardCatalogs coalesces concurrent builds of the same dashboard catalog.
+var dashboardCatalogs = coalesce.NewGroup("dashboard_catalog", nil)
+
+// GetGrafanaBoards builds the catalog of dashboards matching dashboardSearch.
+// Identical concurrent builds, for the same instance, search and credential,
+// share a single fan-out to Grafana.
 func GetGrafanaBoards(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, dashboardSearch string) ([]*models.GrafanaBoard, error) {
+	key := coalesce.Key(strings.TrimSuffix(BaseURL, "/"), dashboardSearch, coalesce.CredentialIdentity(APIKey), strconv.FormatBool(g.PromMode))
+	boards, err, _ := dashboardCatalogs.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return getGrafanaBoards(g, ctx, BaseURL, APIKey, dashboardSearch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return boards.([]*models.GrafanaBoard), nil
+}
+
+func getGrafanaBoards(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, dashboardSearch string) ([]*models.GrafanaBoard, error) {
 	log.Info("Starting GetGrafanaBoards")
 	if strings.HasSuffix(BaseURL, "/") {
 		BaseURL = strings.Trim(BaseURL, "/")
 	}
 	c, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
 	if err != nil {
-		return nil, util.CommonError(err)
+		return nil, util.UpstreamError(err)
 	}
 
 	boardLinks, err := c.SearchDashboards(ctx, dashboardSearch, false)
 	if err != nil {
-		return nil, util.CommonError(err)
+		return nil, util.UpstreamError(err)
 	}
 	boards := []*models.GrafanaBoard{}
 	for _, link := range boardLinks {
@@ -168,7 +200,7 @@ func GetGrafanaBoards(g *models.GrafanaClient, ctx context.Context, BaseURL, API
 		// fmt.Println("DashBoard...... ", board)
 		if err != nil {
 			log.Error("ERROR in calling GetDashboardByUID: ", err)
-			return nil, util.DashboardError(err, link.UID)
+			return nil, util.ResourceError(err, "dashboard", link.UID)
 		}
 		// b, _ := json.Marshal(board)
 		// logrus.Debugf("Board before foramating: %s", b)
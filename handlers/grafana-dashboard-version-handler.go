@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"proxy-api-server/dashdiff"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"strconv"
+
+	"github.com/grafana-tools/sdk"
+)
+
+// GrafanaDashboardVersionsHandler lists the versions of the dashboard of the uid query parameter, latest first.
+func GrafanaDashboardVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	limit, err := intParam(r, "limit")
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	start, err := intParam(r, "start")
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	versions, err := ListDashboardVersions(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), limit, start)
+	respondJSON(w, r, versions, err)
+}
+
+// GrafanaDashboardVersionHandler gets a version of the dashboard of the uid query parameter with its JSON model.
+func GrafanaDashboardVersionHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	version, err := intParam(r, "version")
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	detail, err := GetDashboardVersion(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), version)
+	respondJSON(w, r, detail, err)
+}
+
+// GrafanaDashboardDiffHandler compares the from and to versions of the
+// dashboard of the uid query parameter, to the current version when to is not set.
+func GrafanaDashboardDiffHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	from, err := intParam(r, "from")
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	to, err := intParam(r, "to")
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	diff, err := DiffDashboardVersions(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), from, to)
+	respondJSON(w, r, diff, err)
+}
+
+// GrafanaDashboardRestoreHandler restores a version of the dashboard of the uid query parameter.
+func GrafanaDashboardRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	restoreReq := &models.DashboardRestoreRequest{}
+	if err := json.NewDecoder(r.Body).Decode(restoreReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid restore request: %s", err))
+		return
+	}
+	result, err := RestoreDashboardVersion(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), restoreReq)
+	respondJSON(w, r, result, err)
+}
+
+// intParam returns an integer query parameter, 0 when not set.
+func intParam(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, util.BadRequestError("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// versionedDashboard returns the sdk client of the instance with the id and
+// current version of the dashboard of the uid, the versions API of Grafana
+// takes dashboard ids.
+func versionedDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string) (*sdk.Client, uint, int, error) {
+	grafanaSdkClient, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, 0, 0, util.BadRequestError("invalid grafanaUrl: %s", err)
+	}
+	board, props, err := grafanaSdkClient.GetDashboardByUID(ctx, uid)
+	if err != nil {
+		return nil, 0, 0, util.ResourceError(err, "dashboard", uid)
+	}
+	return grafanaSdkClient, board.ID, props.Version, nil
+}
+
+// ListDashboardVersions returns the versions of the dashboard of the uid,
+// latest first. limit and start are not applied when 0.
+func ListDashboardVersions(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, limit, start int) ([]*models.DashboardVersion, error) {
+	grafanaSdkClient, id, _, err := versionedDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	params := []sdk.QueryParam{}
+	if limit > 0 {
+		params = append(params, sdk.QueryParamLimit(uint(limit)))
+	}
+	if start > 0 {
+		params = append(params, sdk.QueryParamStart(uint(start)))
+	}
+	sdkVersions, err := grafanaSdkClient.GetDashboardVersionsByDashboardID(ctx, id, params...)
+	if err != nil {
+		return nil, util.ResourceError(err, "dashboard", uid)
+	}
+	versions := make([]*models.DashboardVersion, 0, len(sdkVersions))
+	for _, v := range sdkVersions {
+		versions = append(versions, &models.DashboardVersion{
+			Version:       int(v.Version),
+			ParentVersion: int(v.ParentVersion),
+			RestoredFrom:  int(v.RestoredFrom),
+			Created:       v.Created,
+			CreatedBy:     v.CreatedBy,
+			Message:       v.Message,
+		})
+	}
+	return versions, nil
+}
+
+// GetDashboardVersion returns a version of the dashboard of the uid with its JSON model.
+func GetDashboardVersion(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, version int) (*models.DashboardVersionDetail, error) {
+	_, id, _, err := versionedDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	return getVersion(g, ctx, BaseURL, APIKey, uid, id, version)
+}
+
+// getVersion reads a version by dashboard id, which the sdk has no call for.
+func getVersion(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, id uint, version int) (*models.DashboardVersionDetail, error) {
+	reqURL := fmt.Sprintf("%s/api/dashboards/id/%d/versions/%d", BaseURL, id, version)
+	data, status, err := g.GetRequest(ctx, reqURL, APIKey)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	if status != http.StatusOK {
+		upstreamErr := models.NewUpstreamError(status, reqURL, data)
+		log.Errorf("Error: dashboard %s version %d: %s", uid, version, upstreamErr)
+		if status == http.StatusNotFound {
+			notFound := util.NotFoundError("version %d of dashboard %q not found", version, uid)
+			notFound.Upstream, notFound.Err = upstreamErr, upstreamErr
+			return nil, notFound
+		}
+		return nil, upstreamErr
+	}
+	var decoded struct {
+		models.DashboardVersion
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, util.Error("Unable to decode dashboard version", err)
+	}
+	if decoded.Data == nil {
+		return nil, fmt.Errorf("version %d of dashboard %q has no JSON model", version, uid)
+	}
+	return &models.DashboardVersionDetail{DashboardVersion: decoded.DashboardVersion, Dashboard: decoded.Data}, nil
+}
+
+// DiffDashboardVersions compares two versions of the dashboard of the uid,
+// to is the current version when 0.
+func DiffDashboardVersions(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, from, to int) (*models.DashboardDiff, error) {
+	_, id, current, err := versionedDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 {
+		to = current
+	}
+	before, err := getVersion(g, ctx, BaseURL, APIKey, uid, id, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := getVersion(g, ctx, BaseURL, APIKey, uid, id, to)
+	if err != nil {
+		return nil, err
+	}
+	diff := dashdiff.Diff(before.Dashboard, after.Dashboard)
+	diff.UID, diff.From, diff.To = uid, from, to
+	return diff, nil
+}
+
+// RestoreDashboardVersion saves a version of the dashboard of the uid as its
+// new version, through UpdateDashboard so that a dashboard changed since
+// the current version of the request is a conflict.
+func RestoreDashboardVersion(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, req *models.DashboardRestoreRequest) (*models.DashboardSaveResult, error) {
+	_, id, current, err := versionedDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	if req.Version == current {
+		return nil, util.BadRequestError("version %d is the current version of dashboard %q", req.Version, uid)
+	}
+	restored, err := getVersion(g, ctx, BaseURL, APIKey, uid, id, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	if req.CurrentVersion != 0 {
+		current = req.CurrentVersion
+	}
+	message := req.Message
+	if message == "" {
+		message = fmt.Sprintf("Restored from version %d", req.Version)
+	}
+	// the JSON model is decoded by encoding/json, versions are float64
+	restored.Dashboard["version"] = float64(current)
+	return UpdateDashboard(g, ctx, BaseURL, APIKey, uid, &models.DashboardSaveRequest{
+		Dashboard: restored.Dashboard,
+		Message:   message,
+	})
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"strconv"
+	"strings"
+
+	"github.com/grafana-tools/sdk"
+)
+
+// GrafanaDashboardSaveHandler creates a dashboard, or replaces it when
+// overwrite is set.
+func GrafanaDashboardSaveHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	saveReq := &models.DashboardSaveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(saveReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid dashboard: %s", err))
+		return
+	}
+	result, err := CreateDashboard(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, saveReq)
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaDashboardUpdateHandler updates the dashboard of the uid query parameter.
+func GrafanaDashboardUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	saveReq := &models.DashboardSaveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(saveReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid dashboard: %s", err))
+		return
+	}
+	result, err := UpdateDashboard(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), saveReq)
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaDashboardMoveHandler moves the dashboard of the uid query parameter to another folder.
+func GrafanaDashboardMoveHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	moveReq := &models.DashboardMoveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(moveReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid move request: %s", err))
+		return
+	}
+	result, err := MoveDashboard(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), moveReq)
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaDashboardDeleteHandler deletes the dashboard of the uid query
+// parameter. When the version query parameter is set, the dashboard is only
+// deleted if it was not changed since that version.
+func GrafanaDashboardDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			util.WriteError(w, r, util.BadRequestError("invalid version %q", v))
+			return
+		}
+	}
+	result, err := DeleteDashboard(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), version)
+	respondJSON(w, r, result, err)
+}
+
+// grafanaCredentials returns the grafanaUrl and apiKey query parameters.
+func grafanaCredentials(r *http.Request) (string, string, error) {
+	grafanaUrl := r.URL.Query().Get("grafanaUrl")
+	apiKey := r.URL.Query().Get("apiKey")
+	if grafanaUrl == "" {
+		return "", "", util.MissingParameterError("grafanaUrl", "Grafana url not provided")
+	} else if apiKey == "" {
+		return "", "", util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided")
+	}
+	return strings.TrimSuffix(grafanaUrl, "/"), apiKey, nil
+}
+
+// storedDashboard is a dashboard as read from Grafana, with its JSON model
+// kept as is so that saving it back does not drop the fields the sdk does not know.
+type storedDashboard struct {
+	Dashboard map[string]interface{} `json:"dashboard"`
+	Meta      struct {
+		Version   int    `json:"version"`
+		FolderID  int    `json:"folderId"`
+		FolderUID string `json:"folderUid"`
+	} `json:"meta"`
+}
+
+func getStoredDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string) (*storedDashboard, error) {
+	reqURL := BaseURL + "/api/dashboards/uid/" + url.PathEscape(uid)
+	data, status, err := g.GetRequest(ctx, reqURL, APIKey)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	if status != http.StatusOK {
+		return nil, util.ResourceError(models.NewUpstreamError(status, reqURL, data), "dashboard", uid)
+	}
+	stored := &storedDashboard{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		return nil, util.Error("Unable to decode dashboard", err)
+	}
+	if stored.Dashboard == nil {
+		return nil, fmt.Errorf("dashboard %q has no JSON model", uid)
+	}
+	return stored, nil
+}
+
+// CreateDashboard saves a new dashboard. A dashboard with the same uid or
+// title in the folder is a conflict, unless overwrite is set.
+func CreateDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, req *models.DashboardSaveRequest) (*models.DashboardSaveResult, error) {
+	if req.Dashboard == nil {
+		return nil, util.BadRequestError("dashboard is required")
+	}
+	// the id is the one of the instance it was read from
+	delete(req.Dashboard, "id")
+	if !req.Overwrite {
+		delete(req.Dashboard, "version")
+	}
+	return saveDashboard(g, ctx, BaseURL, APIKey, req.Dashboard, req.FolderUID, 0, req.Overwrite, req.Message)
+}
+
+// UpdateDashboard saves a new version of the dashboard of the uid. Unless
+// overwrite is set, the version of the dashboard must be its current one.
+// The dashboard stays in its folder when no folder is given.
+func UpdateDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, req *models.DashboardSaveRequest) (*models.DashboardSaveResult, error) {
+	if req.Dashboard == nil {
+		return nil, util.BadRequestError("dashboard is required")
+	}
+	if dashboardUID, ok := req.Dashboard["uid"].(string); ok && dashboardUID != "" && dashboardUID != uid {
+		return nil, util.BadRequestError("dashboard uid %q does not match %q", dashboardUID, uid)
+	}
+	stored, err := getStoredDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !req.Overwrite {
+		version, ok := dashboardVersion(req.Dashboard)
+		if !ok {
+			return nil, util.BadRequestError("dashboard version is required unless overwrite is set")
+		}
+		if err := checkVersion(uid, version, stored.Meta.Version); err != nil {
+			return nil, err
+		}
+	}
+	req.Dashboard["uid"] = uid
+	req.Dashboard["id"] = stored.Dashboard["id"]
+	folderUID, folderID := req.FolderUID, 0
+	if folderUID == "" {
+		folderUID, folderID = stored.Meta.FolderUID, stored.Meta.FolderID
+	}
+	return saveDashboard(g, ctx, BaseURL, APIKey, req.Dashboard, folderUID, folderID, req.Overwrite, req.Message)
+}
+
+// MoveDashboard saves the dashboard of the uid in another folder.
+func MoveDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, req *models.DashboardMoveRequest) (*models.DashboardSaveResult, error) {
+	stored, err := getStoredDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	if req.Version != 0 {
+		if err := checkVersion(uid, req.Version, stored.Meta.Version); err != nil {
+			return nil, err
+		}
+	}
+	if stored.Meta.FolderUID == req.FolderUID && (req.FolderUID != "" || stored.Meta.FolderID == 0) {
+		return nil, util.BadRequestError("dashboard %q is already in the folder", uid)
+	}
+	message := req.Message
+	if message == "" {
+		message = "Moved to folder " + req.FolderUID
+		if req.FolderUID == "" {
+			message = "Moved to folder General"
+		}
+	}
+	// the version read is saved, so that a change since then is a conflict
+	stored.Dashboard["version"] = stored.Meta.Version
+	return saveDashboard(g, ctx, BaseURL, APIKey, stored.Dashboard, req.FolderUID, 0, false, message)
+}
+
+// DeleteDashboard deletes the dashboard of the uid. When version is not 0,
+// a dashboard changed since that version is a conflict.
+func DeleteDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, version int) (*models.DashboardDeleteResult, error) {
+	// the sdk does not report the status of deletes, a missing dashboard is found here
+	stored, err := getStoredDashboard(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 {
+		if err := checkVersion(uid, version, stored.Meta.Version); err != nil {
+			return nil, err
+		}
+	}
+	grafanaSdkClient, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, util.BadRequestError("invalid grafanaUrl: %s", err)
+	}
+	status, err := grafanaSdkClient.DeleteDashboardByUID(ctx, uid)
+	if err != nil {
+		return nil, util.ResourceError(err, "dashboard", uid)
+	}
+	result := &models.DashboardDeleteResult{UID: uid}
+	result.Title, _ = stored.Dashboard["title"].(string)
+	if status.Message != nil {
+		result.Message = *status.Message
+	}
+	log.Infof("Deleted dashboard %s at version %d", uid, stored.Meta.Version)
+	return result, nil
+}
+
+// saveDashboard posts a dashboard to the dashboards API of Grafana. The sdk
+// SetDashboard is not used, it has no folder uid nor message and drops the
+// fields of the JSON model it does not know.
+func saveDashboard(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, dashboard map[string]interface{}, folderUID string, folderID int, overwrite bool, message string) (*models.DashboardSaveResult, error) {
+	payload := map[string]interface{}{
+		"dashboard": dashboard,
+		"overwrite": overwrite,
+		"message":   message,
+	}
+	if folderUID != "" {
+		payload["folderUid"] = folderUID
+	} else {
+		payload["folderId"] = folderID
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, util.Error("Unable to encode dashboard", err)
+	}
+	reqURL := BaseURL + "/api/dashboards/db"
+	data, status, err := g.PostRequest(ctx, reqURL, APIKey, body)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	if status != http.StatusOK {
+		uid, _ := dashboard["uid"].(string)
+		return nil, saveError(models.NewUpstreamError(status, reqURL, data), uid)
+	}
+	result := &models.DashboardSaveResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, util.Error("Unable to decode save result", err)
+	}
+	log.Infof("Saved dashboard %s version %d: %s", result.UID, result.Version, message)
+	return result, nil
+}
+
+// saveError reports the saves Grafana rejects as a precondition failure,
+// such as a changed version or a dashboard with the same title or uid, as
+// conflicts.
+func saveError(upstreamErr *models.UpstreamError, uid string) error {
+	log.Errorf("Error: saving dashboard %s: %s", uid, upstreamErr)
+	if upstreamErr.Status != http.StatusPreconditionFailed {
+		return upstreamErr
+	}
+	conflict := util.NewError(util.CodeConflict, "dashboard %q not saved: %s", uid, upstreamErr.Message)
+	conflict.Upstream, conflict.Err = upstreamErr, upstreamErr
+	return conflict
+}
+
+// checkVersion returns a conflict when the dashboard was changed since the given version.
+func checkVersion(uid string, version, current int) error {
+	if version == current {
+		return nil
+	}
+	conflict := util.NewError(util.CodeConflict, "dashboard %q was changed: version %d, current version %d", uid, version, current)
+	conflict.Details = map[string]int{"version": version, "currentVersion": current}
+	return conflict
+}
+
+// dashboardVersion returns the version of a JSON model decoded by encoding/json.
+func dashboardVersion(dashboard map[string]interface{}) (int, bool) {
+	version, ok := dashboard["version"].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int(version), true
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"proxy-api-server/dataframe"
+	"proxy-api-server/interpolate"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/timerange"
+	"proxy-api-server/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// interpolatedQueryFields are the datasource query fields holding the query
+// text, in which template variables are replaced.
+var interpolatedQueryFields = []string{"expr", "rawSql", "query", "target", "expression"}
+
+// GrafanaDataSourceQueryHandler forwards queries of any datasource type through Grafana's unified /api/ds/query API
+func GrafanaDataSourceQueryHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl := r.URL.Query().Get("grafanaUrl")
+	apiKey := r.URL.Query().Get("apiKey")
+	if grafanaUrl == "" {
+		log.Error("Grafana url not provided")
+		util.WriteError(w, r, util.MissingParameterError("grafanaUrl", "Grafana url not provided"))
+		return
+	} else if apiKey == "" {
+		log.Error("Grafana api key (userId:password) not provided")
+		util.WriteError(w, r, util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided"))
+		return
+	}
+
+	dsQuery := &models.DataSourceQueryRequest{}
+	if err := json.NewDecoder(r.Body).Decode(dsQuery); err != nil {
+		util.Error("Cannot read request body.", err)
+		util.WriteError(w, r, util.BadRequestError("invalid datasource query body: %s", err))
+		return
+	}
+	if err := validateDataSourceQuery(dsQuery); err != nil {
+		util.WriteError(w, r, util.BadRequestError("%s", err))
+		return
+	}
+
+	client := util.NewGrafanaClient()
+	results, err := GrafanaDataSourceQuery(client, r.Context(), strings.TrimSuffix(grafanaUrl, "/"), apiKey, dsQuery)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&models.DataSourceQueryResponse{Results: results}); err != nil {
+		util.Error("Http request failed: ", err)
+	}
+}
+
+func validateDataSourceQuery(dsQuery *models.DataSourceQueryRequest) error {
+	if len(dsQuery.Queries) == 0 {
+		return fmt.Errorf("datasource query request holds no queries")
+	}
+	refIDs := map[string]bool{}
+	for i, q := range dsQuery.Queries {
+		if q == nil {
+			return fmt.Errorf("query %d is null", i)
+		}
+		if q.RefID == "" {
+			return fmt.Errorf("query %d has no refId", i)
+		}
+		if refIDs[q.RefID] {
+			return fmt.Errorf("duplicate refId %q", q.RefID)
+		}
+		refIDs[q.RefID] = true
+		if q.Datasource == nil || q.Datasource.UID == "" || q.Datasource.Type == "" {
+			return fmt.Errorf("query %q must reference its datasource by uid and type", q.RefID)
+		}
+	}
+	return nil
+}
+
+// GrafanaDataSourceQuery runs the queries through /api/ds/query and decodes
+// the returned data frames. Template variables are interpolated with the
+// formatting rules of each query's datasource type.
+func GrafanaDataSourceQuery(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, dsQuery *models.DataSourceQueryRequest) ([]*models.DataSourceQueryResult, error) {
+	now := time.Now()
+	from, to := dsQuery.From, dsQuery.To
+	if from == "" {
+		from = "now-" + timerange.FormatDuration(timerange.DefaultRange)
+	}
+	if to == "" {
+		to = "now"
+	}
+	fromTime, err := timerange.ParseTime(from, now, false)
+	if err != nil {
+		return nil, &timerange.ParamError{Param: "from", Value: from, Reason: err.Error()}
+	}
+	toTime, err := timerange.ParseTime(to, now, true)
+	if err != nil {
+		return nil, &timerange.ParamError{Param: "to", Value: to, Reason: err.Error()}
+	}
+	if toTime.Before(fromTime) {
+		return nil, &timerange.ParamError{Param: "to", Value: to, Reason: "to must not be before from"}
+	}
+
+	vars := interpolate.Variables{}
+	for name, values := range dsQuery.Variables {
+		vars[name] = &interpolate.Variable{Name: name, Values: values, Multi: len(values) > 1}
+	}
+	restricted, err := tenancy.Restricted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dsTypes := map[string]string{}
+	refIDs := []string{}
+	for _, q := range dsQuery.Queries {
+		refIDs = append(refIDs, q.RefID)
+		if restricted {
+			// the type sent by the caller cannot be trusted to decide how the query is restricted
+			if q.Datasource.Type, err = dataSourceType(g, ctx, BaseURL, APIKey, q.Datasource.UID, dsTypes); err != nil {
+				return nil, err
+			}
+		}
+		dialect := interpolate.DialectFor(q.Datasource.Type)
+		for _, field := range interpolatedQueryFields {
+			if text, ok := q.Model[field].(string); ok {
+				q.Model[field] = interpolate.Interpolate(text, vars, dialect)
+			}
+		}
+		if !restricted {
+			continue
+		}
+		// only PromQL can be rewritten to the series of the caller's tenant
+		if q.Datasource.Type != "prometheus" {
+			return nil, &tenancy.Error{Tenant: tenancy.TenantFromContext(ctx), Reason: fmt.Sprintf("query %q of a %s datasource cannot be restricted to a tenant", q.RefID, q.Datasource.Type)}
+		}
+		expr, ok := q.Model["expr"].(string)
+		if !ok {
+			return nil, &tenancy.Error{Tenant: tenancy.TenantFromContext(ctx), Reason: fmt.Sprintf("query %q has no expr to restrict to a tenant", q.RefID)}
+		}
+		if q.Model["expr"], err = tenancy.Enforce(ctx, expr); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"from":    strconv.FormatInt(fromTime.UnixMilli(), 10),
+		"to":      strconv.FormatInt(toTime.UnixMilli(), 10),
+		"queries": dsQuery.Queries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	reqURL := BaseURL + "/api/ds/query"
+	data, statusCode, err := g.PostRequest(ctx, reqURL, APIKey, body)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	results, decodeErr := dataframe.Decode(data, refIDs)
+	if decodeErr != nil || len(results) == 0 {
+		// Grafana replies with per query errors in the body, other failures have no results
+		if statusCode != http.StatusOK {
+			return nil, models.NewUpstreamError(statusCode, reqURL, data)
+		}
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+	}
+	return results, nil
+}
+
+// dataSourceType returns the type of the datasource with the given uid, as
+// Grafana knows it. Types are remembered in types for the queries of a request.
+func dataSourceType(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, types map[string]string) (string, error) {
+	if dsType, ok := types[uid]; ok {
+		return dsType, nil
+	}
+	data, err := g.MakeRequest(ctx, BaseURL+"/api/datasources/uid/"+url.PathEscape(uid), APIKey)
+	if err != nil {
+		return "", util.UpstreamError(err)
+	}
+	var ds struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &ds); err != nil {
+		return "", util.UpstreamError(err)
+	}
+	types[uid] = ds.Type
+	return ds.Type, nil
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"proxy-api-server/config"
+	"proxy-api-server/models"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/util"
+	"strings"
+	"testing"
+)
+
+// newFakeGrafana serves the datasources prom and logs, and answers
+// /api/ds/query with the status of the query named status, sending the
+// queries it received to sent.
+func newFakeGrafana(t *testing.T, sent chan<- string) *httptest.Server {
+	types := map[string]string{"prom": "prometheus", "logs": "loki"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if uid := strings.TrimPrefix(r.URL.Path, "/api/datasources/uid/"); uid != r.URL.Path {
+			if types[uid] == "" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"message":"Data source not found"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"uid":"` + uid + `","type":"` + types[uid] + `"}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		sent <- string(body)
+		if strings.Contains(string(body), "unavailable") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"message":"datasource unavailable"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"results":{"A":{"status":200,"frames":[]}}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGrafanaDataSourceQuery(t *testing.T) {
+	conf := config.NewConfig()
+	conf.Tenancy.RequireTenant = false
+	conf.Tenancy.Tenants = map[string][]string{"team-a": {`namespace="a"`}}
+	config.Set(conf)
+
+	tests := []struct {
+		name   string
+		tenant string
+		query  string
+		// sent is the expr Grafana receives, empty when the query is rejected
+		sent         string
+		wantTenancy  bool
+		wantUpstream int
+	}{
+		{
+			name:  "unrestricted",
+			query: `{"refId":"A","datasource":{"uid":"prom","type":"prometheus"},"expr":"up"}`,
+			sent:  `up`,
+		},
+		{
+			name:   "restricted",
+			tenant: "team-a",
+			query:  `{"refId":"A","datasource":{"uid":"prom","type":"prometheus"},"expr":"up"}`,
+			sent:   `up{namespace=\"a\"}`,
+		},
+		{
+			name:        "restricted with a wrong datasource type",
+			tenant:      "team-a",
+			query:       `{"refId":"A","datasource":{"uid":"logs","type":"prometheus"},"expr":"{job=\"a\"}"}`,
+			wantTenancy: true,
+		},
+		{
+			name:        "restricted without expr",
+			tenant:      "team-a",
+			query:       `{"refId":"A","datasource":{"uid":"prom","type":"prometheus"},"query":"up"}`,
+			wantTenancy: true,
+		},
+		{
+			name:         "restricted with an unknown datasource",
+			tenant:       "team-a",
+			query:        `{"refId":"A","datasource":{"uid":"other","type":"prometheus"},"expr":"up"}`,
+			wantUpstream: http.StatusNotFound,
+		},
+		{
+			name:         "upstream failure",
+			query:        `{"refId":"A","datasource":{"uid":"prom","type":"prometheus"},"expr":"unavailable"}`,
+			sent:         `unavailable`,
+			wantUpstream: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := make(chan string, 1)
+			server := newFakeGrafana(t, sent)
+			dsQuery := &models.DataSourceQueryRequest{}
+			if err := json.Unmarshal([]byte(`{"queries":[`+tt.query+`]}`), dsQuery); err != nil {
+				t.Fatal(err)
+			}
+			ctx := tenancy.WithTenant(context.Background(), tt.tenant)
+			_, err := GrafanaDataSourceQuery(util.NewGrafanaClient(), ctx, server.URL, "key", dsQuery)
+
+			var tenancyErr *tenancy.Error
+			var upstreamErr *models.UpstreamError
+			switch {
+			case tt.wantTenancy:
+				if !errors.As(err, &tenancyErr) {
+					t.Errorf("GrafanaDataSourceQuery() error = %v, want a tenancy error", err)
+				}
+			case tt.wantUpstream != 0:
+				if !errors.As(err, &upstreamErr) || upstreamErr.Status != tt.wantUpstream {
+					t.Errorf("GrafanaDataSourceQuery() error = %v, want an upstream error of status %d", err, tt.wantUpstream)
+				}
+			case err != nil:
+				t.Errorf("GrafanaDataSourceQuery() error = %v", err)
+			}
+			select {
+			case body := <-sent:
+				if tt.sent == "" || !strings.Contains(body, `"expr":"`+tt.sent+`"`) {
+					t.Errorf("Grafana received %s, want the expr %s", body, tt.sent)
+				}
+			default:
+				if tt.sent != "" {
+					t.Errorf("Grafana received no query, want the expr %s", tt.sent)
+				}
+			}
+		})
+	}
+}
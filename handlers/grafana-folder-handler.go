@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"strconv"
+
+	"github.com/grafana-tools/sdk"
+)
+
+// folderLimit is the number of folders and dashboards read by a listing,
+// the maximum of the search API of Grafana.
+const folderLimit = 5000
+
+// GrafanaFoldersHandler lists the folders at the top level, or the
+// subfolders of the parentUid query parameter.
+func GrafanaFoldersHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	folders, err := ListFolders(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("parentUid"))
+	respondJSON(w, r, folders, err)
+}
+
+// GrafanaFolderHandler gets the folder of the uid query parameter.
+func GrafanaFolderHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	folder, err := GetFolder(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"))
+	respondJSON(w, r, folder, err)
+}
+
+// GrafanaFolderCreateHandler creates a folder.
+func GrafanaFolderCreateHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	createReq := &models.FolderCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(createReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid folder: %s", err))
+		return
+	}
+	folder, err := CreateFolder(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, createReq)
+	respondJSON(w, r, folder, err)
+}
+
+// GrafanaFolderUpdateHandler renames the folder of the uid query parameter.
+func GrafanaFolderUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	updateReq := &models.FolderUpdateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(updateReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid folder: %s", err))
+		return
+	}
+	folder, err := UpdateFolder(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), updateReq)
+	respondJSON(w, r, folder, err)
+}
+
+// GrafanaFolderMoveHandler moves the folder of the uid query parameter to another parent.
+func GrafanaFolderMoveHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	moveReq := &models.FolderMoveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(moveReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid move request: %s", err))
+		return
+	}
+	folder, err := MoveFolder(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), moveReq)
+	respondJSON(w, r, folder, err)
+}
+
+// GrafanaFolderDeleteHandler deletes the folder of the uid query parameter.
+// A folder holding dashboards or subfolders is only deleted, with them,
+// when the force query parameter is set.
+func GrafanaFolderDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		if force, err = strconv.ParseBool(v); err != nil {
+			util.WriteError(w, r, util.BadRequestError("invalid force %q", v))
+			return
+		}
+	}
+	result, err := DeleteFolder(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"), force)
+	respondJSON(w, r, result, err)
+}
+
+// GrafanaFolderDashboardsHandler lists the dashboards of the folder of the uid query parameter.
+func GrafanaFolderDashboardsHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	boards, err := FolderDashboards(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"))
+	respondJSON(w, r, boards, err)
+}
+
+// GrafanaFolderPermissionsHandler lists the permissions of the folder of the uid query parameter.
+func GrafanaFolderPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	permissions, err := FolderPermissions(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey, r.URL.Query().Get("uid"))
+	respondJSON(w, r, permissions, err)
+}
+
+// GrafanaFolderTreeHandler returns the folders of the instance as a tree with their dashboards.
+func GrafanaFolderTreeHandler(w http.ResponseWriter, r *http.Request) {
+	grafanaUrl, apiKey, err := grafanaCredentials(r)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	tree, err := FolderTree(util.NewGrafanaClient(), r.Context(), grafanaUrl, apiKey)
+	respondJSON(w, r, tree, err)
+}
+
+// ListFolders returns the folders at the top level, or the subfolders of
+// parentUID. Grafana without nested folders has no subfolders.
+func ListFolders(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, parentUID string) ([]*models.Folder, error) {
+	params := url.Values{"limit": {strconv.Itoa(folderLimit)}}
+	if parentUID != "" {
+		params.Set("parentUid", parentUID)
+	}
+	folders := []*models.Folder{}
+	if err := folderRequest(g, ctx, http.MethodGet, BaseURL+"/api/folders?"+params.Encode(), APIKey, parentUID, nil, &folders); err != nil {
+		return nil, err
+	}
+	// without nested folders, Grafana ignores parentUid and lists every folder
+	subfolders := folders[:0]
+	for _, folder := range folders {
+		if folder.ParentUID == parentUID {
+			subfolders = append(subfolders, folder)
+		}
+	}
+	return subfolders, nil
+}
+
+// GetFolder returns the folder of the uid.
+func GetFolder(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string) (*models.Folder, error) {
+	folder := &models.Folder{}
+	if err := folderRequest(g, ctx, http.MethodGet, BaseURL+"/api/folders/"+url.PathEscape(uid), APIKey, uid, nil, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// CreateFolder creates a folder. A folder with the same uid or title is a conflict.
+func CreateFolder(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, req *models.FolderCreateRequest) (*models.Folder, error) {
+	if req.Title == "" {
+		return nil, util.BadRequestError("folder title is required")
+	}
+	folder := &models.Folder{}
+	if err := folderRequest(g, ctx, http.MethodPost, BaseURL+"/api/folders", APIKey, req.UID, req, folder); err != nil {
+		return nil, err
+	}
+	log.Infof("Created folder %s %q", folder.UID, folder.Title)
+	return folder, nil
+}
+
+// UpdateFolder renames the folder of the uid. When the version of the
+// request is set, a folder changed since that version is a conflict.
+func UpdateFolder(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, req *models.FolderUpdateRequest) (*models.Folder, error) {
+	if req.Title == "" {
+		return nil, util.BadRequestError("folder title is required")
+	}
+	version := req.Version
+	if version == 0 {
+		current, err := GetFolder(g, ctx, BaseURL, APIKey, uid)
+		if err != nil {
+			return nil, err
+		}
+		version = current.Version
+	}
+	body := map[string]interface{}{"title": req.Title, "version": version}
+	folder := &models.Folder{}
+	if err := folderRequest(g, ctx, http.MethodPut, BaseURL+"/api/folders/"+url.PathEscape(uid), APIKey, uid, body, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// MoveFolder moves the folder of the uid to another parent, which needs
+// nested folders.
+func MoveFolder(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, req *models.FolderMoveRequest) (*models.Folder, error) {
+	if req.ParentUID == uid {
+		return nil, util.BadRequestError("folder %q cannot be its own parent", uid)
+	}
+	// the folder is read first, a missing move API then means nested folders are not enabled
+	if _, err := GetFolder(g, ctx, BaseURL, APIKey, uid); err != nil {
+		return nil, err
+	}
+	folder := &models.Folder{}
+	err := folderRequest(g, ctx, http.MethodPost, BaseURL+"/api/folders/"+url.PathEscape(uid)+"/move", APIKey, uid, req, folder)
+	if err != nil {
+		if util.ToAPIError(err).Code != util.CodeNotFound {
+			return nil, err
+		}
+		if req.ParentUID != "" {
+			if _, parentErr := GetFolder(g, ctx, BaseURL, APIKey, req.ParentUID); parentErr != nil {
+				return nil, parentErr
+			}
+		}
+		return nil, util.BadRequestError("nested folders are not enabled on the instance")
+	}
+	return folder, nil
+}
+
+// DeleteFolder deletes the folder of the uid. Grafana deletes the
+// dashboards and subfolders of a folder with it, so a folder that is not
+// empty is a conflict unless force is set.
+func DeleteFolder(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string, force bool) (*models.FolderDeleteResult, error) {
+	folder, err := GetFolder(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !force {
+		boards, err := folderDashboards(g, ctx, BaseURL, APIKey, folder)
+		if err != nil {
+			return nil, err
+		}
+		subfolders, err := ListFolders(g, ctx, BaseURL, APIKey, uid)
+		if err != nil {
+			return nil, err
+		}
+		if len(boards) > 0 || len(subfolders) > 0 {
+			conflict := util.NewError(util.CodeConflict, "folder %q is not empty: %d dashboards, %d subfolders", uid, len(boards), len(subfolders))
+			conflict.Details = map[string]int{"dashboards": len(boards), "folders": len(subfolders)}
+			return nil, conflict
+		}
+	}
+	result := &models.FolderDeleteResult{UID: uid, Title: folder.Title}
+	if err := folderRequest(g, ctx, http.MethodDelete, BaseURL+"/api/folders/"+url.PathEscape(uid), APIKey, uid, nil, result); err != nil {
+		return nil, err
+	}
+	result.UID, result.Title = uid, folder.Title
+	log.Infof("Deleted folder %s %q", uid, folder.Title)
+	return result, nil
+}
+
+// FolderDashboards returns the dashboards of the folder of the uid, without
+// the dashboards of its subfolders.
+func FolderDashboards(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string) ([]*models.FoundBoard, error) {
+	folder, err := GetFolder(g, ctx, BaseURL, APIKey, uid)
+	if err != nil {
+		return nil, err
+	}
+	return folderDashboards(g, ctx, BaseURL, APIKey, folder)
+}
+
+// folderDashboards searches the dashboards of a folder by id, which the search API takes.
+func folderDashboards(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, folder *models.Folder) ([]*models.FoundBoard, error) {
+	grafanaSdkClient, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, util.BadRequestError("invalid grafanaUrl: %s", err)
+	}
+	found, err := grafanaSdkClient.Search(ctx, sdk.SearchType(sdk.SearchTypeDashboard), sdk.SearchFolderID(folder.ID), sdk.SearchLimit(folderLimit))
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	boards := []*models.FoundBoard{}
+	for _, board := range found {
+		if board.FolderUID == folder.UID {
+			board := models.FoundBoard(board)
+			boards = append(boards, &board)
+		}
+	}
+	return boards, nil
+}
+
+// FolderPermissions returns the permissions of the folder of the uid.
+func FolderPermissions(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, uid string) ([]*models.FolderPermission, error) {
+	grafanaSdkClient, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, util.BadRequestError("invalid grafanaUrl: %s", err)
+	}
+	sdkPermissions, err := grafanaSdkClient.GetFolderPermissions(ctx, url.PathEscape(uid))
+	if err != nil {
+		return nil, util.ResourceError(err, "folder", uid)
+	}
+	permissions := make([]*models.FolderPermission, 0, len(sdkPermissions))
+	for _, p := range sdkPermissions {
+		permissions = append(permissions, &models.FolderPermission{
+			Role:           p.Role,
+			TeamID:         p.TeamId,
+			Team:           p.Team,
+			UserID:         p.UserId,
+			UserLogin:      p.UserLogin,
+			UserEmail:      p.UserEmail,
+			Permission:     uint(p.Permission),
+			PermissionName: p.PermissionName,
+		})
+	}
+	return permissions, nil
+}
+
+// FolderTree returns the General folder as the root of the folder tree,
+// holding the top level folders and the dashboards outside of folders.
+// Dashboards of folders missing from the tree are kept at the root.
+func FolderTree(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string) (*models.FolderNode, error) {
+	root := &models.FolderNode{
+		Folder:     models.Folder{Title: "General"},
+		Folders:    []*models.FolderNode{},
+		Dashboards: []*models.FoundBoard{},
+	}
+	nodes := map[string]*models.FolderNode{"": root}
+	pending := []*models.FolderNode{root}
+	for len(pending) > 0 {
+		parent := pending[0]
+		pending = pending[1:]
+		folders, err := ListFolders(g, ctx, BaseURL, APIKey, parent.UID)
+		if err != nil {
+			return nil, err
+		}
+		for _, folder := range folders {
+			if _, seen := nodes[folder.UID]; seen {
+				continue
+			}
+			node := &models.FolderNode{Folder: *folder, Folders: []*models.FolderNode{}, Dashboards: []*models.FoundBoard{}}
+			nodes[folder.UID] = node
+			parent.Folders = append(parent.Folders, node)
+			pending = append(pending, node)
+		}
+	}
+
+	grafanaSdkClient, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, util.BadRequestError("invalid grafanaUrl: %s", err)
+	}
+	found, err := grafanaSdkClient.Search(ctx, sdk.SearchType(sdk.SearchTypeDashboard), sdk.SearchLimit(folderLimit))
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	for _, board := range found {
+		board := models.FoundBoard(board)
+		node, ok := nodes[board.FolderUID]
+		if !ok {
+			node = root
+		}
+		node.Dashboards = append(node.Dashboards, &board)
+	}
+	return root, nil
+}
+
+// folderRequest calls the folder API of Grafana, the sdk folders have no
+// parent uid. A missing folder is reported as not found, and a changed
+// folder or a folder with the same uid or title as a conflict.
+func folderRequest(g *models.GrafanaClient, ctx context.Context, method, reqURL, APIKey, uid string, body, result interface{}) error {
+	var (
+		data   []byte
+		status int
+		err    error
+	)
+	switch method {
+	case http.MethodGet:
+		data, status, err = g.GetRequest(ctx, reqURL, APIKey)
+	case http.MethodDelete:
+		data, status, err = g.DeleteRequest(ctx, reqURL, APIKey)
+	default:
+		encoded, encodeErr := json.Marshal(body)
+		if encodeErr != nil {
+			return util.Error("Unable to encode folder", encodeErr)
+		}
+		if method == http.MethodPut {
+			data, status, err = g.PutRequest(ctx, reqURL, APIKey, encoded)
+		} else {
+			data, status, err = g.PostRequest(ctx, reqURL, APIKey, encoded)
+		}
+	}
+	if err != nil {
+		return util.UpstreamError(err)
+	}
+	if status != http.StatusOK {
+		upstreamErr := models.NewUpstreamError(status, reqURL, data)
+		switch status {
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			log.Errorf("Error: folder %s: %s", uid, upstreamErr)
+			conflict := util.NewError(util.CodeConflict, "folder %q: %s", uid, upstreamErr.Message)
+			conflict.Upstream, conflict.Err = upstreamErr, upstreamErr
+			return conflict
+		}
+		return util.ResourceError(upstreamErr, "folder", uid)
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return util.Error("Unable to decode folder response", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"proxy-api-server/bundle"
+	"proxy-api-server/dashdiff"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+
+	"github.com/grafana-tools/sdk"
+)
+
+// GrafanaDashboardMigrateHandler copies dashboards between registered instances, see MigrateDashboards.
+func GrafanaDashboardMigrateHandler(w http.ResponseWriter, r *http.Request) {
+	migrateReq := &models.DashboardMigrateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(migrateReq); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid migrate request: %s", err))
+		return
+	}
+	result, err := MigrateDashboards(util.NewGrafanaClient(), r.Context(), migrateReq)
+	respondJSON(w, r, result, err)
+}
+
+// migrationInstance is an instance taking part in a migration.
+type migrationInstance struct {
+	BaseURL     string
+	APIKey      string
+	client      *sdk.Client
+	datasources []sdk.Datasource
+}
+
+func newMigrationInstance(g *models.GrafanaClient, ctx context.Context, name string) (*migrationInstance, error) {
+	BaseURL, APIKey, err := instanceCredentials(name)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
+	if err != nil {
+		return nil, util.BadRequestError("invalid url of instance %q: %s", name, err)
+	}
+	datasources, err := client.GetAllDatasources(ctx)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	return &migrationInstance{BaseURL: BaseURL, APIKey: APIKey, client: client, datasources: datasources}, nil
+}
+
+// MigrateDashboards copies the selected dashboards of the source instance to
+// the target instance. Their datasource references, the ones walked by
+// helpers.ReplaceDatasourceRefs, are remapped with the datasources of the
+// request, otherwise to the datasource of the same name, otherwise to the
+// default or only datasource of the plugin in the target. The folders of the dashboards are recreated
+// in the target unless a target folder is given. A dashboard that cannot be
+// migrated is reported in the result and does not stop the others. On a dry
+// run nothing is saved and the result shows the changes the migration would
+// make, with the diff of the dashboards it would update.
+func MigrateDashboards(g *models.GrafanaClient, ctx context.Context, req *models.DashboardMigrateRequest) (*models.DashboardMigrateResult, error) {
+	if req.Source == "" || req.Target == "" {
+		return nil, util.BadRequestError("source and target are required")
+	}
+	if len(req.DashboardUIDs) == 0 && req.FolderUID == "" {
+		return nil, util.BadRequestError("dashboardUids or folderUid is required")
+	}
+	if req.Source == req.Target && !req.NewUIDs && req.TargetFolderUID == "" {
+		return nil, util.BadRequestError("migrating to the source instance requires newUids or targetFolderUid")
+	}
+	source, err := newMigrationInstance(g, ctx, req.Source)
+	if err != nil {
+		return nil, err
+	}
+	target, err := newMigrationInstance(g, ctx, req.Target)
+	if err != nil {
+		return nil, err
+	}
+	for from, to := range req.Datasources {
+		if _, ok := bundle.FindDatasource(source.datasources, from); !ok {
+			return nil, util.BadRequestError("datasource %q not found in %s", from, req.Source)
+		}
+		if _, ok := bundle.FindDatasource(target.datasources, to); !ok {
+			return nil, util.BadRequestError("datasource %q not found in %s", to, req.Target)
+		}
+	}
+	if req.TargetFolderUID != "" {
+		if _, err := GetFolder(g, ctx, target.BaseURL, target.APIKey, req.TargetFolderUID); err != nil {
+			return nil, err
+		}
+	}
+	selection := &models.BundleExportRequest{DashboardUIDs: req.DashboardUIDs}
+	if req.FolderUID != "" {
+		selection.FolderUIDs = []string{req.FolderUID}
+	}
+	uids, err := exportedDashboards(g, ctx, source.client, source.BaseURL, source.APIKey, selection)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboards := make([]*storedDashboard, 0, len(uids))
+	folders := map[string]*models.Folder{}
+	folderList := []*models.Folder{}
+	for _, uid := range uids {
+		stored, err := getStoredDashboard(g, ctx, source.BaseURL, source.APIKey, uid)
+		if err != nil {
+			return nil, err
+		}
+		if req.TargetFolderUID == "" && stored.Meta.FolderUID != "" {
+			if err := addFolder(g, ctx, source.BaseURL, source.APIKey, folders, &folderList, stored.Meta.FolderUID); err != nil {
+				return nil, err
+			}
+		}
+		dashboards = append(dashboards, stored)
+	}
+
+	result := &models.DashboardMigrateResult{
+		Source:     req.Source,
+		Target:     req.Target,
+		DryRun:     req.DryRun,
+		Dashboards: []*models.DashboardMigration{},
+	}
+	var folderErrors map[string]error
+	result.FoldersCreated, folderErrors = createFolders(g, ctx, target.BaseURL, target.APIKey, folderList, req.DryRun)
+
+	migrated := 0
+	for i, stored := range dashboards {
+		migration := &models.DashboardMigration{SourceUID: uids[i], FolderUID: req.TargetFolderUID, Datasources: map[string]string{}}
+		migration.Title, _ = stored.Dashboard["title"].(string)
+		if migration.FolderUID == "" {
+			migration.FolderUID = stored.Meta.FolderUID
+		}
+		result.Dashboards = append(result.Dashboards, migration)
+		if err := migrateDashboard(g, ctx, source, target, req, stored.Dashboard, migration, folderErrors); err != nil {
+			migration.Status, migration.Error = "failed", err.Error()
+			continue
+		}
+		migrated++
+	}
+	if !req.DryRun {
+		log.Infof("Migrated %d of %d dashboards from %s to %s", migrated, len(dashboards), req.Source, req.Target)
+	}
+	return result, nil
+}
+
+func migrateDashboard(g *models.GrafanaClient, ctx context.Context, source, target *migrationInstance, req *models.DashboardMigrateRequest, dashboard map[string]interface{}, migration *models.DashboardMigration, folderErrors map[string]error) error {
+	if err := folderErrors[migration.FolderUID]; err != nil {
+		return err
+	}
+	// the datasources of the source become inputs, mapped to the datasources of the target
+	inputs := bundle.Externalize(dashboard, source.datasources)
+	mapping := map[string]string{}
+	for _, input := range inputs {
+		ds, _ := bundle.FindDatasource(source.datasources, input.Label)
+		if to, ok := req.Datasources[ds.UID]; ok {
+			mapping[input.Name] = to
+		} else if to, ok := req.Datasources[ds.Name]; ok {
+			mapping[input.Name] = to
+		}
+	}
+	resolved, err := bundle.ResolveInputs(inputs, target.datasources, mapping)
+	if err != nil {
+		return err
+	}
+	for _, input := range inputs {
+		ds, ok := resolved[input.Name]
+		if !ok {
+			return fmt.Errorf("datasource %q has no %s datasource to map to in %s", input.Label, input.PluginID, req.Target)
+		}
+		migration.Datasources[input.Label] = ds.Name
+	}
+	if err := bundle.Internalize(dashboard, resolved); err != nil {
+		return err
+	}
+
+	migration.Action = "create"
+	if req.NewUIDs {
+		delete(dashboard, "uid")
+	} else {
+		migration.UID = migration.SourceUID
+		existing, err := getStoredDashboard(g, ctx, target.BaseURL, target.APIKey, migration.SourceUID)
+		if err != nil && util.ToAPIError(err).Code != util.CodeNotFound {
+			return err
+		}
+		if err == nil {
+			migration.Action = "update"
+			migration.Diff = dashdiff.Diff(existing.Dashboard, dashboard)
+			migration.Diff.UID, migration.Diff.From, migration.Diff.To = migration.UID, existing.Meta.Version, existing.Meta.Version+1
+			if !req.Overwrite {
+				return util.NewError(util.CodeConflict, "dashboard %q exists in %s, set overwrite to replace it", migration.UID, req.Target)
+			}
+		}
+	}
+	if req.DryRun {
+		migration.Status = "planned"
+		return nil
+	}
+
+	message := req.Message
+	if message == "" {
+		message = "Migrated from " + req.Source
+	}
+	saved, err := CreateDashboard(g, ctx, target.BaseURL, target.APIKey, &models.DashboardSaveRequest{
+		Dashboard: dashboard,
+		FolderUID: migration.FolderUID,
+		Overwrite: req.Overwrite,
+		Message:   message,
+	})
+	if err != nil {
+		return err
+	}
+	migration.Status, migration.UID, migration.Version, migration.URL = "migrated", saved.UID, saved.Version, saved.URL
+	return nil
+}
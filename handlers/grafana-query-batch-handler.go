@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"proxy-api-server/config"
+	"proxy-api-server/interpolate"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/timerange"
+	"proxy-api-server/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GrafanaQueryBatchHandler runs many range and instant queries in one round trip
+func GrafanaQueryBatchHandler(w http.ResponseWriter, r *http.Request) {
+	client := util.NewGrafanaClient()
+	grafanaUrl := r.URL.Query().Get("grafanaUrl")
+	apiKey := r.URL.Query().Get("apiKey")
+	if upstream := r.URL.Query().Get("upstream"); upstream != "" {
+		var err error
+		if client, grafanaUrl, apiKey, err = upstreamClient(upstream, "prometheus"); err != nil {
+			respondQuery(w, r, nil, err)
+			return
+		}
+	} else if grafanaUrl == "" {
+		log.Error("Grafana url not provided")
+		util.WriteError(w, r, util.MissingParameterError("grafanaUrl", "Grafana url not provided"))
+		return
+	} else if apiKey == "" {
+		log.Error("Grafana api key (userId:password) not provided")
+		util.WriteError(w, r, util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided"))
+		return
+	}
+
+	queries := []*models.BatchQuery{}
+	if err := json.NewDecoder(r.Body).Decode(&queries); err != nil {
+		util.WriteError(w, r, util.BadRequestError("invalid batch request body: %s", err))
+		return
+	}
+	if err := validateBatch(queries); err != nil {
+		util.WriteError(w, r, util.BadRequestError("%s", err))
+		return
+	}
+
+	results := GrafanaQueryBatch(client, r.Context(), strings.TrimSuffix(grafanaUrl, "/"), apiKey, queries)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&models.BatchQueryResponse{Results: results}); err != nil {
+		util.Error("Http request failed: ", err)
+	}
+}
+
+func validateBatch(queries []*models.BatchQuery) error {
+	conf := config.Get().Batch
+	if len(queries) == 0 {
+		return fmt.Errorf("batch request holds no queries")
+	}
+	if conf.MaxQueries > 0 && len(queries) > conf.MaxQueries {
+		return fmt.Errorf("batch request holds %d queries, at most %d are allowed", len(queries), conf.MaxQueries)
+	}
+	refIDs := map[string]bool{}
+	for i, q := range queries {
+		if q == nil {
+			return fmt.Errorf("query %d is null", i)
+		}
+		if q.RefID == "" {
+			return fmt.Errorf("query %d has no refId", i)
+		}
+		if refIDs[q.RefID] {
+			return fmt.Errorf("duplicate refId %q", q.RefID)
+		}
+		refIDs[q.RefID] = true
+		if strings.TrimSpace(q.Expr) == "" {
+			return fmt.Errorf("query %q has no expr", q.RefID)
+		}
+		if q.Type != "" && q.Type != "range" && q.Type != "instant" {
+			return fmt.Errorf("query %q has unknown type %q, expected range or instant", q.RefID, q.Type)
+		}
+	}
+	return nil
+}
+
+// GrafanaQueryBatch runs the queries with bounded concurrency and returns one
+// result per query, in the order of the queries. A failing query does not
+// fail the others.
+func GrafanaQueryBatch(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, queries []*models.BatchQuery) []*models.BatchQueryResult {
+	conf := config.Get().Batch
+	concurrency := conf.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	datasources := &batchDatasources{g: g, ctx: ctx, baseURL: BaseURL, apiKey: APIKey, lookups: map[string]*datasourceLookup{}}
+	results := make([]*models.BatchQueryResult, len(queries))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		go func(i int, q *models.BatchQuery) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = runBatchQuery(g, ctx, BaseURL, APIKey, q, conf.QueryTimeout, datasources)
+		}(i, q)
+	}
+	wg.Wait()
+	return results
+}
+
+// batchDatasources looks up the Prometheus API of the datasources of a batch,
+// each datasource once for all the queries of the batch.
+type batchDatasources struct {
+	g       *models.GrafanaClient
+	ctx     context.Context
+	baseURL string
+	apiKey  string
+	mu      sync.Mutex
+	lookups map[string]*datasourceLookup
+}
+
+type datasourceLookup struct {
+	once   sync.Once
+	apiURL string
+	err    error
+}
+
+// apiURL returns the URL of the Prometheus API of the datasource of q, by id or by name.
+func (d *batchDatasources) apiURL(q *models.BatchQuery) (string, error) {
+	if q.DatasourceID != "" && !d.g.PromMode {
+		return fmt.Sprintf("%s/api/datasources/proxy/%s", d.baseURL, url.PathEscape(q.DatasourceID)), nil
+	}
+	d.mu.Lock()
+	lookup, ok := d.lookups[q.Datasource]
+	if !ok {
+		lookup = &datasourceLookup{}
+		d.lookups[q.Datasource] = lookup
+	}
+	d.mu.Unlock()
+	lookup.once.Do(func() {
+		lookup.apiURL, lookup.err = prometheusAPI(d.g, d.ctx, d.baseURL, d.apiKey, q.Datasource)
+	})
+	return lookup.apiURL, lookup.err
+}
+
+func runBatchQuery(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, q *models.BatchQuery, maxTimeout time.Duration, datasources *batchDatasources) *models.BatchQueryResult {
+	result := &models.BatchQueryResult{RefID: q.RefID}
+	timeout := maxTimeout
+	if q.Timeout != "" {
+		d, err := timerange.ParseDuration(q.Timeout)
+		if err != nil {
+			return batchError(result, &timerange.ParamError{Param: "timeout", Value: q.Timeout, Reason: err.Error()})
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	apiURL := func() (string, error) {
+		return datasources.apiURL(q)
+	}
+	var data []byte
+	var err error
+	if q.Type == "instant" {
+		data, err = queryInstant(g, ctx, BaseURL, APIKey, q, apiURL)
+	} else {
+		params := batchQueryParams(q)
+		params.Set("query", q.Expr)
+		data, err = queryRange(g, ctx, BaseURL, APIKey, params, apiURL)
+	}
+	if err != nil {
+		log.Errorf("Batch query [%s] failed: %v", q.RefID, err)
+		return batchError(result, err)
+	}
+	result.Status = http.StatusOK
+	result.Data = data
+	return result
+}
+
+// batchError records the error of a failed query in its result, with the code and status of the error catalog.
+func batchError(result *models.BatchQueryResult, err error) *models.BatchQueryResult {
+	apiErr := util.ToAPIError(err)
+	result.Status, result.ErrorCode, result.Error, result.Upstream = apiErr.Status, string(apiErr.Code), apiErr.Message, apiErr.Upstream
+	return result
+}
+
+// queryInstant runs an instant query against the Prometheus API returned by
+// apiURL, evaluated at the end of the query, or now when it has none.
+func queryInstant(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, q *models.BatchQuery, apiURL func() (string, error)) ([]byte, error) {
+	evalTime := ""
+	if q.End != "" {
+		t, err := timerange.ParseTime(q.End, time.Now(), true)
+		if err != nil {
+			return nil, &timerange.ParamError{Param: "end", Value: q.End, Reason: err.Error()}
+		}
+		evalTime = timerange.FormatTime(t)
+	}
+	// the range of the query, when given, defines $__interval, $__rate_interval and $__range
+	timeRange, err := timerange.ParseRange(batchQueryParams(q), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	vars := interpolate.FromQuery(url.Values(q.Variables))
+	addRangeVariables(vars, timeRange)
+	query := interpolate.Interpolate(q.Expr, vars, interpolate.Prometheus)
+	return runPrometheusQuery(ctx, BaseURL, query, apiURL, func(ctx context.Context, api string, params url.Values) ([]byte, error) {
+		if evalTime != "" {
+			params.Set("time", evalTime)
+		}
+		return g.MakeRequest(ctx, api+"/api/v1/query?"+params.Encode(), APIKey)
+	})
+}
+
+// batchQueryParams converts a batch query to the request parameters understood by the query functions.
+func batchQueryParams(q *models.BatchQuery) url.Values {
+	params := url.Values{}
+	for name, values := range q.Variables {
+		params["var-"+name] = values
+	}
+	for param, value := range map[string]string{"start": q.Start, "end": q.End, "step": q.Step} {
+		if value != "" {
+			params.Set(param, value)
+		}
+	}
+	if q.MaxDataPoints > 0 {
+		params.Set("maxDataPoints", strconv.Itoa(q.MaxDataPoints))
+	}
+	return params
+}
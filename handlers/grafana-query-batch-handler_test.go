@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"proxy-api-server/config"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakePrometheus serves range and instant queries. Queries of the metric
+// slow answer late, queries of the metric broken fail.
+func newFakePrometheus(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if strings.Contains(query, "slow") {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if strings.Contains(query, "broken") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"status":"error","errorType":"execution","error":"broken"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/query_range":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"q":"` + query + `"},"values":[[1,"1"]]}]}}`))
+		case "/api/v1/query":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"q":"` + query + `"},"value":[1,"1"]}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGrafanaQueryBatch(t *testing.T) {
+	conf := config.NewConfig()
+	conf.QueryCache.Enabled = false
+	config.Set(conf)
+	server := newFakePrometheus(t)
+	client := util.NewGrafanaClient()
+	client.PromMode = true
+
+	queries := []*models.BatchQuery{
+		{RefID: "A", Expr: "slow", Start: "now-1h"},
+		{RefID: "B", Expr: "sum(", Start: "now-1h"},
+		{RefID: "C", Type: "instant", Expr: "up"},
+		{RefID: "D", Expr: "broken", Start: "now-1h"},
+		{RefID: "E", Expr: "up", Start: "yesterday"},
+		{RefID: "F", Type: "instant", Expr: "rate(x[$__rate_interval])", Start: "now-1h", Step: "15s"},
+	}
+	results := GrafanaQueryBatch(client, context.Background(), server.URL, "", queries)
+
+	want := []struct {
+		status int
+		code   util.ErrorCode
+		data   string
+	}{
+		{status: http.StatusOK, data: `"resultType":"matrix","result":[{"metric":{"q":"slow"}`},
+		{status: http.StatusBadRequest, code: util.CodeInvalidQuery},
+		{status: http.StatusOK, data: `"resultType":"vector","result":[{"metric":{"q":"up"}`},
+		{status: http.StatusUnprocessableEntity, code: util.CodeUpstreamError},
+		{status: http.StatusBadRequest, code: util.CodeInvalidParameter},
+		{status: http.StatusOK, data: `{"metric":{"q":"rate(x[1m])"}`},
+	}
+	if len(results) != len(queries) {
+		t.Fatalf("GrafanaQueryBatch() returned %d results, want %d", len(results), len(queries))
+	}
+	for i, result := range results {
+		if result.RefID != queries[i].RefID {
+			t.Errorf("result %d is the one of %s, want %s", i, result.RefID, queries[i].RefID)
+		}
+		w := want[i]
+		if result.Status != w.status || result.ErrorCode != string(w.code) || !strings.Contains(string(result.Data), w.data) {
+			t.Errorf("result %s = %d %s %s %s, want %d %s %s", result.RefID, result.Status, result.ErrorCode, result.Error, result.Data, w.status, w.code, w.data)
+		}
+	}
+}
@@ -7,25 +7,45 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"proxy-api-server/config"
+	"proxy-api-server/interpolate"
+	"proxy-api-server/limits"
 	"proxy-api-server/log"
 	"proxy-api-server/models"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/timerange"
 	"proxy-api-server/util"
+	"proxy-api-server/variable"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 func GrafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
 
+	reqQuery := r.URL.Query()
+	if upstream := reqQuery.Get("upstream"); upstream != "" {
+		client, baseURL, apiKey, err := upstreamClient(upstream, "prometheus")
+		if err != nil {
+			respondQuery(w, r, nil, err)
+			return
+		}
+		data, err := GrafanaQuery(client, r.Context(), baseURL, apiKey, &reqQuery)
+		respondQuery(w, r, data, err)
+		return
+	}
+
 	grafanaUrl := r.URL.Query().Get("grafanaUrl")
 	apiKey := r.URL.Query().Get("apiKey")
 	if grafanaUrl == "" {
 		log.Error("Grafana url not provided")
-		http.Error(w, fmt.Sprintf("Grafana url not provided"), http.StatusBadRequest)
+		util.WriteError(w, r, util.MissingParameterError("grafanaUrl", "Grafana url not provided"))
 		return
 	} else if apiKey == "" {
 		log.Error("Grafana api key (userId:password) not provided")
-		http.Error(w, fmt.Sprintf("Grafana api key (userId:password) not provided"), http.StatusBadRequest)
+		util.WriteError(w, r, util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided"))
 		return
 	}
 
@@ -42,8 +62,6 @@ func GrafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
 	// 	FirstName: "admin",
 	// }
 
-	reqQuery := r.URL.Query()
-
 	// if prefObj.Grafana == nil || prefObj.Grafana.GrafanaURL == "" {
 	// 	err := ErrGrafanaConfig
 	// 	h.log.Error(err)
@@ -53,92 +71,144 @@ func GrafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
 	log.Info("Getting grafana dashboard with uid")
 	client := util.NewGrafanaClient()
 	data, err := GrafanaQuery(client, r.Context(), prefObj.Grafana.GrafanaURL, prefObj.Grafana.GrafanaAPIKey, &reqQuery)
-	if err != nil {
-		util.Error("Http request failed: ", err)
-		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
-		return
-	}
-	_, _ = w.Write(data)
+	respondQuery(w, r, data, err)
 }
 
+// variableQueryParams are the request parameters of GrafanaQuery that are not template variables.
+var variableQueryParams = []string{"query", "dsid", "start", "end", "regex", "sort", "grafanaUrl", "apiKey", "upstream"}
+
 func GrafanaQuery(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, queryData *url.Values) ([]byte, error) {
 	if queryData == nil {
 		return nil, errors.New("query data passed is nil")
 	}
-	query := strings.TrimSpace(queryData.Get("query"))
-	dsID := queryData.Get("dsid")
-	log.Info("Dashboard uid: ")
-	var queryURL string
-	switch {
-	case strings.HasPrefix(query, "label_values("):
-		val := strings.Replace(query, "label_values(", "", 1)
-		val = strings.TrimSpace(strings.TrimSuffix(val, ")"))
-		if strings.Contains(val, ",") {
-			start := queryData.Get("start")
-			end := queryData.Get("end")
-			comInd := strings.LastIndex(val, ", ")
-			if comInd > -1 {
-				val = val[:comInd]
-			}
-			for key := range *queryData {
-				if key != "query" && key != "dsid" && key != "start" && key != "end" {
-					val1 := queryData.Get(key)
-					val = strings.Replace(val, "$"+key, val1, -1)
-				}
-			}
-			var reqURL string
-			if g.PromMode {
-				reqURL = fmt.Sprintf("%s/api/v1/series", BaseURL)
-			} else {
-				reqURL = fmt.Sprintf("%s/api/datasources/proxy/%s/api/v1/series", BaseURL, dsID)
-			}
-			queryURLInst, _ := url.Parse(reqURL)
-			qParams := queryURLInst.Query()
-			qParams.Set("match[]", val)
-			if start != "" && end != "" {
-				qParams.Set("start", start)
-				qParams.Set("end", end)
-			}
-			queryURLInst.RawQuery = qParams.Encode()
-			queryURL = queryURLInst.String()
-		} else {
-			if g.PromMode {
-				queryURL = fmt.Sprintf("%s/api/v1/label/%s/values", BaseURL, val)
-			} else {
-				queryURL = fmt.Sprintf("%s/api/datasources/proxy/%s/api/v1/label/%s/values", BaseURL, dsID, val)
-			}
+	vars := interpolate.FromQuery(*queryData, variableQueryParams...)
+	query := interpolate.Interpolate(strings.TrimSpace(queryData.Get("query")), vars, interpolate.Prometheus)
+	varQuery, err := variable.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateVariableQuery(varQuery); err != nil {
+		return nil, err
+	}
+	re, err := variable.CompileRegex(queryData.Get("regex"))
+	if err != nil {
+		return nil, &variable.QueryError{Query: query, Reason: err.Error()}
+	}
+	sortOrder, err := variable.ParseSortOrder(queryData.Get("sort"))
+	if err != nil {
+		return nil, &variable.QueryError{Query: query, Reason: err.Error()}
+	}
+
+	var baseURL string
+	if g.PromMode {
+		baseURL = BaseURL
+	} else {
+		baseURL = fmt.Sprintf("%s/api/datasources/proxy/%s", BaseURL, queryData.Get("dsid"))
+	}
+	start, end, err := variableQueryTimes(*queryData)
+	if err != nil {
+		return nil, err
+	}
+	queryLimits := limits.For(ctx, BaseURL)
+	params := url.Values{}
+	if start != "" && end != "" {
+		params.Set("start", start)
+		params.Set("end", end)
+	}
+
+	// restrict the series of the lookup, or the query, to the tenant of the caller
+	if varQuery.Function == variable.QueryResult {
+		varQuery.Expr, err = tenancy.Enforce(ctx, varQuery.Expr)
+	} else {
+		varQuery.Metric, err = tenancy.EnforceSelector(ctx, varQuery.Metric)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if varQuery.Metric != "" {
+		params.Set("match[]", varQuery.Metric)
+	}
+
+	var values []string
+	switch varQuery.Function {
+	case variable.LabelNames:
+		values, err = fetchVariableValues(g, ctx, queryLimits, baseURL+"/api/v1/labels", params, APIKey, variable.DecodeValues)
+	case variable.LabelValues:
+		values, err = fetchVariableValues(g, ctx, queryLimits, baseURL+"/api/v1/label/"+url.PathEscape(varQuery.Label)+"/values", params, APIKey, variable.DecodeValues)
+	case variable.Metrics:
+		values, err = fetchVariableValues(g, ctx, queryLimits, baseURL+"/api/v1/label/__name__/values", params, APIKey, variable.DecodeValues)
+		if err == nil {
+			values = variable.Match(values, regexp.MustCompile(varQuery.Regex))
 		}
-	case strings.HasPrefix(query, "query_result("):
-		val := strings.Replace(query, "query_result(", "", 1)
-		val = strings.TrimSpace(strings.TrimSuffix(val, ")"))
-		for key := range *queryData {
-			if key != "query" && key != "dsid" {
-				val1 := queryData.Get(key)
-				val = strings.Replace(val, "$"+key, val1, -1)
-			}
+	case variable.QueryResult:
+		q := url.Values{}
+		q.Set("query", varQuery.Expr)
+		if end != "" {
+			q.Set("time", end)
 		}
-		var reqURL string
-		if g.PromMode {
-			reqURL = fmt.Sprintf("%s/api/v1/query", BaseURL)
-		} else {
-			reqURL = fmt.Sprintf("%s/api/datasources/proxy/%s/api/v1/query", BaseURL, dsID)
+		if timeout := limits.TimeoutParam(queryLimits); timeout != "" {
+			q.Set("timeout", timeout)
 		}
-		newURL, _ := url.Parse(reqURL)
-		q := url.Values{}
-		q.Set("query", val)
-		newURL.RawQuery = q.Encode()
-		queryURL = newURL.String()
-	default:
-		return json.Marshal(map[string]interface{}{
-			"status": "success",
-			"data":   []string{query},
+		values, err = fetchVariableValues(g, ctx, queryLimits, baseURL+"/api/v1/query", q, APIKey, func(data []byte) ([]string, error) {
+			if err := limits.CheckSeries(ctx, queryLimits, data); err != nil {
+				return nil, err
+			}
+			return variable.DecodeQueryResult(data)
 		})
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	values = variable.Filter(values, re)
+	variable.Sort(values, sortOrder)
+	return json.Marshal(&models.VariableValues{Status: "success", Data: values})
+}
+
+// validateVariableQuery checks the PromQL of a variable query locally, so that
+// broken expressions and series selectors never reach Grafana.
+func validateVariableQuery(varQuery *variable.Query) error {
+	expr := varQuery.Metric
+	if varQuery.Function == variable.QueryResult {
+		expr = varQuery.Expr
+	}
+	if expr == "" {
+		return nil
+	}
+	return checkQuery(expr)
+}
+
+// variableQueryTimes validates the optional start and end of a variable query
+// and converts them to epoch seconds for Prometheus.
+func variableQueryTimes(queryData url.Values) (string, string, error) {
+	now := time.Now()
+	times := []string{"", ""}
+	for i, param := range []string{"start", "end"} {
+		v := queryData.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := timerange.ParseTime(v, now, param == "end")
+		if err != nil {
+			return "", "", &timerange.ParamError{Param: param, Value: v, Reason: err.Error()}
+		}
+		times[i] = timerange.FormatTime(t)
+	}
+	return times[0], times[1], nil
+}
+
+func fetchVariableValues(g *models.GrafanaClient, ctx context.Context, queryLimits config.QueryLimits, reqURL string, params url.Values, APIKey string, decode func([]byte) ([]string, error)) ([]string, error) {
+	queryURL := reqURL
+	if len(params) > 0 {
+		queryURL += "?" + params.Encode()
+	}
 	logrus.Debugf("derived query url: %s", queryURL)
 
+	ctx, cancel := limits.WithTimeout(ctx, queryLimits)
+	defer cancel()
 	data, err := g.MakeRequest(ctx, queryURL, APIKey)
 	if err != nil {
-		return nil, errors.New("error getting data from Grafana API")
+		return nil, util.UpstreamError(err)
 	}
-	return data, nil
+	return decode(data)
 }
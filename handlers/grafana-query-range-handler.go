@@ -8,8 +8,16 @@ import (
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"net/url"
+	"proxy-api-server/interpolate"
+	"proxy-api-server/limits"
 	"proxy-api-server/models"
+	"proxy-api-server/promql"
+	"proxy-api-server/querycache"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/timerange"
 	"proxy-api-server/util"
+	"strconv"
+	"time"
 )
 
 // GrafanaQueryRangeHandler is used for handling Grafana Range queries
@@ -21,49 +29,171 @@ func GrafanaQueryRangeHandler(w http.ResponseWriter, req *http.Request) {
 
 	reqQuery := req.URL.Query()
 	client := util.NewGrafanaClient()
-	data, err := GrafanaQueryRange(client, req.Context(), reqQuery.Get("url"), reqQuery.Get("api-key"), &reqQuery)
-	if err != nil {
-		util.Error("Http request failed: ", err)
-		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
-		return
+	baseURL, apiKey := reqQuery.Get("url"), reqQuery.Get("api-key")
+	if upstream := reqQuery.Get("upstream"); upstream != "" {
+		var err error
+		if client, baseURL, apiKey, err = upstreamClient(upstream, "prometheus"); err != nil {
+			respondQuery(w, req, nil, err)
+			return
+		}
 	}
-	_, _ = w.Write(data)
+	data, err := GrafanaQueryRange(client, req.Context(), baseURL, apiKey, &reqQuery)
+	respondQuery(w, req, data, err)
 }
 
 func GrafanaQueryRange(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, queryData *url.Values) ([]byte, error) {
 	if queryData == nil {
 		return nil, errors.New("query data passed is nil")
 	}
+	return queryRange(g, ctx, BaseURL, APIKey, *queryData, func() (string, error) {
+		return prometheusAPI(g, ctx, BaseURL, APIKey, queryData.Get("ds"))
+	})
+}
 
+// prometheusAPI returns the URL of the Prometheus API of a datasource, found
+// by name, or of the upstream itself in PromMode.
+func prometheusAPI(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey, datasource string) (string, error) {
+	if g.PromMode {
+		// the upstream is the Prometheus API itself, there is no datasource to look up
+		return BaseURL, nil
+	}
 	c, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
 	if err != nil {
-		return nil, util.CommonError(err)
+		return "", util.UpstreamError(err)
 	}
-
-	ds, err := c.GetDatasourceByName(ctx, queryData.Get("ds"))
+	ds, err := c.GetDatasourceByName(ctx, datasource)
 	if err != nil {
 		logrus.Error(err)
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/datasources/proxy/%d", BaseURL, ds.ID), nil
+}
+
+// queryRange runs a range query against the Prometheus API returned by
+// apiURL, once the query is validated, with the limits of the instance at BaseURL.
+func queryRange(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string, queryData url.Values, apiURL func() (string, error)) ([]byte, error) {
+	timeRange, err := timerange.ParseRange(queryData, time.Now())
+	if err != nil {
 		return nil, err
 	}
+	if err := limits.ApplyRange(ctx, limits.For(ctx, BaseURL), timeRange); err != nil {
+		return nil, err
+	}
+	query := interpolateRangeQuery(queryData, timeRange)
+	return runPrometheusQuery(ctx, BaseURL, query, apiURL, func(ctx context.Context, api string, params url.Values) ([]byte, error) {
+		reqURL := api + "/api/v1/query_range"
+		newURL, _ := url.Parse(reqURL)
+		for k, v := range timeRange.Params() {
+			params[k] = v
+		}
+		fetch := func(ctx context.Context, start, end time.Time) ([]byte, error) {
+			blockQuery := url.Values{}
+			for k, v := range params {
+				blockQuery[k] = v
+			}
+			blockQuery.Set("start", timerange.FormatTime(start))
+			blockQuery.Set("end", timerange.FormatTime(end))
+			blockURL := *newURL
+			blockURL.RawQuery = blockQuery.Encode()
+			return g.MakeRequest(ctx, blockURL.String(), APIKey)
+		}
+		if cache := querycache.Default(); cache != nil {
+			key := querycache.Key(reqURL, APIKey, g.TenantID, params.Get("query"), params.Get("step"))
+			return cache.Fetch(ctx, key, timeRange, fetch)
+		}
+		return fetch(ctx, timeRange.Start, timeRange.End)
+	})
+}
 
-	var reqURL string
-	if g.PromMode {
-		reqURL = fmt.Sprintf("%s/api/v1/query_range", BaseURL)
-	} else {
-		reqURL = fmt.Sprintf("%s/api/datasources/proxy/%d/api/v1/query_range", BaseURL, ds.ID)
+// runPrometheusQuery runs the steps shared by range and instant queries. The
+// query is validated before reaching out to Grafana, so that broken queries
+// fail with their syntax error, and restricted to the tenant of the caller.
+// send calls the Prometheus API returned by apiURL with the query and
+// timeout parameters, within the limits of the instance at BaseURL, whose
+// series limit is checked on the response.
+func runPrometheusQuery(ctx context.Context, BaseURL, query string, apiURL func() (string, error), send func(ctx context.Context, api string, params url.Values) ([]byte, error)) ([]byte, error) {
+	if err := checkQuery(query); err != nil {
+		return nil, err
+	}
+	query, err := tenancy.Enforce(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	queryLimits := limits.For(ctx, BaseURL)
+	params := url.Values{"query": {query}}
+	if timeout := limits.TimeoutParam(queryLimits); timeout != "" {
+		params.Set("timeout", timeout)
 	}
 
-	newURL, _ := url.Parse(reqURL)
-	q := url.Values{}
-	q.Set("query", queryData.Get("query"))
-	q.Set("start", queryData.Get("start"))
-	q.Set("end", queryData.Get("end"))
-	q.Set("step", queryData.Get("step"))
-	newURL.RawQuery = q.Encode()
-	queryURL := newURL.String()
-	data, err := g.MakeRequest(ctx, queryURL, APIKey)
+	api, err := apiURL()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := limits.WithTimeout(ctx, queryLimits)
+	defer cancel()
+	data, err := send(ctx, api, params)
 	if err != nil {
-		return nil, util.CommonError(err)
+		return nil, util.UpstreamError(err)
+	}
+	if err := limits.CheckSeries(ctx, queryLimits, data); err != nil {
+		return nil, err
 	}
 	return data, nil
 }
+
+// interpolateRangeQuery replaces the template variables of the query of a
+// range request, including the global variables of its time range.
+func interpolateRangeQuery(queryData url.Values, timeRange *timerange.Range) string {
+	vars := interpolate.FromQuery(queryData, "url", "api-key", "upstream", "ds", "query", "start", "end", "step", "maxDataPoints")
+	addRangeVariables(vars, timeRange)
+	return interpolate.Interpolate(queryData.Get("query"), vars, interpolate.Prometheus)
+}
+
+// defaultScrapeInterval is the scrape interval Grafana assumes for $__rate_interval.
+const defaultScrapeInterval = 15 * time.Second
+
+// addRangeVariables adds Grafana's global $__interval, $__rate_interval and
+// $__range variables for the given range.
+func addRangeVariables(vars interpolate.Variables, r *timerange.Range) {
+	// like Grafana, a rate covers at least four scrapes and one step more than a scrape
+	rateInterval := r.Step + defaultScrapeInterval
+	if rateInterval < 4*defaultScrapeInterval {
+		rateInterval = 4 * defaultScrapeInterval
+	}
+	builtins := map[string]string{
+		"__interval":         timerange.FormatDuration(r.Step),
+		"__interval_ms":      strconv.FormatInt(r.Step.Milliseconds(), 10),
+		"__rate_interval":    timerange.FormatDuration(rateInterval),
+		"__rate_interval_ms": strconv.FormatInt(rateInterval.Milliseconds(), 10),
+		"__range":            timerange.FormatDuration(r.Duration()),
+		"__range_s":          strconv.FormatInt(int64(r.Duration().Seconds()), 10),
+		"__range_ms":         strconv.FormatInt(r.Duration().Milliseconds(), 10),
+	}
+	for name, value := range builtins {
+		vars[name] = &interpolate.Variable{Name: name, Values: []string{value}}
+	}
+}
+
+// checkQuery parses a query before it is sent upstream. Only syntax errors
+// reject it: unknown functions, such as those of MetricsQL, and type errors
+// are logged and left to the upstream.
+func checkQuery(query string) error {
+	_, warnings, err := promql.Parse(query)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		logrus.Warnf("query %q: %s", query, w.Err)
+	}
+	return nil
+}
+
+// respondQuery replies with the upstream response or the error of a query.
+func respondQuery(w http.ResponseWriter, r *http.Request, data []byte, err error) {
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"proxy-api-server/models"
+	"proxy-api-server/promql"
+	"proxy-api-server/timerange"
+	"proxy-api-server/util"
+	"strings"
+	"time"
+)
+
+// GrafanaQueryValidateHandler parses a PromQL expression locally and explains it
+func GrafanaQueryValidateHandler(w http.ResponseWriter, r *http.Request) {
+	req := &models.QueryValidateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		util.Error("Cannot read request body.", err)
+		util.WriteError(w, r, util.BadRequestError("invalid validate request body: %s", err))
+		return
+	}
+	result, err := ValidateQuery(req)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		util.Error("Http request failed: ", err)
+	}
+}
+
+// ValidateQuery interpolates the query the way GrafanaQueryRange does and
+// parses it. A query that does not parse is reported in the result, errors
+// are only returned for invalid requests. Unknown functions and type errors
+// are reported as warnings of a valid query, as they are left to the upstream.
+func ValidateQuery(req *models.QueryValidateRequest) (*models.QueryValidateResult, error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, util.MissingParameterError("query", "query not provided")
+	}
+	params := url.Values{}
+	for name, values := range req.Variables {
+		params["var-"+name] = values
+	}
+	for param, value := range map[string]string{"start": req.Start, "end": req.End, "step": req.Step} {
+		if value != "" {
+			params.Set(param, value)
+		}
+	}
+	timeRange, err := timerange.ParseRange(params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	params.Set("query", req.Query)
+	result := &models.QueryValidateResult{Query: interpolateRangeQuery(params, timeRange)}
+
+	expr, warnings, err := promql.Parse(result.Query)
+	if err != nil {
+		var parseErr *promql.ParseError
+		if !errors.As(err, &parseErr) {
+			return nil, err
+		}
+		result.Error = validationError(parseErr)
+		return result, nil
+	}
+	for _, w := range warnings {
+		result.Warnings = append(result.Warnings, validationError(w))
+	}
+	result.Valid = true
+	result.Type = expr.Type()
+	result.Metrics = promql.Metrics(expr)
+	result.Labels = promql.Labels(expr)
+	result.Tree = promql.Explain(expr)
+	return result, nil
+}
+
+func validationError(err *promql.ParseError) *models.QueryValidationError {
+	line, column := err.LineAndColumn()
+	return &models.QueryValidationError{Message: err.Err, Position: err.PositionRange, Line: line, Column: column}
+}
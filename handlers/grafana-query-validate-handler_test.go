@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"proxy-api-server/models"
+	"testing"
+)
+
+func TestValidateQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      models.QueryValidateRequest
+		query    string
+		valid    bool
+		warnings int
+	}{
+		{
+			name:  "rate interval",
+			req:   models.QueryValidateRequest{Query: `rate(x[$__rate_interval])`, Start: "0", End: "3600", Step: "1m"},
+			query: `rate(x[1m15s])`,
+			valid: true,
+		},
+		{
+			name:  "rate interval of a short step",
+			req:   models.QueryValidateRequest{Query: `rate(x[${__rate_interval}])`, Start: "0", End: "3600", Step: "15s"},
+			query: `rate(x[1m])`,
+			valid: true,
+		},
+		{
+			name:  "info",
+			req:   models.QueryValidateRequest{Query: `info(up)`},
+			query: `info(up)`,
+			valid: true,
+		},
+		{
+			name:  "quoted label names",
+			req:   models.QueryValidateRequest{Query: `{"foo"="bar"}`},
+			query: `{"foo"="bar"}`,
+			valid: true,
+		},
+		{
+			name:     "metricsql",
+			req:      models.QueryValidateRequest{Query: `rollup_rate(x[5m])`},
+			query:    `rollup_rate(x[5m])`,
+			valid:    true,
+			warnings: 1,
+		},
+		{
+			name:  "syntax error",
+			req:   models.QueryValidateRequest{Query: `sum(x`},
+			query: `sum(x`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ValidateQuery(&tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Query != tt.query || result.Valid != tt.valid || len(result.Warnings) != tt.warnings {
+				t.Errorf("ValidateQuery(%q) = %s, valid %t, %d warnings, error %v, want %s, valid %t, %d warnings",
+					tt.req.Query, result.Query, result.Valid, len(result.Warnings), result.Error, tt.query, tt.valid, tt.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"proxy-api-server/backup"
+	"proxy-api-server/config"
+	"proxy-api-server/dashdiff"
+	"proxy-api-server/gitsync"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// syncMu serializes the syncs, so that a commit is not computed from a
+// state of the instance an apply is changing.
+var syncMu sync.Mutex
+
+// GrafanaSyncsHandler lists the git syncs of the configuration.
+func GrafanaSyncsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, r, ListSyncs(r.Context()), nil)
+}
+
+// GrafanaSyncPlanHandler replies with the drift between git and the instance of the sync, see PlanSync.
+func GrafanaSyncPlanHandler(w http.ResponseWriter, r *http.Request) {
+	prune := false
+	if v := r.URL.Query().Get("prune"); v != "" {
+		var err error
+		if prune, err = strconv.ParseBool(v); err != nil {
+			util.WriteError(w, r, util.BadRequestError("invalid prune %q", v))
+			return
+		}
+	}
+	plan, err := PlanSync(util.NewGrafanaClient(), r.Context(), mux.Vars(r)["name"], prune)
+	respondJSON(w, r, plan, err)
+}
+
+// GrafanaSyncApplyHandler applies the dashboards of git to the instance of the sync, see ApplySync.
+func GrafanaSyncApplyHandler(w http.ResponseWriter, r *http.Request) {
+	applyReq := &models.SyncApplyRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(applyReq); err != nil {
+			util.WriteError(w, r, util.BadRequestError("invalid apply request: %s", err))
+			return
+		}
+	}
+	plan, err := ApplySync(util.NewGrafanaClient(), r.Context(), mux.Vars(r)["name"], applyReq)
+	respondJSON(w, r, plan, err)
+}
+
+// GrafanaSyncCommitHandler commits the dashboards of the instance of the sync to git, see CommitSync.
+func GrafanaSyncCommitHandler(w http.ResponseWriter, r *http.Request) {
+	commitReq := &models.SyncCommitRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(commitReq); err != nil {
+			util.WriteError(w, r, util.BadRequestError("invalid commit request: %s", err))
+			return
+		}
+	}
+	result, err := CommitSync(util.NewGrafanaClient(), r.Context(), mux.Vars(r)["name"], commitReq)
+	respondJSON(w, r, result, err)
+}
+
+// syncState is the state of the dashboards of a sync in git and in its instance.
+type syncState struct {
+	models.SyncInfo
+	repo       *gitsync.Repo
+	BaseURL    string
+	APIKey     string
+	dir        string
+	dashboards []*gitsync.Dashboard
+	invalid    map[string]string
+	// paths are the paths of the files of the repository
+	paths map[string]bool
+	// boards are the dashboards of the instance, in the order of the folder tree
+	boards []*models.FoundBoard
+	// parents are the parent uids of the folders of the instance by uid
+	parents map[string]string
+}
+
+// syncEntry is a change of a plan with the dashboards it is computed from.
+type syncEntry struct {
+	change *models.SyncChange
+	git    *gitsync.Dashboard
+	stored *storedDashboard
+}
+
+// ListSyncs returns the git syncs of the configuration with the commit of their branch.
+func ListSyncs(ctx context.Context) []*models.SyncInfo {
+	syncs := []*models.SyncInfo{}
+	for _, s := range config.Get().Syncs {
+		info := &models.SyncInfo{Name: s.Name, Instance: s.Instance, Repository: s.Repository, Branch: s.Branch, Path: s.Path}
+		if repo, err := gitsync.Open(ctx, s.Repository, s.Branch, s.AuthorName, s.AuthorEmail); err != nil {
+			log.Warningf("Git sync %s: %v", s.Name, err)
+		} else {
+			info.Branch = repo.Branch()
+			info.Commit, _ = repo.Head(ctx)
+		}
+		syncs = append(syncs, info)
+	}
+	return syncs
+}
+
+func openSync(g *models.GrafanaClient, ctx context.Context, name string) (*syncState, error) {
+	s, ok := config.Get().GetSync(name)
+	if !ok {
+		return nil, util.NotFoundError("sync %q not found", name)
+	}
+	BaseURL, APIKey, err := instanceCredentials(s.Instance)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := gitsync.Open(ctx, s.Repository, s.Branch, s.AuthorName, s.AuthorEmail)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dir := strings.Trim(s.Path, "/")
+	files, err := repo.Files(ctx, commit, dir)
+	if err != nil {
+		return nil, err
+	}
+	state := &syncState{
+		SyncInfo: models.SyncInfo{Name: s.Name, Instance: s.Instance, Repository: s.Repository, Branch: repo.Branch(), Path: dir, Commit: commit},
+		repo:     repo,
+		BaseURL:  BaseURL,
+		APIKey:   APIKey,
+		dir:      dir,
+		paths:    map[string]bool{},
+		boards:   []*models.FoundBoard{},
+		parents:  map[string]string{},
+	}
+	for _, f := range files {
+		state.paths[f.Path] = true
+	}
+	state.dashboards, state.invalid = gitsync.ParseDashboards(files, dir)
+
+	tree, err := FolderTree(g, ctx, BaseURL, APIKey)
+	if err != nil {
+		return nil, err
+	}
+	for pending := []*models.FolderNode{tree}; len(pending) > 0; pending = pending[1:] {
+		node := pending[0]
+		if node != tree {
+			state.parents[node.UID] = node.ParentUID
+		}
+		state.boards = append(state.boards, node.Dashboards...)
+		pending = append(pending, node.Folders...)
+	}
+	return state, nil
+}
+
+// PlanSync compares the dashboards of the branch of a sync with the ones of
+// its instance. The dashboards of git are told apart by their uid, and the
+// ones of a directory go to the folder of the uid of the directory name.
+// Untracked dashboards of the instance are planned for deletion with prune.
+func PlanSync(g *models.GrafanaClient, ctx context.Context, name string, prune bool) (*models.SyncPlan, error) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	state, err := openSync(g, ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return syncPlan(state, planSync(g, ctx, state, prune)), nil
+}
+
+func syncPlan(state *syncState, entries []*syncEntry) *models.SyncPlan {
+	plan := &models.SyncPlan{SyncInfo: state.SyncInfo, Changes: make([]*models.SyncChange, 0, len(entries)), Invalid: state.invalid}
+	for _, entry := range entries {
+		plan.Changes = append(plan.Changes, entry.change)
+	}
+	return plan
+}
+
+func planSync(g *models.GrafanaClient, ctx context.Context, state *syncState, prune bool) []*syncEntry {
+	entries := []*syncEntry{}
+	tracked := map[string]bool{}
+	current := map[string]bool{}
+	for _, board := range state.boards {
+		current[board.UID] = true
+	}
+	for _, d := range state.dashboards {
+		tracked[d.UID] = true
+		change := &models.SyncChange{UID: d.UID, Path: d.Path, FolderUID: d.FolderUID(), Action: "create"}
+		change.Title, _ = d.Dashboard["title"].(string)
+		entry := &syncEntry{change: change, git: d}
+		entries = append(entries, entry)
+		if !current[d.UID] {
+			continue
+		}
+		stored, err := getStoredDashboard(g, ctx, state.BaseURL, state.APIKey, d.UID)
+		if err != nil {
+			change.Action, change.Status, change.Error = "update", "failed", err.Error()
+			continue
+		}
+		entry.stored = stored
+		change.CurrentFolderUID = stored.Meta.FolderUID
+		gitHash, err := backup.DashboardHash(d.Dashboard, "")
+		if err != nil {
+			change.Action, change.Status, change.Error = "update", "failed", err.Error()
+			continue
+		}
+		currentHash, _ := backup.DashboardHash(stored.Dashboard, "")
+		switch {
+		case gitHash != currentHash:
+			change.Action = "update"
+			change.Diff = dashdiff.Diff(stored.Dashboard, d.Dashboard)
+			change.Diff.UID, change.Diff.From, change.Diff.To = d.UID, stored.Meta.Version, stored.Meta.Version+1
+		case change.FolderUID != change.CurrentFolderUID:
+			change.Action = "move"
+		default:
+			change.Action = "unchanged"
+		}
+	}
+	for _, board := range state.boards {
+		if tracked[board.UID] {
+			continue
+		}
+		change := &models.SyncChange{UID: board.UID, Title: board.Title, FolderUID: board.FolderUID, CurrentFolderUID: board.FolderUID, Action: "untracked"}
+		entry := &syncEntry{change: change}
+		entries = append(entries, entry)
+		if !prune {
+			continue
+		}
+		change.Action = "delete"
+		// the version is read so that a change since the plan is not deleted
+		stored, err := getStoredDashboard(g, ctx, state.BaseURL, state.APIKey, board.UID)
+		if err != nil {
+			change.Status, change.Error = "failed", err.Error()
+			continue
+		}
+		entry.stored = stored
+	}
+	return entries
+}
+
+// ApplySync makes the instance of a sync match the branch: it saves the
+// dashboards of git that it does not have or that differ, creating their
+// folders, and deletes the untracked dashboards with prune. A dashboard
+// that cannot be applied, such as one changed since the plan, is reported
+// in the result and does not stop the others.
+func ApplySync(g *models.GrafanaClient, ctx context.Context, name string, req *models.SyncApplyRequest) (*models.SyncPlan, error) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	state, err := openSync(g, ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	entries := planSync(g, ctx, state, req.Prune)
+
+	folders := []*models.Folder{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if entry.git == nil || entry.change.Action == "unchanged" {
+			continue
+		}
+		for _, folder := range entry.git.Folders {
+			if !seen[folder.UID] {
+				seen[folder.UID] = true
+				folders = append(folders, folder)
+			}
+		}
+	}
+	_, folderErrors := createFolders(g, ctx, state.BaseURL, state.APIKey, folders, false)
+
+	message := req.Message
+	if message == "" {
+		message = "Synced from git " + shortCommit(state.Commit)
+	}
+	applied := 0
+	for _, entry := range entries {
+		change := entry.change
+		if change.Status == "failed" {
+			continue
+		}
+		var err error
+		switch change.Action {
+		case "create", "update", "move":
+			if err = folderErrors[change.FolderUID]; err != nil {
+				break
+			}
+			var saved *models.DashboardSaveResult
+			saved, err = saveSyncDashboard(g, ctx, state, entry, message)
+			if err == nil {
+				change.Version = saved.Version
+			}
+		case "delete":
+			_, err = DeleteDashboard(g, ctx, state.BaseURL, state.APIKey, change.UID, entry.stored.Meta.Version)
+		default:
+			change.Status = "skipped"
+			continue
+		}
+		if err != nil {
+			change.Status, change.Error = "failed", err.Error()
+			continue
+		}
+		change.Status = "applied"
+		applied++
+	}
+	log.Infof("Applied %d dashboards of git sync %s at %s to instance %s", applied, state.Name, shortCommit(state.Commit), state.Instance)
+	return syncPlan(state, entries), nil
+}
+
+// saveSyncDashboard saves the dashboard of git of a change. A dashboard of
+// the instance is saved over the version the plan read, so that a change
+// since the plan is a conflict rather than overwritten.
+func saveSyncDashboard(g *models.GrafanaClient, ctx context.Context, state *syncState, entry *syncEntry, message string) (*models.DashboardSaveResult, error) {
+	if entry.stored == nil {
+		return CreateDashboard(g, ctx, state.BaseURL, state.APIKey, &models.DashboardSaveRequest{
+			Dashboard: entry.git.Dashboard,
+			FolderUID: entry.change.FolderUID,
+			Message:   message,
+		})
+	}
+	dashboard := entry.git.Dashboard
+	dashboard["id"] = entry.stored.Dashboard["id"]
+	dashboard["version"] = entry.stored.Meta.Version
+	return saveDashboard(g, ctx, state.BaseURL, state.APIKey, dashboard, entry.change.FolderUID, 0, false, message)
+}
+
+// CommitSync commits the dashboards of the instance of a sync that differ
+// from the branch, or that it does not track, to the branch, and with prune
+// removes the dashboards of the branch that the instance does not have.
+// A dashboard in another folder in the instance is moved to the directory
+// of the folder. Untracked dashboards are written as <uid>.json.
+func CommitSync(g *models.GrafanaClient, ctx context.Context, name string, req *models.SyncCommitRequest) (*models.SyncCommitResult, error) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	state, err := openSync(g, ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	entries := planSync(g, ctx, state, false)
+
+	changes := map[string][]byte{}
+	result := &models.SyncCommitResult{Written: []string{}, Removed: []string{}}
+	for _, entry := range entries {
+		change := entry.change
+		if change.Status == "failed" {
+			return nil, util.NewError(util.CodeUpstreamError, "unable to read dashboard %q: %s", change.UID, change.Error)
+		}
+		var p string
+		stored := entry.stored
+		switch change.Action {
+		case "update", "move":
+			p = change.Path
+			if change.FolderUID != change.CurrentFolderUID {
+				changes[p] = nil
+				result.Removed = append(result.Removed, p)
+				p = path.Join(gitsync.FolderDir(state.dir, change.CurrentFolderUID, state.parents), path.Base(p))
+				if state.paths[p] {
+					return nil, util.NewError(util.CodeConflict, "%s of dashboard %q is a file of the repository", p, change.UID)
+				}
+			}
+		case "untracked":
+			p = path.Join(gitsync.FolderDir(state.dir, change.CurrentFolderUID, state.parents), change.UID+".json")
+			if state.paths[p] {
+				return nil, util.NewError(util.CodeConflict, "%s of dashboard %q is a file of the repository", p, change.UID)
+			}
+			if stored, err = getStoredDashboard(g, ctx, state.BaseURL, state.APIKey, change.UID); err != nil {
+				return nil, err
+			}
+		case "create":
+			if req.Prune {
+				changes[change.Path] = nil
+				result.Removed = append(result.Removed, change.Path)
+			}
+			continue
+		default:
+			continue
+		}
+		data, err := gitsync.Encode(p, stored.Dashboard)
+		if err != nil {
+			return nil, util.Error("Unable to encode dashboard", err)
+		}
+		changes[p] = data
+		result.Written = append(result.Written, p)
+	}
+	if len(changes) == 0 {
+		return result, nil
+	}
+	sort.Strings(result.Written)
+	sort.Strings(result.Removed)
+
+	message := req.Message
+	if message == "" {
+		message = "Sync dashboards from instance " + state.Instance
+	}
+	if result.Commit, err = state.repo.Commit(ctx, state.Commit, changes, message); err != nil {
+		return nil, err
+	}
+	log.Infof("Committed %d dashboards of instance %s to git sync %s at %s", len(result.Written), state.Instance, state.Name, shortCommit(result.Commit))
+	return result, nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"proxy-api-server/gitsync"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"testing"
+)
+
+// newFakeDashboards serves the dashboard abc at version 3, saved by version
+// like Grafana, and counts the dashboards it saved and deleted.
+func newFakeDashboards(t *testing.T, saved, deleted *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/dashboards/uid/abc":
+			_, _ = w.Write([]byte(`{"dashboard":{"id":12,"uid":"abc","title":"ABC","version":3},"meta":{"version":3,"folderUid":"f"}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/dashboards/db":
+			payload := struct {
+				Dashboard map[string]interface{} `json:"dashboard"`
+				Overwrite bool                   `json:"overwrite"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("decode saved dashboard: %v", err)
+			}
+			if !payload.Overwrite && (payload.Dashboard["version"] != 3.0 || payload.Dashboard["id"] != 12.0) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				_, _ = w.Write([]byte(`{"status":"version-mismatch","message":"The dashboard has been changed by someone else"}`))
+				return
+			}
+			*saved++
+			_, _ = w.Write([]byte(`{"uid":"abc","version":4,"status":"success"}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/dashboards/uid/abc":
+			*deleted++
+			_, _ = w.Write([]byte(`{"title":"ABC","message":"Dashboard ABC deleted"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"not found"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestApplySyncConflictsWithChangesSincePlan(t *testing.T) {
+	ctx := context.Background()
+	saved, deleted := 0, 0
+	server := newFakeDashboards(t, &saved, &deleted)
+	state := &syncState{BaseURL: server.URL}
+	g := util.NewGrafanaClient()
+
+	entry := func(version int) *syncEntry {
+		stored, err := getStoredDashboard(g, ctx, server.URL, "", "abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored.Meta.Version = version
+		return &syncEntry{
+			change: &models.SyncChange{UID: "abc", FolderUID: "f", Action: "update"},
+			git:    &gitsync.Dashboard{UID: "abc", Dashboard: map[string]interface{}{"uid": "abc", "title": "ABC from git", "version": 1.0}},
+			stored: stored,
+		}
+	}
+
+	if result, err := saveSyncDashboard(g, ctx, state, entry(3), "sync"); err != nil || result.Version != 4 {
+		t.Errorf("saveSyncDashboard() at the current version = %v, %v, want version 4", result, err)
+	}
+	_, err := saveSyncDashboard(g, ctx, state, entry(2), "sync")
+	if apiErr := util.ToAPIError(err); apiErr.Code != util.CodeConflict {
+		t.Errorf("saveSyncDashboard() of a dashboard changed since the plan error = %v, want a conflict", err)
+	}
+	if saved != 1 {
+		t.Errorf("saved %d dashboards, want 1", saved)
+	}
+
+	_, err = DeleteDashboard(g, ctx, server.URL, "", "abc", entry(2).stored.Meta.Version)
+	if apiErr := util.ToAPIError(err); apiErr.Code != util.CodeConflict {
+		t.Errorf("DeleteDashboard() of a dashboard changed since the plan error = %v, want a conflict", err)
+	}
+	if _, err := DeleteDashboard(g, ctx, server.URL, "", "abc", entry(3).stored.Meta.Version); err != nil {
+		t.Errorf("DeleteDashboard() at the current version error = %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d dashboards, want 1", deleted)
+	}
+}
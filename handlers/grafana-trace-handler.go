@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"proxy-api-server/limits"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/timerange"
+	"proxy-api-server/traces"
+	"proxy-api-server/util"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana-tools/sdk"
+)
+
+// defaultTraceSearchLimit is the number of traces returned by a search that does not set a limit, as in Grafana.
+const defaultTraceSearchLimit = 20
+
+// errTraceNotFound is returned when the tracing backend does not know the requested trace.
+var errTraceNotFound = util.NotFoundError("trace not found")
+
+// GrafanaTraceHandler returns a trace by id from a Tempo or Jaeger datasource
+func GrafanaTraceHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	trace, err := GrafanaTrace(util.NewGrafanaClient(), r.Context(), params)
+	respondJSON(w, r, trace, err)
+}
+
+// GrafanaTraceSearchHandler searches traces of a Tempo or Jaeger datasource
+func GrafanaTraceSearchHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	summaries, err := GrafanaTraceSearch(util.NewGrafanaClient(), r.Context(), params)
+	respondJSON(w, r, &models.TraceSearchResult{Traces: summaries}, err)
+}
+
+func respondJSON(w http.ResponseWriter, r *http.Request, result interface{}, err error) {
+	if err != nil {
+		respondQuery(w, r, nil, err)
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		respondQuery(w, r, nil, err)
+		return
+	}
+	respondQuery(w, r, data, nil)
+}
+
+// traceIDRegex matches trace ids, of odd length too as Jaeger strips their leading zeros.
+var traceIDRegex = regexp.MustCompile(`^[0-9a-f]{1,32}$`)
+
+// GrafanaTrace fetches a trace by id and returns it as a span tree.
+func GrafanaTrace(g *models.GrafanaClient, ctx context.Context, params url.Values) (*models.Trace, error) {
+	if err := tenancy.RejectRestricted(ctx, "traces"); err != nil {
+		return nil, err
+	}
+	traceID := strings.ToLower(strings.TrimSpace(params.Get("traceId")))
+	if !traceIDRegex.MatchString(traceID) {
+		return nil, util.InvalidParameterError("traceId", params.Get("traceId"), "expected a hexadecimal trace id of up to 32 characters")
+	}
+	ds, baseURL, apiKey, err := resolveTraceDatasource(g, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := limits.WithTimeout(ctx, limits.For(ctx, strings.TrimSuffix(params.Get("grafanaUrl"), "/")))
+	defer cancel()
+	data, status, err := g.GetRequest(ctx, baseURL+"/api/traces/"+traceID, apiKey)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	if status == http.StatusNotFound {
+		return nil, errTraceNotFound
+	}
+	if status != http.StatusOK {
+		log.Errorf("Unable to get trace %s from %s due to status code: %d", traceID, ds.Name, status)
+		return nil, models.NewUpstreamError(status, baseURL+"/api/traces/"+traceID, data)
+	}
+
+	var trace *models.Trace
+	if ds.Type == "jaeger" {
+		trace, err = traces.DecodeJaegerTrace(traceID, data)
+	} else {
+		trace, err = traces.DecodeTempoTrace(traceID, data)
+	}
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	if trace.SpanCount == 0 {
+		return nil, errTraceNotFound
+	}
+	return trace, nil
+}
+
+// GrafanaTraceSearch searches traces by service, operation, tags and
+// duration over a time range, newest first.
+func GrafanaTraceSearch(g *models.GrafanaClient, ctx context.Context, params url.Values) ([]*models.TraceSummary, error) {
+	if err := tenancy.RejectRestricted(ctx, "traces"); err != nil {
+		return nil, err
+	}
+	start, end, err := timerange.ParseBounds(params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	tags, err := traces.ParseTags(params.Get("tags"))
+	if err != nil {
+		return nil, util.InvalidParameterError("tags", params.Get("tags"), "%s", err)
+	}
+	durations := map[string]string{}
+	for _, param := range []string{"minDuration", "maxDuration"} {
+		if v := params.Get(param); v != "" {
+			d, err := timerange.ParseDuration(v)
+			if err != nil {
+				return nil, &timerange.ParamError{Param: param, Value: v, Reason: err.Error()}
+			}
+			durations[param] = d.String()
+		}
+	}
+	limit := defaultTraceSearchLimit
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return nil, util.InvalidParameterError("limit", v, "must be a positive integer")
+		}
+	}
+	service, operation := params.Get("service"), params.Get("operation")
+
+	ds, baseURL, apiKey, err := resolveTraceDatasource(g, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	for param, d := range durations {
+		q.Set(param, d)
+	}
+	q.Set("limit", strconv.Itoa(limit))
+	var reqURL string
+	if ds.Type == "jaeger" {
+		if service == "" {
+			return nil, util.MissingParameterError("service", "Jaeger searches need a service")
+		}
+		q.Set("service", service)
+		if operation != "" {
+			q.Set("operation", operation)
+		}
+		if len(tags) > 0 {
+			encoded, _ := json.Marshal(tags)
+			q.Set("tags", string(encoded))
+		}
+		q.Set("start", strconv.FormatInt(start.UnixMicro(), 10))
+		q.Set("end", strconv.FormatInt(end.UnixMicro(), 10))
+		reqURL = baseURL + "/api/traces?" + q.Encode()
+	} else {
+		if service != "" {
+			tags["service.name"] = service
+		}
+		if operation != "" {
+			tags["name"] = operation
+		}
+		if len(tags) > 0 {
+			q.Set("tags", traces.FormatTags(tags))
+		}
+		q.Set("start", strconv.FormatInt(start.Unix(), 10))
+		q.Set("end", strconv.FormatInt(end.Unix(), 10))
+		reqURL = baseURL + "/api/search?" + q.Encode()
+	}
+
+	ctx, cancel := limits.WithTimeout(ctx, limits.For(ctx, strings.TrimSuffix(params.Get("grafanaUrl"), "/")))
+	defer cancel()
+	data, err := g.MakeRequest(ctx, reqURL, apiKey)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	var summaries []*models.TraceSummary
+	if ds.Type == "jaeger" {
+		summaries, err = traces.DecodeJaegerSearch(data)
+	} else {
+		summaries, err = traces.DecodeTempoSearch(data)
+	}
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].StartTime.After(summaries[j].StartTime)
+	})
+	return summaries, nil
+}
+
+// resolveTraceDatasource finds the Tempo or Jaeger datasource of a request,
+// selected by dsuid, dsid or name (ds), and returns it with the base URL of
+// its datasource proxy and the Grafana credential.
+func resolveTraceDatasource(g *models.GrafanaClient, ctx context.Context, params url.Values) (*sdk.Datasource, string, string, error) {
+	grafanaUrl := strings.TrimSuffix(params.Get("grafanaUrl"), "/")
+	apiKey := params.Get("apiKey")
+	if grafanaUrl == "" {
+		log.Error("Grafana url not provided")
+		return nil, "", "", util.MissingParameterError("grafanaUrl", "Grafana url not provided")
+	} else if apiKey == "" {
+		log.Error("Grafana api key (userId:password) not provided")
+		return nil, "", "", util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided")
+	}
+	c, err := sdk.NewClient(grafanaUrl, apiKey, g.HttpClient)
+	if err != nil {
+		return nil, "", "", util.UpstreamError(err)
+	}
+
+	var ds sdk.Datasource
+	switch {
+	case params.Get("dsuid") != "":
+		data, err := g.MakeRequest(ctx, grafanaUrl+"/api/datasources/uid/"+url.PathEscape(params.Get("dsuid")), apiKey)
+		if err != nil {
+			return nil, "", "", util.UpstreamError(err)
+		}
+		if err := json.Unmarshal(data, &ds); err != nil {
+			return nil, "", "", util.UpstreamError(err)
+		}
+	case params.Get("dsid") != "":
+		id, err := strconv.ParseUint(params.Get("dsid"), 10, 32)
+		if err != nil {
+			return nil, "", "", util.InvalidParameterError("dsid", params.Get("dsid"), "must be a datasource id")
+		}
+		if ds, err = c.GetDatasource(ctx, uint(id)); err != nil {
+			return nil, "", "", util.UpstreamError(err)
+		}
+	case params.Get("ds") != "":
+		if ds, err = c.GetDatasourceByName(ctx, params.Get("ds")); err != nil {
+			return nil, "", "", util.UpstreamError(err)
+		}
+	default:
+		return nil, "", "", util.MissingParameterError("ds", "Trace datasource not provided, expected dsuid, dsid or ds")
+	}
+	if ds.Type != "tempo" && ds.Type != "jaeger" {
+		return nil, "", "", util.BadRequestError("datasource %q is of type %s, expected tempo or jaeger", ds.Name, ds.Type)
+	}
+	return &ds, fmt.Sprintf("%s/api/datasources/proxy/%d", grafanaUrl, ds.ID), apiKey, nil
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"proxy-api-server/config"
+	"proxy-api-server/limits"
+	"proxy-api-server/util"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// InstanceHandler serves a handler taking the Grafana URL and credential as
+// the urlParam and keyParam query parameters for the registered instance
+// named by the {name} path variable. Other path variables are passed on as
+// query parameters of the same name. Only callers allowed to read through
+// the instances are served, see AccessHandler.
+func InstanceHandler(next http.HandlerFunc, urlParam, keyParam string) http.HandlerFunc {
+	return AccessHandler(instanceHandler(next, urlParam, keyParam), false)
+}
+
+// InstanceWriteHandler is InstanceHandler for the handlers changing Grafana
+// or the backups, which are only served to callers with a write role.
+func InstanceWriteHandler(next http.HandlerFunc, urlParam, keyParam string) http.HandlerFunc {
+	return AccessHandler(instanceHandler(next, urlParam, keyParam), true)
+}
+
+func instanceHandler(next http.HandlerFunc, urlParam, keyParam string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		instance, ok := config.Get().GetInstance(name)
+		if !ok {
+			util.WriteError(w, r, util.NotFoundError("instance %q not found", name))
+			return
+		}
+		q := pathParams(r)
+		q.Set(urlParam, strings.TrimSuffix(instance.URL, "/"))
+		q.Set(keyParam, instance.APIKey)
+		r.URL.RawQuery = q.Encode()
+		next(w, r)
+	}
+}
+
+// UpstreamHandler serves a handler taking the upstream query parameter for
+// the upstream named by the {name} path variable. Other path variables are
+// passed on as query parameters of the same name. Only callers allowed to
+// read through the upstreams are served, see AccessHandler.
+func UpstreamHandler(next http.HandlerFunc) http.HandlerFunc {
+	return AccessHandler(func(w http.ResponseWriter, r *http.Request) {
+		q := pathParams(r)
+		q.Set("upstream", mux.Vars(r)["name"])
+		r.URL.RawQuery = q.Encode()
+		next(w, r)
+	}, false)
+}
+
+// AccessHandler serves a handler using the credentials of the configuration
+// only to the callers authenticated by a trusted proxy whose role is allowed
+// by the access configuration to read, or to write when write is set.
+func AccessHandler(next http.HandlerFunc, write bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conf := config.Get()
+		role := limits.RoleFromRequest(r)
+		switch {
+		case !conf.Server.IsTrustedProxy(r.RemoteAddr) || role == "":
+			util.WriteError(w, r, util.NewError(util.CodeForbidden, "%s is only served to callers authenticated by a trusted proxy", r.URL.Path))
+		case write && !conf.Access.CanWrite(role):
+			util.WriteError(w, r, util.NewError(util.CodeForbidden, "role %q is not allowed to change Grafana through %s", role, r.URL.Path))
+		case !conf.Access.CanRead(role):
+			util.WriteError(w, r, util.NewError(util.CodeForbidden, "role %q is not allowed to use %s", role, r.URL.Path))
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// pathParams returns the query parameters of the request with the path
+// variables other than the instance name. Credentials given by the caller
+// are dropped, the instance or upstream provides them.
+func pathParams(r *http.Request) url.Values {
+	q := r.URL.Query()
+	for _, param := range []string{"grafanaUrl", "apiKey", "url", "api-key", "upstream"} {
+		q.Del(param)
+	}
+	for k, v := range mux.Vars(r) {
+		if k != "name" {
+			q.Set(k, v)
+		}
+	}
+	return q
+}
+
+// instanceCredentials returns the URL and credential of the registered instance of the name.
+func instanceCredentials(name string) (string, string, error) {
+	instance, ok := config.Get().GetInstance(name)
+	if !ok {
+		return "", "", util.BadRequestError("instance %q not found", name)
+	}
+	return strings.TrimSuffix(instance.URL, "/"), instance.APIKey, nil
+}
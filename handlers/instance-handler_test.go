@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"proxy-api-server/config"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestInstanceHandlerAccess(t *testing.T) {
+	conf := config.NewConfig()
+	conf.Server.TrustedProxies = []string{"10.0.0.1"}
+	conf.Access = config.Access{Roles: []string{"viewer"}, WriteRoles: []string{"operator"}}
+	conf.Instances = []config.Instance{{Name: "prod", URL: "http://grafana/", APIKey: "secret"}}
+	config.Set(conf)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		role       string
+		write      bool
+		want       int
+	}{
+		{name: "anonymous", remoteAddr: "192.0.2.1:1234", want: http.StatusForbidden},
+		{name: "role not from a trusted proxy", remoteAddr: "192.0.2.1:1234", role: "operator", want: http.StatusForbidden},
+		{name: "trusted proxy without role", remoteAddr: "10.0.0.1:1234", want: http.StatusForbidden},
+		{name: "reader", remoteAddr: "10.0.0.1:1234", role: "viewer", want: http.StatusOK},
+		{name: "writer reads", remoteAddr: "10.0.0.1:1234", role: "operator", want: http.StatusOK},
+		{name: "other role", remoteAddr: "10.0.0.1:1234", role: "guest", want: http.StatusForbidden},
+		{name: "reader writes", remoteAddr: "10.0.0.1:1234", role: "viewer", write: true, want: http.StatusForbidden},
+		{name: "writer", remoteAddr: "10.0.0.1:1234", role: "operator", write: true, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var apiKey string
+			next := func(w http.ResponseWriter, r *http.Request) {
+				apiKey = r.URL.Query().Get("apiKey")
+			}
+			handler := InstanceHandler(next, "grafanaUrl", "apiKey")
+			if tt.write {
+				handler = InstanceWriteHandler(next, "grafanaUrl", "apiKey")
+			}
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/instances/prod/dashboards?apiKey=mine", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.role != "" {
+				r.Header.Set(conf.Limits.RoleHeader, tt.role)
+			}
+			r = mux.SetURLVars(r, map[string]string{"name": "prod"})
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			// the credential of the instance only reaches the handler of allowed callers
+			wantKey := ""
+			if tt.want == http.StatusOK {
+				wantKey = "secret"
+			}
+			if apiKey != wantKey {
+				t.Errorf("handler got the apiKey %q, want %q", apiKey, wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"proxy-api-server/config"
+	"proxy-api-server/interpolate"
+	"proxy-api-server/limits"
+	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/timerange"
+	"proxy-api-server/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana-tools/sdk"
+)
+
+// lokiQueryParams are the request parameters of the Loki endpoints that are not template variables.
+var lokiQueryParams = []string{"upstream", "grafanaUrl", "apiKey", "ds", "dsid", "dsuid", "query", "start", "end", "limit", "direction", "step", "label", "interval"}
+
+// defaultLokiLimit is the number of log lines returned when the request does not set a limit, as in Grafana.
+const defaultLokiLimit = 100
+
+// lokiTarget is a resolved Loki backend: the client to use, the base URL of
+// the Loki HTTP API and the credential sent with every request.
+type lokiTarget struct {
+	client  *models.GrafanaClient
+	baseURL string
+	apiKey  string
+	// instance identifies the backend for per-instance query limits
+	instance string
+}
+
+// resolveLoki finds the Loki backend of a request. A configured upstream is
+// selected with the upstream parameter. Otherwise Loki is reached through
+// the datasource proxy of the Grafana given by grafanaUrl and apiKey, with
+// the datasource selected by dsuid, dsid or name (ds).
+func resolveLoki(ctx context.Context, params url.Values) (*lokiTarget, error) {
+	if name := params.Get("upstream"); name != "" {
+		client, baseURL, apiKey, err := upstreamClient(name, "loki")
+		if err != nil {
+			return nil, err
+		}
+		return &lokiTarget{client: client, baseURL: baseURL, apiKey: apiKey, instance: baseURL}, nil
+	}
+
+	grafanaUrl := strings.TrimSuffix(params.Get("grafanaUrl"), "/")
+	apiKey := params.Get("apiKey")
+	if grafanaUrl == "" {
+		log.Error("Grafana url not provided")
+		return nil, util.MissingParameterError("grafanaUrl", "Grafana url not provided")
+	} else if apiKey == "" {
+		log.Error("Grafana api key (userId:password) not provided")
+		return nil, util.MissingParameterError("apiKey", "Grafana api key (userId:password) not provided")
+	}
+	client := util.NewGrafanaClient()
+	target := &lokiTarget{client: client, apiKey: apiKey, instance: grafanaUrl}
+	switch {
+	case params.Get("dsuid") != "":
+		target.baseURL = fmt.Sprintf("%s/api/datasources/proxy/uid/%s", grafanaUrl, url.PathEscape(params.Get("dsuid")))
+	case params.Get("dsid") != "":
+		target.baseURL = fmt.Sprintf("%s/api/datasources/proxy/%s", grafanaUrl, url.PathEscape(params.Get("dsid")))
+	case params.Get("ds") != "":
+		c, err := sdk.NewClient(grafanaUrl, apiKey, client.HttpClient)
+		if err != nil {
+			return nil, util.UpstreamError(err)
+		}
+		ds, err := c.GetDatasourceByName(ctx, params.Get("ds"))
+		if err != nil {
+			return nil, util.UpstreamError(err)
+		}
+		if ds.Type != "loki" {
+			return nil, util.BadRequestError("datasource %q is of type %s, expected loki", ds.Name, ds.Type)
+		}
+		target.baseURL = fmt.Sprintf("%s/api/datasources/proxy/%d", grafanaUrl, ds.ID)
+	default:
+		return nil, util.MissingParameterError("ds", "Loki datasource not provided, expected upstream, dsuid, dsid or ds")
+	}
+	return target, nil
+}
+
+// lokiTime formats t as the epoch nanoseconds expected by the Loki API.
+func lokiTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// lokiQuery interpolates the LogQL query of a request.
+func lokiQuery(params url.Values, start, end time.Time) string {
+	vars := interpolate.FromQuery(params, lokiQueryParams...)
+	rangeDuration := end.Sub(start)
+	for name, value := range map[string]string{
+		"__range":    timerange.FormatDuration(rangeDuration),
+		"__range_s":  strconv.FormatInt(int64(rangeDuration.Seconds()), 10),
+		"__range_ms": strconv.FormatInt(rangeDuration.Milliseconds(), 10),
+	} {
+		if _, ok := vars[name]; !ok {
+			vars[name] = &interpolate.Variable{Name: name, Values: []string{value}}
+		}
+	}
+	return interpolate.Interpolate(params.Get("query"), vars, interpolate.Loki)
+}
+
+// lokiLimit reads the limit parameter, capped by the configured maximum.
+func lokiLimit(params url.Values) (int, error) {
+	limit := defaultLokiLimit
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, util.InvalidParameterError("limit", v, "must be a positive integer")
+		}
+	}
+	if max := config.Get().Loki.MaxLimit; max > 0 && limit > max {
+		return 0, util.InvalidParameterError("limit", params.Get("limit"), "at most %d log lines can be requested", max)
+	}
+	return limit, nil
+}
+
+// LokiQueryRangeHandler runs a LogQL query over a time range
+func LokiQueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	data, err := LokiQueryRange(r.Context(), params)
+	respondQuery(w, r, data, err)
+}
+
+// LokiLabelsHandler lists the label names known to Loki
+func LokiLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	data, err := LokiLabels(r.Context(), params)
+	respondQuery(w, r, data, err)
+}
+
+// LokiLabelValuesHandler lists the values of a label known to Loki
+func LokiLabelValuesHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	data, err := LokiLabelValues(r.Context(), params)
+	respondQuery(w, r, data, err)
+}
+
+// LokiQueryRange runs a LogQL query over a time range. Direction is backward
+// (newest lines first) unless forward is requested.
+func LokiQueryRange(ctx context.Context, params url.Values) ([]byte, error) {
+	if err := tenancy.RejectRestricted(ctx, "logs"); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.Get("query")) == "" {
+		return nil, util.MissingParameterError("query", "query is required")
+	}
+	start, end, err := timerange.ParseBounds(params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	limit, err := lokiLimit(params)
+	if err != nil {
+		return nil, err
+	}
+	direction := params.Get("direction")
+	if direction == "" {
+		direction = "backward"
+	} else if direction != "backward" && direction != "forward" {
+		return nil, util.InvalidParameterError("direction", direction, "expected backward or forward")
+	}
+	target, err := resolveLoki(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("query", lokiQuery(params, start, end))
+	q.Set("start", lokiTime(start))
+	q.Set("end", lokiTime(end))
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("direction", direction)
+	if v := params.Get("step"); v != "" {
+		q.Set("step", v)
+	}
+	return target.get(ctx, "/loki/api/v1/query_range", q)
+}
+
+// LokiLabels lists the label names seen in the time range.
+func LokiLabels(ctx context.Context, params url.Values) ([]byte, error) {
+	if err := tenancy.RejectRestricted(ctx, "logs"); err != nil {
+		return nil, err
+	}
+	start, end, err := timerange.ParseBounds(params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	target, err := resolveLoki(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("start", lokiTime(start))
+	q.Set("end", lokiTime(end))
+	return target.get(ctx, "/loki/api/v1/labels", q)
+}
+
+// LokiLabelValues lists the values of a label seen in the time range,
+// optionally restricted to the streams matching a query.
+func LokiLabelValues(ctx context.Context, params url.Values) ([]byte, error) {
+	if err := tenancy.RejectRestricted(ctx, "logs"); err != nil {
+		return nil, err
+	}
+	label := params.Get("label")
+	if label == "" {
+		return nil, util.MissingParameterError("label", "label is required")
+	}
+	start, end, err := timerange.ParseBounds(params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	target, err := resolveLoki(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("start", lokiTime(start))
+	q.Set("end", lokiTime(end))
+	if params.Get("query") != "" {
+		q.Set("query", lokiQuery(params, start, end))
+	}
+	return target.get(ctx, "/loki/api/v1/label/"+url.PathEscape(label)+"/values", q)
+}
+
+func (t *lokiTarget) get(ctx context.Context, path string, q url.Values) ([]byte, error) {
+	queryLimits := limits.For(ctx, t.instance)
+	ctx, cancel := limits.WithTimeout(ctx, queryLimits)
+	defer cancel()
+	data, err := t.client.MakeRequest(ctx, t.baseURL+path+"?"+q.Encode(), t.apiKey)
+	if err != nil {
+		return nil, util.UpstreamError(err)
+	}
+	return data, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"proxy-api-server/config"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/util"
+	"testing"
+)
+
+// newFakeLoki answers every request with an empty result and sends the
+// requests it received to received.
+func newFakeLoki(t *testing.T, received chan<- *http.Request) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// setLokiUpstream configures the fake Loki as the upstream named logs.
+func setLokiUpstream(server *httptest.Server) {
+	conf := config.NewConfig()
+	conf.Upstreams = []config.Upstream{{Name: "logs", Type: "loki", URL: server.URL}}
+	config.Set(conf)
+}
+
+func TestLokiQueryRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		params string
+		// want are the parameters Loki receives
+		want     url.Values
+		wantCode util.ErrorCode
+	}{
+		{
+			name:   "query",
+			params: `query={job="$job"}&job=api&start=0&end=60&step=30s&direction=forward&limit=10`,
+			want:   url.Values{"query": {`{job="api"}`}, "start": {"0"}, "end": {"60000000000"}, "step": {"30s"}, "direction": {"forward"}, "limit": {"10"}},
+		},
+		{
+			name:   "defaults",
+			params: `query={job="a"}&start=0&end=60`,
+			want:   url.Values{"query": {`{job="a"}`}, "start": {"0"}, "end": {"60000000000"}, "direction": {"backward"}, "limit": {"100"}},
+		},
+		{name: "no query", params: `query=%20`, wantCode: util.CodeMissingParameter},
+		{name: "invalid limit", params: `query={job="a"}&limit=-1`, wantCode: util.CodeInvalidParameter},
+		{name: "invalid direction", params: `query={job="a"}&direction=up`, wantCode: util.CodeInvalidParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan *http.Request, 1)
+			setLokiUpstream(newFakeLoki(t, received))
+			params, err := url.ParseQuery(tt.params + "&upstream=logs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = LokiQueryRange(context.Background(), params)
+			if tt.wantCode != "" {
+				if err == nil || util.ToAPIError(err).Code != tt.wantCode {
+					t.Fatalf("LokiQueryRange() error = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := <-received
+			if r.URL.Path != "/loki/api/v1/query_range" || r.URL.Query().Encode() != tt.want.Encode() {
+				t.Errorf("Loki received %s?%s, want /loki/api/v1/query_range?%s", r.URL.Path, r.URL.Query().Encode(), tt.want.Encode())
+			}
+		})
+	}
+}
+
+func TestLokiLabelValuesWithoutLabel(t *testing.T) {
+	setLokiUpstream(newFakeLoki(t, make(chan *http.Request, 1)))
+	_, err := LokiLabelValues(context.Background(), url.Values{"upstream": {"logs"}})
+	if err == nil || util.ToAPIError(err).Code != util.CodeMissingParameter {
+		t.Errorf("LokiLabelValues() error = %v, want %s", err, util.CodeMissingParameter)
+	}
+}
+
+// TestLogsAndTracesRejectRestrictedCallers checks that callers restricted to
+// a tenant cannot read logs and traces, which are not restricted to tenants.
+func TestLogsAndTracesRejectRestrictedCallers(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	server := newFakeLoki(t, received)
+	setLokiUpstream(server)
+	conf := config.Get()
+	conf.Tenancy.Tenants = map[string][]string{"team-a": {`namespace="a"`}, "admin": {}}
+	config.Set(conf)
+
+	params := url.Values{
+		"upstream": {"logs"}, "grafanaUrl": {server.URL}, "apiKey": {"key"}, "dsuid": {"traces"},
+		"query": {`{job="a"}`}, "label": {"job"}, "traceId": {"0af7651916cd43dd"}, "service": {"frontend"},
+	}
+	// status returns the status of the error of a call, 200 without error
+	status := func(_ []byte, err error) int {
+		if err != nil {
+			return util.ToAPIError(err).Status
+		}
+		return http.StatusOK
+	}
+	calls := map[string]func(ctx context.Context) int{
+		"query range":  func(ctx context.Context) int { return status(LokiQueryRange(ctx, params)) },
+		"labels":       func(ctx context.Context) int { return status(LokiLabels(ctx, params)) },
+		"label values": func(ctx context.Context) int { return status(LokiLabelValues(ctx, params)) },
+		"tail": func(ctx context.Context) int {
+			w := httptest.NewRecorder()
+			LokiTailHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/loki/tail?"+params.Encode(), nil).WithContext(ctx))
+			return w.Code
+		},
+		"trace": func(ctx context.Context) int {
+			_, err := GrafanaTrace(util.NewGrafanaClient(), ctx, params)
+			return status(nil, err)
+		},
+		"trace search": func(ctx context.Context) int {
+			_, err := GrafanaTraceSearch(util.NewGrafanaClient(), ctx, params)
+			return status(nil, err)
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if got := call(tenancy.WithTenant(context.Background(), "team-a")); got != http.StatusForbidden {
+				t.Errorf("restricted caller: status = %d, want %d", got, http.StatusForbidden)
+			}
+			select {
+			case r := <-received:
+				t.Errorf("restricted caller: upstream received %s", r.URL)
+			default:
+			}
+
+			// an unrestricted tenant passes the check, and fails later or reaches the upstream
+			if got := call(tenancy.WithTenant(context.Background(), "admin")); got == http.StatusForbidden {
+				t.Errorf("unrestricted caller: status = %d", got)
+			}
+			for len(received) > 0 {
+				<-received
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"proxy-api-server/config"
+	"proxy-api-server/log"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/timerange"
+	"proxy-api-server/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tailWriteTimeout bounds the time spent sending a message to a tail client.
+const tailWriteTimeout = 10 * time.Second
+
+var tailUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     tailOriginAllowed,
+}
+
+// tailOriginAllowed accepts the browser origins allowed by the CORS settings
+// of the server, and same-origin requests.
+func tailOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	conf := config.Get()
+	if conf.Server.CORSAllowAll {
+		if conf.Server.WhiteListUrls == "" {
+			return true
+		}
+		for _, allowed := range strings.Split(conf.Server.WhiteListUrls, ",") {
+			if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+				return true
+			}
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// lokiStream is a stream of a Loki query_range or tail response.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiStreamsResponse struct {
+	Data struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// lokiTailMessage has the shape of the messages of Loki's own tail API.
+type lokiTailMessage struct {
+	Streams []*lokiStream `json:"streams"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// LokiTailHandler streams new log lines matching a LogQL query to a browser
+// over a WebSocket. Loki is polled with forward queries from the newest line
+// already sent, so the same request parameters work through the Grafana
+// datasource proxy, which cannot proxy Loki's own tail WebSocket, and against
+// a direct upstream.
+func LokiTailHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	if err := tenancy.RejectRestricted(r.Context(), "logs"); err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(params.Get("query")) == "" {
+		util.WriteError(w, r, util.MissingParameterError("query", "query is required"))
+		return
+	}
+	limit, err := lokiLimit(params)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+	start := time.Now().Add(-timerange.DefaultRange)
+	if params.Get("start") != "" {
+		if start, _, err = timerange.ParseBounds(url.Values{"start": {params.Get("start")}}, time.Now()); err != nil {
+			util.WriteError(w, r, err)
+			return
+		}
+	}
+	target, err := resolveLoki(r.Context(), params)
+	if err != nil {
+		util.WriteError(w, r, err)
+		return
+	}
+
+	conn, err := tailUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied to the client
+		log.Errorf("Unable to upgrade the tail connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// the client only sends control messages, reading them detects a closed connection
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	interval := config.Get().Loki.TailInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	tail := &lokiTail{target: target, params: params, limit: limit, from: start, seen: map[string]bool{}}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		streams, err := tail.poll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Errorf("Loki tail query failed: %v", err)
+			_ = conn.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+			_ = conn.WriteJSON(&lokiTailMessage{Streams: []*lokiStream{}, Error: err.Error()})
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "upstream query failed"))
+			return
+		}
+		if len(streams) > 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+			if err := conn.WriteJSON(&lokiTailMessage{Streams: streams}); err != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lokiTail tracks the position of a live tail.
+type lokiTail struct {
+	target *lokiTarget
+	params url.Values
+	limit  int
+	// from is the timestamp of the newest line sent so far
+	from time.Time
+	// seen holds the lines sent with the from timestamp. Loki's start is
+	// inclusive, so they come back in the next poll and must be skipped.
+	seen map[string]bool
+}
+
+// poll returns the lines written since the previous poll.
+func (t *lokiTail) poll(ctx context.Context) ([]*lokiStream, error) {
+	now := time.Now()
+	q := url.Values{}
+	q.Set("query", lokiQuery(t.params, t.from, now))
+	q.Set("start", lokiTime(t.from))
+	q.Set("end", lokiTime(now))
+	q.Set("limit", strconv.Itoa(t.limit))
+	q.Set("direction", "forward")
+	data, err := t.target.get(ctx, "/loki/api/v1/query_range", q)
+	if err != nil {
+		return nil, err
+	}
+	resp := lokiStreamsResponse{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode loki response: %v", err)
+	}
+	if resp.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("tail needs a log query, the query returned %s", resp.Data.ResultType)
+	}
+	result := []*lokiStream{}
+	if err := json.Unmarshal(resp.Data.Result, &result); err != nil {
+		return nil, fmt.Errorf("unable to decode loki streams: %v", err)
+	}
+
+	newest := t.from.UnixNano()
+	streams := []*lokiStream{}
+	for _, s := range result {
+		labels, _ := json.Marshal(s.Stream)
+		values := [][2]string{}
+		for _, v := range s.Values {
+			ts, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			key := string(labels) + "\x00" + v[0] + "\x00" + v[1]
+			if ts < t.from.UnixNano() || (ts == t.from.UnixNano() && t.seen[key]) {
+				continue
+			}
+			if ts > newest {
+				newest = ts
+			}
+			values = append(values, v)
+		}
+		if len(values) > 0 {
+			streams = append(streams, &lokiStream{Stream: s.Stream, Values: values})
+		}
+	}
+
+	if newest != t.from.UnixNano() {
+		t.from = time.Unix(0, newest)
+		t.seen = map[string]bool{}
+	}
+	for _, s := range streams {
+		labels, _ := json.Marshal(s.Stream)
+		for _, v := range s.Values {
+			if v[0] == strconv.FormatInt(newest, 10) {
+				t.seen[string(labels)+"\x00"+v[0]+"\x00"+v[1]] = true
+			}
+		}
+	}
+	return streams, nil
+}
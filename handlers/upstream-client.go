@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"proxy-api-server/config"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"strings"
+)
+
+// upstreamClient returns a client talking directly to a configured upstream,
+// with the base URL of its API and its credential.
+func upstreamClient(name, upstreamType string) (*models.GrafanaClient, string, string, error) {
+	conf := config.Get()
+	upstream, ok := conf.GetUpstream(name, upstreamType)
+	if !ok {
+		return nil, "", "", util.NewError(util.CodeInvalidParameter, "unknown %s upstream %q", upstreamType, name)
+	}
+	client := util.NewGrafanaClient()
+	client.PromMode = true
+	client.TenantID = upstream.TenantID
+	return client, strings.TrimSuffix(upstream.URL, "/"), upstream.Credential(), nil
+}
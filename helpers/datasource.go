@@ -0,0 +1,110 @@
+package helpers
+
+import (
+	"strings"
+)
+
+// specialDatasources are the datasources built in Grafana, referenced by
+// name or uid, which exist in every instance.
+var specialDatasources = map[string]bool{
+	"-- Grafana --":   true,
+	"grafana":         true,
+	"-- Mixed --":     true,
+	"-- Dashboard --": true,
+}
+
+// IsDatasourceVariable reports whether a datasource reference is a template
+// variable, such as $ds or ${ds}, which ProcessBoard resolves with the
+// datasource variables of the board.
+func IsDatasourceVariable(ref string) bool {
+	return strings.HasPrefix(ref, "$")
+}
+
+// DatasourceVariable returns the name of the template variable of a datasource
+// reference, such as ds for "$ds", "${ds}" or {"uid": "${ds}"}.
+func DatasourceVariable(ref interface{}) (string, bool) {
+	var id string
+	switch ref := ref.(type) {
+	case string:
+		id = ref
+	case map[string]interface{}:
+		id, _ = ref["uid"].(string)
+	}
+	if !IsDatasourceVariable(id) {
+		return "", false
+	}
+	name := strings.TrimPrefix(id, "$")
+	if strings.HasPrefix(name, "{") && strings.HasSuffix(name, "}") {
+		name = name[1 : len(name)-1]
+	}
+	return name, true
+}
+
+// DatasourceRef returns the name or uid of a datasource reference of a
+// dashboard JSON model, a name before Grafana 8.3 and an object with a uid
+// since. Default, built-in and variable references have no datasource.
+func DatasourceRef(ref interface{}) (string, bool) {
+	var id string
+	switch ref := ref.(type) {
+	case string:
+		id = ref
+	case map[string]interface{}:
+		id, _ = ref["uid"].(string)
+	}
+	if id == "" || specialDatasources[id] || IsDatasourceVariable(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// ReplaceDatasourceRefs calls replace with every datasource reference of a
+// dashboard JSON model, the ones of its panels, including the panels of
+// rows, their queries, its template variables and annotations, and sets
+// the reference to the value it returns. Like ProcessBoard, the rows of
+// Grafana before 5.0 are only read when the dashboard has no panels.
+func ReplaceDatasourceRefs(dashboard map[string]interface{}, replace func(ref interface{}) interface{}) {
+	replaceIn := func(object map[string]interface{}) {
+		if ref, ok := object["datasource"]; ok && ref != nil {
+			object["datasource"] = replace(ref)
+		}
+	}
+	var panels func(list interface{})
+	panels = func(list interface{}) {
+		for _, panel := range objects(list) {
+			replaceIn(panel)
+			for _, target := range objects(panel["targets"]) {
+				replaceIn(target)
+			}
+			// collapsed rows hold their panels
+			panels(panel["panels"])
+		}
+	}
+	panels(dashboard["panels"])
+	// rows of the dashboards of Grafana before 5.0
+	if len(objects(dashboard["panels"])) == 0 {
+		for _, row := range objects(dashboard["rows"]) {
+			panels(row["panels"])
+		}
+	}
+	for _, section := range []string{"templating", "annotations"} {
+		if object, ok := dashboard[section].(map[string]interface{}); ok {
+			for _, item := range objects(object["list"]) {
+				// datasource variables reference a plugin type in their query, not a datasource
+				if item["type"] != "datasource" {
+					replaceIn(item)
+				}
+			}
+		}
+	}
+}
+
+func objects(list interface{}) []map[string]interface{} {
+	items, _ := list.([]interface{})
+	result := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			result = append(result, object)
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"proxy-api-server/models"
+	"reflect"
+	"testing"
+
+	"github.com/grafana-tools/sdk"
+)
+
+func TestDatasourceVariable(t *testing.T) {
+	tests := []struct {
+		ref  interface{}
+		want string
+		ok   bool
+	}{
+		{"$ds", "ds", true},
+		{"${ds}", "ds", true},
+		{map[string]interface{}{"type": "prometheus", "uid": "${ds}"}, "ds", true},
+		{"prometheus", "", false},
+		{map[string]interface{}{"uid": "abc"}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := DatasourceVariable(tt.ref)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("DatasourceVariable(%v) = %q, %t, want %q, %t", tt.ref, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReplaceDatasourceRefs(t *testing.T) {
+	dashboard := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{
+		"panels": [
+			{"datasource": "a", "targets": [{"datasource": {"uid": "b"}}]},
+			{"type": "row", "panels": [{"datasource": "c"}]}
+		],
+		"rows": [{"panels": [{"datasource": "ignored"}]}],
+		"templating": {"list": [{"type": "query", "datasource": "d"}, {"type": "datasource", "query": "prometheus"}]},
+		"annotations": {"list": [{"datasource": "e"}]}
+	}`), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	ReplaceDatasourceRefs(dashboard, func(ref interface{}) interface{} {
+		if id, ok := DatasourceRef(ref); ok {
+			got = append(got, id)
+		}
+		return ref
+	})
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReplaceDatasourceRefs() walked %v, want %v", got, want)
+	}
+}
+
+func TestProcessBoardResolvesDatasourceVariables(t *testing.T) {
+	board := &sdk.Board{}
+	if err := json.Unmarshal([]byte(`{
+		"templating": {"list": [{"name": "ds", "type": "datasource", "query": "prometheus"}]},
+		"panels": [
+			{"type": "graph", "datasource": "$ds"},
+			{"type": "graph", "datasource": {"type": "prometheus", "uid": "${ds}"}}
+		]
+	}`), board); err != nil {
+		t.Fatal(err)
+	}
+	grafBoard, err := ProcessBoard(&models.GrafanaClient{PromMode: true}, context.Background(), nil, board, &sdk.FoundBoard{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grafBoard.Panels) != 2 {
+		t.Fatalf("ProcessBoard() returned %d panels, want 2", len(grafBoard.Panels))
+	}
+	for _, p := range grafBoard.Panels {
+		ds, ok := p.Datasource.(*models.GrafanaDataSource)
+		if !ok || ds.Name != "Prometheus" {
+			t.Errorf("panel datasource = %#v, want Prometheus", p.Datasource)
+		}
+	}
+}
@@ -18,7 +18,7 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 	if !g.PromMode {
 		org, err := c.GetActualOrg(ctx)
 		if err != nil {
-			return nil, util.CommonError(err)
+			return nil, util.UpstreamError(err)
 		}
 		orgID = org.ID
 	}
@@ -45,11 +45,10 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 				dsName = cases.Title(language.Und).String(strings.ToLower(fmt.Sprint(tmpVar.Query))) // datasource name can be found in the query field
 				tmpDsName[tmpVar.Name] = dsName
 			} else if tmpVar.Type == "query" && tmpVar.Datasource != nil {
-				tempDsStr := fmt.Sprint(tmpVar.Datasource)
-				if !strings.HasPrefix(tempDsStr, "$") {
-					dsName = tempDsStr
+				if name, ok := DatasourceVariable(tmpVar.Datasource); ok {
+					dsName = tmpDsName[name]
 				} else {
-					dsName = tmpDsName[strings.Replace(tempDsStr, "$", "", 1)]
+					dsName = fmt.Sprint(tmpVar.Datasource)
 				}
 				// if !strings.HasPrefix(*tmpVar.Datasource, "$") {
 				// 	dsName = *tmpVar.Datasource
@@ -57,15 +56,12 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 				// 	dsName = tmpDsName[strings.Replace(*tmpVar.Datasource, "$", "", 1)]
 				// }
 			} else {
-				err := fmt.Errorf("unable to get datasource name for tmpvar: %+#v", tmpVar)
-				// logrus.Error(err)
-				util.CommonError(err)
-				return nil, err
+				return nil, util.Error("Unable to get datasource name", fmt.Errorf("unable to get datasource name for tmpvar: %+#v", tmpVar))
 			}
 			if c != nil {
 				ds, err = c.GetDatasourceByName(ctx, dsName)
 				if err != nil {
-					return nil, util.DashboardError(err, "Error getting Grafana Board's Datasource")
+					return nil, util.ResourceError(err, "datasource", dsName)
 				}
 			} else {
 				ds.Name = dsName
@@ -90,13 +86,7 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 		for _, p1 := range board.Panels {
 			if p1.OfType != sdk.TextType && p1.OfType != sdk.TableType && p1.Type != "row" { // turning off text ,table and row panels for now
 				if p1.Datasource != nil {
-					tempDsStr := fmt.Sprint(p1.Datasource)
-					if strings.HasPrefix(tempDsStr, "$") { // Formating Datasource id
-						p1.Datasource = &models.GrafanaDataSource{
-							Name: tmpDsName[strings.Replace(tempDsStr, "$", "", 1)],
-						}
-
-					}
+					p1.Datasource = resolveDatasourceVariable(p1.Datasource, tmpDsName)
 					// if strings.HasPrefix(*p1.Datasource, "$") { // Formating Datasource id
 					// 	*p1.Datasource = tmpDsName[strings.Replace(*p1.Datasource, "$", "", 1)]
 					// }
@@ -105,12 +95,7 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 			} else if p1.OfType != sdk.TextType && p1.OfType != sdk.TableType && p1.Type == "row" && len(p1.Panels) > 0 { // Looking for Panels with Row
 				for _, p2 := range p1.Panels { // Adding Panels inside the Row Panel to grafBoard
 					if p2.OfType != sdk.TextType && p2.OfType != sdk.TableType && p2.Type != "row" {
-						tempDsStr := fmt.Sprint(p2.Datasource)
-						if strings.HasPrefix(tempDsStr, "$") { // Formating Datasource id
-							p2.Datasource = &models.GrafanaDataSource{
-								Name: tmpDsName[strings.Replace(tempDsStr, "$", "", 1)],
-							}
-						}
+						p2.Datasource = resolveDatasourceVariable(p2.Datasource, tmpDsName)
 						// if strings.HasPrefix(*p2.Datasource, "$") { // Formating Datasource id
 						// 	*p2.Datasource = tmpDsName[strings.Replace(*p2.Datasource, "$", "", 1)]
 						// }
@@ -125,13 +110,7 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 			} else {
 				if p1.OfType == sdk.TableType && p1.Type == "table" {
 					if p1.Datasource != nil {
-						tempDsStr := fmt.Sprint(p1.Datasource)
-						if strings.HasPrefix(tempDsStr, "$") { // Formating Datasource id
-							p1.Datasource = &models.GrafanaDataSource{
-								Name: tmpDsName[strings.Replace(tempDsStr, "$", "", 1)],
-							}
-
-						}
+						p1.Datasource = resolveDatasourceVariable(p1.Datasource, tmpDsName)
 						// if strings.HasPrefix(*p1.Datasource, "$") { // Formating Datasource id
 						// 	*p1.Datasource = tmpDsName[strings.Replace(*p1.Datasource, "$", "", 1)]
 						// }
@@ -144,12 +123,7 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 		for _, r1 := range board.Rows {
 			for _, p2 := range r1.Panels {
 				if p2.OfType != sdk.TextType && p2.OfType != sdk.TableType && p2.Type != "row" { // turning off text, table and row panels for now
-					tempDsStr := fmt.Sprint(p2.Datasource)
-					if strings.HasPrefix(tempDsStr, "$") { // Formating Datasource id
-						p2.Datasource = &models.GrafanaDataSource{
-							Name: tmpDsName[strings.Replace(tempDsStr, "$", "", 1)],
-						}
-					}
+					p2.Datasource = resolveDatasourceVariable(p2.Datasource, tmpDsName)
 					// if strings.HasPrefix(*p2.Datasource, "$") { // Formating Datasource id
 					// 	*p2.Datasource = tmpDsName[strings.Replace(*p2.Datasource, "$", "", 1)]
 					// }
@@ -165,6 +139,16 @@ func ProcessBoard(g *models.GrafanaClient, ctx context.Context, c *sdk.Client, b
 	return grafBoard, nil
 }
 
+// resolveDatasourceVariable replaces a datasource reference to a template
+// variable with the datasource of the variable, names maps the datasource
+// variables of the board to their datasource.
+func resolveDatasourceVariable(ref interface{}, names map[string]string) interface{} {
+	if name, ok := DatasourceVariable(ref); ok {
+		return &models.GrafanaDataSource{Name: names[name]}
+	}
+	return ref
+}
+
 func Validate(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey string) error {
 	fmt.Println("Staring Validate")
 	if strings.HasSuffix(BaseURL, "/") {
@@ -172,11 +156,11 @@ func Validate(g *models.GrafanaClient, ctx context.Context, BaseURL, APIKey stri
 	}
 	c, err := sdk.NewClient(BaseURL, APIKey, g.HttpClient)
 	if err != nil {
-		return util.CommonError(err)
+		return util.UpstreamError(err)
 	}
 
 	if _, err := c.GetActualOrg(ctx); err != nil {
-		return util.CommonError(err)
+		return util.UpstreamError(err)
 	}
 	fmt.Println("Validate completed")
 	return nil
@@ -0,0 +1,62 @@
+package internalmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsType defines all of the server's internal metrics.
+type MetricsType struct {
+	CoalesceUpstreamCalls *prometheus.CounterVec
+	CoalescedRequests     *prometheus.CounterVec
+	DeprecatedRequests    *prometheus.CounterVec
+}
+
+// Metrics contains all of the server's internal metrics.
+// These are the metrics that Prometheus scrapes from the /metrics endpoint.
+var Metrics = MetricsType{
+	CoalesceUpstreamCalls: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_api_coalesce_upstream_calls_total",
+			Help: "The number of upstream calls actually made by a request coalescing group.",
+		},
+		[]string{"operation", "route"},
+	),
+	CoalescedRequests: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_api_coalesced_requests_total",
+			Help: "The number of requests that shared an identical in-flight upstream call instead of making their own.",
+		},
+		[]string{"operation", "route"},
+	),
+	DeprecatedRequests: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_api_deprecated_requests_total",
+			Help: "The number of requests to deprecated routes, which are retired once unused.",
+		},
+		[]string{"route", "method"},
+	),
+}
+
+// RegisterInternalMetrics must be called at startup to prepare the Prometheus scrape endpoint.
+func RegisterInternalMetrics() {
+	prometheus.MustRegister(
+		Metrics.CoalesceUpstreamCalls,
+		Metrics.CoalescedRequests,
+		Metrics.DeprecatedRequests,
+	)
+}
+
+// GetCoalesceUpstreamCallsMetric returns the counter of upstream calls made for the given operation and route template.
+func GetCoalesceUpstreamCallsMetric(operation, route string) prometheus.Counter {
+	return Metrics.CoalesceUpstreamCalls.With(prometheus.Labels{"operation": operation, "route": route})
+}
+
+// GetCoalescedRequestsMetric returns the counter of requests saved by coalescing for the given operation and route template.
+func GetCoalescedRequestsMetric(operation, route string) prometheus.Counter {
+	return Metrics.CoalescedRequests.With(prometheus.Labels{"operation": operation, "route": route})
+}
+
+// GetDeprecatedRequestsMetric returns the counter of requests to the given deprecated route.
+func GetDeprecatedRequestsMetric(route, method string) prometheus.Counter {
+	return Metrics.DeprecatedRequests.With(prometheus.Labels{"route": route, "method": method})
+}
@@ -0,0 +1,218 @@
+package interpolate
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type formatter func(name string, values []string, multi bool) string
+
+// formats implements Grafana's named variable formats (${var:format}).
+var formats = map[string]formatter{
+	"csv":           formatCSV,
+	"distributed":   formatDistributed,
+	"doublequote":   formatDoubleQuote,
+	"glob":          formatGlob,
+	"json":          formatJSON,
+	"lucene":        formatLucene,
+	"percentencode": formatPercentEncode,
+	"pipe":          formatPipe,
+	"queryparam":    formatQueryParam,
+	"raw":           formatCSV,
+	"regex":         formatRegex,
+	"singlequote":   formatSingleQuote,
+	"sqlstring":     formatSQLString,
+	"text":          formatText,
+}
+
+var (
+	regexSpecialChars      = regexp.MustCompile(`[\\^$*+?.()|\[\]{}/]`)
+	luceneSpecialChars     = regexp.MustCompile(`[!*+\-=<>\s&|()\[\]{}^~?:\\/"]`)
+	prometheusSpecialChars = regexp.MustCompile(`[$^*{}\[\]'+?.()|]`)
+	lokiSpecialChars       = regexp.MustCompile(`[$^*{}\[\]+?.()|]`)
+)
+
+func formatCSV(_ string, values []string, _ bool) string {
+	return strings.Join(values, ",")
+}
+
+func formatText(_ string, values []string, _ bool) string {
+	return strings.Join(values, " + ")
+}
+
+func formatPipe(_ string, values []string, _ bool) string {
+	return strings.Join(values, "|")
+}
+
+func formatGlob(_ string, values []string, _ bool) string {
+	if len(values) > 1 {
+		return "{" + strings.Join(values, ",") + "}"
+	}
+	return strings.Join(values, "")
+}
+
+func formatDistributed(name string, values []string, _ bool) string {
+	out := make([]string, 0, len(values))
+	for i, v := range values {
+		if i == 0 {
+			out = append(out, v)
+			continue
+		}
+		out = append(out, name+"="+v)
+	}
+	return strings.Join(out, ",")
+}
+
+func formatRegex(_ string, values []string, _ bool) string {
+	escaped := mapValues(values, regexEscape)
+	if len(escaped) == 1 {
+		return escaped[0]
+	}
+	return "(" + strings.Join(escaped, "|") + ")"
+}
+
+func formatLucene(_ string, values []string, _ bool) string {
+	if len(values) == 1 {
+		return luceneEscape(values[0])
+	}
+	quoted := mapValues(values, func(v string) string {
+		return `"` + luceneEscape(v) + `"`
+	})
+	return "(" + strings.Join(quoted, " OR ") + ")"
+}
+
+func formatPercentEncode(_ string, values []string, _ bool) string {
+	if len(values) > 1 {
+		return encodeURIComponent("{" + strings.Join(values, ",") + "}")
+	}
+	return encodeURIComponent(strings.Join(values, ""))
+}
+
+func formatQueryParam(name string, values []string, _ bool) string {
+	params := mapValues(values, func(v string) string {
+		return "var-" + encodeURIComponent(name) + "=" + encodeURIComponent(v)
+	})
+	return strings.Join(params, "&")
+}
+
+func formatSingleQuote(_ string, values []string, _ bool) string {
+	quoted := mapValues(values, func(v string) string {
+		return "'" + strings.ReplaceAll(v, "'", `\'`) + "'"
+	})
+	return strings.Join(quoted, ",")
+}
+
+func formatDoubleQuote(_ string, values []string, _ bool) string {
+	quoted := mapValues(values, func(v string) string {
+		return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+	})
+	return strings.Join(quoted, ",")
+}
+
+func formatSQLString(_ string, values []string, _ bool) string {
+	return strings.Join(mapValues(values, sqlQuoteLiteral), ",")
+}
+
+func formatJSON(_ string, values []string, multi bool) string {
+	var b []byte
+	if multi {
+		b, _ = json.Marshal(values)
+	} else {
+		b, _ = json.Marshal(strings.Join(values, ""))
+	}
+	return string(b)
+}
+
+// formatPrometheus mirrors the Prometheus datasource: single values are
+// escaped for use inside a string literal, multi-value and "All" variables
+// become an alternation usable with =~.
+func formatPrometheus(values []string, multi bool) string {
+	if !multi {
+		return prometheusRegularEscape(strings.Join(values, ""))
+	}
+	escaped := mapValues(values, prometheusSpecialRegexEscape)
+	if len(escaped) == 1 {
+		return escaped[0]
+	}
+	return "(" + strings.Join(escaped, "|") + ")"
+}
+
+// formatLoki mirrors the Loki datasource, which joins multiple values without
+// surrounding parentheses.
+func formatLoki(values []string, multi bool) string {
+	if !multi {
+		return lokiRegularEscape(strings.Join(values, ""))
+	}
+	return strings.Join(mapValues(values, lokiSpecialRegexEscape), "|")
+}
+
+// formatSQL mirrors the SQL datasources: single values are escaped, multi-value
+// and "All" variables become a comma separated list of quoted literals.
+func formatSQL(values []string, multi bool) string {
+	if !multi {
+		return strings.ReplaceAll(strings.Join(values, ""), "'", "''")
+	}
+	return strings.Join(mapValues(values, sqlQuoteLiteral), ",")
+}
+
+func regexEscape(v string) string {
+	return regexSpecialChars.ReplaceAllString(v, `\$0`)
+}
+
+func luceneEscape(v string) string {
+	return luceneSpecialChars.ReplaceAllString(v, `\$0`)
+}
+
+func prometheusRegularEscape(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return strings.ReplaceAll(v, "'", `\\'`)
+}
+
+func prometheusSpecialRegexEscape(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\\\`)
+	return prometheusSpecialChars.ReplaceAllString(v, `\\$0`)
+}
+
+func lokiRegularEscape(v string) string {
+	return strings.ReplaceAll(v, "'", `\\'`)
+}
+
+func lokiSpecialRegexEscape(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\\\`)
+	return lokiRegularEscape(lokiSpecialChars.ReplaceAllString(v, `\\$0`))
+}
+
+func sqlQuoteLiteral(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// encodeURIComponent escapes v like the JavaScript function of the same name.
+func encodeURIComponent(v string) string {
+	var sb strings.Builder
+	for _, b := range []byte(v) {
+		if isUnreservedURIChar(b) {
+			sb.WriteByte(b)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%%%02X", b))
+	}
+	return sb.String()
+}
+
+func isUnreservedURIChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("-_.!~*'()", b) > -1
+}
+
+func mapValues(values []string, fn func(string) string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, fn(v))
+	}
+	return out
+}
@@ -0,0 +1,168 @@
+package interpolate
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Dialect selects the default formatting applied to a variable when the
+// expression does not request an explicit format (e.g. ${var:csv}).
+type Dialect int
+
+const (
+	// Raw follows Grafana's fallback behaviour and formats values as a glob.
+	Raw Dialect = iota
+	Prometheus
+	Loki
+	SQL
+	Lucene
+)
+
+// AllToken is the value Grafana uses in URLs and variable state for "All".
+const AllToken = "$__all"
+
+// Variable is a single template variable with its current selection.
+type Variable struct {
+	Name       string
+	Values     []string
+	Multi      bool
+	IncludeAll bool
+	// AllValue is the custom "All" value of the variable. When set it is
+	// substituted verbatim, without any escaping.
+	AllValue string
+	// Options are all the values the variable can take. They are used to
+	// expand "All" when no AllValue is configured.
+	Options []string
+}
+
+// IsAll reports whether the variable currently has "All" selected.
+func (v *Variable) IsAll() bool {
+	return len(v.Values) == 1 && (v.Values[0] == AllToken || v.Values[0] == "All")
+}
+
+// Variables holds template variables keyed by name.
+type Variables map[string]*Variable
+
+// Grafana's variable syntax: $var, [[var]], [[var:format]], ${var}, ${var.field} and ${var:format}
+var variableRegex = regexp.MustCompile(`\$(\w+)|\[\[(\w+?)(?::(\w+))?\]\]|\$\{(\w+)(?:\.([^:^\}]+))?(?::([^\}]+))?\}`)
+
+// FromQuery builds variables from request parameters. Every parameter that is
+// not listed in reserved becomes a variable; a "var-" prefix, as used in
+// Grafana dashboard URLs, is stripped. Repeated parameters produce a
+// multi-value variable.
+func FromQuery(query url.Values, reserved ...string) Variables {
+	skip := map[string]bool{}
+	for _, r := range reserved {
+		skip[r] = true
+	}
+	vars := Variables{}
+	for key, values := range query {
+		if skip[key] || len(values) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(key, "var-")
+		if name == "" {
+			continue
+		}
+		v := &Variable{
+			Name:   name,
+			Values: append([]string{}, values...),
+			Multi:  len(values) > 1,
+		}
+		if v.IsAll() {
+			v.IncludeAll = true
+		}
+		vars[name] = v
+	}
+	return vars
+}
+
+// Interpolate replaces every template variable reference in expr using the
+// formatting rules of the given dialect. References to unknown variables are
+// left untouched.
+func Interpolate(expr string, vars Variables, dialect Dialect) string {
+	if len(vars) == 0 || !strings.ContainsAny(expr, "$[") {
+		return expr
+	}
+	return variableRegex.ReplaceAllStringFunc(expr, func(match string) string {
+		groups := variableRegex.FindStringSubmatch(match)
+		name := groups[1] + groups[2] + groups[4]
+		format := groups[3] + groups[6]
+		v, ok := vars[name]
+		if !ok {
+			return match
+		}
+		// format arguments such as ${var:date:iso} are not supported, only the format name is used
+		if i := strings.Index(format, ":"); i > -1 {
+			format = format[:i]
+		}
+		return formatVariable(v, format, dialect)
+	})
+}
+
+func formatVariable(v *Variable, format string, dialect Dialect) string {
+	values := v.Values
+	if v.IsAll() {
+		if v.AllValue != "" {
+			return v.AllValue
+		}
+		if len(v.Options) > 0 {
+			values = v.Options
+		} else {
+			return defaultAllValue(format, dialect)
+		}
+	}
+	multi := v.Multi || v.IncludeAll || len(values) > 1
+	if format != "" {
+		if f, ok := formats[format]; ok {
+			return f(v.Name, values, multi)
+		}
+	}
+	switch dialect {
+	case Prometheus:
+		return formatPrometheus(values, multi)
+	case Loki:
+		return formatLoki(values, multi)
+	case SQL:
+		return formatSQL(values, multi)
+	case Lucene:
+		return formatLucene(v.Name, values, multi)
+	default:
+		return formatGlob(v.Name, values, multi)
+	}
+}
+
+// defaultAllValue is used when "All" is selected but neither a custom all
+// value nor the variable options are known.
+func defaultAllValue(format string, dialect Dialect) string {
+	switch format {
+	case "glob", "lucene":
+		return "*"
+	case "regex", "pipe":
+		return ".*"
+	case "":
+		if dialect == Prometheus || dialect == Loki {
+			return ".*"
+		}
+		if dialect == Raw || dialect == Lucene {
+			return "*"
+		}
+	}
+	return AllToken
+}
+
+// DialectFor returns the dialect matching a Grafana datasource type.
+func DialectFor(datasourceType string) Dialect {
+	switch datasourceType {
+	case "prometheus":
+		return Prometheus
+	case "loki":
+		return Loki
+	case "postgres", "grafana-postgresql-datasource", "mysql", "mssql":
+		return SQL
+	case "elasticsearch", "grafana-opensearch-datasource":
+		return Lucene
+	}
+	return Raw
+}
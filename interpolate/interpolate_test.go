@@ -0,0 +1,220 @@
+package interpolate
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	single := func(name, value string) *Variable {
+		return &Variable{Name: name, Values: []string{value}}
+	}
+	multi := func(name string, values ...string) *Variable {
+		return &Variable{Name: name, Values: values, Multi: true}
+	}
+	all := func(name string, options ...string) *Variable {
+		return &Variable{Name: name, Values: []string{AllToken}, IncludeAll: true, Options: options}
+	}
+
+	tests := []struct {
+		name    string
+		expr    string
+		vars    Variables
+		dialect Dialect
+		want    string
+	}{
+		{
+			name: "variable",
+			expr: `up{job="$job"}`,
+			vars: Variables{"job": single("job", "api")},
+			want: `up{job="api"}`,
+		},
+		{
+			name: "longest variable name",
+			expr: `$var $var_name`,
+			vars: Variables{"var": single("var", "a"), "var_name": single("var_name", "b")},
+			want: `a b`,
+		},
+		{
+			name: "variable name prefix",
+			expr: `$var_name`,
+			vars: Variables{"var": single("var", "a")},
+			want: `$var_name`,
+		},
+		{
+			name: "braces",
+			expr: `${var}_name`,
+			vars: Variables{"var": single("var", "a")},
+			want: `a_name`,
+		},
+		{
+			name: "unknown variable",
+			expr: `up{job="$job"}`,
+			vars: Variables{"instance": single("instance", "x")},
+			want: `up{job="$job"}`,
+		},
+		{
+			name: "brackets",
+			expr: `up{job="[[job]]"}`,
+			vars: Variables{"job": single("job", "api")},
+			want: `up{job="api"}`,
+		},
+		{
+			name: "brackets with format",
+			expr: `[[job:csv]]`,
+			vars: Variables{"job": multi("job", "a", "b")},
+			want: `a,b`,
+		},
+		{
+			name: "regex format",
+			expr: `${host:regex}`,
+			vars: Variables{"host": multi("host", "a.example", "b")},
+			want: `(a\.example|b)`,
+		},
+		{
+			name: "regex format single value",
+			expr: `${host:regex}`,
+			vars: Variables{"host": single("host", "a.example")},
+			want: `a\.example`,
+		},
+		{
+			name: "csv format",
+			expr: `${host:csv}`,
+			vars: Variables{"host": multi("host", "a", "b", "c")},
+			want: `a,b,c`,
+		},
+		{
+			name: "pipe format",
+			expr: `${host:pipe}`,
+			vars: Variables{"host": multi("host", "a", "b")},
+			want: `a|b`,
+		},
+		{
+			name: "format arguments",
+			expr: `${host:csv:ignored}`,
+			vars: Variables{"host": multi("host", "a", "b")},
+			want: `a,b`,
+		},
+		{
+			name:    "prometheus multi-value",
+			expr:    `up{job=~"$job"}`,
+			vars:    Variables{"job": multi("job", "a.b", "c")},
+			dialect: Prometheus,
+			want:    `up{job=~"(a\\.b|c)"}`,
+		},
+		{
+			name:    "prometheus single value",
+			expr:    `up{job="$job"}`,
+			vars:    Variables{"job": single("job", `it's`)},
+			dialect: Prometheus,
+			want:    `up{job="it\\'s"}`,
+		},
+		{
+			name:    "prometheus all with options",
+			expr:    `up{job=~"$job"}`,
+			vars:    Variables{"job": all("job", "a", "b")},
+			dialect: Prometheus,
+			want:    `up{job=~"(a|b)"}`,
+		},
+		{
+			name:    "prometheus all without options",
+			expr:    `up{job=~"$job"}`,
+			vars:    Variables{"job": all("job")},
+			dialect: Prometheus,
+			want:    `up{job=~".*"}`,
+		},
+		{
+			name:    "custom all value",
+			expr:    `up{job=~"$job"}`,
+			vars:    Variables{"job": {Name: "job", Values: []string{"All"}, IncludeAll: true, AllValue: "api.*"}},
+			dialect: Prometheus,
+			want:    `up{job=~"api.*"}`,
+		},
+		{
+			name:    "all with format",
+			expr:    `${job:pipe}`,
+			vars:    Variables{"job": all("job")},
+			dialect: Prometheus,
+			want:    `.*`,
+		},
+		{
+			name:    "loki multi-value",
+			expr:    `{app=~"$app"}`,
+			vars:    Variables{"app": multi("app", "a", "b")},
+			dialect: Loki,
+			want:    `{app=~"a|b"}`,
+		},
+		{
+			name:    "sql multi-value",
+			expr:    `WHERE host IN ($host)`,
+			vars:    Variables{"host": multi("host", "a", "o'b")},
+			dialect: SQL,
+			want:    `WHERE host IN ('a','o''b')`,
+		},
+		{
+			name: "raw multi-value",
+			expr: `$host`,
+			vars: Variables{"host": multi("host", "a", "b")},
+			want: `{a,b}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Interpolate(tt.expr, tt.vars, tt.dialect); got != tt.want {
+				t.Errorf("Interpolate(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		reserved []string
+		want     Variables
+	}{
+		{
+			name:  "var prefix",
+			query: "var-job=api&instance=a",
+			want: Variables{
+				"job":      {Name: "job", Values: []string{"api"}},
+				"instance": {Name: "instance", Values: []string{"a"}},
+			},
+		},
+		{
+			name:  "multi-value",
+			query: "var-host=a&var-host=b",
+			want: Variables{
+				"host": {Name: "host", Values: []string{"a", "b"}, Multi: true},
+			},
+		},
+		{
+			name:  "all",
+			query: "var-host=$__all",
+			want: Variables{
+				"host": {Name: "host", Values: []string{AllToken}, IncludeAll: true},
+			},
+		},
+		{
+			name:     "reserved parameters",
+			query:    "query=up&start=1&var-job=api&var-=x",
+			reserved: []string{"query", "start"},
+			want: Variables{
+				"job": {Name: "job", Values: []string{"api"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FromQuery(query, tt.reserved...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package limits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"proxy-api-server/config"
+	"proxy-api-server/timerange"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type roleKey struct{}
+
+// Error is returned when a query exceeds one of the configured limits.
+type Error struct {
+	Limit     string `json:"limit"`
+	Requested string `json:"requested"`
+	Allowed   string `json:"allowed"`
+	Role      string `json:"role,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("query exceeds the %s limit: requested %s, allowed %s", e.Limit, e.Requested, e.Allowed)
+}
+
+// WithRole returns a context carrying the role of the caller.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the caller role stored by WithRole.
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey{}).(string)
+	return role
+}
+
+// RoleFromRequest reads the caller role from the configured role header. The
+// header is only read from the trusted authenticating proxies, a caller could
+// otherwise pick the role of its limits.
+func RoleFromRequest(r *http.Request) string {
+	conf := config.Get()
+	header := conf.Limits.RoleHeader
+	if header == "" || !conf.Server.IsTrustedProxy(r.RemoteAddr) {
+		return ""
+	}
+	return r.Header.Get(header)
+}
+
+// For returns the limits that apply to a query against instance by the caller
+// whose role is stored in ctx.
+func For(ctx context.Context, instance string) config.QueryLimits {
+	conf := config.Get().Limits
+	l := conf.QueryLimits
+	if il, ok := conf.Instances[strings.TrimSuffix(instance, "/")]; ok {
+		l = merge(l, il)
+	}
+	if rl, ok := conf.Roles[RoleFromContext(ctx)]; ok {
+		l = merge(l, rl)
+	}
+	return l
+}
+
+// ApplyRange checks r against the maximum range and adjusts its step to the
+// minimum step and to the maximum number of points per series. When excess
+// points are configured to be rejected an error is returned instead.
+func ApplyRange(ctx context.Context, l config.QueryLimits, r *timerange.Range) error {
+	role := RoleFromContext(ctx)
+	if l.MaxRange > 0 && r.Duration() > l.MaxRange {
+		return &Error{Limit: "max_range", Requested: timerange.FormatDuration(r.Duration()), Allowed: timerange.FormatDuration(l.MaxRange), Role: role}
+	}
+	if l.MinStep > 0 && r.Step < l.MinStep {
+		r.Step = l.MinStep
+		r.Align()
+	}
+	if l.MaxPoints > 0 && r.Points() > l.MaxPoints {
+		if l.RejectExcessPoints {
+			return &Error{Limit: "max_points", Requested: strconv.FormatInt(r.Points(), 10), Allowed: strconv.FormatInt(l.MaxPoints, 10), Role: role}
+		}
+		intervals := l.MaxPoints - 1
+		if intervals < 1 {
+			intervals = 1
+		}
+		step := r.Duration() / time.Duration(intervals)
+		r.Step = step.Truncate(time.Second) + time.Second
+		r.Align()
+	}
+	return nil
+}
+
+// CheckSeries returns an error when a Prometheus query response holds more
+// series than allowed.
+func CheckSeries(ctx context.Context, l config.QueryLimits, data []byte) error {
+	if l.MaxSeries <= 0 {
+		return nil
+	}
+	resp := struct {
+		Data struct {
+			Result []json.RawMessage `json:"result"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil
+	}
+	if n := len(resp.Data.Result); n > l.MaxSeries {
+		return &Error{Limit: "max_series", Requested: strconv.Itoa(n), Allowed: strconv.Itoa(l.MaxSeries), Role: RoleFromContext(ctx)}
+	}
+	return nil
+}
+
+// WithTimeout bounds ctx by the query timeout, if one is configured.
+func WithTimeout(ctx context.Context, l config.QueryLimits) (context.Context, context.CancelFunc) {
+	if l.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, l.Timeout)
+}
+
+// MaxTimeout returns the longest configured query timeout, of every instance
+// and role, zero when a query may have no timeout.
+func MaxTimeout() time.Duration {
+	conf := config.Get().Limits
+	max := conf.Timeout
+	for _, overrides := range []map[string]config.QueryLimits{conf.Instances, conf.Roles} {
+		for _, l := range overrides {
+			if max > 0 && l.Timeout > max {
+				max = l.Timeout
+			}
+		}
+	}
+	return max
+}
+
+// TimeoutParam returns the timeout to pass to Prometheus, or an empty string when there is none.
+func TimeoutParam(l config.QueryLimits) string {
+	if l.Timeout <= 0 {
+		return ""
+	}
+	return timerange.FormatDuration(l.Timeout)
+}
+
+func merge(base, override config.QueryLimits) config.QueryLimits {
+	if override.MaxRange > 0 {
+		base.MaxRange = override.MaxRange
+	}
+	if override.MaxPoints > 0 {
+		base.MaxPoints = override.MaxPoints
+	}
+	if override.MinStep > 0 {
+		base.MinStep = override.MinStep
+	}
+	if override.MaxSeries > 0 {
+		base.MaxSeries = override.MaxSeries
+	}
+	if override.Timeout > 0 {
+		base.Timeout = override.Timeout
+	}
+	if override.RejectExcessPoints {
+		base.RejectExcessPoints = true
+	}
+	return base
+}
@@ -0,0 +1,64 @@
+package limits
+
+import (
+	"context"
+	"net/http/httptest"
+	"proxy-api-server/config"
+	"testing"
+	"time"
+)
+
+func TestRoleFromRequest(t *testing.T) {
+	conf := config.NewConfig()
+	conf.Server.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+	config.Set(conf)
+
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"10.1.2.3:4000", "operator"},
+		{"192.168.1.1:4000", "operator"},
+		{"192.168.1.2:4000", ""},
+		{"127.0.0.1:4000", ""},
+		{"invalid", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/query", nil)
+		r.RemoteAddr = tt.remoteAddr
+		r.Header.Set("X-Proxy-Role", "operator")
+		if got := RoleFromRequest(r); got != tt.want {
+			t.Errorf("RoleFromRequest() from %s = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	conf := config.NewConfig()
+	conf.Limits.MaxRange = 24 * time.Hour
+	conf.Limits.MaxSeries = 100
+	conf.Limits.Roles = map[string]config.QueryLimits{"operator": {MaxRange: 90 * 24 * time.Hour}}
+	conf.Limits.Instances = map[string]config.QueryLimits{"http://grafana": {MaxSeries: 50}}
+	config.Set(conf)
+
+	tests := []struct {
+		name      string
+		role      string
+		instance  string
+		maxRange  time.Duration
+		maxSeries int
+	}{
+		{"defaults", "", "http://other", 24 * time.Hour, 100},
+		{"unknown role", "admin", "http://other", 24 * time.Hour, 100},
+		{"role", "operator", "http://other", 90 * 24 * time.Hour, 100},
+		{"instance", "", "http://grafana/", 24 * time.Hour, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := For(WithRole(context.Background(), tt.role), tt.instance)
+			if l.MaxRange != tt.maxRange || l.MaxSeries != tt.maxSeries {
+				t.Errorf("For() = %s/%d, want %s/%d", l.MaxRange, l.MaxSeries, tt.maxRange, tt.maxSeries)
+			}
+		})
+	}
+}
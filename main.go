@@ -22,7 +22,10 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"proxy-api-server/cli"
 	"proxy-api-server/config"
+	"proxy-api-server/handlers"
+	"proxy-api-server/internalmetrics"
 	"proxy-api-server/log"
 	"proxy-api-server/server"
 
@@ -87,8 +90,13 @@ func main() {
 		//config.Set(config.NewConfig())
 	}
 
-	cfg := config.Get()
-	log.Tracef("proxy-api-server configuration:\n%s", cfg)
+	// a command after the flags, such as export or import, runs instead of the server
+	if flag.NArg() > 0 {
+		if err := cli.Run(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// if err := validateConfig(); err != nil {
 	// 	log.Fatal(err)
@@ -106,12 +114,18 @@ func main() {
 	// authentication.InitializeAuthenticationController(cfg.Auth.Strategy)
 
 	// prepare our internal metrics so Prometheus can scrape them
-	// internalmetrics.RegisterInternalMetrics()
+	internalmetrics.RegisterInternalMetrics()
 
 	// Start listening to requests
 	server := server.NewServer()
 	server.Start()
 
+	// back up the dashboards of the instances on their schedules
+	backups, err := handlers.StartBackups()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// wait forever, or at least until we are told to exit
 	log.Infof("server started. wait forever to terminate")
 	waitForTermination()
@@ -119,6 +133,9 @@ func main() {
 	// Shutdown internal components
 	// log.Info("Shutting down internal components")
 	server.Stop()
+	if backups != nil {
+		backups.Stop()
+	}
 }
 
 func waitForTermination() {
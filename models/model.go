@@ -1,10 +1,20 @@
 package models
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"proxy-api-server/coalesce"
+	"proxy-api-server/limits"
+	"proxy-api-server/promql"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/grafana-tools/sdk"
 	"github.com/sirupsen/logrus"
@@ -51,6 +61,376 @@ type FoundBoard struct {
 	FolderURL   string   `json:"folderUrl"`
 }
 
+// DashboardSaveRequest is a dashboard to create or update. Unless overwrite
+// is set, the version of the dashboard must be the one it was read with, so
+// that concurrent changes are not lost.
+type DashboardSaveRequest struct {
+	Dashboard map[string]interface{} `json:"dashboard"`
+	// FolderUID is the folder of the dashboard, the General folder when not set
+	FolderUID string `json:"folderUid,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+	// Message is the commit message of the new version
+	Message string `json:"message,omitempty"`
+}
+
+// DashboardMoveRequest moves a dashboard to another folder
+type DashboardMoveRequest struct {
+	// FolderUID is the target folder, the General folder when not set
+	FolderUID string `json:"folderUid"`
+	// Version is the version the dashboard was read with, not checked when not set
+	Version int    `json:"version,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// DashboardSaveResult is a saved dashboard
+type DashboardSaveResult struct {
+	ID      uint   `json:"id"`
+	UID     string `json:"uid"`
+	URL     string `json:"url"`
+	Slug    string `json:"slug,omitempty"`
+	Status  string `json:"status"`
+	Version int    `json:"version"`
+}
+
+// DashboardDeleteResult is a deleted dashboard
+type DashboardDeleteResult struct {
+	UID     string `json:"uid"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// Folder is a dashboard folder of a Grafana instance
+type Folder struct {
+	ID    int    `json:"id"`
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	// ParentUID is the parent of a nested folder, empty at the top level
+	ParentUID string `json:"parentUid,omitempty"`
+	Version   int    `json:"version,omitempty"`
+}
+
+// FolderCreateRequest creates a folder, nested in the parent when Grafana supports nested folders
+type FolderCreateRequest struct {
+	// UID is generated by Grafana when not set
+	UID       string `json:"uid,omitempty"`
+	Title     string `json:"title"`
+	ParentUID string `json:"parentUid,omitempty"`
+}
+
+// FolderUpdateRequest renames a folder
+type FolderUpdateRequest struct {
+	Title string `json:"title"`
+	// Version is the version the folder was read with, not checked when not set
+	Version int `json:"version,omitempty"`
+}
+
+// FolderMoveRequest moves a nested folder to another parent
+type FolderMoveRequest struct {
+	// ParentUID is the new parent, the top level when empty
+	ParentUID string `json:"parentUid"`
+}
+
+// FolderDeleteResult is a deleted folder
+type FolderDeleteResult struct {
+	UID     string `json:"uid"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// FolderNode is a folder of the folder tree with its subfolders and dashboards
+type FolderNode struct {
+	Folder
+	Folders    []*FolderNode `json:"folders"`
+	Dashboards []*FoundBoard `json:"dashboards"`
+}
+
+// FolderPermission is a permission granted on a folder to a role, team or user
+type FolderPermission struct {
+	Role           string `json:"role,omitempty"`
+	TeamID         uint   `json:"teamId,omitempty"`
+	Team           string `json:"team,omitempty"`
+	UserID         uint   `json:"userId,omitempty"`
+	UserLogin      string `json:"userLogin,omitempty"`
+	UserEmail      string `json:"userEmail,omitempty"`
+	Permission     uint   `json:"permission"`
+	PermissionName string `json:"permissionName"`
+}
+
+// BundleManifest describes the folders, dashboards and datasource inputs of an export bundle
+type BundleManifest struct {
+	// Version is the version of the bundle format
+	Version    int                `json:"version"`
+	Source     string             `json:"source,omitempty"`
+	Created    time.Time          `json:"created"`
+	Inputs     []*BundleInput     `json:"inputs"`
+	Folders    []*Folder          `json:"folders"`
+	Dashboards []*BundleDashboard `json:"dashboards"`
+}
+
+// BundleInput is a datasource referenced by the dashboards of a bundle as a
+// ${DS_*} input, as in the __inputs of the dashboards Grafana exports
+type BundleInput struct {
+	Name        string `json:"name"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	PluginID    string `json:"pluginId"`
+}
+
+// BundleDashboard is a dashboard of a bundle, stored in the file of its path
+type BundleDashboard struct {
+	UID       string   `json:"uid"`
+	Title     string   `json:"title"`
+	FolderUID string   `json:"folderUid,omitempty"`
+	Path      string   `json:"path"`
+	Inputs    []string `json:"inputs"`
+}
+
+// BundleExportRequest selects the dashboards of an export bundle. Every
+// dashboard is exported when none and no folder is selected.
+type BundleExportRequest struct {
+	DashboardUIDs []string `json:"dashboardUids,omitempty"`
+	// FolderUIDs selects the dashboards of folders, with their subfolders
+	FolderUIDs []string `json:"folderUids,omitempty"`
+	Format     string   `json:"format,omitempty"` // zip, the default, or tar for a gzipped tar
+}
+
+// BundleImportResult reports the import of a bundle per folder and dashboard
+type BundleImportResult struct {
+	// Inputs are the datasources the inputs of the bundle were mapped to
+	Inputs         map[string]string        `json:"inputs"`
+	FoldersCreated []string                 `json:"foldersCreated"`
+	Dashboards     []*BundleDashboardResult `json:"dashboards"`
+}
+
+// BundleDashboardResult is the import of a dashboard of a bundle
+type BundleDashboardResult struct {
+	UID     string `json:"uid"`
+	Title   string `json:"title"`
+	Status  string `json:"status"` // imported or failed
+	Version int    `json:"version,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DashboardMigrateRequest selects the dashboards to copy from a registered instance to another
+type DashboardMigrateRequest struct {
+	Source        string   `json:"source"`
+	Target        string   `json:"target"`
+	DashboardUIDs []string `json:"dashboardUids,omitempty"`
+	// FolderUID selects the dashboards of a folder of the source and its subfolders
+	FolderUID string `json:"folderUid,omitempty"`
+	// Datasources maps datasources of the source to datasources of the target, by name or uid
+	Datasources map[string]string `json:"datasources,omitempty"`
+	// TargetFolderUID is the folder of the target to migrate to, by default the folders of the source are recreated
+	TargetFolderUID string `json:"targetFolderUid,omitempty"`
+	// NewUIDs lets the target give the dashboards new uids instead of keeping the ones of the source
+	NewUIDs   bool   `json:"newUids,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// DashboardMigrateResult reports the migration of dashboards, or the changes it would make on a dry run
+type DashboardMigrateResult struct {
+	Source         string                `json:"source"`
+	Target         string                `json:"target"`
+	DryRun         bool                  `json:"dryRun"`
+	FoldersCreated []string              `json:"foldersCreated"`
+	Dashboards     []*DashboardMigration `json:"dashboards"`
+}
+
+// DashboardMigration is the migration of a dashboard
+type DashboardMigration struct {
+	SourceUID string `json:"sourceUid"`
+	// UID is the uid in the target, empty on a dry run with new uids
+	UID       string `json:"uid"`
+	Title     string `json:"title"`
+	FolderUID string `json:"folderUid"`
+	Action    string `json:"action"` // create or update
+	Status    string `json:"status"` // planned, migrated or failed
+	// Datasources maps the datasources of the source used by the dashboard to the ones of the target, by name
+	Datasources map[string]string `json:"datasources"`
+	// Diff is the change to the dashboard of the target when it is updated
+	Diff    *DashboardDiff `json:"diff,omitempty"`
+	Version int            `json:"version,omitempty"`
+	URL     string         `json:"url,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// Backup identifies a backup of a dashboard, or of the folders of an instance
+type Backup struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Hash    string    `json:"hash"` // Prefix of the content hash, the same for backups of the same content
+}
+
+// DashboardBackups lists the backups of a dashboard, latest first
+type DashboardBackups struct {
+	UID     string    `json:"uid"`
+	Backups []*Backup `json:"backups"`
+}
+
+// DashboardBackup is a backup of a dashboard with its JSON model
+type DashboardBackup struct {
+	Backup
+	UID       string                 `json:"uid"`
+	FolderUID string                 `json:"folderUid"`
+	Version   int                    `json:"version"`
+	Dashboard map[string]interface{} `json:"dashboard"`
+}
+
+// FolderBackup is a backup of the folders of an instance, parents first
+type FolderBackup struct {
+	Backup
+	Folders []*Folder `json:"folders"`
+}
+
+// BackupResult reports a backup of the dashboards of an instance
+type BackupResult struct {
+	Instance string    `json:"instance"`
+	Started  time.Time `json:"started"`
+	// Saved are the uids of the dashboards backed up, the others were unchanged since their latest backup
+	Saved     []string `json:"saved"`
+	Unchanged int      `json:"unchanged"`
+	// FoldersSaved is set when the folders changed since their latest backup
+	FoldersSaved bool `json:"foldersSaved"`
+	// Pruned is the number of backups deleted beyond the retention
+	Pruned int `json:"pruned"`
+	// Failed are the errors of the dashboards that could not be backed up, by uid
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// BackupRestoreRequest selects the backup of a dashboard to restore, the latest one by default
+type BackupRestoreRequest struct {
+	Backup    string `json:"backup,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// SyncInfo describes a git repository synchronized with an instance
+type SyncInfo struct {
+	Name       string `json:"name"`
+	Instance   string `json:"instance"`
+	Repository string `json:"repository"`
+	Branch     string `json:"branch"`
+	Path       string `json:"path"`
+	Commit     string `json:"commit"` // Commit of the branch, empty when it has none
+}
+
+// SyncPlan is the drift between the dashboards of a git repository and its instance, and the changes to resolve it
+type SyncPlan struct {
+	SyncInfo
+	Changes []*SyncChange `json:"changes"`
+	// Invalid are the errors of the files of the repository that are not dashboards, or are in a directory that is not a folder uid, by path
+	Invalid map[string]string `json:"invalid,omitempty"`
+}
+
+// SyncChange is the state of a dashboard in git and in the instance.
+//
+// Its action is create when it is only in git, update when it differs, move
+// when only its folder differs, unchanged, and untracked when it is only in
+// the instance, or delete when untracked dashboards are pruned.
+type SyncChange struct {
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+	// Path is the path of the dashboard in the repository, empty for untracked dashboards
+	Path      string `json:"path,omitempty"`
+	FolderUID string `json:"folderUid"`
+	// CurrentFolderUID is the folder of the dashboard in the instance
+	CurrentFolderUID string `json:"currentFolderUid,omitempty"`
+	Action           string `json:"action"`
+	// Diff is the change of the dashboard of the instance to the one of git for updates
+	Diff    *DashboardDiff `json:"diff,omitempty"`
+	Status  string         `json:"status,omitempty"` // applied, skipped or failed, when applied
+	Version int            `json:"version,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// SyncApplyRequest applies the dashboards of git to the instance
+type SyncApplyRequest struct {
+	// Prune deletes the dashboards of the instance that are not in git
+	Prune   bool   `json:"prune,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// SyncCommitRequest commits the dashboards of the instance that differ from git to the repository
+type SyncCommitRequest struct {
+	// Prune removes the dashboards of git that are not in the instance
+	Prune   bool   `json:"prune,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// SyncCommitResult reports a commit of the dashboards of the instance to the repository
+type SyncCommitResult struct {
+	// Commit is empty when git was already in sync
+	Commit  string   `json:"commit"`
+	Written []string `json:"written"`
+	Removed []string `json:"removed"`
+}
+
+// DashboardVersion is a saved version of a dashboard
+type DashboardVersion struct {
+	Version       int       `json:"version"`
+	ParentVersion int       `json:"parentVersion"`
+	RestoredFrom  int       `json:"restoredFrom,omitempty"`
+	Created       time.Time `json:"created"`
+	CreatedBy     string    `json:"createdBy"`
+	Message       string    `json:"message"`
+}
+
+// DashboardVersionDetail is a version of a dashboard with its JSON model
+type DashboardVersionDetail struct {
+	DashboardVersion
+	Dashboard map[string]interface{} `json:"dashboard"`
+}
+
+// DashboardRestoreRequest restores a version of a dashboard as a new version
+type DashboardRestoreRequest struct {
+	Version int `json:"version"`
+	// CurrentVersion is the version the dashboard was read with, not checked when not set
+	CurrentVersion int    `json:"currentVersion,omitempty"`
+	Message        string `json:"message,omitempty"`
+}
+
+// DashboardDiff is the structural difference between two versions of a dashboard
+type DashboardDiff struct {
+	UID  string `json:"uid"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	// Fields are the changed fields of the dashboard outside of its panels, such as title or templating
+	Fields        []string        `json:"fields"`
+	PanelsAdded   []*PanelSummary `json:"panelsAdded"`
+	PanelsRemoved []*PanelSummary `json:"panelsRemoved"`
+	PanelsChanged []*PanelChange  `json:"panelsChanged"`
+}
+
+// PanelSummary identifies a panel of a dashboard
+type PanelSummary struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+// PanelChange is a panel changed between two versions of a dashboard
+type PanelChange struct {
+	PanelSummary
+	// Fields are the changed fields of the panel other than its queries
+	Fields  []string       `json:"fields"`
+	Queries []*QueryChange `json:"queries"`
+}
+
+// QueryChange is a query of a panel added, removed or changed between two versions
+type QueryChange struct {
+	RefID  string `json:"refId"`
+	Change string `json:"change"` // added, removed or changed
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// Fields are the changed fields of the query, such as expr or datasource
+	Fields []string `json:"fields,omitempty"`
+}
+
 // Preference represents the data stored in session / local DB
 type Preference struct {
 	Grafana *Grafana `json:"grafana,omitempty"`
@@ -82,9 +462,208 @@ type User struct {
 type Provider interface {
 }
 
+// BatchQuery is a single query of a batch request
+type BatchQuery struct {
+	RefID         string              `json:"refId"`
+	Type          string              `json:"type,omitempty"`         // "range" (default) or "instant"
+	Datasource    string              `json:"datasource,omitempty"`   // Datasource name
+	DatasourceID  string              `json:"datasourceId,omitempty"` // Datasource id, looked up from the name when not set
+	Expr          string              `json:"expr"`
+	Start         string              `json:"start,omitempty"`
+	End           string              `json:"end,omitempty"`
+	Step          string              `json:"step,omitempty"`
+	MaxDataPoints int                 `json:"maxDataPoints,omitempty"`
+	Timeout       string              `json:"timeout,omitempty"`
+	Variables     map[string][]string `json:"variables,omitempty"`
+}
+
+// BatchQueryResult is the outcome of a single query of a batch request
+type BatchQueryResult struct {
+	RefID     string          `json:"refId"`
+	Status    int             `json:"status"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ErrorCode string          `json:"errorCode,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Upstream  *UpstreamError  `json:"upstream,omitempty"`
+}
+
+// BatchQueryResponse is the response of a batch request, with a result per query
+type BatchQueryResponse struct {
+	Results []*BatchQueryResult `json:"results"`
+}
+
+// QueryValidateRequest is a PromQL expression to validate, with the template
+// variables and time range used to interpolate it
+type QueryValidateRequest struct {
+	Query     string              `json:"query"`
+	Start     string              `json:"start,omitempty"`
+	End       string              `json:"end,omitempty"`
+	Step      string              `json:"step,omitempty"`
+	Variables map[string][]string `json:"variables,omitempty"`
+}
+
+// QueryValidateResult describes a validated PromQL expression
+type QueryValidateResult struct {
+	Valid bool `json:"valid"`
+	// Query is the expression after interpolation of template variables
+	Query   string                `json:"query"`
+	Type    promql.ValueType      `json:"type,omitempty"`
+	Metrics []string              `json:"metrics,omitempty"`
+	Labels  []string              `json:"labels,omitempty"`
+	Tree    *promql.Node          `json:"tree,omitempty"`
+	Error   *QueryValidationError `json:"error,omitempty"`
+	// Warnings are unknown functions and type errors, which do not block the query
+	Warnings []*QueryValidationError `json:"warnings,omitempty"`
+}
+
+// QueryValidationError is a syntax or type error of a PromQL expression. The
+// position is a byte range of the interpolated query, line and column are 1-based.
+type QueryValidationError struct {
+	Message  string               `json:"message"`
+	Position promql.PositionRange `json:"position"`
+	Line     int                  `json:"line"`
+	Column   int                  `json:"column"`
+}
+
+// VariableValues are the values of a template variable query
+type VariableValues struct {
+	Status string   `json:"status"`
+	Data   []string `json:"data"`
+}
+
+// DataSourceRef identifies a Grafana datasource
+type DataSourceRef struct {
+	UID  string `json:"uid"`
+	Type string `json:"type"`
+}
+
+// DataSourceQuery is a query of Grafana's unified /api/ds/query API. The
+// datasource specific fields, such as expr or rawSql, are kept in Model and
+// forwarded as they are.
+type DataSourceQuery struct {
+	RefID      string
+	Datasource *DataSourceRef
+	Model      map[string]interface{}
+}
+
+func (q *DataSourceQuery) UnmarshalJSON(b []byte) error {
+	model := map[string]interface{}{}
+	if err := json.Unmarshal(b, &model); err != nil {
+		return err
+	}
+	typed := struct {
+		RefID      string         `json:"refId"`
+		Datasource *DataSourceRef `json:"datasource"`
+	}{}
+	if err := json.Unmarshal(b, &typed); err != nil {
+		return err
+	}
+	q.RefID, q.Datasource, q.Model = typed.RefID, typed.Datasource, model
+	return nil
+}
+
+func (q DataSourceQuery) MarshalJSON() ([]byte, error) {
+	model := map[string]interface{}{}
+	for k, v := range q.Model {
+		model[k] = v
+	}
+	model["refId"] = q.RefID
+	model["datasource"] = q.Datasource
+	return json.Marshal(model)
+}
+
+// DataSourceQueryRequest is a request of the datasource query endpoint
+type DataSourceQueryRequest struct {
+	From      string              `json:"from,omitempty"`
+	To        string              `json:"to,omitempty"`
+	Queries   []*DataSourceQuery  `json:"queries"`
+	Variables map[string][]string `json:"variables,omitempty"`
+}
+
+// DataFrameField is a column of a data frame with its values
+type DataFrameField struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Config json.RawMessage   `json:"config,omitempty"`
+	Values []interface{}     `json:"values"`
+}
+
+// DataFrame is a simplified Grafana data frame
+type DataFrame struct {
+	Name   string            `json:"name,omitempty"`
+	Meta   json.RawMessage   `json:"meta,omitempty"`
+	Fields []*DataFrameField `json:"fields"`
+}
+
+// DataSourceQueryResult holds the frames returned for one query
+type DataSourceQueryResult struct {
+	RefID  string       `json:"refId"`
+	Status int          `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Frames []*DataFrame `json:"frames"`
+}
+
+// DataSourceQueryResponse is the response of the datasource query endpoint
+type DataSourceQueryResponse struct {
+	Results []*DataSourceQueryResult `json:"results"`
+}
+
+// Trace is a trace from Tempo or Jaeger, normalized with its spans as a tree
+type Trace struct {
+	TraceID         string    `json:"traceId"`
+	RootServiceName string    `json:"rootServiceName,omitempty"`
+	RootName        string    `json:"rootName,omitempty"`
+	StartTime       time.Time `json:"startTime"`
+	DurationMs      float64   `json:"durationMs"`
+	SpanCount       int       `json:"spanCount"`
+	Roots           []*Span   `json:"roots"`
+}
+
+// Span is a span of a trace with its child spans
+type Span struct {
+	SpanID             string                 `json:"spanId"`
+	ParentSpanID       string                 `json:"parentSpanId,omitempty"`
+	Name               string                 `json:"name"`
+	ServiceName        string                 `json:"serviceName"`
+	Kind               string                 `json:"kind,omitempty"`   // server, client, producer, consumer or internal
+	Status             string                 `json:"status,omitempty"` // ok, error or unset
+	StatusMessage      string                 `json:"statusMessage,omitempty"`
+	StartTime          time.Time              `json:"startTime"`
+	DurationMs         float64                `json:"durationMs"`
+	Attributes         map[string]interface{} `json:"attributes,omitempty"`
+	ResourceAttributes map[string]interface{} `json:"resourceAttributes,omitempty"`
+	Events             []*SpanEvent           `json:"events,omitempty"`
+	Children           []*Span                `json:"children"`
+}
+
+// SpanEvent is a timestamped event of a span, a log in Jaeger
+type SpanEvent struct {
+	Time       time.Time              `json:"time"`
+	Name       string                 `json:"name,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// TraceSummary is a trace found by a trace search
+type TraceSummary struct {
+	TraceID         string    `json:"traceId"`
+	RootServiceName string    `json:"rootServiceName,omitempty"`
+	RootName        string    `json:"rootName,omitempty"`
+	StartTime       time.Time `json:"startTime"`
+	DurationMs      float64   `json:"durationMs"`
+}
+
+// TraceSearchResult is the response of a trace search
+type TraceSearchResult struct {
+	Traces []*TraceSummary `json:"traces"`
+}
+
 type GrafanaClient struct {
 	HttpClient *http.Client
-	PromMode   bool
+	// PromMode is set when the client talks to the backend directly instead of through Grafana
+	PromMode bool
+	// TenantID is sent in the X-Scope-OrgID header expected by multi-tenant backends
+	TenantID string
 }
 
 type HandlerConfig struct {
@@ -92,13 +671,163 @@ type HandlerConfig struct {
 	GrafanaClientForQuery *GrafanaClient
 }
 
+// upstreamRequests coalesces identical concurrent MakeRequest calls. A call
+// is bounded by the longest configured query timeout, whatever the timeout
+// of the caller that started it.
+var upstreamRequests = coalesce.NewGroup("upstream_request", limits.MaxTimeout)
+
+// MakeRequest performs a GET request against the upstream. Identical concurrent
+// requests, for the same normalized URL and credential, share a single upstream call.
 func (g *GrafanaClient) MakeRequest(ctx context.Context, queryURL, APIKey string) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodGet, queryURL, nil)
+	key := requestKey(queryURL, APIKey, g.PromMode, g.TenantID)
+	data, err, _ := upstreamRequests.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return g.makeRequest(ctx, queryURL, APIKey)
+	})
 	if err != nil {
 		return nil, err
 	}
-	if !g.PromMode {
-		req.Header.Set("Authorization", APIKey)
+	return data.([]byte), nil
+}
+
+// GetRequest performs a GET request against the upstream without coalescing.
+// Unlike MakeRequest it returns the status code, so that callers can tell a
+// missing resource from a failure.
+func (g *GrafanaClient) GetRequest(ctx context.Context, reqURL, APIKey string) ([]byte, int, error) {
+	return g.doRequest(ctx, http.MethodGet, reqURL, APIKey, nil, SetAuthorization)
+}
+
+// PostRequest performs a POST request with a JSON body against the upstream.
+// Like util.HandleHttpRequest it returns the response body and status code;
+// the error is only set when no response was received.
+func (g *GrafanaClient) PostRequest(ctx context.Context, reqURL, APIKey string, body []byte) ([]byte, int, error) {
+	return g.doRequest(ctx, http.MethodPost, reqURL, APIKey, bytes.NewReader(body), SetAuthorization)
+}
+
+// PutRequest performs a PUT request with a JSON body against the upstream, like PostRequest.
+func (g *GrafanaClient) PutRequest(ctx context.Context, reqURL, APIKey string, body []byte) ([]byte, int, error) {
+	return g.doRequest(ctx, http.MethodPut, reqURL, APIKey, bytes.NewReader(body), SetAuthorization)
+}
+
+// DeleteRequest performs a DELETE request against the upstream, like PostRequest.
+func (g *GrafanaClient) DeleteRequest(ctx context.Context, reqURL, APIKey string) ([]byte, int, error) {
+	return g.doRequest(ctx, http.MethodDelete, reqURL, APIKey, nil, SetAuthorization)
+}
+
+func (g *GrafanaClient) makeRequest(ctx context.Context, queryURL, APIKey string) ([]byte, error) {
+	data, statusCode, err := g.doRequest(ctx, http.MethodGet, queryURL, APIKey, nil, g.authorize)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		upstreamErr := NewUpstreamError(statusCode, queryURL, data)
+		logrus.Errorf("unable to get data from %s due to status code: %d: %s", upstreamErr.Path, statusCode, upstreamErr.Message)
+		return nil, upstreamErr
+	}
+	return data, nil
+}
+
+// maxUpstreamMessage bounds the length of an upstream error message taken from a plain text body.
+const maxUpstreamMessage = 1024
+
+// UpstreamError is returned when Grafana or a backend answers with an error
+// status. It keeps the error reported by the upstream: the errorType and error
+// of the Prometheus API, or the message of the Grafana API.
+type UpstreamError struct {
+	Status    int    `json:"status"`
+	ErrorType string `json:"errorType,omitempty"`
+	Message   string `json:"message"`
+	// Path is the path of the request, without the query that may carry credentials or the full expression
+	Path string `json:"path,omitempty"`
+}
+
+// NewUpstreamError builds the error of an upstream response from its status and body.
+func NewUpstreamError(status int, reqURL string, body []byte) *UpstreamError {
+	e := &UpstreamError{Status: status}
+	if u, err := url.Parse(reqURL); err == nil {
+		e.Path = u.Path
+	}
+	var decoded struct {
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+		Message   string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		e.ErrorType = decoded.ErrorType
+		switch {
+		case decoded.Error != "":
+			e.Message = decoded.Error
+		case decoded.Message != "":
+			e.Message = decoded.Message
+		}
+	} else if text := strings.TrimSpace(string(body)); text != "" && utf8.ValidString(text) {
+		// Loki and Tempo reply with plain text errors
+		if len(text) > maxUpstreamMessage {
+			text = text[:maxUpstreamMessage] + "..."
+		}
+		e.Message = text
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(status)
+	}
+	return e
+}
+
+func (e *UpstreamError) Error() string {
+	if e.ErrorType != "" {
+		return fmt.Sprintf("upstream responded with status %d (%s): %s", e.Status, e.ErrorType, e.Message)
+	}
+	return fmt.Sprintf("upstream responded with status %d: %s", e.Status, e.Message)
+}
+
+// HTTPStatus is the status to reply with for the upstream error. Errors of
+// the request, such as a bad query or credential, keep their status; failures
+// of the upstream itself become 502, or 504 when it timed out.
+func (e *UpstreamError) HTTPStatus() int {
+	switch {
+	case e.ErrorType == "timeout" || e.Status == http.StatusGatewayTimeout:
+		return http.StatusGatewayTimeout
+	case e.Status == http.StatusServiceUnavailable:
+		return http.StatusServiceUnavailable
+	case e.Status >= 400 && e.Status < 500:
+		return e.Status
+	}
+	return http.StatusBadGateway
+}
+
+// ErrorResponse is the JSON body of a failed request, the same for every endpoint
+//
+// swagger:model errorResponse
+type ErrorResponse struct {
+	Error *ErrorDetail `json:"error"`
+}
+
+// SwaggerErrorResponse documents the error body of every endpoint
+//
+// swagger:response errorResponse
+type SwaggerErrorResponse struct {
+	// in: body
+	Body ErrorResponse
+}
+
+// ErrorDetail describes the error of a failed request
+type ErrorDetail struct {
+	// Code is one of the codes of util.ErrorCatalog, such as invalid_query or upstream_error
+	Code      string         `json:"code"`
+	Status    int            `json:"status"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"requestId,omitempty"`
+	Details   interface{}    `json:"details,omitempty"`
+	Upstream  *UpstreamError `json:"upstream,omitempty"`
+}
+
+func (g *GrafanaClient) doRequest(ctx context.Context, method, reqURL, APIKey string, body io.Reader, authorize func(*http.Request, string)) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	authorize(req, APIKey)
+	if g.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", g.TenantID)
 	}
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/json")
@@ -106,19 +835,59 @@ func (g *GrafanaClient) MakeRequest(ctx context.Context, queryURL, APIKey string
 	// c := &http.Client{}
 	resp, err := g.HttpClient.Do(req)
 	if err != nil {
-		return nil, err
+		// the query of the URL may hold the full expression, keep it out of the error
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+		}
+		return nil, http.StatusBadGateway, err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, http.StatusBadGateway, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		// return nil, fmt.Errorf("%s", data)
-		logrus.Errorf("unable to get data from URL: %s due to status code: %d", queryURL, resp.StatusCode)
-		return nil, fmt.Errorf("unable to fetch data from url: %s", queryURL)
+	return data, resp.StatusCode, nil
+}
+
+// authorize authenticates the requests of MakeRequest. The credential is
+// sent to Grafana as is, as it always was, and to an upstream like
+// SetAuthorization, as documented for the upstream api_key.
+func (g *GrafanaClient) authorize(req *http.Request, APIKey string) {
+	if g.PromMode {
+		SetAuthorization(req, APIKey)
+		return
 	}
-	return data, nil
+	req.Header.Set("Authorization", APIKey)
+}
+
+// SetAuthorization authenticates req with a Grafana credential the same way
+// the grafana-tools sdk client does: "user:password" is sent as basic auth and
+// anything else as a bearer token. A credential that already carries its
+// scheme, such as "Bearer <token>", is sent unchanged.
+func SetAuthorization(req *http.Request, APIKey string) {
+	switch {
+	case APIKey == "":
+		return
+	case strings.HasPrefix(APIKey, "Bearer ") || strings.HasPrefix(APIKey, "Basic "):
+		req.Header.Set("Authorization", APIKey)
+	case strings.Contains(APIKey, ":"):
+		parts := strings.SplitN(APIKey, ":", 2)
+		req.SetBasicAuth(parts[0], parts[1])
+	default:
+		req.Header.Set("Authorization", "Bearer "+APIKey)
+	}
+}
+
+// requestKey returns the coalescing key of a request, made of the normalized
+// URL and the credential identity.
+func requestKey(queryURL, APIKey string, promMode bool, tenantID string) string {
+	normalized := queryURL
+	if u, err := url.Parse(queryURL); err == nil {
+		u.RawQuery = u.Query().Encode()
+		u.Fragment = ""
+		normalized = u.String()
+	}
+	return coalesce.Key(normalized, coalesce.CredentialIdentity(APIKey), strconv.FormatBool(promMode), tenantID)
 }
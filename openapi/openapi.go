@@ -0,0 +1,312 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"proxy-api-server/models"
+	"proxy-api-server/util"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Spec describes the parameters, request body and result of a route.
+type Spec struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Parameters  []Parameter
+	// Body is a value of the model of the JSON request body, nil without body
+	Body interface{}
+	// BodyType is the media type of a request body that is not JSON, such as an archive
+	BodyType string
+	// Result is a value of the model of the response, nil for free-form JSON
+	Result interface{}
+	// ResultType is the media type of the response, application/json by default
+	ResultType string
+	// Status is the status of a successful response, 200 by default
+	Status int
+	// Deprecated routes are kept as aliases of their Successor until they are retired
+	Deprecated bool
+	Successor  string
+}
+
+// Parameter is a query, path or header parameter of a route.
+type Parameter struct {
+	Name        string
+	In          string
+	Type        string
+	Format      string
+	Description string
+	Required    bool
+	// RequiredWithout names a parameter, the parameter is required when that one is not set
+	RequiredWithout string
+	Enum            []string
+}
+
+// Query returns an optional string query parameter.
+func Query(name, description string) Parameter {
+	return Parameter{Name: name, In: "query", Type: "string", Description: description}
+}
+
+// Path returns a path parameter, which is always required.
+func Path(name, description string) Parameter {
+	return Parameter{Name: name, In: "path", Type: "string", Description: description, Required: true}
+}
+
+// Require returns the parameter marked as required.
+func (p Parameter) Require() Parameter {
+	p.Required = true
+	return p
+}
+
+// RequireWithout returns the parameter marked as required when other is not set.
+func (p Parameter) RequireWithout(other string) Parameter {
+	p.RequiredWithout = other
+	return p
+}
+
+// Typed returns the parameter with a JSON schema type, such as integer.
+func (p Parameter) Typed(schemaType string) Parameter {
+	p.Type = schemaType
+	return p
+}
+
+// OneOf returns the parameter restricted to the given values.
+func (p Parameter) OneOf(values ...string) Parameter {
+	p.Enum = values
+	return p
+}
+
+// Route is a route of the API as documented in the specification.
+type Route struct {
+	Name   string
+	Method string
+	Path   string
+	Spec   *Spec
+}
+
+// Document is an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string                   `json:"openapi"`
+	Info       Info                     `json:"info"`
+	Paths      map[string]*PathItem     `json:"paths"`
+	Components Components               `json:"components"`
+	Tags       []Tag                    `json:"tags,omitempty"`
+	ErrorCodes []util.ErrorCatalogEntry `json:"x-error-codes,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type Components struct {
+	Schemas   map[string]*Schema   `json:"schemas"`
+	Responses map[string]*Response `json:"responses,omitempty"`
+}
+
+// PathItem holds the operations of a path by lower-case method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Deprecated  bool                 `json:"deprecated,omitempty"`
+	Parameters  []*ParameterObject   `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type ParameterObject struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// errorResponse is the name of the shared response of every error status.
+const errorResponse = "errorResponse"
+
+// pathParamRegex matches the path variables of mux patterns, with their optional regular expression.
+var pathParamRegex = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Build returns the document of the routes. Every route must have a Spec,
+// so that the document does not silently miss an endpoint.
+func Build(info Info, routes []Route) (*Document, error) {
+	doc := &Document{
+		OpenAPI:    "3.0.3",
+		Info:       info,
+		Paths:      map[string]*PathItem{},
+		ErrorCodes: util.ErrorCatalog,
+	}
+	gen := newGenerator()
+	missing := []string{}
+	tags := map[string]bool{}
+	for _, route := range routes {
+		if route.Spec == nil {
+			missing = append(missing, route.Method+" "+route.Path)
+			continue
+		}
+		path := pathParamRegex.ReplaceAllString(route.Path, "{$1}")
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		method := strings.ToLower(route.Method)
+		if _, ok := (*item)[method]; ok {
+			return nil, fmt.Errorf("duplicate route %s %s", route.Method, route.Path)
+		}
+		(*item)[method] = gen.operation(route)
+		for _, tag := range route.Spec.Tags {
+			tags[tag] = true
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("routes without an OpenAPI spec: %s", strings.Join(missing, ", "))
+	}
+	for _, tag := range sortedNames(tags) {
+		doc.Tags = append(doc.Tags, Tag{Name: tag})
+	}
+
+	errorSchema := gen.schemaOf(&models.ErrorResponse{})
+	if detail, ok := gen.schemas["ErrorDetail"]; ok {
+		if code, ok := detail.Properties["code"]; ok {
+			for _, e := range util.ErrorCatalog {
+				code.Enum = append(code.Enum, string(e.Code))
+			}
+		}
+	}
+	doc.Components = Components{
+		Schemas: gen.schemas,
+		Responses: map[string]*Response{
+			errorResponse: {
+				Description: errorDescription(),
+				Content:     map[string]*MediaType{"application/json": {Schema: errorSchema}},
+			},
+		},
+	}
+	return doc, nil
+}
+
+func (g *generator) operation(route Route) *Operation {
+	spec := route.Spec
+	op := &Operation{
+		OperationID: strings.ToLower(route.Method) + route.Name,
+		Summary:     spec.Summary,
+		Description: spec.Description,
+		Tags:        spec.Tags,
+		Deprecated:  spec.Deprecated,
+		Responses:   map[string]*Response{},
+	}
+	if spec.Successor != "" {
+		op.Description = strings.TrimSpace(op.Description + " Deprecated, use " + spec.Successor + " instead.")
+	}
+	for _, p := range spec.Parameters {
+		description := p.Description
+		if p.RequiredWithout != "" {
+			description += ", required without " + p.RequiredWithout
+		}
+		op.Parameters = append(op.Parameters, &ParameterObject{
+			Name:        p.Name,
+			In:          p.In,
+			Description: description,
+			Required:    p.Required,
+			Schema:      p.schema(),
+		})
+	}
+	if spec.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{"application/json": {Schema: g.schemaOf(spec.Body)}},
+		}
+	} else if spec.BodyType != "" {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{spec.BodyType: {Schema: &Schema{Type: "string", Format: "binary"}}},
+		}
+	}
+
+	status := spec.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	result := &Response{Description: http.StatusText(status)}
+	resultType := spec.ResultType
+	if resultType == "" {
+		resultType = "application/json"
+	}
+	if status != http.StatusSwitchingProtocols {
+		var schema *Schema
+		switch {
+		case spec.Result != nil:
+			schema = g.schemaOf(spec.Result)
+		case resultType == "application/json":
+			schema = &Schema{Type: "object"}
+		case strings.HasPrefix(resultType, "text/"):
+			schema = &Schema{Type: "string"}
+		default:
+			schema = &Schema{Type: "string", Format: "binary"}
+		}
+		result.Content = map[string]*MediaType{resultType: {Schema: schema}}
+	}
+	op.Responses[strconv.Itoa(status)] = result
+	op.Responses["default"] = &Response{Ref: "#/components/responses/" + errorResponse}
+	return op
+}
+
+func (p Parameter) schema() *Schema {
+	s := &Schema{Type: p.Type, Format: p.Format, Enum: p.Enum}
+	if s.Type == "" {
+		s.Type = "string"
+	}
+	if s.Type == "array" {
+		s.Items = &Schema{Type: "string"}
+	}
+	return s
+}
+
+// errorDescription documents the error codes of the catalog as a table.
+func errorDescription() string {
+	var b strings.Builder
+	b.WriteString("Error body of every route, with a code of the error catalog:\n\n")
+	b.WriteString("| Code | Status | Description |\n|---|---|---|\n")
+	for _, e := range util.ErrorCatalog {
+		fmt.Fprintf(&b, "| `%s` | %d | %s |\n", e.Code, e.Status, e.Description)
+	}
+	return b.String()
+}
+
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,52 @@
+package openapi
+
+import (
+	_ "embed"
+	"io/fs"
+	"net/http"
+	"path"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// swaggerUI is the Swagger UI page. It loads the document from openapi.json
+// next to it, and the Swagger UI assets served by UIAssetHandler.
+//
+//go:embed swagger-ui.html
+var swaggerUI []byte
+
+// UIAssets are the Swagger UI assets loaded by the page. They are embedded in
+// the binary from the swagger-ui dist files of github.com/swaggo/files, whose
+// version is pinned in go.mod and checked by go.sum.
+var UIAssets = []string{"swagger-ui.css", "swagger-ui-bundle.js"}
+
+// UIHandler serves the Swagger UI of the document.
+func UIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(swaggerUI)
+}
+
+// UIAssetHandler serves the Swagger UI assets, /api/docs/swagger-ui.css for instance.
+func UIAssetHandler(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	for _, asset := range UIAssets {
+		if name != asset {
+			continue
+		}
+		data, err := fs.ReadFile(swaggerFiles.FS, asset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", assetTypes[path.Ext(asset)])
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		_, _ = w.Write(data)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+var assetTypes = map[string]string{
+	".css": "text/css; charset=utf-8",
+	".js":  "text/javascript; charset=utf-8",
+}
@@ -0,0 +1,29 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestUIAssets(t *testing.T) {
+	// the page only loads the embedded assets, nothing from another origin
+	refs := regexp.MustCompile(`(?:href|src)="([^"]+)"`).FindAllStringSubmatch(string(swaggerUI), -1)
+	if len(refs) != len(UIAssets) {
+		t.Errorf("the page loads %v, want the %d embedded assets", refs, len(UIAssets))
+	}
+	for _, ref := range refs {
+		w := httptest.NewRecorder()
+		UIAssetHandler(w, httptest.NewRequest(http.MethodGet, "/api/"+ref[1], nil))
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("GET /api/%s = %d with %d bytes, want the asset", ref[1], w.Code, w.Body.Len())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	UIAssetHandler(w, httptest.NewRequest(http.MethodGet, "/api/docs/index.html", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /api/docs/index.html = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,213 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"proxy-api-server/util"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBodySize bounds the size of the JSON request bodies read for validation.
+const maxBodySize = 32 << 20
+
+// ValidateRequests checks the parameters and body of the requests of a
+// route against its spec before they reach the handler, and replies with
+// the invalid fields otherwise.
+func ValidateRequests(spec *Spec, next http.Handler) http.Handler {
+	if spec == nil || (len(spec.Parameters) == 0 && spec.Body == nil) {
+		return next
+	}
+	var bodySchema *Schema
+	gen := newGenerator()
+	if spec.Body != nil {
+		bodySchema = gen.schemaOf(spec.Body)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := spec.validateParameters(r)
+		if bodySchema != nil {
+			data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				util.WriteError(w, r, util.BadRequestError("request body is larger than %d bytes", maxBodySize))
+				return
+			} else if err != nil {
+				util.WriteError(w, r, util.BadRequestError("cannot read request body: %s", err))
+				return
+			}
+			// the handler decodes the body again
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			fields = append(fields, validateBody(gen, bodySchema, data)...)
+		}
+		if len(fields) > 0 {
+			util.WriteError(w, r, util.ValidationError(fields))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateParameters returns the parameters of the request that do not match the spec.
+func (s *Spec) validateParameters(r *http.Request) []util.FieldError {
+	query := r.URL.Query()
+	vars := mux.Vars(r)
+	get := func(p Parameter) string {
+		switch p.In {
+		case "path":
+			return vars[p.Name]
+		case "header":
+			return r.Header.Get(p.Name)
+		}
+		return query.Get(p.Name)
+	}
+	fields := []util.FieldError{}
+	for _, p := range s.Parameters {
+		value := get(p)
+		if value == "" {
+			if p.Required {
+				fields = append(fields, util.FieldError{Field: p.Name, In: p.In, Reason: "is required"})
+			} else if p.RequiredWithout != "" && query.Get(p.RequiredWithout) == "" {
+				fields = append(fields, util.FieldError{Field: p.Name, In: p.In, Reason: fmt.Sprintf("is required without %s", p.RequiredWithout)})
+			}
+			continue
+		}
+		if reason := p.check(value); reason != "" {
+			fields = append(fields, util.FieldError{Field: p.Name, In: p.In, Reason: reason})
+		}
+	}
+	return fields
+}
+
+// check returns why a value does not match the type and values of the parameter.
+func (p Parameter) check(value string) string {
+	switch p.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "must be an integer"
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "must be a number"
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be true or false"
+		}
+	}
+	if len(p.Enum) > 0 && !contains(p.Enum, value) {
+		return "must be one of " + strings.Join(p.Enum, ", ")
+	}
+	return ""
+}
+
+// validateBody checks a JSON body against its schema. Fields holding null are
+// accepted as encoding/json decodes them as zero values.
+func validateBody(gen *generator, schema *Schema, data []byte) []util.FieldError {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []util.FieldError{{In: "body", Reason: "request body is required"}}
+	}
+	var body interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return []util.FieldError{{In: "body", Reason: fmt.Sprintf("invalid JSON: %s", err)}}
+	}
+	v := &bodyValidator{schemas: gen.schemas}
+	v.check("", schema, body)
+	return v.fields
+}
+
+type bodyValidator struct {
+	schemas map[string]*Schema
+	fields  []util.FieldError
+}
+
+func (v *bodyValidator) fail(field, format string, args ...interface{}) {
+	v.fields = append(v.fields, util.FieldError{Field: field, In: "body", Reason: fmt.Sprintf(format, args...)})
+}
+
+func (v *bodyValidator) check(field string, schema *Schema, value interface{}) {
+	if schema.Ref != "" {
+		schema = v.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	if schema == nil || value == nil {
+		return
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, "must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				v.fail(join(field, name), "is required")
+			}
+		}
+		names := map[string]bool{}
+		for name := range object {
+			names[name] = true
+		}
+		for _, name := range sortedNames(names) {
+			fieldValue := object[name]
+			if property, ok := schema.Properties[name]; ok {
+				v.check(join(field, name), property, fieldValue)
+			} else if schema.AdditionalProperties != nil {
+				v.check(join(field, name), schema.AdditionalProperties, fieldValue)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, "must be an array")
+			return
+		}
+		for i, item := range array {
+			v.check(fmt.Sprintf("%s[%d]", field, i), schema.Items, item)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(field, "must be a string")
+		} else if len(schema.Enum) > 0 && !contains(schema.Enum, s) {
+			v.fail(field, "must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if f, err := n.Float64(); !ok || err != nil || f != math.Trunc(f) {
+			v.fail(field, "must be an integer")
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			v.fail(field, "must be a number")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "must be true or false")
+		}
+	}
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"proxy-api-server/models"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type testPanel struct {
+	ID    int    `json:"id"`
+	Title string `json:"title,omitempty"`
+}
+
+type testBody struct {
+	Name   string            `json:"name"`
+	Panels []testPanel       `json:"panels,omitempty"`
+	Ratio  float64           `json:"ratio,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Pinned bool              `json:"pinned,omitempty"`
+}
+
+func TestValidateRequests(t *testing.T) {
+	spec := &Spec{
+		Parameters: []Parameter{
+			Path("uid", "Dashboard uid"),
+			{Name: "limit", In: "query", Type: "integer"},
+			{Name: "direction", In: "query", Type: "string", Enum: []string{"backward", "forward"}},
+			{Name: "dsuid", In: "query", Type: "string", RequiredWithout: "ds"},
+		},
+		Body: testBody{},
+	}
+	tests := []struct {
+		name  string
+		uid   string
+		query string
+		body  string
+		// fields are the invalid fields reported, none when the request reaches the handler
+		fields []string
+	}{
+		{
+			name:  "valid",
+			uid:   "abc",
+			query: "limit=10&direction=forward&dsuid=x",
+			body:  `{"name":"a","panels":[{"id":1,"title":"t"}],"ratio":0.5,"labels":{"a":"b"},"pinned":true,"unknown":1}`,
+		},
+		{name: "null fields", uid: "abc", query: "ds=x", body: `{"name":null,"panels":null}`},
+		{name: "missing path parameter", query: "ds=x", body: `{"name":"a"}`, fields: []string{"path uid"}},
+		{name: "not an integer", uid: "abc", query: "ds=x&limit=ten", body: `{"name":"a"}`, fields: []string{"query limit"}},
+		{name: "not in the enum", uid: "abc", query: "ds=x&direction=up", body: `{"name":"a"}`, fields: []string{"query direction"}},
+		{name: "required without", uid: "abc", body: `{"name":"a"}`, fields: []string{"query dsuid"}},
+		{name: "missing body", uid: "abc", query: "ds=x", fields: []string{"body "}},
+		{name: "invalid JSON", uid: "abc", query: "ds=x", body: `{"name":`, fields: []string{"body "}},
+		{name: "missing field", uid: "abc", query: "ds=x", body: `{}`, fields: []string{"body name"}},
+		{
+			name:   "wrong types",
+			uid:    "abc",
+			query:  "ds=x",
+			body:   `{"name":1,"panels":[{"id":1.5},{"id":"2"},3],"ratio":"x","labels":{"a":1},"pinned":"yes"}`,
+			fields: []string{"body labels.a", "body name", "body panels[0].id", "body panels[1].id", "body panels[2]", "body pinned", "body ratio"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			handler := ValidateRequests(spec, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the handler can read the body again
+				data, _ := io.ReadAll(r.Body)
+				received = string(data)
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/?"+tt.query, strings.NewReader(tt.body))
+			if tt.uid != "" {
+				r = mux.SetURLVars(r, map[string]string{"uid": tt.uid})
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tt.fields == nil {
+				if w.Code != http.StatusOK || received != tt.body {
+					t.Errorf("status = %d, handler received %q, want the request to reach the handler: %s", w.Code, received, w.Body)
+				}
+				return
+			}
+			got := decodeFields(t, w)
+			if !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("invalid fields = %q, want %q", got, tt.fields)
+			}
+		})
+	}
+}
+
+// decodeFields checks that w holds the validation error of the catalog and
+// returns its fields as "in field".
+func decodeFields(t *testing.T, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var resp struct {
+		Error struct {
+			Code    string `json:"code"`
+			Status  int    `json:"status"`
+			Details struct {
+				Fields []struct {
+					Field string `json:"field"`
+					In    string `json:"in"`
+				} `json:"fields"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %s: %v", w.Body, err)
+	}
+	if w.Code != http.StatusBadRequest || resp.Error.Code != "validation_failed" || resp.Error.Status != w.Code {
+		t.Errorf("response = %d %s, want %d validation_failed", w.Code, w.Body, http.StatusBadRequest)
+	}
+	fields := []string{}
+	for _, f := range resp.Error.Details.Fields {
+		fields = append(fields, f.In+" "+f.Field)
+	}
+	return fields
+}
+
+func TestValidateRequestsBodySize(t *testing.T) {
+	reached := false
+	handler := ValidateRequests(&Spec{Body: testBody{}}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	body := `{"name":"` + strings.Repeat("a", maxBodySize) + `"}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	var resp models.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %s: %v", w.Body, err)
+	}
+	if reached || w.Code != http.StatusBadRequest || resp.Error.Code != "bad_request" {
+		t.Errorf("oversized body: status %d %s, handler reached %t, want a bad request", w.Code, w.Body, reached)
+	}
+}
+
+func TestValidateRequestsWithoutSpec(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, spec := range []*Spec{nil, {Summary: "no parameters"}} {
+		if handler := ValidateRequests(spec, next); reflect.ValueOf(handler).Pointer() != reflect.ValueOf(next).Pointer() {
+			t.Errorf("ValidateRequests(%+v) wraps the handler, want it unchanged", spec)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ValueType is the type an expression evaluates to.
+type ValueType string
+
+const (
+	ValueTypeNone   ValueType = "none"
+	ValueTypeScalar ValueType = "scalar"
+	ValueTypeVector ValueType = "vector"
+	ValueTypeMatrix ValueType = "matrix"
+	ValueTypeString ValueType = "string"
+)
+
+// PositionRange is the byte range of a node in the parsed expression.
+type PositionRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Expr is a node of a parsed PromQL expression.
+type Expr interface {
+	// String returns the expression in PromQL.
+	String() string
+	// Type returns the type the expression evaluates to.
+	Type() ValueType
+	PositionRange() PositionRange
+}
+
+// MatchType is the operator of a label matcher.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// LabelMatcher is a label matcher of a vector selector, such as job="api".
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewLabelMatcher creates a matcher, compiling its regular expression.
+// Regular expressions are fully anchored, as in Prometheus.
+func NewLabelMatcher(t MatchType, name, value string) (*LabelMatcher, error) {
+	m := &LabelMatcher{Type: t, Name: name, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Matches reports whether the label value v satisfies the matcher.
+func (m *LabelMatcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m *LabelMatcher) String() string {
+	return fmt.Sprintf("%s%s%s", labelName(m.Name), m.Type, quote(m.Value))
+}
+
+// NumberLiteral is a float literal.
+type NumberLiteral struct {
+	Val      float64
+	PosRange PositionRange
+}
+
+// StringLiteral is a string literal.
+type StringLiteral struct {
+	Val      string
+	PosRange PositionRange
+}
+
+// VectorSelector selects series by metric name and label matchers.
+type VectorSelector struct {
+	Name     string
+	Matchers []*LabelMatcher
+	Modifiers
+	PosRange PositionRange
+}
+
+// Modifiers are the offset and @ modifiers of a selector or subquery.
+type Modifiers struct {
+	Offset time.Duration
+	// Timestamp is set by "@ <time>", in seconds since the epoch
+	Timestamp *float64
+	// StartOrEnd is "start" or "end" for "@ start()" and "@ end()"
+	StartOrEnd string
+}
+
+// MatrixSelector selects a range of samples of the series of a vector selector.
+type MatrixSelector struct {
+	VectorSelector *VectorSelector
+	Range          time.Duration
+	EndPos         int
+}
+
+// SubqueryExpr evaluates an instant expression over a range.
+type SubqueryExpr struct {
+	Expr  Expr
+	Range time.Duration
+	// Step is zero when the default evaluation interval is used
+	Step time.Duration
+	Modifiers
+	EndPos int
+}
+
+// ParenExpr is an expression in parentheses.
+type ParenExpr struct {
+	Expr     Expr
+	PosRange PositionRange
+}
+
+// UnaryExpr is a negated or explicitly positive expression.
+type UnaryExpr struct {
+	Op       string
+	Expr     Expr
+	StartPos int
+}
+
+// VectorMatching describes how the series of the two sides of a binary
+// operation are matched.
+type VectorMatching struct {
+	// Card is one-to-one, many-to-one (group_left) or one-to-many (group_right)
+	Card string
+	// On is set for on(...), otherwise MatchingLabels are ignored with ignoring(...)
+	On             bool
+	MatchingLabels []string
+	// Include are the labels of the group_left or group_right modifier
+	Include []string
+	// explicit reports whether on or ignoring was written
+	explicit bool
+}
+
+const (
+	CardOneToOne   = "one-to-one"
+	CardManyToOne  = "many-to-one"
+	CardOneToMany  = "one-to-many"
+	CardManyToMany = "many-to-many"
+)
+
+// BinaryExpr is a binary operation.
+type BinaryExpr struct {
+	Op         string
+	LHS, RHS   Expr
+	ReturnBool bool
+	Matching   *VectorMatching
+}
+
+// AggregateExpr is an aggregation such as sum by (job) (rate(x[5m])).
+type AggregateExpr struct {
+	Op       string
+	Expr     Expr
+	Param    Expr
+	Grouping []string
+	Without  bool
+	// grouped reports whether a by or without clause was written
+	grouped  bool
+	PosRange PositionRange
+}
+
+// Call is a function call.
+type Call struct {
+	Func     *Function
+	Args     []Expr
+	PosRange PositionRange
+}
+
+func (e *NumberLiteral) Type() ValueType  { return ValueTypeScalar }
+func (e *StringLiteral) Type() ValueType  { return ValueTypeString }
+func (e *VectorSelector) Type() ValueType { return ValueTypeVector }
+func (e *MatrixSelector) Type() ValueType { return ValueTypeMatrix }
+func (e *SubqueryExpr) Type() ValueType   { return ValueTypeMatrix }
+func (e *ParenExpr) Type() ValueType      { return e.Expr.Type() }
+func (e *UnaryExpr) Type() ValueType      { return e.Expr.Type() }
+func (e *AggregateExpr) Type() ValueType  { return ValueTypeVector }
+func (e *Call) Type() ValueType           { return e.Func.ReturnType }
+
+func (e *BinaryExpr) Type() ValueType {
+	if e.LHS.Type() == ValueTypeScalar && e.RHS.Type() == ValueTypeScalar {
+		return ValueTypeScalar
+	}
+	return ValueTypeVector
+}
+
+func (e *NumberLiteral) PositionRange() PositionRange  { return e.PosRange }
+func (e *StringLiteral) PositionRange() PositionRange  { return e.PosRange }
+func (e *VectorSelector) PositionRange() PositionRange { return e.PosRange }
+func (e *ParenExpr) PositionRange() PositionRange      { return e.PosRange }
+func (e *AggregateExpr) PositionRange() PositionRange  { return e.PosRange }
+func (e *Call) PositionRange() PositionRange           { return e.PosRange }
+
+func (e *MatrixSelector) PositionRange() PositionRange {
+	return PositionRange{Start: e.VectorSelector.PosRange.Start, End: e.EndPos}
+}
+
+func (e *SubqueryExpr) PositionRange() PositionRange {
+	return PositionRange{Start: e.Expr.PositionRange().Start, End: e.EndPos}
+}
+
+func (e *UnaryExpr) PositionRange() PositionRange {
+	return PositionRange{Start: e.StartPos, End: e.Expr.PositionRange().End}
+}
+
+func (e *BinaryExpr) PositionRange() PositionRange {
+	return PositionRange{Start: e.LHS.PositionRange().Start, End: e.RHS.PositionRange().End}
+}
+
+// Children returns the direct sub-expressions of a node.
+func Children(e Expr) []Expr {
+	switch n := e.(type) {
+	case *MatrixSelector:
+		return []Expr{n.VectorSelector}
+	case *SubqueryExpr:
+		return []Expr{n.Expr}
+	case *ParenExpr:
+		return []Expr{n.Expr}
+	case *UnaryExpr:
+		return []Expr{n.Expr}
+	case *BinaryExpr:
+		return []Expr{n.LHS, n.RHS}
+	case *AggregateExpr:
+		if n.Param != nil {
+			return []Expr{n.Param, n.Expr}
+		}
+		return []Expr{n.Expr}
+	case *Call:
+		return n.Args
+	}
+	return nil
+}
+
+// Inspect calls f for e and all its sub-expressions, depth first.
+func Inspect(e Expr, f func(Expr)) {
+	f(e)
+	for _, c := range Children(e) {
+		Inspect(c, f)
+	}
+}
+
+// Selectors returns the vector selectors of an expression, including those of matrix selectors.
+func Selectors(e Expr) []*VectorSelector {
+	selectors := []*VectorSelector{}
+	Inspect(e, func(n Expr) {
+		if vs, ok := n.(*VectorSelector); ok {
+			selectors = append(selectors, vs)
+		}
+	})
+	return selectors
+}
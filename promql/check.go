@@ -0,0 +1,91 @@
+package promql
+
+// DocumentedType returns the name of a value type as used in the Prometheus documentation.
+func DocumentedType(t ValueType) string {
+	switch t {
+	case ValueTypeVector:
+		return "instant vector"
+	case ValueTypeMatrix:
+		return "range vector"
+	}
+	return string(t)
+}
+
+// checkTypes verifies the operand types of every node of expr. Type errors
+// are warnings when parsing leniently.
+func (p *parser) checkTypes(expr Expr) {
+	for _, c := range Children(expr) {
+		p.checkTypes(c)
+	}
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		lt, rt := e.LHS.Type(), e.RHS.Type()
+		for _, t := range []ValueType{lt, rt} {
+			if t != ValueTypeScalar && t != ValueTypeVector {
+				p.warnf(e.PositionRange(), "binary expression must contain only scalar and instant vector types")
+			}
+		}
+		bothVectors := lt == ValueTypeVector && rt == ValueTypeVector
+		if isSetOperator(e.Op) && !bothVectors {
+			p.warnf(e.PositionRange(), "set operator %q not allowed in binary scalar expression", e.Op)
+		}
+		if isComparisonOperator(e.Op) && lt == ValueTypeScalar && rt == ValueTypeScalar && !e.ReturnBool {
+			p.warnf(e.PositionRange(), "comparisons between scalars must use BOOL modifier")
+		}
+		if e.Matching != nil && !bothVectors {
+			p.warnf(e.PositionRange(), "vector matching only allowed between instant vectors")
+		}
+		if e.Matching != nil && e.Matching.On {
+			for _, l := range e.Matching.Include {
+				for _, on := range e.Matching.MatchingLabels {
+					if l == on {
+						p.warnf(e.PositionRange(), "label %q must not occur in ON and GROUP clause at once", l)
+					}
+				}
+			}
+		}
+	case *UnaryExpr:
+		if t := e.Expr.Type(); t != ValueTypeScalar && t != ValueTypeVector {
+			p.warnf(e.PositionRange(), "unary expression only allowed on expressions of type scalar or instant vector, got %q", DocumentedType(t))
+		}
+	case *SubqueryExpr:
+		if t := e.Expr.Type(); t != ValueTypeVector {
+			p.warnf(e.PositionRange(), "subquery is only allowed on instant vector, got %s instead", DocumentedType(t))
+		}
+	case *AggregateExpr:
+		if t := e.Expr.Type(); t != ValueTypeVector {
+			p.warnf(e.PositionRange(), "expected type %s in aggregation expression, got %s", DocumentedType(ValueTypeVector), DocumentedType(t))
+		}
+		if e.Param != nil {
+			if want, got := aggregators[e.Op], e.Param.Type(); want != got {
+				p.warnf(e.Param.PositionRange(), "expected type %s in aggregation parameter, got %s", DocumentedType(want), DocumentedType(got))
+			}
+		}
+	case *Call:
+		fn, n := e.Func, len(e.Args)
+		if fn.unknown {
+			return
+		}
+		min := len(fn.ArgTypes) - fn.Optional
+		switch {
+		case fn.Variadic && n < min:
+			p.warnf(e.PositionRange(), "expected at least %d argument(s) in call to %q, got %d", min, fn.Name, n)
+		case !fn.Variadic && fn.Optional > 0 && (n < min || n > len(fn.ArgTypes)):
+			p.warnf(e.PositionRange(), "expected %d to %d argument(s) in call to %q, got %d", min, len(fn.ArgTypes), fn.Name, n)
+		case !fn.Variadic && fn.Optional == 0 && n != len(fn.ArgTypes):
+			p.warnf(e.PositionRange(), "expected %d argument(s) in call to %q, got %d", len(fn.ArgTypes), fn.Name, n)
+		}
+		for i, a := range e.Args {
+			if i >= len(fn.ArgTypes) && !fn.Variadic {
+				break
+			}
+			want := fn.ArgTypes[len(fn.ArgTypes)-1]
+			if i < len(fn.ArgTypes) {
+				want = fn.ArgTypes[i]
+			}
+			if got := a.Type(); got != want {
+				p.warnf(a.PositionRange(), "expected type %s in call to function %q, got %s", DocumentedType(want), fn.Name, DocumentedType(got))
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package promql
+
+import "fmt"
+
+// EnforceError is returned when a selector explicitly selects series an
+// enforced matcher excludes, so the query cannot be rewritten safely.
+type EnforceError struct {
+	Selector string
+	Matcher  string
+}
+
+func (e *EnforceError) Error() string {
+	return fmt.Sprintf("selector %s conflicts with enforced matcher %s", e.Selector, e.Matcher)
+}
+
+// EnforceMatchers adds the enforced matchers to every vector selector of expr,
+// including those of range vectors and subqueries.
+func EnforceMatchers(expr Expr, enforced []*LabelMatcher) error {
+	for _, vs := range Selectors(expr) {
+		if err := enforceSelector(vs, enforced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func enforceSelector(vs *VectorSelector, enforced []*LabelMatcher) error {
+	missing := []*LabelMatcher{}
+	for _, e := range enforced {
+		if e.Name == "__name__" && vs.Name != "" && !e.Matches(vs.Name) {
+			return &EnforceError{Selector: vs.String(), Matcher: e.String()}
+		}
+		present := false
+		for _, m := range vs.Matchers {
+			if m.Name != e.Name {
+				continue
+			}
+			if m.Type == MatchEqual && !e.Matches(m.Value) {
+				return &EnforceError{Selector: vs.String(), Matcher: e.String()}
+			}
+			if m.Type == e.Type && m.Value == e.Value {
+				present = true
+			}
+		}
+		if !present {
+			missing = append(missing, e)
+		}
+	}
+	// the selector is only changed once it is known not to conflict
+	vs.Matchers = append(vs.Matchers, missing...)
+	return nil
+}
+
+// EnforceSelector adds the enforced matchers to a series selector as used by
+// the match[] parameter of the series and label APIs. An empty selector
+// becomes a selector of the enforced matchers alone.
+func EnforceSelector(selector string, enforced []*LabelMatcher) (string, error) {
+	if selector == "" {
+		vs := &VectorSelector{Matchers: enforced}
+		return vs.String(), nil
+	}
+	expr, err := ParseExpr(selector)
+	if err != nil {
+		return "", err
+	}
+	vs, ok := expr.(*VectorSelector)
+	if !ok || vs.Offset != 0 || vs.Timestamp != nil || vs.StartOrEnd != "" {
+		return "", &ParseError{PositionRange: expr.PositionRange(), Err: "expected a series selector", Query: selector}
+	}
+	if err := enforceSelector(vs, enforced); err != nil {
+		return "", err
+	}
+	return vs.String(), nil
+}
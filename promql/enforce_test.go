@@ -0,0 +1,242 @@
+package promql
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEnforceMatchers(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		enforced string
+		want     string
+		conflict bool
+	}{
+		{
+			name:     "metric",
+			query:    `up`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `up{namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "name matcher",
+			query:    `{__name__=~".+"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `{__name__=~".+", namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "function",
+			query:    `rate(http_requests_total[5m])`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `rate(http_requests_total{namespace=~"team-a-.*"}[5m])`,
+		},
+		{
+			name:     "nested selectors",
+			query:    `sum by (job) (rate(a[5m])) / sum by (job) (rate(b{job="api"}[5m]))`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `sum by (job) (rate(a{namespace=~"team-a-.*"}[5m])) / sum by (job) (rate(b{job="api", namespace=~"team-a-.*"}[5m]))`,
+		},
+		{
+			name:     "subquery",
+			query:    `max_over_time(rate(x[5m])[1h:1m])`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `max_over_time(rate(x{namespace=~"team-a-.*"}[5m])[1h:1m])`,
+		},
+		{
+			name:     "offset",
+			query:    `sum(rate(x[5m] offset 1h))`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `sum(rate(x{namespace=~"team-a-.*"}[5m] offset 1h))`,
+		},
+		{
+			name:     "at modifier",
+			query:    `x @ 1700000000 - rate(x[5m] @ end() offset 5m)`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `x{namespace=~"team-a-.*"} @ 1700000000.000 - rate(x{namespace=~"team-a-.*"}[5m] offset 5m @ end())`,
+		},
+		{
+			name:     "binary operations",
+			query:    `(a or b) unless c * on(job) group_left(instance) d`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `(a{namespace=~"team-a-.*"} or b{namespace=~"team-a-.*"}) unless c{namespace=~"team-a-.*"} * on(job) group_left(instance) d{namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "unary and scalars",
+			query:    `-x + time()`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `-x{namespace=~"team-a-.*"} + time()`,
+		},
+		{
+			name:     "aggregation parameter",
+			query:    `topk(scalar(y), x)`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `topk(scalar(y{namespace=~"team-a-.*"}), x{namespace=~"team-a-.*"})`,
+		},
+		{
+			name:     "absent",
+			query:    `absent(x{job="api"})`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `absent(x{job="api", namespace=~"team-a-.*"})`,
+		},
+		{
+			name:     "no selector",
+			query:    `vector(1) + 2`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `vector(1) + 2`,
+		},
+		{
+			name:     "already enforced",
+			query:    `x{namespace=~"team-a-.*"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `x{namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "several matchers",
+			query:    `x`,
+			enforced: `namespace=~"team-a-.*", cluster="prod"`,
+			want:     `x{namespace=~"team-a-.*", cluster="prod"}`,
+		},
+		{
+			name:     "override with a wider regex",
+			query:    `x{namespace=~".*"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `x{namespace=~".*", namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "override with a negative matcher",
+			query:    `x{namespace!="team-a-1"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `x{namespace!="team-a-1", namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "override with an allowed value",
+			query:    `x{namespace="team-a-1"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `x{namespace="team-a-1", namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "override with another tenant",
+			query:    `x{namespace="team-b"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			conflict: true,
+		},
+		{
+			name:     "override in one side of a binary operation",
+			query:    `x or y{namespace="team-b"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			conflict: true,
+		},
+		{
+			name:     "override repeated",
+			query:    `x{namespace="team-a-1", namespace="team-b"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			conflict: true,
+		},
+		{
+			name:     "override of the second matcher",
+			query:    `x{cluster="dev"}`,
+			enforced: `namespace=~"team-a-.*", cluster="prod"`,
+			conflict: true,
+		},
+		{
+			name:     "enforced metric name",
+			query:    `other`,
+			enforced: `__name__=~"team_a_.*"`,
+			conflict: true,
+		},
+		{
+			name:     "escaped value",
+			query:    `x{job="a\"} or vector(1) #"}`,
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `x{job="a\"} or vector(1) #", namespace=~"team-a-.*"}`,
+		},
+		{
+			name:     "comment",
+			query:    "x # {namespace=\"team-b\"}\n or y",
+			enforced: `namespace=~"team-a-.*"`,
+			want:     `x{namespace=~"team-a-.*"} or y{namespace=~"team-a-.*"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enforced, err := ParseMatchers(tt.enforced)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expr, err := ParseExpr(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = EnforceMatchers(expr, enforced)
+			if tt.conflict {
+				var enforceErr *EnforceError
+				if !errors.As(err, &enforceErr) {
+					t.Fatalf("EnforceMatchers(%q) error = %v, want a conflict", tt.query, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EnforceMatchers(%q) error = %v", tt.query, err)
+			}
+			got := expr.String()
+			if got != tt.want {
+				t.Errorf("EnforceMatchers(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+
+			// the rewritten query parses back to selectors that all hold the enforced matchers
+			reparsed, err := ParseExpr(got)
+			if err != nil {
+				t.Fatalf("rewritten query %s does not parse: %v", got, err)
+			}
+			for _, vs := range Selectors(reparsed) {
+				for _, e := range enforced {
+					if !hasMatcher(vs, e) {
+						t.Errorf("selector %s of %s lacks %s", vs, got, e)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEnforceSelector(t *testing.T) {
+	enforced, err := ParseMatchers(`namespace=~"team-a-.*"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		selector string
+		want     string
+		wantErr  bool
+	}{
+		{selector: ``, want: `{namespace=~"team-a-.*"}`},
+		{selector: `up`, want: `up{namespace=~"team-a-.*"}`},
+		{selector: `{job="api"}`, want: `{job="api", namespace=~"team-a-.*"}`},
+		{selector: `up{namespace="team-b"}`, wantErr: true},
+		{selector: `up[5m]`, wantErr: true},
+		{selector: `up offset 1h`, wantErr: true},
+		{selector: `sum(up)`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := EnforceSelector(tt.selector, enforced)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("EnforceSelector(%q) = %s, want an error", tt.selector, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("EnforceSelector(%q) = %s, %v, want %s", tt.selector, got, err, tt.want)
+		}
+	}
+}
+
+func hasMatcher(vs *VectorSelector, e *LabelMatcher) bool {
+	for _, m := range vs.Matchers {
+		if m.Name == e.Name && m.Type == e.Type && m.Value == e.Value {
+			return true
+		}
+	}
+	return false
+}
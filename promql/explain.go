@@ -0,0 +1,132 @@
+package promql
+
+import "sort"
+
+// Node is the JSON form of an expression node, as returned by Explain.
+type Node struct {
+	// Kind is the node type, such as vectorSelector, binaryExpr or call
+	Kind     string        `json:"kind"`
+	Type     ValueType     `json:"type"`
+	Expr     string        `json:"expr"`
+	Position PositionRange `json:"position"`
+	// Op is the operator of unary, binary and aggregation expressions
+	Op       string   `json:"op,omitempty"`
+	Func     string   `json:"func,omitempty"`
+	Metric   string   `json:"metric,omitempty"`
+	Matchers []string `json:"matchers,omitempty"`
+	Grouping []string `json:"grouping,omitempty"`
+	Without  bool     `json:"without,omitempty"`
+	Range    string   `json:"range,omitempty"`
+	Step     string   `json:"step,omitempty"`
+	Offset   string   `json:"offset,omitempty"`
+	Children []*Node  `json:"children,omitempty"`
+}
+
+// Explain returns the expression tree of expr.
+func Explain(expr Expr) *Node {
+	n := &Node{Type: expr.Type(), Expr: expr.String(), Position: expr.PositionRange()}
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		n.Kind = "numberLiteral"
+	case *StringLiteral:
+		n.Kind = "stringLiteral"
+	case *VectorSelector:
+		n.Kind = "vectorSelector"
+		n.Metric = e.Name
+		for _, m := range e.Matchers {
+			n.Matchers = append(n.Matchers, m.String())
+		}
+		if e.Offset != 0 {
+			n.Offset = formatDuration(e.Offset)
+		}
+	case *MatrixSelector:
+		n.Kind = "matrixSelector"
+		n.Range = formatDuration(e.Range)
+	case *SubqueryExpr:
+		n.Kind = "subquery"
+		n.Range = formatDuration(e.Range)
+		if e.Step != 0 {
+			n.Step = formatDuration(e.Step)
+		}
+		if e.Offset != 0 {
+			n.Offset = formatDuration(e.Offset)
+		}
+	case *ParenExpr:
+		n.Kind = "parenExpr"
+	case *UnaryExpr:
+		n.Kind = "unaryExpr"
+		n.Op = e.Op
+	case *BinaryExpr:
+		n.Kind = "binaryExpr"
+		n.Op = e.Op
+		if e.Matching != nil {
+			n.Grouping = e.Matching.MatchingLabels
+			n.Without = !e.Matching.On
+		}
+	case *AggregateExpr:
+		n.Kind = "aggregation"
+		n.Op = e.Op
+		n.Grouping = e.Grouping
+		n.Without = e.Without
+	case *Call:
+		n.Kind = "call"
+		n.Func = e.Func.Name
+	}
+	for _, c := range Children(expr) {
+		n.Children = append(n.Children, Explain(c))
+	}
+	return n
+}
+
+// Metrics returns the sorted metric names selected by expr, by name or by an
+// equality matcher on __name__.
+func Metrics(expr Expr) []string {
+	set := map[string]bool{}
+	for _, vs := range Selectors(expr) {
+		if vs.Name != "" {
+			set[vs.Name] = true
+		}
+		for _, m := range vs.Matchers {
+			if m.Name == "__name__" && m.Type == MatchEqual {
+				set[m.Value] = true
+			}
+		}
+	}
+	return sortedKeys(set)
+}
+
+// Labels returns the sorted label names referenced by expr, in label matchers,
+// in grouping clauses and in vector matching.
+func Labels(expr Expr) []string {
+	set := map[string]bool{}
+	Inspect(expr, func(n Expr) {
+		switch e := n.(type) {
+		case *VectorSelector:
+			for _, m := range e.Matchers {
+				if m.Name != "__name__" {
+					set[m.Name] = true
+				}
+			}
+		case *AggregateExpr:
+			for _, l := range e.Grouping {
+				set[l] = true
+			}
+		case *BinaryExpr:
+			if e.Matching != nil {
+				for _, l := range append(append([]string{}, e.Matching.MatchingLabels...), e.Matching.Include...) {
+					set[l] = true
+				}
+			}
+		}
+	})
+	return sortedKeys(set)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
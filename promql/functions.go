@@ -0,0 +1,154 @@
+package promql
+
+// Function describes a PromQL function.
+type Function struct {
+	Name     string
+	ArgTypes []ValueType
+	// Optional is the number of trailing arguments that can be omitted
+	Optional int
+	// Variadic allows the last argument to be repeated, as in label_join
+	Variadic   bool
+	ReturnType ValueType
+	// unknown marks a function missing from Functions, parsed leniently
+	unknown bool
+}
+
+// Functions are the functions known to the parser.
+var Functions = map[string]*Function{}
+
+func init() {
+	s, v, m, t := ValueTypeScalar, ValueTypeVector, ValueTypeMatrix, ValueTypeString
+	for _, f := range []*Function{
+		{Name: "abs", ArgTypes: args(v), ReturnType: v},
+		{Name: "absent", ArgTypes: args(v), ReturnType: v},
+		{Name: "absent_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "acos", ArgTypes: args(v), ReturnType: v},
+		{Name: "acosh", ArgTypes: args(v), ReturnType: v},
+		{Name: "asin", ArgTypes: args(v), ReturnType: v},
+		{Name: "asinh", ArgTypes: args(v), ReturnType: v},
+		{Name: "atan", ArgTypes: args(v), ReturnType: v},
+		{Name: "atanh", ArgTypes: args(v), ReturnType: v},
+		{Name: "avg_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "ceil", ArgTypes: args(v), ReturnType: v},
+		{Name: "changes", ArgTypes: args(m), ReturnType: v},
+		{Name: "clamp", ArgTypes: args(v, s, s), ReturnType: v},
+		{Name: "clamp_max", ArgTypes: args(v, s), ReturnType: v},
+		{Name: "clamp_min", ArgTypes: args(v, s), ReturnType: v},
+		{Name: "cos", ArgTypes: args(v), ReturnType: v},
+		{Name: "cosh", ArgTypes: args(v), ReturnType: v},
+		{Name: "count_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "day_of_month", ArgTypes: args(v), Optional: 1, ReturnType: v},
+		{Name: "day_of_week", ArgTypes: args(v), Optional: 1, ReturnType: v},
+		{Name: "day_of_year", ArgTypes: args(v), Optional: 1, ReturnType: v},
+		{Name: "days_in_month", ArgTypes: args(v), Optional: 1, ReturnType: v},
+		{Name: "deg", ArgTypes: args(v), ReturnType: v},
+		{Name: "delta", ArgTypes: args(m), ReturnType: v},
+		{Name: "deriv", ArgTypes: args(m), ReturnType: v},
+		{Name: "double_exponential_smoothing", ArgTypes: args(m, s, s), ReturnType: v},
+		{Name: "exp", ArgTypes: args(v), ReturnType: v},
+		{Name: "floor", ArgTypes: args(v), ReturnType: v},
+		{Name: "histogram_avg", ArgTypes: args(v), ReturnType: v},
+		{Name: "histogram_count", ArgTypes: args(v), ReturnType: v},
+		{Name: "histogram_fraction", ArgTypes: args(s, s, v), ReturnType: v},
+		{Name: "histogram_quantile", ArgTypes: args(s, v), ReturnType: v},
+		{Name: "histogram_stddev", ArgTypes: args(v), ReturnType: v},
+		{Name: "histogram_stdvar", ArgTypes: args(v), ReturnType: v},
+		{Name: "histogram_sum", ArgTypes: args(v), ReturnType: v},
+		{Name: "holt_winters", ArgTypes: args(m, s, s), ReturnType: v},
+		{Name: "hour", ArgTypes: args(v), Optional: 1, ReturnType: v},
+		{Name: "idelta", ArgTypes: args(m), ReturnType: v},
+		{Name: "increase", ArgTypes: args(m), ReturnType: v},
+		{Name: "info", ArgTypes: args(v, v), Optional: 1, ReturnType: v},
+		{Name: "irate", ArgTypes: args(m), ReturnType: v},
+		{Name: "label_join", ArgTypes: args(v, t, t, t), Optional: 1, Variadic: true, ReturnType: v},
+		{Name: "label_replace", ArgTypes: args(v, t, t, t, t), ReturnType: v},
+		{Name: "last_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "ln", ArgTypes: args(v), ReturnType: v},
+		{Name: "log10", ArgTypes: args(v), ReturnType: v},
+		{Name: "log2", ArgTypes: args(v), ReturnType: v},
+		{Name: "mad_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "max_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "min_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "minute", ArgTypes: args(v), Optional: 1, ReturnType: v},
+		{Name: "month", ArgTypes: args(v), Optional: 1, ReturnType: v},
+		{Name: "pi", ArgTypes: args(), ReturnType: s},
+		{Name: "predict_linear", ArgTypes: args(m, s), ReturnType: v},
+		{Name: "present_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "quantile_over_time", ArgTypes: args(s, m), ReturnType: v},
+		{Name: "rad", ArgTypes: args(v), ReturnType: v},
+		{Name: "rate", ArgTypes: args(m), ReturnType: v},
+		{Name: "resets", ArgTypes: args(m), ReturnType: v},
+		{Name: "round", ArgTypes: args(v, s), Optional: 1, ReturnType: v},
+		{Name: "scalar", ArgTypes: args(v), ReturnType: s},
+		{Name: "sgn", ArgTypes: args(v), ReturnType: v},
+		{Name: "sin", ArgTypes: args(v), ReturnType: v},
+		{Name: "sinh", ArgTypes: args(v), ReturnType: v},
+		{Name: "sort", ArgTypes: args(v), ReturnType: v},
+		{Name: "sort_by_label", ArgTypes: args(v, t), Optional: 1, Variadic: true, ReturnType: v},
+		{Name: "sort_by_label_desc", ArgTypes: args(v, t), Optional: 1, Variadic: true, ReturnType: v},
+		{Name: "sort_desc", ArgTypes: args(v), ReturnType: v},
+		{Name: "sqrt", ArgTypes: args(v), ReturnType: v},
+		{Name: "stddev_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "stdvar_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "sum_over_time", ArgTypes: args(m), ReturnType: v},
+		{Name: "tan", ArgTypes: args(v), ReturnType: v},
+		{Name: "tanh", ArgTypes: args(v), ReturnType: v},
+		{Name: "time", ArgTypes: args(), ReturnType: s},
+		{Name: "timestamp", ArgTypes: args(v), ReturnType: v},
+		{Name: "vector", ArgTypes: args(s), ReturnType: v},
+		{Name: "year", ArgTypes: args(v), Optional: 1, ReturnType: v},
+	} {
+		Functions[f.Name] = f
+	}
+}
+
+func args(types ...ValueType) []ValueType {
+	return types
+}
+
+// aggregators are the aggregation operators. The value tells the type of the
+// parameter the aggregation takes before the expression, if any.
+var aggregators = map[string]ValueType{
+	"avg":          ValueTypeNone,
+	"bottomk":      ValueTypeScalar,
+	"count":        ValueTypeNone,
+	"count_values": ValueTypeString,
+	"group":        ValueTypeNone,
+	"limit_ratio":  ValueTypeScalar,
+	"limitk":       ValueTypeScalar,
+	"max":          ValueTypeNone,
+	"min":          ValueTypeNone,
+	"quantile":     ValueTypeScalar,
+	"stddev":       ValueTypeNone,
+	"stdvar":       ValueTypeNone,
+	"sum":          ValueTypeNone,
+	"topk":         ValueTypeScalar,
+}
+
+// binary operators by precedence, from lowest to highest
+var binaryPrecedence = map[string]int{
+	"or":     1,
+	"and":    2,
+	"unless": 2,
+	"==":     3,
+	"!=":     3,
+	"<=":     3,
+	"<":      3,
+	">=":     3,
+	">":      3,
+	"+":      4,
+	"-":      4,
+	"*":      5,
+	"/":      5,
+	"%":      5,
+	"atan2":  5,
+	"^":      6,
+}
+
+func isComparisonOperator(op string) bool {
+	return binaryPrecedence[op] == 3
+}
+
+func isSetOperator(op string) bool {
+	return op == "and" || op == "or" || op == "unless"
+}
@@ -0,0 +1,305 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type itemType int
+
+const (
+	itemEOF itemType = iota
+	itemIdentifier
+	itemNumber
+	itemDuration
+	itemString
+	itemLeftParen
+	itemRightParen
+	itemLeftBrace
+	itemRightBrace
+	itemLeftBracket
+	itemRightBracket
+	itemComma
+	itemColon
+	itemAt
+	itemAssign   // =
+	itemEqlRegex // =~
+	itemNeqRegex // !~
+	itemADD
+	itemSUB
+	itemMUL
+	itemDIV
+	itemMOD
+	itemPOW
+	itemEQLC // ==
+	itemNEQ
+	itemLTE
+	itemLSS
+	itemGTE
+	itemGTR
+)
+
+var itemNames = map[itemType]string{
+	itemEOF:          "end of input",
+	itemIdentifier:   "identifier",
+	itemNumber:       "number",
+	itemDuration:     "duration",
+	itemString:       "string",
+	itemLeftParen:    "(",
+	itemRightParen:   ")",
+	itemLeftBrace:    "{",
+	itemRightBrace:   "}",
+	itemLeftBracket:  "[",
+	itemRightBracket: "]",
+	itemComma:        ",",
+	itemColon:        ":",
+	itemAt:           "@",
+	itemAssign:       "=",
+	itemEqlRegex:     "=~",
+	itemNeqRegex:     "!~",
+	itemADD:          "+",
+	itemSUB:          "-",
+	itemMUL:          "*",
+	itemDIV:          "/",
+	itemMOD:          "%",
+	itemPOW:          "^",
+	itemEQLC:         "==",
+	itemNEQ:          "!=",
+	itemLTE:          "<=",
+	itemLSS:          "<",
+	itemGTE:          ">=",
+	itemGTR:          ">",
+}
+
+func (t itemType) String() string {
+	return itemNames[t]
+}
+
+// item is a token of a PromQL expression with its position.
+type item struct {
+	typ itemType
+	pos int
+	val string
+}
+
+func (i item) PositionRange() PositionRange {
+	return PositionRange{Start: i.pos, End: i.pos + len(i.val)}
+}
+
+func (i item) describe() string {
+	switch i.typ {
+	case itemEOF:
+		return "end of input"
+	case itemIdentifier, itemNumber, itemDuration, itemString:
+		return fmt.Sprintf("%s %q", i.typ, i.val)
+	}
+	return fmt.Sprintf("%q", i.val)
+}
+
+// lex splits a PromQL expression into tokens. Comments are dropped.
+func lex(input string) ([]item, error) {
+	items := []item{}
+	bracketDepth := 0
+	for pos := 0; pos < len(input); {
+		c := input[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+			continue
+		case c == '#':
+			for pos < len(input) && input[pos] != '\n' {
+				pos++
+			}
+			continue
+		case isIdentifierStart(c) && !(c == ':' && bracketDepth > 0):
+			end := pos + 1
+			// colons belong to metric names, except inside the brackets of a subquery
+			for end < len(input) && (isAlphaNumeric(input[end]) || (input[end] == ':' && bracketDepth == 0)) {
+				end++
+			}
+			items = append(items, item{itemIdentifier, pos, input[pos:end]})
+			pos = end
+			continue
+		case isDigit(c) || (c == '.' && pos+1 < len(input) && isDigit(input[pos+1])):
+			it, err := lexNumberOrDuration(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, it)
+			pos += len(it.val)
+			continue
+		case c == '"' || c == '\'' || c == '`':
+			end, err := scanString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item{itemString, pos, input[pos:end]})
+			pos = end
+			continue
+		}
+
+		typ, width := lexOperator(input[pos:])
+		if width == 0 {
+			r, _ := utf8.DecodeRuneInString(input[pos:])
+			return nil, &ParseError{PositionRange: PositionRange{Start: pos, End: pos + 1}, Err: fmt.Sprintf("unexpected character %q", r), Query: input}
+		}
+		switch typ {
+		case itemLeftBracket:
+			bracketDepth++
+		case itemRightBracket:
+			if bracketDepth > 0 {
+				bracketDepth--
+			}
+		}
+		items = append(items, item{typ, pos, input[pos : pos+width]})
+		pos += width
+	}
+	items = append(items, item{itemEOF, len(input), ""})
+	return items, nil
+}
+
+var twoCharOperators = map[string]itemType{
+	"==": itemEQLC,
+	"!=": itemNEQ,
+	"<=": itemLTE,
+	">=": itemGTE,
+	"=~": itemEqlRegex,
+	"!~": itemNeqRegex,
+}
+
+var oneCharOperators = map[byte]itemType{
+	'(': itemLeftParen,
+	')': itemRightParen,
+	'{': itemLeftBrace,
+	'}': itemRightBrace,
+	'[': itemLeftBracket,
+	']': itemRightBracket,
+	',': itemComma,
+	':': itemColon,
+	'@': itemAt,
+	'=': itemAssign,
+	'+': itemADD,
+	'-': itemSUB,
+	'*': itemMUL,
+	'/': itemDIV,
+	'%': itemMOD,
+	'^': itemPOW,
+	'<': itemLSS,
+	'>': itemGTR,
+}
+
+func lexOperator(s string) (itemType, int) {
+	if len(s) >= 2 {
+		if typ, ok := twoCharOperators[s[:2]]; ok {
+			return typ, 2
+		}
+	}
+	if typ, ok := oneCharOperators[s[0]]; ok {
+		return typ, 1
+	}
+	return itemEOF, 0
+}
+
+// lexNumberOrDuration scans a number, such as 1, 1.5e3 or 0x1f, or a
+// duration, such as 5m or 1h30m.
+func lexNumberOrDuration(input string, pos int) (item, error) {
+	end := pos
+	for end < len(input) && isDigit(input[end]) {
+		end++
+	}
+	if end < len(input) && end > pos && isDurationUnit(input, end) {
+		for end < len(input) {
+			width := durationUnitWidth(input, end)
+			if width == 0 {
+				break
+			}
+			end += width
+			digits := end
+			for digits < len(input) && isDigit(input[digits]) {
+				digits++
+			}
+			if digits == end || !isDurationUnit(input, digits) {
+				break
+			}
+			end = digits
+		}
+		if end < len(input) && isAlphaNumeric(input[end]) {
+			return item{}, &ParseError{PositionRange: PositionRange{Start: pos, End: end + 1}, Err: fmt.Sprintf("bad duration syntax: %q", input[pos:end+1]), Query: input}
+		}
+		return item{itemDuration, pos, input[pos:end]}, nil
+	}
+
+	end = pos
+	if strings.HasPrefix(input[pos:], "0x") || strings.HasPrefix(input[pos:], "0X") {
+		end += 2
+		for end < len(input) && strings.IndexByte("0123456789abcdefABCDEF", input[end]) >= 0 {
+			end++
+		}
+	} else {
+		for end < len(input) && (isDigit(input[end]) || input[end] == '.') {
+			end++
+		}
+		if end < len(input) && (input[end] == 'e' || input[end] == 'E') {
+			exp := end + 1
+			if exp < len(input) && (input[exp] == '+' || input[exp] == '-') {
+				exp++
+			}
+			if exp < len(input) && isDigit(input[exp]) {
+				end = exp
+				for end < len(input) && isDigit(input[end]) {
+					end++
+				}
+			}
+		}
+	}
+	if end < len(input) && isAlphaNumeric(input[end]) {
+		return item{}, &ParseError{PositionRange: PositionRange{Start: pos, End: end + 1}, Err: fmt.Sprintf("bad number or duration syntax: %q", input[pos:end+1]), Query: input}
+	}
+	return item{itemNumber, pos, input[pos:end]}, nil
+}
+
+func isDurationUnit(input string, pos int) bool {
+	return durationUnitWidth(input, pos) > 0
+}
+
+func durationUnitWidth(input string, pos int) int {
+	if pos >= len(input) {
+		return 0
+	}
+	if strings.HasPrefix(input[pos:], "ms") {
+		return 2
+	}
+	if strings.IndexByte("smhdwy", input[pos]) >= 0 {
+		return 1
+	}
+	return 0
+}
+
+// scanString returns the end of the quoted string starting at pos.
+func scanString(input string, pos int) (int, error) {
+	quote := input[pos]
+	for i := pos + 1; i < len(input); i++ {
+		switch {
+		case input[i] == '\\' && quote != '`':
+			i++
+		case input[i] == quote:
+			return i + 1, nil
+		case input[i] == '\n' && quote != '`':
+			return 0, &ParseError{PositionRange: PositionRange{Start: pos, End: i}, Err: "unterminated quoted string", Query: input}
+		}
+	}
+	return 0, &ParseError{PositionRange: PositionRange{Start: pos, End: len(input)}, Err: "unterminated quoted string", Query: input}
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlphaNumeric(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,646 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+	"proxy-api-server/timerange"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ParseError is a syntax or type error of a PromQL expression.
+type ParseError struct {
+	PositionRange PositionRange
+	Err           string
+	Query         string
+}
+
+// LineAndColumn returns the 1-based line and column of the start of the error.
+func (e *ParseError) LineAndColumn() (int, int) {
+	start := e.PositionRange.Start
+	if start > len(e.Query) {
+		start = len(e.Query)
+	}
+	before := e.Query[:start]
+	line := strings.Count(before, "\n") + 1
+	column := start - strings.LastIndex(before, "\n")
+	return line, column
+}
+
+func (e *ParseError) Error() string {
+	line, column := e.LineAndColumn()
+	return fmt.Sprintf("%d:%d: parse error: %s", line, column, e.Err)
+}
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type parser struct {
+	input string
+	items []item
+	pos   int
+	// lenient turns unknown functions and type errors into warnings
+	lenient  bool
+	warnings []*ParseError
+}
+
+// ParseExpr parses and type checks a PromQL expression.
+func ParseExpr(input string) (Expr, error) {
+	p, err := newParser(input)
+	if err != nil {
+		return nil, err
+	}
+	return p.parse()
+}
+
+// Parse parses a PromQL expression like ParseExpr but only fails on syntax
+// errors. Calls of unknown functions, such as those of MetricsQL, and type
+// errors are returned as warnings and left for the upstream to judge.
+func Parse(input string) (Expr, []*ParseError, error) {
+	p, err := newParser(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.lenient = true
+	expr, err := p.parse()
+	if err != nil {
+		return nil, nil, err
+	}
+	return expr, p.warnings, nil
+}
+
+func (p *parser) parse() (expr Expr, err error) {
+	defer p.recover(&err)
+	if p.peek().typ == itemEOF {
+		p.errorf(PositionRange{}, "no expression found in input")
+	}
+	expr = p.parseExpr(0)
+	if it := p.peek(); it.typ != itemEOF {
+		p.errorf(it.PositionRange(), "unexpected %s", it.describe())
+	}
+	p.checkTypes(expr)
+	return expr, nil
+}
+
+// ParseMatchers parses a comma separated list of label matchers, such as
+// namespace=~"team-a-.*", env="prod".
+func ParseMatchers(input string) (matchers []*LabelMatcher, err error) {
+	// report positions in the input rather than in the wrapped selector
+	defer func() {
+		if parseErr, ok := err.(*ParseError); ok {
+			parseErr.Query = input
+			parseErr.PositionRange.Start = clamp(parseErr.PositionRange.Start-1, len(input))
+			parseErr.PositionRange.End = clamp(parseErr.PositionRange.End-1, len(input))
+		}
+	}()
+	p, err := newParser("{" + input + "}")
+	if err != nil {
+		return nil, err
+	}
+	defer p.recover(&err)
+	matchers = p.parseMatchers()
+	if it := p.peek(); it.typ != itemEOF {
+		p.errorf(it.PositionRange(), "unexpected %s", it.describe())
+	}
+	return matchers, nil
+}
+
+func newParser(input string) (*parser, error) {
+	items, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{input: input, items: items}, nil
+}
+
+// errorf aborts parsing, the error is returned by recover.
+func (p *parser) errorf(pos PositionRange, format string, args ...interface{}) {
+	panic(&ParseError{PositionRange: pos, Err: fmt.Sprintf(format, args...), Query: p.input})
+}
+
+// warnf records a warning when parsing leniently and aborts parsing otherwise.
+func (p *parser) warnf(pos PositionRange, format string, args ...interface{}) {
+	if !p.lenient {
+		p.errorf(pos, format, args...)
+	}
+	p.warnings = append(p.warnings, &ParseError{PositionRange: pos, Err: fmt.Sprintf(format, args...), Query: p.input})
+}
+
+func (p *parser) recover(err *error) {
+	if r := recover(); r != nil {
+		parseErr, ok := r.(*ParseError)
+		if !ok {
+			panic(r)
+		}
+		*err = parseErr
+	}
+}
+
+func (p *parser) peek() item {
+	return p.items[p.pos]
+}
+
+func (p *parser) peekAt(offset int) item {
+	if p.pos+offset >= len(p.items) {
+		return p.items[len(p.items)-1]
+	}
+	return p.items[p.pos+offset]
+}
+
+func (p *parser) next() item {
+	it := p.items[p.pos]
+	if it.typ != itemEOF {
+		p.pos++
+	}
+	return it
+}
+
+func (p *parser) expect(typ itemType, context string) item {
+	it := p.next()
+	if it.typ != typ {
+		p.errorf(it.PositionRange(), "unexpected %s in %s, expected %q", it.describe(), context, typ.String())
+	}
+	return it
+}
+
+func isKeyword(it item, keyword string) bool {
+	return it.typ == itemIdentifier && strings.EqualFold(it.val, keyword)
+}
+
+// binaryOperator returns the binary operator of the item, if it is one.
+func binaryOperator(it item) (string, bool) {
+	switch it.typ {
+	case itemADD, itemSUB, itemMUL, itemDIV, itemMOD, itemPOW, itemEQLC, itemNEQ, itemLTE, itemLSS, itemGTE, itemGTR:
+		return it.val, true
+	case itemIdentifier:
+		op := strings.ToLower(it.val)
+		if op == "and" || op == "or" || op == "unless" || op == "atan2" {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// parseExpr parses binary operations of at least the given precedence by precedence climbing.
+func (p *parser) parseExpr(minPrecedence int) Expr {
+	lhs := p.parseUnary()
+	for {
+		op, ok := binaryOperator(p.peek())
+		if !ok || binaryPrecedence[op] < minPrecedence {
+			return lhs
+		}
+		p.next()
+		be := &BinaryExpr{Op: op, LHS: lhs}
+		p.parseBinaryModifiers(be)
+		next := binaryPrecedence[op] + 1
+		if op == "^" {
+			// right associative
+			next = binaryPrecedence[op]
+		}
+		be.RHS = p.parseExpr(next)
+		lhs = be
+	}
+}
+
+func (p *parser) parseBinaryModifiers(be *BinaryExpr) {
+	if isKeyword(p.peek(), "bool") {
+		it := p.next()
+		if !isComparisonOperator(be.Op) {
+			p.errorf(it.PositionRange(), "bool modifier can only be used on comparison operators")
+		}
+		be.ReturnBool = true
+	}
+	if it := p.peek(); isKeyword(it, "on") || isKeyword(it, "ignoring") {
+		p.next()
+		be.Matching = &VectorMatching{
+			Card:           CardOneToOne,
+			On:             isKeyword(it, "on"),
+			MatchingLabels: p.parseLabelList(),
+			explicit:       true,
+		}
+		if it := p.peek(); isKeyword(it, "group_left") || isKeyword(it, "group_right") {
+			p.next()
+			if isSetOperator(be.Op) {
+				p.errorf(it.PositionRange(), "no grouping allowed for %q operation", be.Op)
+			}
+			be.Matching.Card = CardManyToOne
+			if isKeyword(it, "group_right") {
+				be.Matching.Card = CardOneToMany
+			}
+			be.Matching.Include = []string{}
+			if p.peek().typ == itemLeftParen {
+				be.Matching.Include = p.parseLabelList()
+			}
+		}
+	}
+}
+
+func (p *parser) parseUnary() Expr {
+	it := p.peek()
+	if it.typ != itemADD && it.typ != itemSUB {
+		return p.parsePostfix(p.parsePrimary())
+	}
+	p.next()
+	// unary operators bind weaker than ^, -2^2 is -4
+	expr := p.parseExpr(binaryPrecedence["^"])
+	if n, ok := expr.(*NumberLiteral); ok {
+		if it.typ == itemSUB {
+			n.Val = -n.Val
+		}
+		n.PosRange.Start = it.pos
+		return n
+	}
+	return &UnaryExpr{Op: it.val, Expr: expr, StartPos: it.pos}
+}
+
+func (p *parser) parsePrimary() Expr {
+	it := p.peek()
+	switch it.typ {
+	case itemNumber:
+		p.next()
+		return &NumberLiteral{Val: p.parseNumber(it), PosRange: it.PositionRange()}
+	case itemString:
+		p.next()
+		return &StringLiteral{Val: p.unquote(it), PosRange: it.PositionRange()}
+	case itemLeftParen:
+		p.next()
+		expr := p.parseExpr(0)
+		end := p.expect(itemRightParen, "parenthesized expression")
+		return &ParenExpr{Expr: expr, PosRange: PositionRange{Start: it.pos, End: end.pos + 1}}
+	case itemLeftBrace:
+		return p.parseVectorSelector()
+	case itemIdentifier:
+		name := strings.ToLower(it.val)
+		next := p.peekAt(1)
+		if name == "inf" || name == "nan" {
+			p.next()
+			return &NumberLiteral{Val: p.parseNumber(it), PosRange: it.PositionRange()}
+		}
+		if _, ok := aggregators[name]; ok && (next.typ == itemLeftParen || isKeyword(next, "by") || isKeyword(next, "without")) {
+			return p.parseAggregation()
+		}
+		if next.typ == itemLeftParen {
+			return p.parseCall()
+		}
+		return p.parseVectorSelector()
+	case itemEOF:
+		p.errorf(it.PositionRange(), "unexpected end of input")
+	}
+	p.errorf(it.PositionRange(), "unexpected %s", it.describe())
+	return nil
+}
+
+func (p *parser) parseNumber(it item) float64 {
+	switch strings.ToLower(it.val) {
+	case "inf":
+		return math.Inf(1)
+	case "nan":
+		return math.NaN()
+	}
+	if strings.HasPrefix(it.val, "0x") || strings.HasPrefix(it.val, "0X") {
+		n, err := strconv.ParseInt(it.val, 0, 64)
+		if err != nil {
+			p.errorf(it.PositionRange(), "invalid number %q", it.val)
+		}
+		return float64(n)
+	}
+	f, err := strconv.ParseFloat(it.val, 64)
+	if err != nil {
+		p.errorf(it.PositionRange(), "invalid number %q", it.val)
+	}
+	return f
+}
+
+// unquote returns the value of a double quoted, single quoted or raw string.
+func (p *parser) unquote(it item) string {
+	s := it.val
+	switch s[0] {
+	case '`':
+		return s[1 : len(s)-1]
+	case '\'':
+		// rewrite as a double quoted string for strconv
+		var sb strings.Builder
+		sb.WriteByte('"')
+		body := s[1 : len(s)-1]
+		for i := 0; i < len(body); i++ {
+			switch {
+			case body[i] == '\\' && i+1 < len(body) && body[i+1] == '\'':
+				sb.WriteByte('\'')
+				i++
+			case body[i] == '\\' && i+1 < len(body):
+				sb.WriteString(body[i : i+2])
+				i++
+			case body[i] == '"':
+				sb.WriteString(`\"`)
+			default:
+				sb.WriteByte(body[i])
+			}
+		}
+		sb.WriteByte('"')
+		s = sb.String()
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		p.errorf(it.PositionRange(), "invalid string %s: %v", it.val, err)
+	}
+	return v
+}
+
+func (p *parser) parseDuration(it item) time.Duration {
+	if it.typ != itemDuration {
+		p.errorf(it.PositionRange(), "unexpected %s, expected a duration", it.describe())
+	}
+	d, err := timerange.ParseDuration(it.val)
+	if err != nil {
+		p.errorf(it.PositionRange(), "invalid duration %q: %v", it.val, err)
+	}
+	return d
+}
+
+func (p *parser) parseVectorSelector() Expr {
+	vs := &VectorSelector{Matchers: []*LabelMatcher{}}
+	start := p.peek()
+	vs.PosRange = start.PositionRange()
+	if start.typ == itemIdentifier {
+		p.next()
+		vs.Name = start.val
+	}
+	if p.peek().typ == itemLeftBrace {
+		vs.Matchers = p.parseMatchers()
+		vs.PosRange.End = p.items[p.pos-1].pos + 1
+	}
+
+	if vs.Name != "" {
+		for _, m := range vs.Matchers {
+			if m.Name == "__name__" {
+				p.errorf(vs.PosRange, "metric name must not be set twice: %q or %q", vs.Name, m.Value)
+			}
+		}
+	} else {
+		empty := true
+		for _, m := range vs.Matchers {
+			if !m.Matches("") {
+				empty = false
+			}
+		}
+		if empty {
+			p.errorf(vs.PosRange, "vector selector must contain at least one non-empty matcher")
+		}
+	}
+	return vs
+}
+
+func (p *parser) parseMatchers() []*LabelMatcher {
+	p.expect(itemLeftBrace, "label matching")
+	matchers := []*LabelMatcher{}
+	for p.peek().typ != itemRightBrace {
+		name := p.next()
+		label := p.labelName(name, "label matching")
+		if next := p.peek().typ; name.typ == itemString && (next == itemComma || next == itemRightBrace) {
+			// a lone quoted name is the metric name, as in {"http.requests.total"}
+			m, _ := NewLabelMatcher(MatchEqual, "__name__", label)
+			matchers = append(matchers, m)
+			if next == itemComma {
+				p.next()
+			}
+			continue
+		}
+		opItem := p.next()
+		var op MatchType
+		switch opItem.typ {
+		case itemAssign:
+			op = MatchEqual
+		case itemNEQ:
+			op = MatchNotEqual
+		case itemEqlRegex:
+			op = MatchRegexp
+		case itemNeqRegex:
+			op = MatchNotRegexp
+		default:
+			p.errorf(opItem.PositionRange(), "unexpected %s in label matching, expected one of \"=\", \"!=\", \"=~\" or \"!~\"", opItem.describe())
+		}
+		value := p.expect(itemString, "label matching")
+		m, err := NewLabelMatcher(op, label, p.unquote(value))
+		if err != nil {
+			p.errorf(value.PositionRange(), "invalid regular expression in label matcher: %v", err)
+		}
+		matchers = append(matchers, m)
+		if p.peek().typ != itemRightBrace {
+			p.expect(itemComma, "label matching")
+		}
+	}
+	p.next()
+	return matchers
+}
+
+// labelName returns the label name of an identifier or, for names outside of
+// the legacy character set, of a quoted UTF-8 string.
+func (p *parser) labelName(it item, context string) string {
+	switch it.typ {
+	case itemIdentifier:
+		if !labelNameRegex.MatchString(it.val) {
+			p.errorf(it.PositionRange(), "invalid label name %q", it.val)
+		}
+		return it.val
+	case itemString:
+		name := p.unquote(it)
+		if name == "" || !utf8.ValidString(name) {
+			p.errorf(it.PositionRange(), "invalid label name %s", it.val)
+		}
+		return name
+	}
+	p.errorf(it.PositionRange(), "unexpected %s in %s, expected a label name", it.describe(), context)
+	return ""
+}
+
+// parseLabelList parses a parenthesized list of label names, as used by by, without, on and ignoring.
+func (p *parser) parseLabelList() []string {
+	p.expect(itemLeftParen, "grouping")
+	labels := []string{}
+	for p.peek().typ != itemRightParen {
+		labels = append(labels, p.labelName(p.next(), "grouping"))
+		if p.peek().typ != itemRightParen {
+			p.expect(itemComma, "grouping")
+		}
+	}
+	p.next()
+	return labels
+}
+
+func (p *parser) parseAggregation() Expr {
+	opItem := p.next()
+	agg := &AggregateExpr{Op: strings.ToLower(opItem.val), PosRange: opItem.PositionRange()}
+	parseGrouping := func() {
+		if it := p.peek(); isKeyword(it, "by") || isKeyword(it, "without") {
+			if agg.grouped {
+				p.errorf(it.PositionRange(), "aggregation may only have one by or without clause")
+			}
+			p.next()
+			agg.Without = isKeyword(it, "without")
+			agg.Grouping = p.parseLabelList()
+			agg.grouped = true
+		}
+	}
+	parseGrouping()
+	p.expect(itemLeftParen, "aggregation")
+	if aggregators[agg.Op] != ValueTypeNone {
+		agg.Param = p.parseExpr(0)
+		p.expect(itemComma, "aggregation")
+	}
+	agg.Expr = p.parseExpr(0)
+	end := p.expect(itemRightParen, "aggregation")
+	agg.PosRange.End = end.pos + 1
+	parseGrouping()
+	if agg.grouped {
+		agg.PosRange.End = p.items[p.pos-1].pos + 1
+	}
+	return agg
+}
+
+func (p *parser) parseCall() Expr {
+	nameItem := p.next()
+	fn, ok := Functions[nameItem.val]
+	if !ok {
+		p.warnf(nameItem.PositionRange(), "unknown function with name %q", nameItem.val)
+		fn = &Function{Name: nameItem.val, Variadic: true, ReturnType: ValueTypeVector, unknown: true}
+	}
+	call := &Call{Func: fn, Args: []Expr{}, PosRange: nameItem.PositionRange()}
+	p.expect(itemLeftParen, "function call")
+	for p.peek().typ != itemRightParen {
+		call.Args = append(call.Args, p.parseExpr(0))
+		if p.peek().typ != itemRightParen {
+			p.expect(itemComma, "function call")
+		}
+	}
+	call.PosRange.End = p.next().pos + 1
+	return call
+}
+
+// parsePostfix parses the ranges, subqueries and offset and @ modifiers following an expression.
+func (p *parser) parsePostfix(expr Expr) Expr {
+	for {
+		it := p.peek()
+		switch {
+		case it.typ == itemLeftBracket:
+			expr = p.parseRange(expr)
+		case isKeyword(it, "offset"):
+			p.next()
+			p.parseOffset(expr, it)
+		case it.typ == itemAt:
+			p.next()
+			p.parseAt(expr, it)
+		default:
+			return expr
+		}
+	}
+}
+
+func (p *parser) parseRange(expr Expr) Expr {
+	open := p.next()
+	rangeItem := p.next()
+	rng := p.parseDuration(rangeItem)
+	if p.peek().typ == itemColon {
+		p.next()
+		sq := &SubqueryExpr{Expr: expr, Range: rng}
+		if p.peek().typ == itemDuration {
+			sq.Step = p.parseDuration(p.next())
+		}
+		sq.EndPos = p.expect(itemRightBracket, "subquery selector").pos + 1
+		return sq
+	}
+	end := p.expect(itemRightBracket, "range selector")
+	vs, ok := expr.(*VectorSelector)
+	if !ok {
+		p.errorf(PositionRange{Start: open.pos, End: end.pos + 1}, "ranges only allowed for vector selectors")
+	}
+	if vs.Offset != 0 || vs.Timestamp != nil || vs.StartOrEnd != "" {
+		p.errorf(PositionRange{Start: open.pos, End: end.pos + 1}, "no offset or @ modifiers allowed before range")
+	}
+	return &MatrixSelector{VectorSelector: vs, Range: rng, EndPos: end.pos + 1}
+}
+
+func (p *parser) modifiers(expr Expr, it item) *Modifiers {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		return &e.Modifiers
+	case *MatrixSelector:
+		return &e.VectorSelector.Modifiers
+	case *SubqueryExpr:
+		return &e.Modifiers
+	}
+	p.errorf(it.PositionRange(), "%s modifier must be preceded by an instant vector selector or range vector selector or a subquery", it.val)
+	return nil
+}
+
+func (p *parser) parseOffset(expr Expr, it item) {
+	mod := p.modifiers(expr, it)
+	if mod.Offset != 0 {
+		p.errorf(it.PositionRange(), "offset may not be set multiple times")
+	}
+	negative := false
+	if p.peek().typ == itemSUB {
+		p.next()
+		negative = true
+	}
+	d := p.parseDuration(p.next())
+	if negative {
+		d = -d
+	}
+	mod.Offset = d
+	p.setEnd(expr)
+}
+
+func (p *parser) parseAt(expr Expr, it item) {
+	mod := p.modifiers(expr, it)
+	if mod.Timestamp != nil || mod.StartOrEnd != "" {
+		p.errorf(it.PositionRange(), "@ <timestamp> may not be set multiple times")
+	}
+	next := p.next()
+	switch {
+	case isKeyword(next, "start") || isKeyword(next, "end"):
+		p.expect(itemLeftParen, "@ modifier")
+		p.expect(itemRightParen, "@ modifier")
+		mod.StartOrEnd = strings.ToLower(next.val)
+	case next.typ == itemNumber || next.typ == itemSUB || next.typ == itemADD:
+		sign := 1.0
+		if next.typ != itemNumber {
+			if next.typ == itemSUB {
+				sign = -1
+			}
+			next = p.expect(itemNumber, "@ modifier")
+		}
+		ts := sign * p.parseNumber(next)
+		if math.IsInf(ts, 0) || math.IsNaN(ts) {
+			p.errorf(next.PositionRange(), "timestamp out of bounds for @ modifier: %f", ts)
+		}
+		mod.Timestamp = &ts
+	default:
+		p.errorf(next.PositionRange(), "unexpected %s in @ modifier, expected a timestamp, start() or end()", next.describe())
+	}
+	p.setEnd(expr)
+}
+
+// setEnd extends the position of a selector or subquery to include its modifiers.
+func (p *parser) setEnd(expr Expr) {
+	end := p.items[p.pos-1].pos + len(p.items[p.pos-1].val)
+	switch e := expr.(type) {
+	case *VectorSelector:
+		e.PosRange.End = end
+	case *MatrixSelector:
+		e.EndPos = end
+	case *SubqueryExpr:
+		e.EndPos = end
+	}
+}
+
+func clamp(pos, max int) int {
+	if pos < 0 {
+		return 0
+	}
+	if pos > max {
+		return max
+	}
+	return pos
+}
@@ -0,0 +1,102 @@
+package promql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		want     string
+		warnings []string
+		wantErr  string
+	}{
+		{
+			name:  "info",
+			query: `info(rate(http_requests_total[5m]), {k8s_cluster_name=~".+"})`,
+			want:  `info(rate(http_requests_total[5m]), {k8s_cluster_name=~".+"})`,
+		},
+		{
+			name:  "quoted label name",
+			query: `sum by ("service.name") (x{"http.method"="GET"})`,
+			want:  `sum by ("service.name") (x{"http.method"="GET"})`,
+		},
+		{
+			name:  "quoted metric name",
+			query: `{"http.requests.total", job="api"}`,
+			want:  `{__name__="http.requests.total", job="api"}`,
+		},
+		{
+			name:  "quoted legacy label name",
+			query: `x{"job"="api"} / on("job") y`,
+			want:  `x{job="api"} / on(job) y`,
+		},
+		{
+			name:     "unknown function",
+			query:    `sum(range_median(x))`,
+			want:     `sum(range_median(x))`,
+			warnings: []string{`unknown function with name "range_median"`},
+		},
+		{
+			name:     "metricsql rate without range",
+			query:    `rate(x) + median_over_time(y[5m])`,
+			want:     `rate(x) + median_over_time(y[5m])`,
+			warnings: []string{`unknown function with name "median_over_time"`, `expected type range vector in call to function "rate", got instant vector`},
+		},
+		{
+			name:     "wrong argument count",
+			query:    `time(1)`,
+			want:     `time(1)`,
+			warnings: []string{`expected 0 argument(s) in call to "time", got 1`},
+		},
+		{
+			name:    "undefined variable",
+			query:   `rate(x[$__rate_interval])`,
+			wantErr: `unexpected character '$'`,
+		},
+		{
+			name:    "unclosed selector",
+			query:   `x{job="api"`,
+			wantErr: `label matching`,
+		},
+		{
+			name:    "empty quoted label name",
+			query:   `x{""="a"}`,
+			wantErr: `invalid label name`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, warnings, err := Parse(tt.query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse(%q) error = %v, want %q", tt.query, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.query, err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+			got := []string{}
+			for _, w := range warnings {
+				got = append(got, w.Err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("Parse(%q) warnings = %q, want %q", tt.query, got, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestParseExprIsStrict(t *testing.T) {
+	for _, query := range []string{`range_median(x)`, `rate(x)`, `sum(x[5m])`} {
+		if _, err := ParseExpr(query); err == nil {
+			t.Errorf("ParseExpr(%q) succeeded, want an error", query)
+		}
+	}
+}
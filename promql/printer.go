@@ -0,0 +1,147 @@
+package promql
+
+import (
+	"math"
+	"proxy-api-server/timerange"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func quote(s string) string {
+	return strconv.Quote(s)
+}
+
+// labelName quotes label names that are not valid identifiers.
+func labelName(name string) string {
+	if labelNameRegex.MatchString(name) {
+		return name
+	}
+	return quote(name)
+}
+
+// labelList formats the label names of a grouping.
+func labelList(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, labelName(name))
+	}
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
+
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		return "-" + timerange.FormatDuration(-d)
+	}
+	return timerange.FormatDuration(d)
+}
+
+func (e *NumberLiteral) String() string {
+	switch {
+	case math.IsInf(e.Val, 1):
+		return "Inf"
+	case math.IsInf(e.Val, -1):
+		return "-Inf"
+	case math.IsNaN(e.Val):
+		return "NaN"
+	}
+	return strconv.FormatFloat(e.Val, 'g', -1, 64)
+}
+
+func (e *StringLiteral) String() string {
+	return quote(e.Val)
+}
+
+func (m Modifiers) String() string {
+	s := ""
+	if m.Offset != 0 {
+		s += " offset " + formatDuration(m.Offset)
+	}
+	if m.Timestamp != nil {
+		s += " @ " + strconv.FormatFloat(*m.Timestamp, 'f', 3, 64)
+	} else if m.StartOrEnd != "" {
+		s += " @ " + m.StartOrEnd + "()"
+	}
+	return s
+}
+
+// selector returns the selector without its modifiers.
+func (e *VectorSelector) selector() string {
+	if len(e.Matchers) == 0 {
+		return e.Name
+	}
+	matchers := make([]string, 0, len(e.Matchers))
+	for _, m := range e.Matchers {
+		matchers = append(matchers, m.String())
+	}
+	return e.Name + "{" + strings.Join(matchers, ", ") + "}"
+}
+
+func (e *VectorSelector) String() string {
+	return e.selector() + e.Modifiers.String()
+}
+
+func (e *MatrixSelector) String() string {
+	return e.VectorSelector.selector() + "[" + formatDuration(e.Range) + "]" + e.VectorSelector.Modifiers.String()
+}
+
+func (e *SubqueryExpr) String() string {
+	step := ""
+	if e.Step != 0 {
+		step = formatDuration(e.Step)
+	}
+	return e.Expr.String() + "[" + formatDuration(e.Range) + ":" + step + "]" + e.Modifiers.String()
+}
+
+func (e *ParenExpr) String() string {
+	return "(" + e.Expr.String() + ")"
+}
+
+func (e *UnaryExpr) String() string {
+	return e.Op + e.Expr.String()
+}
+
+func (e *BinaryExpr) String() string {
+	op := e.Op
+	if e.ReturnBool {
+		op += " bool"
+	}
+	if m := e.Matching; m != nil && m.explicit {
+		if m.On {
+			op += " on" + labelList(m.MatchingLabels)
+		} else {
+			op += " ignoring" + labelList(m.MatchingLabels)
+		}
+		switch m.Card {
+		case CardManyToOne:
+			op += " group_left" + labelList(m.Include)
+		case CardOneToMany:
+			op += " group_right" + labelList(m.Include)
+		}
+	}
+	return e.LHS.String() + " " + op + " " + e.RHS.String()
+}
+
+func (e *AggregateExpr) String() string {
+	s := e.Op
+	if e.grouped {
+		if e.Without {
+			s += " without"
+		} else {
+			s += " by"
+		}
+		s += " " + labelList(e.Grouping) + " "
+	}
+	if e.Param != nil {
+		return s + "(" + e.Param.String() + ", " + e.Expr.String() + ")"
+	}
+	return s + "(" + e.Expr.String() + ")"
+}
+
+func (e *Call) String() string {
+	args := make([]string, 0, len(e.Args))
+	for _, a := range e.Args {
+		args = append(args, a.String())
+	}
+	return e.Func.Name + "(" + strings.Join(args, ", ") + ")"
+}
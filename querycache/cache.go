@@ -0,0 +1,208 @@
+package querycache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"proxy-api-server/config"
+	"proxy-api-server/log"
+	"proxy-api-server/timerange"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FetchFunc runs the range query against the upstream for [start, end].
+type FetchFunc func(ctx context.Context, start, end time.Time) ([]byte, error)
+
+// Cache is a range query result cache in the style of the Prometheus query
+// frontend. Ranges are split into step aligned blocks; blocks that are old
+// enough to be final are cached, and only missing blocks and the recent tail
+// are fetched from upstream.
+type Cache struct {
+	store         Store
+	splitInterval time.Duration
+	maxFreshness  time.Duration
+}
+
+var (
+	defaultCache     *Cache
+	defaultCacheOnce sync.Once
+)
+
+// New creates a cache from its configuration. A disk store is added when a
+// directory is configured, it is not bounded by max_entries nor max_bytes.
+func New(conf config.QueryCache) (*Cache, error) {
+	memory := newMemoryStore(conf.MaxEntries, conf.MaxBytes)
+	var store Store = memory
+	if conf.Directory != "" {
+		disk, err := newDiskStore(conf.Directory)
+		if err != nil {
+			return nil, err
+		}
+		store = &tieredStore{memory: memory, disk: disk}
+	}
+	return &Cache{
+		store:         store,
+		splitInterval: conf.SplitInterval,
+		maxFreshness:  conf.MaxFreshness,
+	}, nil
+}
+
+// Default returns the cache described by the global configuration, or nil
+// when caching is disabled or cannot be initialized.
+func Default() *Cache {
+	defaultCacheOnce.Do(func() {
+		conf := config.Get().QueryCache
+		if !conf.Enabled {
+			return
+		}
+		c, err := New(conf)
+		if err != nil {
+			log.Errorf("Unable to initialize the query cache, caching is disabled: %v", err)
+			return
+		}
+		defaultCache = c
+	})
+	return defaultCache
+}
+
+// Key builds a cache key from the parts identifying a range query, such as
+// the upstream, the query and the step.
+func Key(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:])
+}
+
+// Fetch returns the result of the range query r, reusing cached blocks and
+// fetching the rest with fetch.
+func (c *Cache) Fetch(ctx context.Context, key string, r *timerange.Range, fetch FetchFunc) ([]byte, error) {
+	stepMs := r.Step.Milliseconds()
+	if stepMs <= 0 {
+		return fetch(ctx, r.Start, r.End)
+	}
+	blockMs := c.blockSize(r).Milliseconds()
+	startMs, endMs := r.Start.UnixMilli(), r.End.UnixMilli()
+	finalMs := time.Now().Add(-c.maxFreshness).UnixMilli()
+
+	parts := []*response{}
+	run := &pendingRun{start: -1}
+	for b := startMs - startMs%blockMs; b <= endMs; b += blockMs {
+		blockEnd := b + blockMs - stepMs
+		if blockEnd > finalMs {
+			// the block may still change, fetch only what was asked for
+			run.add(maxInt64(b, startMs), minInt64(blockEnd, endMs), -1)
+			continue
+		}
+		if data, ok := c.store.Get(blockKey(key, blockMs, b)); ok {
+			if cached, err := decodeMatrix(data); err == nil {
+				resp, err := c.flush(ctx, run, fetch, blockMs, stepMs, key)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, resp...)
+				parts = append(parts, cached)
+				continue
+			}
+		}
+		run.add(b, blockEnd, b)
+	}
+	resp, err := c.flush(ctx, run, fetch, blockMs, stepMs, key)
+	if err != nil {
+		return nil, err
+	}
+	parts = append(parts, resp...)
+	return encode(trim(merge(parts), startMs, endMs))
+}
+
+// blockSize picks hour blocks for short ranges and day blocks otherwise,
+// unless a split interval is configured. Blocks are always a multiple of the step.
+func (c *Cache) blockSize(r *timerange.Range) time.Duration {
+	split := c.splitInterval
+	if split <= 0 {
+		split = time.Hour
+		if r.Duration() > 2*timerange.Day {
+			split = timerange.Day
+		}
+	}
+	if split < r.Step {
+		return r.Step
+	}
+	return split - split%r.Step
+}
+
+// pendingRun collects consecutive blocks that are missing from the cache so
+// that they can be fetched with a single upstream call.
+type pendingRun struct {
+	start, end int64
+	blocks     []int64
+}
+
+func (p *pendingRun) add(start, end, cacheableBlock int64) {
+	if p.start < 0 {
+		p.start = start
+	}
+	p.end = end
+	if cacheableBlock >= 0 {
+		p.blocks = append(p.blocks, cacheableBlock)
+	}
+}
+
+func (c *Cache) flush(ctx context.Context, run *pendingRun, fetch FetchFunc, blockMs, stepMs int64, key string) ([]*response, error) {
+	if run.start < 0 {
+		return nil, nil
+	}
+	data, err := fetch(ctx, time.UnixMilli(run.start).UTC(), time.UnixMilli(run.end).UTC())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := decodeMatrix(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Warnings) == 0 {
+		for _, b := range run.blocks {
+			block, err := encode(trim(resp, b, b+blockMs-stepMs))
+			if err != nil {
+				continue
+			}
+			c.store.Set(blockKey(key, blockMs, b), block)
+		}
+	}
+	*run = pendingRun{start: -1}
+	return []*response{resp}, nil
+}
+
+func blockKey(key string, blockMs, blockStart int64) string {
+	return key + "-" + strconv.FormatInt(blockMs, 10) + "-" + strconv.FormatInt(blockStart, 10)
+}
+
+func encode(resp *response) ([]byte, error) {
+	if resp.Data.Result == nil {
+		resp.Data.Result = []*series{}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(resp); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,280 @@
+package querycache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"proxy-api-server/config"
+	"proxy-api-server/timerange"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// base is an hour boundary, long enough ago for its blocks to be final.
+var base = time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+
+// fakeUpstream answers range queries the way Prometheus does, with a series
+// sampled at every step and another one only during even hours.
+type fakeUpstream struct {
+	step     time.Duration
+	calls    [][2]time.Time
+	warnings bool
+}
+
+func (f *fakeUpstream) fetch(ctx context.Context, start, end time.Time) ([]byte, error) {
+	f.calls = append(f.calls, [2]time.Time{start, end})
+	return f.response(start, end), nil
+}
+
+// response returns the response of Prometheus for [start, end], compact and
+// with the series sorted by labels.
+func (f *fakeUpstream) response(start, end time.Time) []byte {
+	var all, even []string
+	for t := start; !t.After(end); t = t.Add(f.step) {
+		ms := t.UnixMilli()
+		sample := fmt.Sprintf(`[%s,"%d"]`, strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64), ms/1000%97)
+		all = append(all, sample)
+		if t.Hour()%2 == 0 {
+			even = append(even, sample)
+		}
+	}
+	result := []string{}
+	if len(all) > 0 {
+		result = append(result, `{"metric":{"__name__":"up","job":"a"},"values":[`+strings.Join(all, ",")+`]}`)
+	}
+	if len(even) > 0 {
+		result = append(result, `{"metric":{"job":"b"},"values":[`+strings.Join(even, ",")+`]}`)
+	}
+	warnings := ""
+	if f.warnings {
+		warnings = `,"warnings":["partial response"]`
+	}
+	return []byte(`{"status":"success","data":{"resultType":"matrix","result":[` + strings.Join(result, ",") + `]}` + warnings + `}`)
+}
+
+func newTestCache(t *testing.T, conf config.QueryCache) *Cache {
+	t.Helper()
+	if conf.MaxFreshness == 0 {
+		conf.MaxFreshness = 10 * time.Minute
+	}
+	c, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func at(d time.Duration) time.Time {
+	return base.Add(d)
+}
+
+func TestFetch(t *testing.T) {
+	type request struct {
+		start, end time.Duration
+		// fetched are the ranges asked to the upstream
+		fetched [][2]time.Duration
+	}
+	h := time.Hour
+	tests := []struct {
+		name     string
+		step     time.Duration
+		requests []request
+	}{
+		{
+			name: "cached range",
+			step: time.Minute,
+			requests: []request{
+				{start: 0, end: 3 * h, fetched: [][2]time.Duration{{0, 4*h - time.Minute}}},
+				{start: 0, end: 3 * h},
+			},
+		},
+		{
+			name: "overlap at the end",
+			step: time.Minute,
+			requests: []request{
+				{start: 0, end: 2*h + 30*time.Minute, fetched: [][2]time.Duration{{0, 3*h - time.Minute}}},
+				{start: h + 30*time.Minute, end: 5 * h, fetched: [][2]time.Duration{{3 * h, 6*h - time.Minute}}},
+				{start: 10 * time.Minute, end: 5*h + 50*time.Minute},
+			},
+		},
+		{
+			name: "overlap at the start",
+			step: 30 * time.Second,
+			requests: []request{
+				{start: 2 * h, end: 4 * h, fetched: [][2]time.Duration{{2 * h, 5*h - 30*time.Second}}},
+				{start: 0, end: 3*h + 15*time.Minute, fetched: [][2]time.Duration{{0, 2*h - 30*time.Second}}},
+			},
+		},
+		{
+			name: "cached block within the range",
+			step: time.Minute,
+			requests: []request{
+				{start: 2 * h, end: 2*h + 59*time.Minute, fetched: [][2]time.Duration{{2 * h, 3*h - time.Minute}}},
+				{start: 0, end: 4*h + 30*time.Minute, fetched: [][2]time.Duration{{0, 2*h - time.Minute}, {3 * h, 5*h - time.Minute}}},
+				{start: 30 * time.Minute, end: 4 * h},
+			},
+		},
+		{
+			name: "step not dividing the hour",
+			step: 7 * time.Minute,
+			// blocks of 56 minutes, aligned to the epoch rather than to the hour
+			requests: []request{
+				{start: 0, end: 2 * h, fetched: [][2]time.Duration{{-16 * time.Minute, 2*h + 25*time.Minute}}},
+				{start: 14 * time.Minute, end: 2 * h},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t, config.QueryCache{MaxEntries: 100})
+			upstream := &fakeUpstream{step: tt.step}
+			for i, req := range tt.requests {
+				upstream.calls = nil
+				r := &timerange.Range{Start: at(req.start), End: at(req.end), Step: tt.step}
+				r.Align()
+				got, err := c.Fetch(context.Background(), "key", r, upstream.fetch)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := upstream.response(r.Start, r.End); !bytes.Equal(got, want) {
+					t.Errorf("request %d: Fetch() =\n%s\nwant the uncached response\n%s", i, got, want)
+				}
+				if len(upstream.calls) != len(req.fetched) {
+					t.Fatalf("request %d: fetched %v, want %v", i, upstream.calls, req.fetched)
+				}
+				for j, call := range upstream.calls {
+					if want := req.fetched[j]; !call[0].Equal(at(want[0])) || !call[1].Equal(at(want[1])) {
+						t.Errorf("request %d: fetch %d = %s - %s, want %s - %s", i, j, call[0], call[1], at(want[0]), at(want[1]))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFetchFreshTail(t *testing.T) {
+	c := newTestCache(t, config.QueryCache{MaxEntries: 100})
+	upstream := &fakeUpstream{step: time.Minute}
+	end := time.Now().UTC()
+	r := &timerange.Range{Start: end.Add(-3 * time.Hour), End: end, Step: time.Minute}
+	r.Align()
+	for i := 0; i < 2; i++ {
+		upstream.calls = nil
+		got, err := c.Fetch(context.Background(), "key", r, upstream.fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := upstream.response(r.Start, r.End); !bytes.Equal(got, want) {
+			t.Errorf("Fetch() =\n%s\nwant\n%s", got, want)
+		}
+		// the recent blocks are fetched every time, only up to the end of the range
+		last := upstream.calls[len(upstream.calls)-1]
+		if !last[1].Equal(r.End) {
+			t.Errorf("fetch %d of the tail ends at %s, want %s", i, last[1], r.End)
+		}
+		if i == 1 && len(upstream.calls) != 1 {
+			t.Errorf("second Fetch() called the upstream %d times, want only for the tail", len(upstream.calls))
+		}
+	}
+}
+
+func TestFetchWithWarningsIsNotCached(t *testing.T) {
+	c := newTestCache(t, config.QueryCache{MaxEntries: 100})
+	upstream := &fakeUpstream{step: time.Minute, warnings: true}
+	r := &timerange.Range{Start: at(0), End: at(time.Hour), Step: time.Minute}
+	for i := 0; i < 2; i++ {
+		got, err := c.Fetch(context.Background(), "key", r, upstream.fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(got, []byte(`"warnings":["partial response"]`)) {
+			t.Errorf("Fetch() = %s, want the warnings of the upstream", got)
+		}
+	}
+	if len(upstream.calls) != 2 {
+		t.Errorf("upstream called %d times, want 2", len(upstream.calls))
+	}
+}
+
+func TestFetchFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	upstream := &fakeUpstream{step: time.Minute}
+	r := &timerange.Range{Start: at(0), End: at(3 * time.Hour), Step: time.Minute}
+	first, err := newTestCache(t, config.QueryCache{MaxEntries: 100, Directory: dir}).Fetch(context.Background(), "key", r, upstream.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new cache, as after a restart, reads the blocks from the directory
+	upstream.calls = nil
+	got, err := newTestCache(t, config.QueryCache{MaxEntries: 100, Directory: dir}).Fetch(context.Background(), "key", r, upstream.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, first) || len(upstream.calls) != 0 {
+		t.Errorf("Fetch() from disk = %s with %d upstream calls, want %s without", got, len(upstream.calls), first)
+	}
+}
+
+func TestFetchWithoutStep(t *testing.T) {
+	c := newTestCache(t, config.QueryCache{MaxEntries: 100})
+	upstream := &fakeUpstream{step: time.Minute}
+	r := &timerange.Range{Start: at(0), End: at(time.Hour)}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Fetch(context.Background(), "key", r, upstream.fetch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(upstream.calls) != 2 {
+		t.Errorf("upstream called %d times, want 2", len(upstream.calls))
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+		sets       []string
+		want       []string
+	}{
+		{name: "entries", maxEntries: 2, sets: []string{"a", "b", "c"}, want: []string{"b", "c"}},
+		{name: "recently read", maxEntries: 2, sets: []string{"a", "b", "get a", "c"}, want: []string{"a", "c"}},
+		{name: "bytes", maxBytes: 25, sets: []string{"a", "b", "c"}, want: []string{"b", "c"}},
+		{name: "replaced", maxBytes: 25, sets: []string{"a", "b", "a", "c"}, want: []string{"a", "c"}},
+		{name: "too large", maxBytes: 5, sets: []string{"a"}, want: []string{}},
+		{name: "unbounded", sets: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoryStore(tt.maxEntries, tt.maxBytes)
+			for _, set := range tt.sets {
+				if key := strings.TrimPrefix(set, "get "); key != set {
+					s.Get(key)
+					continue
+				}
+				// every value is 10 bytes
+				s.Set(set, []byte("value of "+set))
+			}
+			var size int64
+			for _, key := range []string{"a", "b", "c"} {
+				_, ok := s.Get(key)
+				want := false
+				for _, w := range tt.want {
+					want = want || w == key
+				}
+				if ok != want {
+					t.Errorf("Get(%s) = %t, want %t", key, ok, want)
+				}
+				if ok {
+					size += 10
+				}
+			}
+			if s.size != size {
+				t.Errorf("size = %d, want %d", s.size, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package querycache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// response mirrors a Prometheus query_range response. Field order matches
+// Prometheus so that merged responses have the same shape as upstream ones.
+type response struct {
+	Status   string       `json:"status"`
+	Data     responseData `json:"data"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+type responseData struct {
+	ResultType string    `json:"resultType"`
+	Result     []*series `json:"result"`
+}
+
+type series struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []json.RawMessage `json:"values,omitempty"`
+	Histograms []json.RawMessage `json:"histograms,omitempty"`
+}
+
+func decodeMatrix(data []byte) (*response, error) {
+	resp := &response{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("unable to decode range query response: %v", err)
+	}
+	if resp.Status != "success" || resp.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("range query response is not a successful matrix")
+	}
+	return resp, nil
+}
+
+// sampleTime returns the timestamp of a [timestamp, value] pair in milliseconds.
+func sampleTime(pair json.RawMessage) (int64, error) {
+	var v []json.RawMessage
+	if err := json.Unmarshal(pair, &v); err != nil || len(v) == 0 {
+		return 0, fmt.Errorf("invalid sample %s", pair)
+	}
+	ts, err := strconv.ParseFloat(string(v[0]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample timestamp %s", v[0])
+	}
+	return int64(math.Round(ts * 1000)), nil
+}
+
+// trim returns a copy of resp holding only the samples within [startMs, endMs].
+func trim(resp *response, startMs, endMs int64) *response {
+	out := &response{Status: resp.Status, Warnings: resp.Warnings, Data: responseData{ResultType: resp.Data.ResultType}}
+	for _, s := range resp.Data.Result {
+		t := &series{
+			Metric:     s.Metric,
+			Values:     trimSamples(s.Values, startMs, endMs),
+			Histograms: trimSamples(s.Histograms, startMs, endMs),
+		}
+		if len(t.Values) > 0 || len(t.Histograms) > 0 {
+			out.Data.Result = append(out.Data.Result, t)
+		}
+	}
+	return out
+}
+
+func trimSamples(samples []json.RawMessage, startMs, endMs int64) []json.RawMessage {
+	var out []json.RawMessage
+	for _, pair := range samples {
+		ts, err := sampleTime(pair)
+		if err != nil || ts < startMs || ts > endMs {
+			continue
+		}
+		out = append(out, pair)
+	}
+	return out
+}
+
+// merge concatenates responses covering consecutive, non overlapping ranges.
+// Series are matched by label set and sorted the way Prometheus sorts a matrix.
+func merge(parts []*response) *response {
+	out := &response{Status: "success", Data: responseData{ResultType: "matrix", Result: []*series{}}}
+	byLabels := map[string]*series{}
+	seenWarnings := map[string]bool{}
+	for _, p := range parts {
+		for _, w := range p.Warnings {
+			if !seenWarnings[w] {
+				seenWarnings[w] = true
+				out.Warnings = append(out.Warnings, w)
+			}
+		}
+		for _, s := range p.Data.Result {
+			key := labelsKey(s.Metric)
+			m, ok := byLabels[key]
+			if !ok {
+				m = &series{Metric: s.Metric}
+				byLabels[key] = m
+				out.Data.Result = append(out.Data.Result, m)
+			}
+			m.Values = append(m.Values, s.Values...)
+			m.Histograms = append(m.Histograms, s.Histograms...)
+		}
+	}
+	sort.Slice(out.Data.Result, func(i, j int) bool {
+		return compareLabels(out.Data.Result[i].Metric, out.Data.Result[j].Metric) < 0
+	})
+	return out
+}
+
+func sortedLabelNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func labelsKey(m map[string]string) string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// compareLabels orders label sets like Prometheus' labels.Compare.
+func compareLabels(a, b map[string]string) int {
+	an, bn := sortedLabelNames(a), sortedLabelNames(b)
+	for i := 0; i < len(an) && i < len(bn); i++ {
+		if an[i] != bn[i] {
+			if an[i] < bn[i] {
+				return -1
+			}
+			return 1
+		}
+		if a[an[i]] != b[bn[i]] {
+			if a[an[i]] < b[bn[i]] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(an) - len(bn)
+}
@@ -0,0 +1,128 @@
+package querycache
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"proxy-api-server/log"
+	"sync"
+)
+
+// Store keeps finished range query blocks.
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+}
+
+type memoryEntry struct {
+	key   string
+	value []byte
+}
+
+// memoryStore is an LRU store bounded by the number of entries and by their
+// total size. A bound of zero is no bound.
+type memoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+func newMemoryStore(maxEntries int, maxBytes int64) *memoryStore {
+	return &memoryStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func (s *memoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*memoryEntry).value, true
+}
+
+func (s *memoryStore) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+	// a block larger than the whole budget is not kept
+	if s.maxBytes > 0 && int64(len(value)) > s.maxBytes {
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&memoryEntry{key: key, value: value})
+	s.size += int64(len(value))
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries || s.maxBytes > 0 && s.size > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *memoryStore) remove(e *list.Element) {
+	entry := s.lru.Remove(e).(*memoryEntry)
+	delete(s.entries, entry.key)
+	s.size -= int64(len(entry.value))
+}
+
+// diskStore keeps one file per block in a directory.
+type diskStore struct {
+	dir string
+}
+
+func newDiskStore(dir string) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskStore{dir: dir}, nil
+}
+
+func (s *diskStore) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(filepath.Join(s.dir, key+".json"))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *diskStore) Set(key string, value []byte) {
+	path := filepath.Join(s.dir, key+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, value, 0o644); err != nil {
+		log.Warningf("Unable to write query cache block [%s]: %v", path, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Warningf("Unable to write query cache block [%s]: %v", path, err)
+	}
+}
+
+// tieredStore reads from memory first and falls back to disk, promoting disk hits into memory.
+type tieredStore struct {
+	memory *memoryStore
+	disk   *diskStore
+}
+
+func (s *tieredStore) Get(key string) ([]byte, bool) {
+	if v, ok := s.memory.Get(key); ok {
+		return v, true
+	}
+	v, ok := s.disk.Get(key)
+	if ok {
+		s.memory.Set(key, v)
+	}
+	return v, ok
+}
+
+func (s *tieredStore) Set(key string, value []byte) {
+	s.memory.Set(key, value)
+	s.disk.Set(key, value)
+}
@@ -1,10 +1,20 @@
 package routing
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"proxy-api-server/coalesce"
+	"proxy-api-server/config"
 	"proxy-api-server/handlers"
+	"proxy-api-server/internalmetrics"
+	"proxy-api-server/models"
+	"proxy-api-server/openapi"
+	"proxy-api-server/util"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Route describes a single route
@@ -14,139 +24,1123 @@ type Route struct {
 	Pattern       string
 	HandlerFunc   http.HandlerFunc
 	Authenticated bool
+	// Spec documents the route in the OpenAPI document, every route must have one
+	Spec *openapi.Spec
 }
 
-// Routes holds an array of Route. A note on swagger documentation. The path variables and query parameters
-// are defined in ../doc.go.  YOu need to manually associate params and routes.
+// Routes holds an array of Route. A note on swagger documentation. The parameters, request body and result
+// of each route are described by its Spec, from which the OpenAPI document is built.
 type Routes struct {
 	Routes []Route
 }
 
-// NewRoutes creates and returns all the API routes
+// Parameters shared by several routes
+var (
+	instanceParam = openapi.Path("name", "Name of a registered Grafana instance")
+	upstreamParam = openapi.Path("name", "Name of a configured upstream")
+	grafanaParams = []openapi.Parameter{
+		openapi.Query("grafanaUrl", "Base URL of the Grafana instance").Require(),
+		openapi.Query("apiKey", "Grafana api key or user:password").Require(),
+	}
+	grafanaPostParams = []openapi.Parameter{
+		openapi.Query("grafanaUrl", "URL of the Grafana API to post the body to").Require(),
+		openapi.Query("apiKey", "Grafana api key").Require(),
+	}
+	upstreamParams = []openapi.Parameter{
+		openapi.Query("upstream", "Name of a configured Prometheus upstream, used instead of Grafana"),
+		openapi.Query("grafanaUrl", "Base URL of the Grafana instance").RequireWithout("upstream"),
+		openapi.Query("apiKey", "Grafana api key or user:password").RequireWithout("upstream"),
+	}
+	variableParams = []openapi.Parameter{
+		openapi.Query("query", "Variable query").Require(),
+		openapi.Query("dsid", "Id of the Prometheus datasource"),
+		openapi.Query("start", "Start of the time range, RFC3339, epoch seconds or relative such as now-1h"),
+		openapi.Query("end", "End of the time range"),
+		openapi.Query("regex", "Regex filtering and capturing the values, plain or /pattern/flags"),
+		openapi.Query("sort", "Sort order of the values, as in Grafana, from 0 (disabled) to 8").Typed("integer"),
+	}
+	rangeParams = []openapi.Parameter{
+		openapi.Query("query", "PromQL expression").Require(),
+		openapi.Query("start", "Start of the time range, RFC3339, epoch seconds or relative such as now-1h"),
+		openapi.Query("end", "End of the time range, now by default"),
+		openapi.Query("step", "Query resolution, such as 30s, derived from maxDataPoints by default"),
+		openapi.Query("maxDataPoints", "Maximum number of points per series, used when step is not set").Typed("integer"),
+	}
+	promDatasourceParam   = openapi.Query("ds", "Name of the Prometheus datasource")
+	traceDatasourceParams = []openapi.Parameter{
+		openapi.Query("dsuid", "Uid of the Tempo or Jaeger datasource"),
+		openapi.Query("dsid", "Id of the datasource, when dsuid is not set").Typed("integer"),
+		openapi.Query("ds", "Name of the datasource, when neither dsuid nor dsid is set"),
+	}
+	traceParams       = withParams(grafanaParams, traceDatasourceParams...)
+	traceSearchParams = []openapi.Parameter{
+		openapi.Query("start", "Start of the time range"),
+		openapi.Query("end", "End of the time range"),
+		openapi.Query("service", "Service name"),
+		openapi.Query("operation", "Span name"),
+		openapi.Query("tags", "Tags as logfmt, such as http.status_code=500 error=true"),
+		openapi.Query("minDuration", "Minimum trace duration, such as 100ms"),
+		openapi.Query("maxDuration", "Maximum trace duration"),
+		openapi.Query("limit", "Maximum number of traces, 20 by default").Typed("integer"),
+	}
+	lokiDatasourceParams = []openapi.Parameter{
+		openapi.Query("dsuid", "Uid of the Loki datasource"),
+		openapi.Query("dsid", "Id of the datasource, when dsuid is not set"),
+		openapi.Query("ds", "Name of the datasource, when neither dsuid nor dsid is set"),
+	}
+	lokiParams = withParams([]openapi.Parameter{
+		openapi.Query("upstream", "Name of a configured Loki upstream, used instead of Grafana"),
+		openapi.Query("grafanaUrl", "Base URL of the Grafana instance").RequireWithout("upstream"),
+		openapi.Query("apiKey", "Grafana api key or user:password").RequireWithout("upstream"),
+	}, lokiDatasourceParams...)
+	logRangeParams = []openapi.Parameter{
+		openapi.Query("query", "LogQL expression").Require(),
+		openapi.Query("start", "Start of the time range"),
+		openapi.Query("end", "End of the time range"),
+		openapi.Query("limit", "Maximum number of log lines, 100 by default").Typed("integer"),
+		openapi.Query("direction", "Order of the log lines, backward by default").OneOf("backward", "forward"),
+		openapi.Query("step", "Step of metric queries"),
+	}
+	logLabelParams = []openapi.Parameter{
+		openapi.Query("start", "Start of the time range"),
+		openapi.Query("end", "End of the time range"),
+	}
+	logLabelValuesParams = withParams(logLabelParams,
+		openapi.Query("query", "LogQL stream selector restricting the values"),
+	)
+	logTailParams = []openapi.Parameter{
+		openapi.Query("query", "LogQL expression").Require(),
+		openapi.Query("start", "Time of the first line to send, one hour ago by default"),
+		openapi.Query("limit", "Maximum number of log lines per message").Typed("integer"),
+	}
+)
+
+// withParams returns shared parameters followed by the parameters of a route.
+func withParams(shared []openapi.Parameter, params ...openapi.Parameter) []openapi.Parameter {
+	return append(append([]openapi.Parameter{}, shared...), params...)
+}
+
+// NewRoutes creates and returns all the API routes. The routes without a version prefix are
+// deprecated aliases of the /api/v1 routes, kept until they are retired.
 func NewRoutes() (r *Routes) {
 	r = new(Routes)
 
 	r.Routes = []Route{
 
-		// swagger:route GET /grafana/dashboard
-		// ---
-		// Endpoint to get list of grafana dashboards with panels and datasources
-		//
-		//     Produces:
-		//     - application/json
-		//
-		//     Schemes: http, https
-		//
-		// responses:
-		//      500: internalError
-		//      200: statusInfo
+		{
+			"InstanceDashboards",
+			"GET",
+			"/api/v1/instances/{name}/dashboards",
+			handlers.InstanceHandler(handlers.GrafanaDashboardHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "List the dashboards of an instance with their panels and template variables",
+				Tags:       []string{"dashboards"},
+				Parameters: []openapi.Parameter{instanceParam},
+				Result:     []*models.GrafanaBoard{},
+			},
+		},
+		{
+			"InstanceDashboard",
+			"GET",
+			"/api/v1/instances/{name}/dashboards/{uid}",
+			handlers.InstanceHandler(handlers.GetGrafanaDashbordByUidHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Get a dashboard by uid",
+				Tags:       []string{"dashboards"},
+				Parameters: []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the dashboard")},
+				Result:     &models.GrafanaDashboard{},
+			},
+		},
+		{
+			"InstanceDashboardCreate",
+			"POST",
+			"/api/v1/instances/{name}/dashboards",
+			handlers.InstanceWriteHandler(handlers.GrafanaDashboardSaveHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Create a dashboard",
+				Description: "A dashboard with the same uid, or the same title in the folder, is a conflict unless overwrite is set.",
+				Tags:        []string{"dashboards"},
+				Parameters:  []openapi.Parameter{instanceParam},
+				Body:        &models.DashboardSaveRequest{},
+				Result:      &models.DashboardSaveResult{},
+			},
+		},
+		{
+			"InstanceDashboardUpdate",
+			"PUT",
+			"/api/v1/instances/{name}/dashboards/{uid}",
+			handlers.InstanceWriteHandler(handlers.GrafanaDashboardUpdateHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Update a dashboard",
+				Description: "Unless overwrite is set, the version of the dashboard must be its current version, otherwise the update is a conflict. The dashboard stays in its folder when folderUid is not set.",
+				Tags:        []string{"dashboards"},
+				Parameters:  []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the dashboard")},
+				Body:        &models.DashboardSaveRequest{},
+				Result:      &models.DashboardSaveResult{},
+			},
+		},
+		{
+			"InstanceDashboardDelete",
+			"DELETE",
+			"/api/v1/instances/{name}/dashboards/{uid}",
+			handlers.InstanceWriteHandler(handlers.GrafanaDashboardDeleteHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary: "Delete a dashboard",
+				Tags:    []string{"dashboards"},
+				Parameters: []openapi.Parameter{
+					instanceParam,
+					openapi.Path("uid", "Uid of the dashboard"),
+					openapi.Query("version", "Version the dashboard was read with, a conflict when it was changed since").Typed("integer"),
+				},
+				Result: &models.DashboardDeleteResult{},
+			},
+		},
+		{
+			"InstanceDashboardMove",
+			"POST",
+			"/api/v1/instances/{name}/dashboards/{uid}/move",
+			handlers.InstanceWriteHandler(handlers.GrafanaDashboardMoveHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Move a dashboard to another folder",
+				Description: "The dashboard is saved as a new version in the folder, the General folder when folderUid is empty.",
+				Tags:        []string{"dashboards"},
+				Parameters:  []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the dashboard")},
+				Body:        &models.DashboardMoveRequest{},
+				Result:      &models.DashboardSaveResult{},
+			},
+		},
+		{
+			"InstanceDashboardVersions",
+			"GET",
+			"/api/v1/instances/{name}/dashboards/{uid}/versions",
+			handlers.InstanceHandler(handlers.GrafanaDashboardVersionsHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary: "List the versions of a dashboard, latest first",
+				Tags:    []string{"dashboards"},
+				Parameters: []openapi.Parameter{
+					instanceParam,
+					openapi.Path("uid", "Uid of the dashboard"),
+					openapi.Query("limit", "Maximum number of versions").Typed("integer"),
+					openapi.Query("start", "Number of versions to skip").Typed("integer"),
+				},
+				Result: []*models.DashboardVersion{},
+			},
+		},
+		{
+			"InstanceDashboardVersion",
+			"GET",
+			"/api/v1/instances/{name}/dashboards/{uid}/versions/{version}",
+			handlers.InstanceHandler(handlers.GrafanaDashboardVersionHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary: "Get a version of a dashboard with its JSON model",
+				Tags:    []string{"dashboards"},
+				Parameters: []openapi.Parameter{
+					instanceParam,
+					openapi.Path("uid", "Uid of the dashboard"),
+					openapi.Path("version", "Version of the dashboard").Typed("integer"),
+				},
+				Result: &models.DashboardVersionDetail{},
+			},
+		},
+		{
+			"InstanceDashboardDiff",
+			"GET",
+			"/api/v1/instances/{name}/dashboards/{uid}/diff",
+			handlers.InstanceHandler(handlers.GrafanaDashboardDiffHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Compare two versions of a dashboard",
+				Description: "Lists the changed fields of the dashboard, and the panels added, removed or changed with the changes of their queries.",
+				Tags:        []string{"dashboards"},
+				Parameters: []openapi.Parameter{
+					instanceParam,
+					openapi.Path("uid", "Uid of the dashboard"),
+					openapi.Query("from", "Version to compare").Typed("integer").Require(),
+					openapi.Query("to", "Version to compare with, the current version by default").Typed("integer"),
+				},
+				Result: &models.DashboardDiff{},
+			},
+		},
+		{
+			"InstanceDashboardRestore",
+			"POST",
+			"/api/v1/instances/{name}/dashboards/{uid}/restore",
+			handlers.InstanceWriteHandler(handlers.GrafanaDashboardRestoreHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Restore a version of a dashboard",
+				Description: "The version is saved as the new version of the dashboard. When currentVersion is set and the dashboard was changed since, the restore is a conflict.",
+				Tags:        []string{"dashboards"},
+				Parameters:  []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the dashboard")},
+				Body:        &models.DashboardRestoreRequest{},
+				Result:      &models.DashboardSaveResult{},
+			},
+		},
+		{
+			"InstanceFolders",
+			"GET",
+			"/api/v1/instances/{name}/folders",
+			handlers.InstanceHandler(handlers.GrafanaFoldersHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary: "List the folders at the top level, or the subfolders of a folder",
+				Tags:    []string{"folders"},
+				Parameters: []openapi.Parameter{
+					instanceParam,
+					openapi.Query("parentUid", "Uid of the parent folder, with nested folders"),
+				},
+				Result: []*models.Folder{},
+			},
+		},
+		{
+			"InstanceFolderCreate",
+			"POST",
+			"/api/v1/instances/{name}/folders",
+			handlers.InstanceWriteHandler(handlers.GrafanaFolderCreateHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Create a folder",
+				Description: "The folder is nested in parentUid when the instance has nested folders. A folder with the same uid or title is a conflict.",
+				Tags:        []string{"folders"},
+				Parameters:  []openapi.Parameter{instanceParam},
+				Body:        &models.FolderCreateRequest{},
+				Result:      &models.Folder{},
+			},
+		},
+		{
+			"InstanceFolderTree",
+			"GET",
+			"/api/v1/instances/{name}/folder-tree",
+			handlers.InstanceHandler(handlers.GrafanaFolderTreeHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Get the folder tree with the dashboards of each folder",
+				Description: "The root is the General folder, holding the top level folders and the dashboards outside of folders.",
+				Tags:        []string{"folders"},
+				Parameters:  []openapi.Parameter{instanceParam},
+				Result:      &models.FolderNode{},
+			},
+		},
+		{
+			"InstanceFolder",
+			"GET",
+			"/api/v1/instances/{name}/folders/{uid}",
+			handlers.InstanceHandler(handlers.GrafanaFolderHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Get a folder by uid",
+				Tags:       []string{"folders"},
+				Parameters: []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the folder")},
+				Result:     &models.Folder{},
+			},
+		},
+		{
+			"InstanceFolderUpdate",
+			"PUT",
+			"/api/v1/instances/{name}/folders/{uid}",
+			handlers.InstanceWriteHandler(handlers.GrafanaFolderUpdateHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Rename a folder",
+				Description: "When version is set and the folder was changed since, the rename is a conflict.",
+				Tags:        []string{"folders"},
+				Parameters:  []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the folder")},
+				Body:        &models.FolderUpdateRequest{},
+				Result:      &models.Folder{},
+			},
+		},
+		{
+			"InstanceFolderDelete",
+			"DELETE",
+			"/api/v1/instances/{name}/folders/{uid}",
+			handlers.InstanceWriteHandler(handlers.GrafanaFolderDeleteHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Delete a folder",
+				Description: "Grafana deletes the dashboards and subfolders of a folder with it, a folder that is not empty is a conflict unless force is set.",
+				Tags:        []string{"folders"},
+				Parameters: []openapi.Parameter{
+					instanceParam,
+					openapi.Path("uid", "Uid of the folder"),
+					openapi.Query("force", "Delete the folder with its dashboards and subfolders").Typed("boolean"),
+				},
+				Result: &models.FolderDeleteResult{},
+			},
+		},
+		{
+			"InstanceFolderMove",
+			"POST",
+			"/api/v1/instances/{name}/folders/{uid}/move",
+			handlers.InstanceWriteHandler(handlers.GrafanaFolderMoveHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Move a folder to another parent",
+				Description: "Needs nested folders on the instance. The folder moves to the top level when parentUid is empty.",
+				Tags:        []string{"folders"},
+				Parameters:  []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the folder")},
+				Body:        &models.FolderMoveRequest{},
+				Result:      &models.Folder{},
+			},
+		},
+		{
+			"InstanceFolderDashboards",
+			"GET",
+			"/api/v1/instances/{name}/folders/{uid}/dashboards",
+			handlers.InstanceHandler(handlers.GrafanaFolderDashboardsHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "List the dashboards of a folder, without those of its subfolders",
+				Tags:       []string{"folders"},
+				Parameters: []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the folder")},
+				Result:     []*models.FoundBoard{},
+			},
+		},
+		{
+			"InstanceFolderPermissions",
+			"GET",
+			"/api/v1/instances/{name}/folders/{uid}/permissions",
+			handlers.InstanceHandler(handlers.GrafanaFolderPermissionsHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "List the permissions of a folder",
+				Tags:       []string{"folders"},
+				Parameters: []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the folder")},
+				Result:     []*models.FolderPermission{},
+			},
+		},
+		{
+			"InstanceExport",
+			"POST",
+			"/api/v1/instances/{name}/export",
+			handlers.InstanceHandler(handlers.GrafanaExportHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Export dashboards as a bundle",
+				Description: "The bundle is a zip, or a gzipped tar, of the dashboards in the directories of their folders and a manifest.json. The datasources of the dashboards are replaced with ${DS_*} inputs. With no dashboardUids nor folderUids every dashboard is exported.",
+				Tags:        []string{"bundles"},
+				Parameters:  []openapi.Parameter{instanceParam},
+				Body:        &models.BundleExportRequest{},
+				ResultType:  "application/zip",
+			},
+		},
+		{
+			"InstanceImport",
+			"POST",
+			"/api/v1/instances/{name}/import",
+			handlers.InstanceWriteHandler(handlers.GrafanaImportHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Import a bundle of dashboards",
+				Description: "Missing folders of the bundle are created. An input not mapped by an input parameter goes to the datasource of the same name, otherwise to the default or only datasource of its plugin. The result lists the status of every dashboard.",
+				Tags:        []string{"bundles"},
+				Parameters: withParams([]openapi.Parameter{instanceParam},
+					openapi.Query("input", "Datasource of an input of the bundle as NAME=datasource, with the name or uid of the datasource, can be repeated"),
+					openapi.Query("overwrite", "Replace the dashboards of the same uid or title").Typed("boolean"),
+				),
+				BodyType: "application/octet-stream",
+				Result:   &models.BundleImportResult{},
+			},
+		},
+		{
+			"InstanceBackup",
+			"POST",
+			"/api/v1/instances/{name}/backups",
+			handlers.InstanceWriteHandler(handlers.GrafanaBackupHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Back up the dashboards and folders now",
+				Description: "Only the dashboards and folders changed since their latest backup, told by their content hash, are saved. The backups of each beyond backup.retention are deleted.",
+				Tags:        []string{"backups"},
+				Parameters:  []openapi.Parameter{instanceParam},
+				Result:      &models.BackupResult{},
+			},
+		},
+		{
+			"InstanceBackups",
+			"GET",
+			"/api/v1/instances/{name}/backups",
+			handlers.InstanceHandler(handlers.GrafanaBackupsHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "List the backed up dashboards with their backups",
+				Description: "Dashboards deleted from the instance keep their backups.",
+				Tags:        []string{"backups"},
+				Parameters:  []openapi.Parameter{instanceParam},
+				Result:      []*models.DashboardBackups{},
+			},
+		},
+		{
+			"InstanceDashboardBackups",
+			"GET",
+			"/api/v1/instances/{name}/backups/{uid}",
+			handlers.InstanceHandler(handlers.GrafanaDashboardBackupsHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "List the backups of a dashboard, latest first",
+				Tags:       []string{"backups"},
+				Parameters: []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the dashboard")},
+				Result:     &models.DashboardBackups{},
+			},
+		},
+		{
+			"InstanceDashboardBackup",
+			"GET",
+			"/api/v1/instances/{name}/backups/{uid}/{backup}",
+			handlers.InstanceHandler(handlers.GrafanaDashboardBackupHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Get a backup of a dashboard with its JSON model",
+				Tags:       []string{"backups"},
+				Parameters: []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the dashboard"), openapi.Path("backup", "Id of the backup, such as 20240131T120000Z-0123456789abcdef, or latest")},
+				Result:     &models.DashboardBackup{},
+			},
+		},
+		{
+			"InstanceBackupRestore",
+			"POST",
+			"/api/v1/instances/{name}/backups/{uid}/restore",
+			handlers.InstanceWriteHandler(handlers.GrafanaBackupRestoreHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Restore a backup of a dashboard",
+				Description: "The latest backup is restored unless backup is set. A deleted folder of the dashboard is recreated from the latest backup of the folders. A dashboard of the same uid is a conflict unless overwrite is set.",
+				Tags:        []string{"backups"},
+				Parameters:  []openapi.Parameter{instanceParam, openapi.Path("uid", "Uid of the dashboard")},
+				Body:        &models.BackupRestoreRequest{},
+				Result:      &models.DashboardSaveResult{},
+			},
+		},
+		{
+			"InstanceVariables",
+			"GET",
+			"/api/v1/instances/{name}/variables",
+			handlers.InstanceHandler(handlers.GrafanaQueryHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Get the values of a template variable query",
+				Description: "The query is a Grafana variable query: label_names(), label_values(), metrics() or query_result(). Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"queries"},
+				Parameters:  withParams(variableParams, instanceParam),
+				Result:      &models.VariableValues{},
+			},
+		},
+		{
+			"InstanceQueryRange",
+			"GET",
+			"/api/v1/instances/{name}/query-range",
+			handlers.InstanceHandler(handlers.GrafanaQueryRangeHandler, "url", "api-key"),
+			true,
+			&openapi.Spec{
+				Summary:     "Run a PromQL query over a time range",
+				Description: "The response is the one of the Prometheus query_range API. Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"queries"},
+				Parameters:  withParams(rangeParams, instanceParam, promDatasourceParam),
+			},
+		},
+		{
+			"InstanceQueryBatch",
+			"POST",
+			"/api/v1/instances/{name}/query/batch",
+			handlers.InstanceHandler(handlers.GrafanaQueryBatchHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Run many range and instant queries in one round trip",
+				Tags:       []string{"queries"},
+				Parameters: []openapi.Parameter{instanceParam},
+				Body:       []*models.BatchQuery{},
+				Result:     &models.BatchQueryResponse{},
+			},
+		},
+		{
+			"InstanceDataSourceQuery",
+			"POST",
+			"/api/v1/instances/{name}/ds/query",
+			handlers.InstanceHandler(handlers.GrafanaDataSourceQueryHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Query any Grafana datasource through the unified query API",
+				Tags:       []string{"queries"},
+				Parameters: []openapi.Parameter{instanceParam},
+				Body:       &models.DataSourceQueryRequest{},
+				Result:     &models.DataSourceQueryResponse{},
+			},
+		},
+		{
+			"InstanceTrace",
+			"GET",
+			"/api/v1/instances/{name}/traces/{traceId}",
+			handlers.InstanceHandler(handlers.GrafanaTraceHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Get a trace by id as a span tree",
+				Tags:       []string{"traces"},
+				Parameters: withParams(traceDatasourceParams, instanceParam, openapi.Path("traceId", "Hexadecimal id of the trace")),
+				Result:     &models.Trace{},
+			},
+		},
+		{
+			"InstanceTraceSearch",
+			"GET",
+			"/api/v1/instances/{name}/traces",
+			handlers.InstanceHandler(handlers.GrafanaTraceSearchHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Search traces",
+				Tags:       []string{"traces"},
+				Parameters: withParams(withParams(traceDatasourceParams, traceSearchParams...), instanceParam),
+				Result:     &models.TraceSearchResult{},
+			},
+		},
+		{
+			"InstanceLogQueryRange",
+			"GET",
+			"/api/v1/instances/{name}/logs/query-range",
+			handlers.InstanceHandler(handlers.LokiQueryRangeHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Run a LogQL query over a time range",
+				Description: "The response is the one of the Loki query_range API. Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"logs"},
+				Parameters:  withParams(withParams(lokiDatasourceParams, logRangeParams...), instanceParam),
+			},
+		},
+		{
+			"InstanceLogLabels",
+			"GET",
+			"/api/v1/instances/{name}/logs/labels",
+			handlers.InstanceHandler(handlers.LokiLabelsHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Get the label names of the time range",
+				Tags:       []string{"logs"},
+				Parameters: withParams(withParams(lokiDatasourceParams, logLabelParams...), instanceParam),
+			},
+		},
+		{
+			"InstanceLogLabelValues",
+			"GET",
+			"/api/v1/instances/{name}/logs/labels/{label}/values",
+			handlers.InstanceHandler(handlers.LokiLabelValuesHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:    "Get the values of a label of the time range",
+				Tags:       []string{"logs"},
+				Parameters: withParams(withParams(lokiDatasourceParams, logLabelValuesParams...), instanceParam, openapi.Path("label", "Label name")),
+			},
+		},
+		{
+			"InstanceLogTail",
+			"GET",
+			"/api/v1/instances/{name}/logs/tail",
+			handlers.InstanceHandler(handlers.LokiTailHandler, "grafanaUrl", "apiKey"),
+			true,
+			&openapi.Spec{
+				Summary:     "Stream new log lines over a WebSocket",
+				Description: "The connection is upgraded to a WebSocket that receives messages shaped like the ones of the Loki tail API.",
+				Tags:        []string{"logs"},
+				Parameters:  withParams(withParams(lokiDatasourceParams, logTailParams...), instanceParam),
+				Status:      http.StatusSwitchingProtocols,
+			},
+		},
+		{
+			"UpstreamVariables",
+			"GET",
+			"/api/v1/upstreams/{name}/variables",
+			handlers.UpstreamHandler(handlers.GrafanaQueryHandler),
+			true,
+			&openapi.Spec{
+				Summary:     "Get the values of a template variable query",
+				Description: "The query is a Grafana variable query: label_names(), label_values(), metrics() or query_result(). Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"queries"},
+				Parameters:  withParams(variableParams, upstreamParam),
+				Result:      &models.VariableValues{},
+			},
+		},
+		{
+			"UpstreamQueryRange",
+			"GET",
+			"/api/v1/upstreams/{name}/query-range",
+			handlers.UpstreamHandler(handlers.GrafanaQueryRangeHandler),
+			true,
+			&openapi.Spec{
+				Summary:     "Run a PromQL query over a time range",
+				Description: "The response is the one of the Prometheus query_range API. Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"queries"},
+				Parameters:  withParams(rangeParams, upstreamParam),
+			},
+		},
+		{
+			"UpstreamQueryBatch",
+			"POST",
+			"/api/v1/upstreams/{name}/query/batch",
+			handlers.UpstreamHandler(handlers.GrafanaQueryBatchHandler),
+			true,
+			&openapi.Spec{
+				Summary:    "Run many range and instant queries in one round trip",
+				Tags:       []string{"queries"},
+				Parameters: []openapi.Parameter{upstreamParam},
+				Body:       []*models.BatchQuery{},
+				Result:     &models.BatchQueryResponse{},
+			},
+		},
+		{
+			"UpstreamLogQueryRange",
+			"GET",
+			"/api/v1/upstreams/{name}/logs/query-range",
+			handlers.UpstreamHandler(handlers.LokiQueryRangeHandler),
+			true,
+			&openapi.Spec{
+				Summary:     "Run a LogQL query over a time range",
+				Description: "The response is the one of the Loki query_range API. Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"logs"},
+				Parameters:  withParams(logRangeParams, upstreamParam),
+			},
+		},
+		{
+			"UpstreamLogLabels",
+			"GET",
+			"/api/v1/upstreams/{name}/logs/labels",
+			handlers.UpstreamHandler(handlers.LokiLabelsHandler),
+			true,
+			&openapi.Spec{
+				Summary:    "Get the label names of the time range",
+				Tags:       []string{"logs"},
+				Parameters: withParams(logLabelParams, upstreamParam),
+			},
+		},
+		{
+			"UpstreamLogLabelValues",
+			"GET",
+			"/api/v1/upstreams/{name}/logs/labels/{label}/values",
+			handlers.UpstreamHandler(handlers.LokiLabelValuesHandler),
+			true,
+			&openapi.Spec{
+				Summary:    "Get the values of a label of the time range",
+				Tags:       []string{"logs"},
+				Parameters: withParams(logLabelValuesParams, upstreamParam, openapi.Path("label", "Label name")),
+			},
+		},
+		{
+			"UpstreamLogTail",
+			"GET",
+			"/api/v1/upstreams/{name}/logs/tail",
+			handlers.UpstreamHandler(handlers.LokiTailHandler),
+			true,
+			&openapi.Spec{
+				Summary:     "Stream new log lines over a WebSocket",
+				Description: "The connection is upgraded to a WebSocket that receives messages shaped like the ones of the Loki tail API.",
+				Tags:        []string{"logs"},
+				Parameters:  withParams(logTailParams, upstreamParam),
+				Status:      http.StatusSwitchingProtocols,
+			},
+		},
+		{
+			"DashboardMigrate",
+			"POST",
+			"/api/v1/dashboards/migrate",
+			handlers.AccessHandler(handlers.GrafanaDashboardMigrateHandler, true),
+			true,
+			&openapi.Spec{
+				Summary:     "Migrate dashboards between instances",
+				Description: "The datasources of the dashboards are remapped with datasources, otherwise to the datasource of the same name, otherwise to the default or only datasource of the plugin in the target. A dashboard of the same uid in the target is a conflict unless overwrite is set. With dryRun nothing is saved and the result shows the datasource changes and the diff of the dashboards that would be updated.",
+				Tags:        []string{"dashboards"},
+				Body:        &models.DashboardMigrateRequest{},
+				Result:      &models.DashboardMigrateResult{},
+			},
+		},
+		{
+			"Syncs",
+			"GET",
+			"/api/v1/syncs",
+			handlers.AccessHandler(handlers.GrafanaSyncsHandler, false),
+			true,
+			&openapi.Spec{
+				Summary: "List the git syncs",
+				Tags:    []string{"sync"},
+				Result:  []*models.SyncInfo{},
+			},
+		},
+		{
+			"SyncPlan",
+			"GET",
+			"/api/v1/syncs/{name}/plan",
+			handlers.AccessHandler(handlers.GrafanaSyncPlanHandler, false),
+			true,
+			&openapi.Spec{
+				Summary:     "Show the drift between git and the instance",
+				Description: "Every dashboard of the branch and of the instance is listed with the action an apply would take: create, update with the diff of the dashboard of the instance, move to the folder of its directory, unchanged, or untracked for the dashboards only in the instance, delete with prune.",
+				Tags:        []string{"sync"},
+				Parameters: withParams([]openapi.Parameter{openapi.Path("name", "Name of a git sync of the configuration")},
+					openapi.Query("prune", "Plan the deletion of the dashboards that are not in git").Typed("boolean"),
+				),
+				Result: &models.SyncPlan{},
+			},
+		},
+		{
+			"SyncApply",
+			"POST",
+			"/api/v1/syncs/{name}/apply",
+			handlers.AccessHandler(handlers.GrafanaSyncApplyHandler, true),
+			true,
+			&openapi.Spec{
+				Summary:     "Apply git to the instance",
+				Description: "The dashboards of the branch that the instance does not have or that differ are saved, and their folders created. With prune the dashboards that are not in git are deleted. The result is the plan with the status of every change.",
+				Tags:        []string{"sync"},
+				Parameters:  []openapi.Parameter{openapi.Path("name", "Name of a git sync of the configuration")},
+				Body:        &models.SyncApplyRequest{},
+				Result:      &models.SyncPlan{},
+			},
+		},
+		{
+			"SyncCommit",
+			"POST",
+			"/api/v1/syncs/{name}/commit",
+			handlers.AccessHandler(handlers.GrafanaSyncCommitHandler, true),
+			true,
+			&openapi.Spec{
+				Summary:     "Commit the changes of the instance to git",
+				Description: "The dashboards of the instance that differ from the branch or that it does not track are committed to the branch. With prune the dashboards that the instance does not have are removed. A branch checked out in a working tree is fast-forwarded.",
+				Tags:        []string{"sync"},
+				Parameters:  []openapi.Parameter{openapi.Path("name", "Name of a git sync of the configuration")},
+				Body:        &models.SyncCommitRequest{},
+				Result:      &models.SyncCommitResult{},
+			},
+		},
+		{
+			"QueryValidate",
+			"POST",
+			"/api/v1/query/validate",
+			handlers.GrafanaQueryValidateHandler,
+			true,
+			&openapi.Spec{
+				Summary:     "Validate a PromQL query and explain its expression tree",
+				Description: "A query that does not parse is reported with valid false and the position of the error.",
+				Tags:        []string{"queries"},
+				Body:        &models.QueryValidateRequest{},
+				Result:      &models.QueryValidateResult{},
+			},
+		},
 		{
 			"GrafanaDashboard",
 			"GET",
 			"/grafana/dashboard",
 			handlers.GrafanaDashboardHandler,
 			true,
+			&openapi.Spec{
+				Summary:    "List the dashboards of a Grafana instance with their panels and template variables",
+				Tags:       []string{"dashboards"},
+				Parameters: grafanaParams,
+				Result:     []*models.GrafanaBoard{},
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/dashboards",
+			},
 		},
-		// swagger:route GET /grafana/dashboard/uid
-		// ---
-		// Endpoint to get the grafana dashboard by uid
-		//
-		//     Produces:
-		//     - application/json
-		//
-		//     Schemes: http, https
-		//
-		// responses:
-		//      500: internalError
-		//      200: statusInfo
 		{
 			"GrafanaDashboardByUID",
 			"GET",
 			"/grafana/dashboard/uid",
 			handlers.GetGrafanaDashbordByUidHandler,
 			true,
+			&openapi.Spec{
+				Summary:    "Get a dashboard by uid",
+				Tags:       []string{"dashboards"},
+				Parameters: withParams(grafanaParams, openapi.Query("uid", "Uid of the dashboard").Require()),
+				Result:     &models.GrafanaDashboard{},
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/dashboards/{uid}",
+			},
 		},
-		// swagger:route POST /grafana/create-dashboard
-		// ---
-		// Endpoint to create dashboard in grafana
-		//
-		//     Produces:
-		//     - application/json
-		//
-		//     Schemes: http, https
-		//
-		// responses:
-		//      500: internalError
-		//      200: statusInfo
 		{
 			"GrafanaCreateDashboard",
 			"POST",
 			"/grafana/create-dashboard",
 			handlers.GrafanaApiHandler,
 			true,
+			&openapi.Spec{
+				Summary:     "Create or update a dashboard in Grafana",
+				Description: "The body is posted as it is to grafanaUrl, such as the /api/dashboards/db API of Grafana, with the api key in the api-key header.",
+				Tags:        []string{"dashboards"},
+				Parameters:  grafanaPostParams,
+				Body:        map[string]interface{}{},
+				Deprecated:  true,
+				Successor:   "/api/v1/instances/{name}/dashboards",
+			},
 		},
-		// swagger:route GET /grafana/query
-		// ---
-		// Endpoint to get the grafana details by query
-		//
-		//     Produces:
-		//     - application/json
-		//
-		//     Schemes: http, https
-		//
-		// responses:
-		//      500: internalError
-		//      200: statusInfo
 		{
 			"GrafanaQuery",
 			"GET",
 			"/grafana/query",
 			handlers.GrafanaQueryHandler,
 			true,
+			&openapi.Spec{
+				Summary:     "Get the values of a template variable query",
+				Description: "The query is a Grafana variable query: label_names(), label_values(), metrics() or query_result(). Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"queries"},
+				Parameters:  withParams(upstreamParams, variableParams...),
+				Result:      &models.VariableValues{},
+				Deprecated:  true,
+				Successor:   "/api/v1/instances/{name}/variables",
+			},
 		},
-		// swagger:route POST /grafana/query
-		// ---
-		// Endpoint to get the grafana query
-		//
-		//     Produces:
-		//     - application/json
-		//
-		//     Schemes: http, https
-		//
-		// responses:
-		//      500: internalError
-		//      200: statusInfo
 		{
 			"GrafanaQuery",
 			"POST",
 			"/grafana/query",
 			handlers.GrafanaApiHandler,
 			true,
+			&openapi.Spec{
+				Summary:     "Post a query to a Grafana API",
+				Description: "The body is posted as it is to grafanaUrl with the api key in the api-key header. There is no versioned alias, use the typed query routes.",
+				Tags:        []string{"queries"},
+				Parameters:  grafanaPostParams,
+				Body:        map[string]interface{}{},
+				Deprecated:  true,
+			},
 		},
-		// swagger:route GET /grafana/query-range
-		// ---
-		// Endpoint to get the grafana details by query range
-		//
-		//     Produces:
-		//     - application/json
-		//
-		//     Schemes: http, https
-		//
-		// responses:
-		//      500: internalError
-		//      200: statusInfo
 		{
 			"GrafanaQueryRange",
 			"GET",
 			"/grafana/query-range",
 			handlers.GrafanaQueryRangeHandler,
 			true,
+			&openapi.Spec{
+				Summary:     "Run a PromQL query over a time range",
+				Description: "The response is the one of the Prometheus query_range API. Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"queries"},
+				Parameters: withParams(rangeParams,
+					openapi.Query("url", "Base URL of the Grafana instance").RequireWithout("upstream"),
+					openapi.Query("api-key", "Grafana api key or user:password").RequireWithout("upstream"),
+					openapi.Query("upstream", "Name of a configured Prometheus upstream, used instead of Grafana"),
+					promDatasourceParam,
+				),
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/query-range",
+			},
+		},
+		{
+			"GrafanaQueryBatch",
+			"POST",
+			"/grafana/query/batch",
+			handlers.GrafanaQueryBatchHandler,
+			true,
+			&openapi.Spec{
+				Summary:    "Run many range and instant queries in one round trip",
+				Tags:       []string{"queries"},
+				Parameters: upstreamParams,
+				Body:       []*models.BatchQuery{},
+				Result:     &models.BatchQueryResponse{},
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/query/batch",
+			},
+		},
+		{
+			"GrafanaQueryValidate",
+			"POST",
+			"/grafana/query/validate",
+			handlers.GrafanaQueryValidateHandler,
+			true,
+			&openapi.Spec{
+				Summary:     "Validate a PromQL query and explain its expression tree",
+				Description: "A query that does not parse is reported with valid false and the position of the error.",
+				Tags:        []string{"queries"},
+				Body:        &models.QueryValidateRequest{},
+				Result:      &models.QueryValidateResult{},
+				Deprecated:  true,
+				Successor:   "/api/v1/query/validate",
+			},
+		},
+		{
+			"GrafanaDataSourceQuery",
+			"POST",
+			"/grafana/ds/query",
+			handlers.GrafanaDataSourceQueryHandler,
+			true,
+			&openapi.Spec{
+				Summary:    "Query any Grafana datasource through the unified query API",
+				Tags:       []string{"queries"},
+				Parameters: grafanaParams,
+				Body:       &models.DataSourceQueryRequest{},
+				Result:     &models.DataSourceQueryResponse{},
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/ds/query",
+			},
+		},
+		{
+			"GrafanaTrace",
+			"GET",
+			"/grafana/trace",
+			handlers.GrafanaTraceHandler,
+			true,
+			&openapi.Spec{
+				Summary:    "Get a trace by id as a span tree",
+				Tags:       []string{"traces"},
+				Parameters: withParams(traceParams, openapi.Query("traceId", "Hexadecimal id of the trace").Require()),
+				Result:     &models.Trace{},
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/traces/{traceId}",
+			},
+		},
+		{
+			"GrafanaTraceSearch",
+			"GET",
+			"/grafana/trace/search",
+			handlers.GrafanaTraceSearchHandler,
+			true,
+			&openapi.Spec{
+				Summary:    "Search traces",
+				Tags:       []string{"traces"},
+				Parameters: withParams(traceParams, traceSearchParams...),
+				Result:     &models.TraceSearchResult{},
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/traces",
+			},
+		},
+		{
+			"LokiQueryRange",
+			"GET",
+			"/loki/query-range",
+			handlers.LokiQueryRangeHandler,
+			true,
+			&openapi.Spec{
+				Summary:     "Run a LogQL query over a time range",
+				Description: "The response is the one of the Loki query_range API. Other parameters, with or without a var- prefix, are template variables.",
+				Tags:        []string{"logs"},
+				Parameters:  withParams(lokiParams, logRangeParams...),
+				Deprecated:  true,
+				Successor:   "/api/v1/instances/{name}/logs/query-range",
+			},
+		},
+		{
+			"LokiLabels",
+			"GET",
+			"/loki/labels",
+			handlers.LokiLabelsHandler,
+			true,
+			&openapi.Spec{
+				Summary:    "Get the label names of the time range",
+				Tags:       []string{"logs"},
+				Parameters: withParams(lokiParams, logLabelParams...),
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/logs/labels",
+			},
+		},
+		{
+			"LokiLabelValues",
+			"GET",
+			"/loki/label-values",
+			handlers.LokiLabelValuesHandler,
+			true,
+			&openapi.Spec{
+				Summary:    "Get the values of a label of the time range",
+				Tags:       []string{"logs"},
+				Parameters: withParams(withParams(lokiParams, logLabelValuesParams...), openapi.Query("label", "Label name").Require()),
+				Deprecated: true,
+				Successor:  "/api/v1/instances/{name}/logs/labels/{label}/values",
+			},
+		},
+		{
+			"LokiTail",
+			"GET",
+			"/loki/tail",
+			handlers.LokiTailHandler,
+			true,
+			&openapi.Spec{
+				Summary:     "Stream new log lines over a WebSocket",
+				Description: "The connection is upgraded to a WebSocket that receives messages shaped like the ones of the Loki tail API.",
+				Tags:        []string{"logs"},
+				Parameters:  withParams(lokiParams, logTailParams...),
+				Status:      http.StatusSwitchingProtocols,
+				Deprecated:  true,
+				Successor:   "/api/v1/instances/{name}/logs/tail",
+			},
+		},
+		{
+			"Metrics",
+			"GET",
+			"/metrics",
+			promhttp.Handler().ServeHTTP,
+			false,
+			&openapi.Spec{
+				Summary:    "Scrape the internal metrics of the proxy",
+				Tags:       []string{"server"},
+				ResultType: "text/plain",
+			},
+		},
+		{
+			"OpenAPI",
+			"GET",
+			"/api/openapi.json",
+			r.openAPIHandler,
+			false,
+			&openapi.Spec{
+				Summary: "Get the OpenAPI 3 document of the API",
+				Tags:    []string{"server"},
+			},
+		},
+		{
+			"SwaggerUI",
+			"GET",
+			"/api/docs",
+			openapi.UIHandler,
+			false,
+			&openapi.Spec{
+				Summary:    "Browse the API with Swagger UI",
+				Tags:       []string{"server"},
+				ResultType: "text/html",
+			},
+		},
+		{
+			"SwaggerUIAsset",
+			"GET",
+			"/api/docs/{asset}",
+			openapi.UIAssetHandler,
+			false,
+			&openapi.Spec{
+				Summary:    "Get a Swagger UI asset",
+				Tags:       []string{"server"},
+				Parameters: []openapi.Parameter{{Name: "asset", In: "path", Type: "string", Description: "Asset name", Required: true, Enum: openapi.UIAssets}},
+				ResultType: "text/plain",
+			},
 		},
 	}
 
 	return
 }
 
+// OpenAPI returns the OpenAPI document of the routes.
+func (r *Routes) OpenAPI() (*openapi.Document, error) {
+	routes := make([]openapi.Route, 0, len(r.Routes))
+	for _, route := range r.Routes {
+		routes = append(routes, openapi.Route{Name: route.Name, Method: route.Method, Path: route.Pattern, Spec: route.Spec})
+	}
+	info := openapi.Info{
+		Title:       "proxy-api-server",
+		Description: "Proxy to Grafana, Prometheus, Loki and tracing backends",
+		Version:     "1.0",
+	}
+	return openapi.Build(info, routes)
+}
+
+func (r *Routes) openAPIHandler(w http.ResponseWriter, req *http.Request) {
+	doc, err := r.OpenAPI()
+	if err != nil {
+		util.WriteError(w, req, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		util.Error("Http request failed: ", err)
+	}
+}
+
 func NewRouter() *mux.Router {
 
 	// conf := config.Get()
@@ -212,7 +1206,7 @@ func NewRouter() *mux.Router {
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(route.HandlerFunc)
+			Handler(routeHandler(route, deprecationHandler(route, openapi.ValidateRequests(route.Spec, route.HandlerFunc))))
 	}
 
 	// if authController := authentication.GetAuthController(); authController != nil {
@@ -236,9 +1230,47 @@ func NewRouter() *mux.Router {
 
 	// rootRouter.PathPrefix(webRootWithSlash).HandlerFunc(fileServerHandler)
 
+	appRouter.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.WriteError(w, r, util.NotFoundError("no route for %s", r.URL.Path))
+	})
+	appRouter.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.WriteError(w, r, util.BadRequestError("method %s not allowed for %s", r.Method, r.URL.Path))
+	})
+
 	return appRouter
 }
 
+// routeHandler stores the route template in the request context, so that the
+// metrics of the upstream calls of a request are labelled by its route.
+func routeHandler(route Route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(coalesce.WithRoute(r.Context(), route.Pattern)))
+	})
+}
+
+// legacyDeprecation is when the unversioned routes were deprecated in favour of /api/v1.
+var legacyDeprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// deprecationHandler announces the retirement of a deprecated route in the
+// Deprecation and Sunset headers of its responses, and counts its requests.
+func deprecationHandler(route Route, next http.Handler) http.Handler {
+	if route.Spec == nil || !route.Spec.Deprecated {
+		return next
+	}
+	sunset := ""
+	if t, err := time.Parse("2006-01-02", config.Get().Server.LegacySunset); err == nil {
+		sunset = t.Format(http.TimeFormat)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecation.Unix()))
+		if sunset != "" {
+			w.Header().Set("Sunset", sunset)
+		}
+		internalmetrics.GetDeprecatedRequestsMetric(route.Name, route.Method).Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
 // statusResponseWriter contains a ResponseWriter and a StatusCode to read in the metrics middleware
 type statusResponseWriter struct {
 	http.ResponseWriter
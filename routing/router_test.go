@@ -0,0 +1,15 @@
+package routing
+
+import "testing"
+
+func TestRoutesHaveSpecs(t *testing.T) {
+	routes := NewRoutes()
+	for _, route := range routes.Routes {
+		if route.Spec == nil {
+			t.Errorf("route %s %s (%s) has no OpenAPI spec", route.Method, route.Pattern, route.Name)
+		}
+	}
+	if _, err := routes.OpenAPI(); err != nil {
+		t.Errorf("OpenAPI() error = %v", err)
+	}
+}
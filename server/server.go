@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"net/http"
 	"proxy-api-server/config"
+	"proxy-api-server/limits"
 	"proxy-api-server/log"
+	"proxy-api-server/requestid"
 	"proxy-api-server/routing"
-	"proxy-api-server/util"
+	"proxy-api-server/tenancy"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -46,7 +48,7 @@ func NewServer() *Server {
 	// 	tracingProvider = observability.InitTracer(conf.Server.Observability.Tracing.CollectorURL)
 	// }
 
-	middlewares := []mux.MiddlewareFunc{}
+	middlewares := []mux.MiddlewareFunc{requestIDMiddleware, callerRoleMiddleware, callerTenantMiddleware}
 	if conf.Server.CORSAllowAll {
 		middlewares = append(middlewares, corsAllowed)
 	}
@@ -114,7 +116,7 @@ func (s *Server) Start() {
 		// } else {
 		s.router.Use(plainHttpMiddleware)
 		err = s.httpServer.ListenAndServe()
-		util.CommonError(err)
+		log.Error(err)
 		// }
 		// log.Warning(err)
 	}()
@@ -165,6 +167,38 @@ func corsAllowed(next http.Handler) http.Handler {
 // 	}
 // }
 
+// requestIDMiddleware tags every request with an id, reused from the request when set, which is returned in the response and in error bodies.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.WithID(r.Context(), id)))
+	})
+}
+
+// callerRoleMiddleware stores the caller role in the request context so that query limits can be resolved per role.
+func callerRoleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role := limits.RoleFromRequest(r); role != "" {
+			r = r.WithContext(limits.WithRole(r.Context(), role))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// callerTenantMiddleware stores the caller tenant in the request context so that its label matchers are enforced on PromQL.
+func callerTenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenant := tenancy.TenantFromRequest(r); tenant != "" {
+			r = r.WithContext(tenancy.WithTenant(r.Context(), tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func plainHttpMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.URL.Scheme = "http"
@@ -0,0 +1,137 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"proxy-api-server/config"
+	"proxy-api-server/promql"
+)
+
+type tenantKey struct{}
+
+// Error is returned when the PromQL of a caller cannot be restricted to its tenant.
+type Error struct {
+	Tenant string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Tenant == "" {
+		return fmt.Sprintf("tenant isolation: %s", e.Reason)
+	}
+	return fmt.Sprintf("tenant isolation for %q: %s", e.Tenant, e.Reason)
+}
+
+// WithTenant returns a context carrying the tenant of the caller.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant stored by WithTenant.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// TenantFromRequest reads the tenant from the configured tenant header. The
+// header is only read from the trusted authenticating proxies, a caller could
+// otherwise pick its tenant.
+func TenantFromRequest(r *http.Request) string {
+	conf := config.Get()
+	header := conf.Tenancy.TenantHeader
+	if header == "" || !conf.Server.IsTrustedProxy(r.RemoteAddr) {
+		return ""
+	}
+	return r.Header.Get(header)
+}
+
+// Matchers returns the label matchers enforced for the tenant stored in ctx,
+// none when the caller is not restricted or no tenants are configured. An
+// unknown tenant is always rejected, a missing one unless tenants are not
+// required.
+func Matchers(ctx context.Context) ([]*promql.LabelMatcher, error) {
+	conf := config.Get().Tenancy
+	if len(conf.Tenants) == 0 {
+		return nil, nil
+	}
+	tenant := TenantFromContext(ctx)
+	if tenant == "" {
+		if conf.RequireTenant {
+			return nil, &Error{Reason: "no tenant provided"}
+		}
+		return nil, nil
+	}
+	configured, ok := conf.Tenants[tenant]
+	if !ok {
+		return nil, &Error{Tenant: tenant, Reason: "unknown tenant"}
+	}
+	matchers := []*promql.LabelMatcher{}
+	for _, m := range configured {
+		parsed, err := promql.ParseMatchers(m)
+		if err != nil {
+			return nil, &Error{Tenant: tenant, Reason: fmt.Sprintf("invalid matcher %q: %v", m, err)}
+		}
+		matchers = append(matchers, parsed...)
+	}
+	return matchers, nil
+}
+
+// Restricted reports whether matchers are enforced on the PromQL of the caller.
+func Restricted(ctx context.Context) (bool, error) {
+	matchers, err := Matchers(ctx)
+	return len(matchers) > 0, err
+}
+
+// RejectRestricted returns an error when the caller is restricted to a
+// tenant, for the APIs whose data cannot be restricted, such as logs and traces.
+func RejectRestricted(ctx context.Context, data string) error {
+	restricted, err := Restricted(ctx)
+	if err != nil || !restricted {
+		return err
+	}
+	return &Error{Tenant: TenantFromContext(ctx), Reason: fmt.Sprintf("%s cannot be restricted to a tenant", data)}
+}
+
+// Enforce rewrites a PromQL expression so that every selector of it only
+// selects series of the tenant stored in ctx. The query is returned unchanged
+// when the caller is not restricted.
+func Enforce(ctx context.Context, query string) (string, error) {
+	matchers, err := Matchers(ctx)
+	if err != nil || len(matchers) == 0 {
+		return query, err
+	}
+	// unknown functions and type errors are the upstream's call, as for unrestricted callers
+	expr, _, err := promql.Parse(query)
+	if err != nil {
+		return "", err
+	}
+	if err := promql.EnforceMatchers(expr, matchers); err != nil {
+		return "", wrap(ctx, err)
+	}
+	return expr.String(), nil
+}
+
+// EnforceSelector restricts a series selector, as used by the match[]
+// parameter of the series and label APIs, to the tenant stored in ctx. An
+// empty selector of a restricted caller selects all series of its tenant.
+func EnforceSelector(ctx context.Context, selector string) (string, error) {
+	matchers, err := Matchers(ctx)
+	if err != nil || len(matchers) == 0 {
+		return selector, err
+	}
+	selector, err = promql.EnforceSelector(selector, matchers)
+	if err != nil {
+		return "", wrap(ctx, err)
+	}
+	return selector, nil
+}
+
+func wrap(ctx context.Context, err error) error {
+	var enforceErr *promql.EnforceError
+	if errors.As(err, &enforceErr) {
+		return &Error{Tenant: TenantFromContext(ctx), Reason: err.Error()}
+	}
+	return err
+}
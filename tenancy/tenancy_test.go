@@ -0,0 +1,125 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"proxy-api-server/config"
+	"testing"
+)
+
+func setTenants(requireTenant bool) {
+	conf := config.NewConfig()
+	conf.Server.TrustedProxies = []string{"10.0.0.1"}
+	conf.Tenancy.RequireTenant = requireTenant
+	conf.Tenancy.Tenants = map[string][]string{
+		"team-a": {`namespace=~"team-a-.*"`},
+		"team-b": {`namespace=~"team-b-.*"`, `cluster="prod"`},
+		"admin":  {},
+	}
+	config.Set(conf)
+}
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		name          string
+		tenant        string
+		requireTenant bool
+		want          []string
+		wantErr       bool
+	}{
+		{name: "tenant", tenant: "team-a", requireTenant: true, want: []string{`namespace=~"team-a-.*"`}},
+		{name: "several matchers", tenant: "team-b", requireTenant: true, want: []string{`namespace=~"team-b-.*"`, `cluster="prod"`}},
+		{name: "unrestricted tenant", tenant: "admin", requireTenant: true, want: []string{}},
+		{name: "unknown tenant", tenant: "team-c", requireTenant: false, wantErr: true},
+		{name: "no tenant", requireTenant: true, wantErr: true},
+		{name: "no tenant allowed", requireTenant: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTenants(tt.requireTenant)
+			matchers, err := Matchers(WithTenant(context.Background(), tt.tenant))
+			if tt.wantErr {
+				var tenancyErr *Error
+				if !errors.As(err, &tenancyErr) {
+					t.Fatalf("Matchers() error = %v, want a tenancy error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, m := range matchers {
+				got = append(got, m.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Matchers() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Matchers() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchersWithoutTenants(t *testing.T) {
+	config.Set(config.NewConfig())
+	matchers, err := Matchers(context.Background())
+	if err != nil || len(matchers) != 0 {
+		t.Errorf("Matchers() without tenants = %v, %v, want none", matchers, err)
+	}
+}
+
+func TestTenantFromRequest(t *testing.T) {
+	setTenants(true)
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"10.0.0.1:5000", "team-a"},
+		{"10.0.0.2:5000", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/query", nil)
+		r.RemoteAddr = tt.remoteAddr
+		r.Header.Set("X-Proxy-Tenant", "team-a")
+		if got := TenantFromRequest(r); got != tt.want {
+			t.Errorf("TenantFromRequest() from %s = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	setTenants(true)
+	tests := []struct {
+		name    string
+		tenant  string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{name: "tenant", tenant: "team-b", query: `sum(rate(x[5m]))`, want: `sum(rate(x{namespace=~"team-b-.*", cluster="prod"}[5m]))`},
+		{name: "unrestricted tenant", tenant: "admin", query: `sum(rate(x[5m]))`, want: `sum(rate(x[5m]))`},
+		{name: "unknown function", tenant: "team-a", query: `rollup_rate(x[5m])`, want: `rollup_rate(x{namespace=~"team-a-.*"}[5m])`},
+		{name: "other tenant", tenant: "team-a", query: `x{namespace="team-b-1"}`, wantErr: true},
+		{name: "no tenant", query: `x`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Enforce(WithTenant(context.Background(), tt.tenant), tt.query)
+			if tt.wantErr {
+				var tenancyErr *Error
+				if !errors.As(err, &tenancyErr) {
+					t.Fatalf("Enforce(%q) = %s, %v, want a tenancy error", tt.query, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Enforce(%q) = %s, %v, want %s", tt.query, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package timerange
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Day  = 24 * time.Hour
+	Week = 7 * Day
+	Year = 365 * Day
+)
+
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  Day,
+	"w":  Week,
+	"y":  Year,
+}
+
+var durationRegex = regexp.MustCompile(`^((\d+)y)?((\d+)w)?((\d+)d)?((\d+)h)?((\d+)m)?((\d+)s)?((\d+)ms)?$`)
+
+// ParseDuration parses a Prometheus duration such as 30s, 5m or 1h30m, or a
+// plain number of seconds such as 15 or 0.5.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f <= 0 {
+			return 0, fmt.Errorf("duration must be positive: %s", s)
+		}
+		if f*float64(time.Second) >= math.MaxInt64 {
+			return 0, fmt.Errorf("duration out of range: %s", s)
+		}
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	m := durationRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("not a valid duration: %s", s)
+	}
+	var d time.Duration
+	for i, unit := range []string{"y", "w", "d", "h", "m", "s", "ms"} {
+		if v := m[2*i+2]; v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			u := durationUnits[unit]
+			if err != nil || time.Duration(n) > (math.MaxInt64-d)/u {
+				return 0, fmt.Errorf("duration out of range: %s", s)
+			}
+			d += time.Duration(n) * u
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive: %s", s)
+	}
+	return d, nil
+}
+
+// FormatDuration formats d the way Prometheus and Grafana print intervals, e.g. 1h30m or 15s.
+func FormatDuration(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	var sb strings.Builder
+	for _, unit := range []string{"y", "w", "d", "h", "m", "s", "ms"} {
+		u := durationUnits[unit]
+		if n := d / u; n > 0 {
+			sb.WriteString(strconv.FormatInt(int64(n), 10) + unit)
+			d -= n * u
+		}
+	}
+	if sb.Len() == 0 {
+		return "0s"
+	}
+	return sb.String()
+}
+
+// roundIntervals are the "nice" intervals Grafana rounds a calculated interval to.
+var roundIntervals = []struct {
+	upTo     time.Duration
+	interval time.Duration
+}{
+	{10 * time.Millisecond, time.Millisecond},
+	{15 * time.Millisecond, 10 * time.Millisecond},
+	{35 * time.Millisecond, 20 * time.Millisecond},
+	{75 * time.Millisecond, 50 * time.Millisecond},
+	{150 * time.Millisecond, 100 * time.Millisecond},
+	{350 * time.Millisecond, 200 * time.Millisecond},
+	{750 * time.Millisecond, 500 * time.Millisecond},
+	{1500 * time.Millisecond, time.Second},
+	{3500 * time.Millisecond, 2 * time.Second},
+	{7500 * time.Millisecond, 5 * time.Second},
+	{12500 * time.Millisecond, 10 * time.Second},
+	{17500 * time.Millisecond, 15 * time.Second},
+	{25 * time.Second, 20 * time.Second},
+	{45 * time.Second, 30 * time.Second},
+	{90 * time.Second, time.Minute},
+	{210 * time.Second, 2 * time.Minute},
+	{450 * time.Second, 5 * time.Minute},
+	{750 * time.Second, 10 * time.Minute},
+	{1050 * time.Second, 15 * time.Minute},
+	{1500 * time.Second, 20 * time.Minute},
+	{2700 * time.Second, 30 * time.Minute},
+	{5400 * time.Second, time.Hour},
+	{9000 * time.Second, 2 * time.Hour},
+	{16200 * time.Second, 3 * time.Hour},
+	{32400 * time.Second, 6 * time.Hour},
+	{Day, 12 * time.Hour},
+	{Week, Day},
+	{3 * Week, Week},
+	{6*Week - 1, 30 * Day},
+}
+
+// RoundInterval rounds d to a human friendly interval using Grafana's table.
+func RoundInterval(d time.Duration) time.Duration {
+	for _, r := range roundIntervals {
+		if d <= r.upTo {
+			return r.interval
+		}
+	}
+	return Year
+}
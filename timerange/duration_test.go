@@ -0,0 +1,87 @@
+package timerange
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "30s", want: 30 * time.Second},
+		{s: "5m", want: 5 * time.Minute},
+		{s: "1h30m", want: 90 * time.Minute},
+		{s: "1y2w3d", want: Year + 2*Week + 3*Day},
+		{s: "500ms", want: 500 * time.Millisecond},
+		{s: "15", want: 15 * time.Second},
+		{s: "0.5", want: 500 * time.Millisecond},
+		{s: " 1m ", want: time.Minute},
+		{s: "", wantErr: true},
+		{s: "0", wantErr: true},
+		{s: "-5", wantErr: true},
+		{s: "0s", wantErr: true},
+		{s: "5", want: 5 * time.Second},
+		{s: "1.5h", wantErr: true},
+		{s: "30m1h", wantErr: true},
+		{s: "5x", wantErr: true},
+		{s: "99999999999999999999s", wantErr: true},
+		{s: "300y", wantErr: true},
+		{s: "200y200y", wantErr: true},
+		{s: "1e30", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDuration(%q) = %s, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %s, %v, want %s", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                "0s",
+		-time.Second:     "0s",
+		15 * time.Second: "15s",
+		90 * time.Minute: "1h30m",
+		Year + Week + Day + 1500*time.Millisecond: "1y1w1d1s500ms",
+		time.Microsecond: "0s",
+	}
+	for d, want := range tests {
+		if got := FormatDuration(d); got != want {
+			t.Errorf("FormatDuration(%d) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestRoundInterval(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want time.Duration
+	}{
+		{time.Millisecond, time.Millisecond},
+		{12 * time.Millisecond, 10 * time.Millisecond},
+		{3600 * time.Millisecond, 5 * time.Second},
+		{14 * time.Second, 15 * time.Second},
+		{40 * time.Second, 30 * time.Second},
+		{80 * time.Second, time.Minute},
+		{10 * time.Minute, 10 * time.Minute},
+		{2 * time.Hour, 2 * time.Hour},
+		{20 * time.Hour, 12 * time.Hour},
+		{2 * Day, Day},
+		{10 * Week, Year},
+	}
+	for _, tt := range tests {
+		if got := RoundInterval(tt.d); got != tt.want {
+			t.Errorf("RoundInterval(%s) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package timerange
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRange is used when a query does not specify its start.
+	DefaultRange = time.Hour
+	// DefaultMaxDataPoints is used to derive the step when neither step nor maxDataPoints is given.
+	DefaultMaxDataPoints = 1000
+)
+
+// Range is a validated query time range with its resolution step.
+type Range struct {
+	Start time.Time
+	End   time.Time
+	Step  time.Duration
+}
+
+// ParamError reports an invalid time parameter before any upstream call is made.
+type ParamError struct {
+	Param  string
+	Value  string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Param, e.Value, e.Reason)
+}
+
+// ParseRange reads the start, end, step and maxDataPoints parameters of a
+// range query. End defaults to now and start to DefaultRange before end. The
+// step is either given explicitly as a duration or number of seconds, or
+// derived from maxDataPoints and rounded to a nice interval. The returned
+// range is aligned to step boundaries so that repeated queries return stable
+// samples.
+func ParseRange(params url.Values, now time.Time) (*Range, error) {
+	r := &Range{}
+	var err error
+	if r.Start, r.End, err = ParseBounds(params, now); err != nil {
+		return nil, err
+	}
+
+	if v := params.Get("step"); v != "" {
+		if r.Step, err = ParseDuration(v); err != nil {
+			return nil, &ParamError{Param: "step", Value: v, Reason: err.Error()}
+		}
+	} else {
+		maxDataPoints := DefaultMaxDataPoints
+		if v := params.Get("maxDataPoints"); v != "" {
+			if maxDataPoints, err = strconv.Atoi(v); err != nil || maxDataPoints <= 0 {
+				return nil, &ParamError{Param: "maxDataPoints", Value: v, Reason: "must be a positive integer"}
+			}
+		}
+		r.Step = RoundInterval(r.End.Sub(r.Start) / time.Duration(maxDataPoints))
+	}
+
+	r.Align()
+	return r, nil
+}
+
+// ParseBounds reads the start and end parameters of a query without aligning
+// them. End defaults to now and start to DefaultRange before end.
+func ParseBounds(params url.Values, now time.Time) (time.Time, time.Time, error) {
+	var err error
+	end := now.UTC()
+	if v := params.Get("end"); v != "" {
+		if end, err = ParseTime(v, now, true); err != nil {
+			return time.Time{}, time.Time{}, &ParamError{Param: "end", Value: v, Reason: err.Error()}
+		}
+	}
+	start := end.Add(-DefaultRange)
+	if v := params.Get("start"); v != "" {
+		if start, err = ParseTime(v, now, false); err != nil {
+			return time.Time{}, time.Time{}, &ParamError{Param: "start", Value: v, Reason: err.Error()}
+		}
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, &ParamError{Param: "end", Value: params.Get("end"), Reason: "end must not be before start"}
+	}
+	return start, end, nil
+}
+
+// Align moves start and end down to the nearest multiple of the step.
+func (r *Range) Align() {
+	if r.Step <= 0 {
+		return
+	}
+	r.Start = alignTime(r.Start, r.Step)
+	r.End = alignTime(r.End, r.Step)
+}
+
+// Duration returns the length of the range.
+func (r *Range) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Points returns the number of samples a series has over the range.
+func (r *Range) Points() int64 {
+	if r.Step <= 0 {
+		return 0
+	}
+	return int64(r.Duration()/r.Step) + 1
+}
+
+// Params returns the range as Prometheus query_range parameters.
+func (r *Range) Params() url.Values {
+	q := url.Values{}
+	q.Set("start", FormatTime(r.Start))
+	q.Set("end", FormatTime(r.End))
+	q.Set("step", strconv.FormatFloat(r.Step.Seconds(), 'f', -1, 64))
+	return q
+}
+
+func alignTime(t time.Time, step time.Duration) time.Time {
+	ms := t.UnixMilli()
+	stepMs := step.Milliseconds()
+	if stepMs <= 0 {
+		return t
+	}
+	return time.UnixMilli(ms - ms%stepMs).UTC()
+}
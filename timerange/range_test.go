@@ -0,0 +1,103 @@
+package timerange
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	now := time.Date(2024, 1, 31, 13, 45, 30, 0, time.UTC)
+	tests := []struct {
+		name      string
+		params    url.Values
+		start     time.Time
+		end       time.Time
+		step      time.Duration
+		wantParam string
+	}{
+		{
+			name:  "defaults",
+			start: time.Date(2024, 1, 31, 12, 45, 30, 0, time.UTC),
+			end:   time.Date(2024, 1, 31, 13, 45, 30, 0, time.UTC),
+			step:  5 * time.Second,
+		},
+		{
+			name:   "explicit step",
+			params: url.Values{"start": {"now-6h"}, "end": {"now"}, "step": {"1m"}},
+			start:  time.Date(2024, 1, 31, 7, 45, 0, 0, time.UTC),
+			end:    time.Date(2024, 1, 31, 13, 45, 0, 0, time.UTC),
+			step:   time.Minute,
+		},
+		{
+			name:   "step in seconds",
+			params: url.Values{"start": {"1706700000"}, "end": {"1706703600"}, "step": {"60"}},
+			start:  time.Unix(1706700000, 0).UTC(),
+			end:    time.Unix(1706703600, 0).UTC(),
+			step:   time.Minute,
+		},
+		{
+			name:   "max data points",
+			params: url.Values{"start": {"now-1d"}, "maxDataPoints": {"100"}},
+			start:  time.Date(2024, 1, 30, 13, 45, 0, 0, time.UTC),
+			end:    time.Date(2024, 1, 31, 13, 45, 0, 0, time.UTC),
+			step:   15 * time.Minute,
+		},
+		{name: "invalid start", params: url.Values{"start": {"yesterday"}}, wantParam: "start"},
+		{name: "invalid end", params: url.Values{"end": {"now-99999999999999999999d"}}, wantParam: "end"},
+		{name: "end before start", params: url.Values{"start": {"now"}, "end": {"now-1h"}}, wantParam: "end"},
+		{name: "invalid step", params: url.Values{"step": {"0"}}, wantParam: "step"},
+		{name: "invalid max data points", params: url.Values{"maxDataPoints": {"-1"}}, wantParam: "maxDataPoints"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRange(tt.params, now)
+			if tt.wantParam != "" {
+				var paramErr *ParamError
+				if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+					t.Fatalf("ParseRange() error = %v, want an invalid %s", err, tt.wantParam)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !r.Start.Equal(tt.start) || !r.End.Equal(tt.end) || r.Step != tt.step {
+				t.Errorf("ParseRange() = %s - %s step %s, want %s - %s step %s", r.Start, r.End, r.Step, tt.start, tt.end, tt.step)
+			}
+		})
+	}
+}
+
+func TestAlign(t *testing.T) {
+	r := &Range{
+		Start: time.Date(2024, 1, 31, 12, 7, 42, 5e8, time.UTC),
+		End:   time.Date(2024, 1, 31, 13, 14, 59, 0, time.UTC),
+		Step:  5 * time.Minute,
+	}
+	r.Align()
+	if want := time.Date(2024, 1, 31, 12, 5, 0, 0, time.UTC); !r.Start.Equal(want) {
+		t.Errorf("Align() start = %s, want %s", r.Start, want)
+	}
+	if want := time.Date(2024, 1, 31, 13, 10, 0, 0, time.UTC); !r.End.Equal(want) {
+		t.Errorf("Align() end = %s, want %s", r.End, want)
+	}
+	if got := r.Points(); got != 14 {
+		t.Errorf("Points() = %d, want 14", got)
+	}
+
+	unaligned := &Range{Start: r.Start.Add(time.Second), End: r.End}
+	unaligned.Align()
+	if !unaligned.Start.Equal(r.Start.Add(time.Second)) {
+		t.Errorf("Align() without a step moved the start to %s", unaligned.Start)
+	}
+}
+
+func TestParams(t *testing.T) {
+	r := &Range{Start: time.Unix(1706700000, 0), End: time.Unix(1706703600, 0), Step: 1500 * time.Millisecond}
+	want := url.Values{"start": {"1706700000"}, "end": {"1706703600"}, "step": {"1.5"}}
+	if got := r.Params(); got.Encode() != want.Encode() {
+		t.Errorf("Params() = %s, want %s", got.Encode(), want.Encode())
+	}
+}
@@ -0,0 +1,153 @@
+package timerange
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// epochMillisThreshold separates epoch seconds from epoch milliseconds. As
+// seconds it is more than 3000 years away, so larger values are treated as
+// milliseconds.
+const epochMillisThreshold = 1e11
+
+// ParseTime parses an absolute or relative time. Supported forms are Grafana's
+// date math (now, now-6h, now/d, now-1d/d), RFC3339 timestamps and epoch
+// seconds or milliseconds. When roundUp is set, date math rounding moves to
+// the end of the unit instead of the start, as Grafana does for the end of a
+// range.
+func ParseTime(s string, now time.Time, roundUp bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("empty time")
+	}
+	if strings.HasPrefix(s, "now") {
+		return parseDateMath(s[len("now"):], now, roundUp)
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			return time.Time{}, fmt.Errorf("not a valid epoch time: %s", s)
+		}
+		if f > epochMillisThreshold {
+			return time.UnixMilli(int64(f)).UTC(), nil
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("not a valid time: %s, expected now-<duration>, RFC3339 or epoch seconds/milliseconds", s)
+}
+
+// FormatTime formats t as epoch seconds, keeping millisecond precision.
+func FormatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
+
+func parseDateMath(expr string, now time.Time, roundUp bool) (time.Time, error) {
+	t := now.UTC()
+	for i := 0; i < len(expr); {
+		op := expr[i]
+		i++
+		switch op {
+		case '/':
+			if i >= len(expr) {
+				return time.Time{}, fmt.Errorf("missing unit after / in now%s", expr)
+			}
+			unit := expr[i]
+			i++
+			var err error
+			if t, err = roundTime(t, unit, roundUp); err != nil {
+				return time.Time{}, err
+			}
+		case '+', '-':
+			j := i
+			for j < len(expr) && '0' <= expr[j] && expr[j] <= '9' {
+				j++
+			}
+			n := 1
+			if j > i {
+				var err error
+				if n, err = strconv.Atoi(expr[i:j]); err != nil {
+					return time.Time{}, fmt.Errorf("number out of range in now%s", expr)
+				}
+			}
+			if j >= len(expr) {
+				return time.Time{}, fmt.Errorf("missing unit in now%s", expr)
+			}
+			if op == '-' {
+				n = -n
+			}
+			var err error
+			if t, err = addUnits(t, n, expr[j]); err != nil {
+				return time.Time{}, err
+			}
+			i = j + 1
+		default:
+			return time.Time{}, fmt.Errorf("unexpected %q in now%s", op, expr)
+		}
+	}
+	return t, nil
+}
+
+// maxDateMath bounds the offsets of date math, so that they cannot overflow a
+// time.Duration.
+const maxDateMath = 250 * Year
+
+// unitLengths are the lengths of the units of date math, months counting 31 days.
+var unitLengths = map[byte]time.Duration{'y': Year, 'M': 31 * Day, 'w': Week, 'd': Day, 'h': time.Hour, 'm': time.Minute, 's': time.Second}
+
+func addUnits(t time.Time, n int, unit byte) (time.Time, error) {
+	if length, ok := unitLengths[unit]; ok && (n > int(maxDateMath/length) || n < -int(maxDateMath/length)) {
+		return time.Time{}, fmt.Errorf("offset of %d%c out of range", n, unit)
+	}
+	switch unit {
+	case 'y':
+		return t.AddDate(n, 0, 0), nil
+	case 'M':
+		return t.AddDate(0, n, 0), nil
+	case 'w':
+		return t.AddDate(0, 0, 7*n), nil
+	case 'd':
+		return t.AddDate(0, 0, n), nil
+	case 'h':
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown time unit %q", unit)
+}
+
+func roundTime(t time.Time, unit byte, roundUp bool) (time.Time, error) {
+	var start time.Time
+	switch unit {
+	case 'y':
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	case 'M':
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case 'w':
+		// weeks start on Monday, as in Grafana's default locale
+		offset := (int(t.Weekday()) + 6) % 7
+		start = time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+	case 'd':
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case 'h':
+		start = t.Truncate(time.Hour)
+	case 'm':
+		start = t.Truncate(time.Minute)
+	case 's':
+		start = t.Truncate(time.Second)
+	default:
+		return time.Time{}, fmt.Errorf("unknown time unit %q", unit)
+	}
+	if !roundUp {
+		return start, nil
+	}
+	end, _ := addUnits(start, 1, unit)
+	return end.Add(-time.Millisecond), nil
+}
@@ -0,0 +1,76 @@
+package timerange
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2024, 1, 31, 13, 45, 30, 0, time.UTC)
+	tests := []struct {
+		s       string
+		roundUp bool
+		want    time.Time
+		wantErr bool
+	}{
+		{s: "now", want: now},
+		{s: " now ", want: now},
+		{s: "now-6h", want: now.Add(-6 * time.Hour)},
+		{s: "now+30m", want: now.Add(30 * time.Minute)},
+		{s: "now-1M", want: time.Date(2023, 12, 31, 13, 45, 30, 0, time.UTC)},
+		{s: "now-1y-2d", want: time.Date(2023, 1, 29, 13, 45, 30, 0, time.UTC)},
+		{s: "now-h", want: now.Add(-time.Hour)},
+		{s: "now/d", want: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{s: "now/d", roundUp: true, want: time.Date(2024, 1, 31, 23, 59, 59, 999e6, time.UTC)},
+		{s: "now-1d/d", want: time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC)},
+		{s: "now/w", want: time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC)},
+		{s: "now/M", roundUp: true, want: time.Date(2024, 1, 31, 23, 59, 59, 999e6, time.UTC)},
+		{s: "now/y", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{s: "1706708730", want: time.Unix(1706708730, 0).UTC()},
+		{s: "1706708730.5", want: time.Unix(1706708730, 5e8).UTC()},
+		{s: "1706708730500", want: time.UnixMilli(1706708730500).UTC()},
+		{s: "2024-01-31T13:45:30Z", want: now},
+		{s: "2024-01-31T14:45:30+01:00", want: now},
+		{s: "", wantErr: true},
+		{s: "now-", wantErr: true},
+		{s: "now-6", wantErr: true},
+		{s: "now-6x", wantErr: true},
+		{s: "now/", wantErr: true},
+		{s: "now/x", wantErr: true},
+		{s: "now*2", wantErr: true},
+		{s: "now-99999999999999999999d", wantErr: true},
+		{s: "now-999999999999h", wantErr: true},
+		{s: "now+300y", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "NaN", wantErr: true},
+		{s: "Inf", wantErr: true},
+		{s: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.s, now, tt.roundUp)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseTime(%q) = %s, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q, %t) = %s, %v, want %s", tt.s, tt.roundUp, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	tests := map[time.Time]string{
+		time.Unix(1706708730, 0):   "1706708730",
+		time.Unix(1706708730, 5e8): "1706708730.5",
+		time.Unix(1706708730, 1e6): "1706708730.001",
+		time.Unix(1706708730, 1e5): "1706708730",
+	}
+	for tm, want := range tests {
+		if got := FormatTime(tm); got != want {
+			t.Errorf("FormatTime(%s) = %s, want %s", tm, got, want)
+		}
+	}
+}
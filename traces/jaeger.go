@@ -0,0 +1,154 @@
+package traces
+
+import (
+	"encoding/json"
+	"fmt"
+	"proxy-api-server/models"
+	"strings"
+	"time"
+)
+
+// jaegerResponse is the response of the Jaeger query API for both a trace
+// lookup and a trace search.
+type jaegerResponse struct {
+	Data   []jaegerTrace `json:"data"`
+	Errors []struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	} `json:"errors"`
+}
+
+type jaegerTrace struct {
+	TraceID   string       `json:"traceID"`
+	Spans     []jaegerSpan `json:"spans"`
+	Processes map[string]struct {
+		ServiceName string           `json:"serviceName"`
+		Tags        []jaegerKeyValue `json:"tags"`
+	} `json:"processes"`
+}
+
+type jaegerSpan struct {
+	TraceID       string `json:"traceID"`
+	SpanID        string `json:"spanID"`
+	OperationName string `json:"operationName"`
+	References    []struct {
+		RefType string `json:"refType"`
+		TraceID string `json:"traceID"`
+		SpanID  string `json:"spanID"`
+	} `json:"references"`
+	// StartTime and Duration are in microseconds
+	StartTime int64            `json:"startTime"`
+	Duration  int64            `json:"duration"`
+	Tags      []jaegerKeyValue `json:"tags"`
+	Logs      []struct {
+		Timestamp int64            `json:"timestamp"`
+		Fields    []jaegerKeyValue `json:"fields"`
+	} `json:"logs"`
+	ProcessID string `json:"processID"`
+}
+
+type jaegerKeyValue struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// DecodeJaegerTrace converts a Jaeger trace response into a span tree.
+func DecodeJaegerTrace(traceID string, data []byte) (*models.Trace, error) {
+	traces, err := decodeJaeger(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(traces) == 0 {
+		return BuildTree(strings.ToLower(traceID), nil), nil
+	}
+	return jaegerTree(traces[0]), nil
+}
+
+// DecodeJaegerSearch converts a Jaeger search response into trace summaries.
+// Jaeger returns complete traces, the summary is taken from their root span.
+func DecodeJaegerSearch(data []byte) ([]*models.TraceSummary, error) {
+	traces, err := decodeJaeger(data)
+	if err != nil {
+		return nil, err
+	}
+	summaries := []*models.TraceSummary{}
+	for _, t := range traces {
+		summaries = append(summaries, Summarize(jaegerTree(t)))
+	}
+	return summaries, nil
+}
+
+func decodeJaeger(data []byte) ([]jaegerTrace, error) {
+	resp := jaegerResponse{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode jaeger response: %v", err)
+	}
+	if len(resp.Errors) > 0 && len(resp.Data) == 0 {
+		return nil, fmt.Errorf("jaeger query failed: %s", resp.Errors[0].Msg)
+	}
+	return resp.Data, nil
+}
+
+func jaegerTree(t jaegerTrace) *models.Trace {
+	spans := []*models.Span{}
+	for _, s := range t.Spans {
+		process := t.Processes[s.ProcessID]
+		start := time.UnixMicro(s.StartTime).UTC()
+		span := &models.Span{
+			SpanID:             s.SpanID,
+			Name:               s.OperationName,
+			ServiceName:        process.ServiceName,
+			Status:             "unset",
+			StartTime:          start,
+			DurationMs:         float64(s.Duration) / 1000,
+			Attributes:         jaegerAttributes(s.Tags),
+			ResourceAttributes: jaegerAttributes(process.Tags),
+		}
+		for _, ref := range s.References {
+			if ref.RefType == "CHILD_OF" || span.ParentSpanID == "" {
+				span.ParentSpanID = ref.SpanID
+			}
+		}
+		// Jaeger keeps the OpenTelemetry span kind and status in tags
+		if kind, ok := span.Attributes["span.kind"].(string); ok {
+			span.Kind = kind
+			delete(span.Attributes, "span.kind")
+		}
+		if code, ok := span.Attributes["otel.status_code"].(string); ok {
+			span.Status = strings.ToLower(code)
+			delete(span.Attributes, "otel.status_code")
+		}
+		if msg, ok := span.Attributes["otel.status_description"].(string); ok {
+			span.StatusMessage = msg
+			delete(span.Attributes, "otel.status_description")
+		}
+		if isErr, ok := span.Attributes["error"].(bool); ok && isErr {
+			span.Status = "error"
+		}
+		for _, l := range s.Logs {
+			event := &models.SpanEvent{
+				Time:       time.UnixMicro(l.Timestamp).UTC(),
+				Attributes: jaegerAttributes(l.Fields),
+			}
+			if name, ok := event.Attributes["event"].(string); ok {
+				event.Name = name
+				delete(event.Attributes, "event")
+			}
+			span.Events = append(span.Events, event)
+		}
+		spans = append(spans, span)
+	}
+	return BuildTree(t.TraceID, spans)
+}
+
+func jaegerAttributes(kvs []jaegerKeyValue) map[string]interface{} {
+	if len(kvs) == 0 {
+		return nil
+	}
+	attrs := map[string]interface{}{}
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
@@ -0,0 +1,74 @@
+package traces
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseTags parses span tags given in logfmt, such as
+// `http.status_code=500 error=true db.statement="select 1"`, the format
+// Grafana uses for trace search tags.
+func ParseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	s = strings.TrimSpace(s)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 || strings.ContainsAny(s[:eq], " \t\"") {
+			return nil, fmt.Errorf("invalid tags %q, expected key=value pairs separated by spaces", s)
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := closingQuote(s)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted value of tag %q", key)
+			}
+			unquoted, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value of tag %q: %v", key, err)
+			}
+			value, s = unquoted, s[end+1:]
+		} else if i := strings.IndexAny(s, " \t"); i >= 0 {
+			value, s = s[:i], s[i:]
+		} else {
+			value, s = s, ""
+		}
+		tags[key] = value
+		s = strings.TrimLeft(s, " \t")
+	}
+	return tags, nil
+}
+
+// FormatTags formats tags in logfmt, sorted by key.
+func FormatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := tags[k]
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// closingQuote returns the index of the quote closing the quoted string s starts with.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
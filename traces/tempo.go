@@ -0,0 +1,234 @@
+package traces
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"proxy-api-server/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tempo returns traces in the OTLP JSON format. Older versions name the
+// resource spans "batches" and the scope spans "instrumentationLibrarySpans".
+type tempoTrace struct {
+	Batches       []tempoResourceSpans `json:"batches"`
+	ResourceSpans []tempoResourceSpans `json:"resourceSpans"`
+	Trace         *struct {
+		ResourceSpans []tempoResourceSpans `json:"resourceSpans"`
+	} `json:"trace"`
+}
+
+type tempoResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans                  []tempoScopeSpans `json:"scopeSpans"`
+	InstrumentationLibrarySpans []tempoScopeSpans `json:"instrumentationLibrarySpans"`
+}
+
+type tempoScopeSpans struct {
+	Spans []tempoSpan `json:"spans"`
+}
+
+type tempoSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId"`
+	Name              string          `json:"name"`
+	Kind              json.RawMessage `json:"kind"`
+	StartTimeUnixNano json.Number     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   json.Number     `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue  `json:"attributes"`
+	Status            struct {
+		Code    json.RawMessage `json:"code"`
+		Message string          `json:"message"`
+	} `json:"status"`
+	Events []struct {
+		TimeUnixNano json.Number    `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes"`
+	} `json:"events"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string     `json:"stringValue"`
+	IntValue    json.Number `json:"intValue"`
+	DoubleValue *float64    `json:"doubleValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	ArrayValue  *struct {
+		Values []otlpValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+}
+
+type tempoSearchResponse struct {
+	Traces []struct {
+		TraceID           string      `json:"traceID"`
+		RootServiceName   string      `json:"rootServiceName"`
+		RootTraceName     string      `json:"rootTraceName"`
+		StartTimeUnixNano json.Number `json:"startTimeUnixNano"`
+		DurationMs        float64     `json:"durationMs"`
+	} `json:"traces"`
+}
+
+var otlpSpanKinds = map[string]string{
+	"1": "internal", "SPAN_KIND_INTERNAL": "internal",
+	"2": "server", "SPAN_KIND_SERVER": "server",
+	"3": "client", "SPAN_KIND_CLIENT": "client",
+	"4": "producer", "SPAN_KIND_PRODUCER": "producer",
+	"5": "consumer", "SPAN_KIND_CONSUMER": "consumer",
+}
+
+var otlpStatusCodes = map[string]string{
+	"0": "unset", "STATUS_CODE_UNSET": "unset",
+	"1": "ok", "STATUS_CODE_OK": "ok",
+	"2": "error", "STATUS_CODE_ERROR": "error",
+}
+
+// DecodeTempoTrace converts a Tempo trace response into a span tree.
+func DecodeTempoTrace(traceID string, data []byte) (*models.Trace, error) {
+	resp := tempoTrace{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode tempo trace: %v", err)
+	}
+	batches := resp.Batches
+	if len(batches) == 0 {
+		batches = resp.ResourceSpans
+	}
+	if len(batches) == 0 && resp.Trace != nil {
+		batches = resp.Trace.ResourceSpans
+	}
+
+	spans := []*models.Span{}
+	for _, batch := range batches {
+		resource := otlpAttributes(batch.Resource.Attributes)
+		serviceName, _ := resource["service.name"].(string)
+		for _, scope := range append(batch.ScopeSpans, batch.InstrumentationLibrarySpans...) {
+			for _, s := range scope.Spans {
+				start := unixNano(s.StartTimeUnixNano)
+				span := &models.Span{
+					SpanID:             otlpID(s.SpanID),
+					ParentSpanID:       otlpID(s.ParentSpanID),
+					Name:               s.Name,
+					ServiceName:        serviceName,
+					Kind:               otlpSpanKinds[enumValue(s.Kind)],
+					Status:             otlpStatusCodes[enumValue(s.Status.Code)],
+					StatusMessage:      s.Status.Message,
+					StartTime:          start,
+					DurationMs:         durationMs(unixNano(s.EndTimeUnixNano).Sub(start)),
+					Attributes:         otlpAttributes(s.Attributes),
+					ResourceAttributes: resource,
+				}
+				if span.Status == "" {
+					span.Status = "unset"
+				}
+				for _, e := range s.Events {
+					span.Events = append(span.Events, &models.SpanEvent{
+						Time:       unixNano(e.TimeUnixNano),
+						Name:       e.Name,
+						Attributes: otlpAttributes(e.Attributes),
+					})
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+	return BuildTree(otlpID(traceID), spans), nil
+}
+
+// DecodeTempoSearch converts a Tempo search response into trace summaries.
+func DecodeTempoSearch(data []byte) ([]*models.TraceSummary, error) {
+	resp := tempoSearchResponse{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode tempo search response: %v", err)
+	}
+	summaries := []*models.TraceSummary{}
+	for _, t := range resp.Traces {
+		summaries = append(summaries, &models.TraceSummary{
+			TraceID:         otlpID(t.TraceID),
+			RootServiceName: t.RootServiceName,
+			RootName:        t.RootTraceName,
+			StartTime:       unixNano(t.StartTimeUnixNano),
+			DurationMs:      t.DurationMs,
+		})
+	}
+	return summaries, nil
+}
+
+// otlpID returns a trace or span id as lowercase hex. OTLP JSON encodes ids
+// as hex, but Tempo versions marshalling the protobuf directly send base64.
+func otlpID(id string) string {
+	if id == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(id); err == nil && (len(id) == 16 || len(id) == 32) {
+		return strings.ToLower(id)
+	}
+	if b, err := base64.StdEncoding.DecodeString(id); err == nil && (len(b) == 8 || len(b) == 16) {
+		return hex.EncodeToString(b)
+	}
+	return strings.ToLower(id)
+}
+
+// enumValue returns a protobuf enum sent either as its name or as its number.
+func enumValue(raw json.RawMessage) string {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return name
+	}
+	return string(raw)
+}
+
+func unixNano(n json.Number) time.Time {
+	ns, err := strconv.ParseInt(n.String(), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+func otlpAttributes(kvs []otlpKeyValue) map[string]interface{} {
+	if len(kvs) == 0 {
+		return nil
+	}
+	attrs := map[string]interface{}{}
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value.value()
+	}
+	return attrs
+}
+
+func (v otlpValue) value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != "":
+		if i, err := v.IntValue.Int64(); err == nil {
+			return i
+		}
+		return v.IntValue.String()
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.ArrayValue != nil:
+		values := []interface{}{}
+		for _, item := range v.ArrayValue.Values {
+			values = append(values, item.value())
+		}
+		return values
+	case v.KvlistValue != nil:
+		return otlpAttributes(v.KvlistValue.Values)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package traces
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"proxy-api-server/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkoutTrace is the outline of the trace of the testdata fixtures: a
+// server span with a failed client child, and a span whose parent is missing.
+const checkoutTrace = `frontend "GET /checkout" server ok 2023-11-14T22:13:20Z 100ms
+  checkout "SELECT" client error "connection reset" 2023-11-14T22:13:20.01Z 30ms events [exception]
+checkout "retry"  unset 2023-11-14T22:13:20.05Z 10ms
+`
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// outline prints the span tree of a trace, one span per line.
+func outline(spans []*models.Span, depth int) string {
+	var b strings.Builder
+	for _, s := range spans {
+		fmt.Fprintf(&b, "%s%s %q %s %s", strings.Repeat("  ", depth), s.ServiceName, s.Name, s.Kind, s.Status)
+		if s.StatusMessage != "" {
+			fmt.Fprintf(&b, " %q", s.StatusMessage)
+		}
+		fmt.Fprintf(&b, " %s %gms", s.StartTime.Format(time.RFC3339Nano), s.DurationMs)
+		if len(s.Events) > 0 {
+			names := []string{}
+			for _, e := range s.Events {
+				names = append(names, e.Name)
+			}
+			fmt.Fprintf(&b, " events %v", names)
+		}
+		b.WriteString("\n")
+		b.WriteString(outline(s.Children, depth+1))
+	}
+	return b.String()
+}
+
+func TestDecodeTrace(t *testing.T) {
+	tests := []struct {
+		fixture string
+		decode  func(string, []byte) (*models.Trace, error)
+		traceID string
+		// rootAttributes are the attributes of the server span
+		rootAttributes map[string]interface{}
+	}{
+		{
+			fixture:        "tempo-trace.json",
+			decode:         DecodeTempoTrace,
+			traceID:        "0af7651916cd43dd8448eb211c80319c",
+			rootAttributes: map[string]interface{}{"http.status_code": int64(200), "http.route": "/checkout"},
+		},
+		{
+			fixture:        "tempo-trace-protobuf.json",
+			decode:         DecodeTempoTrace,
+			traceID:        "0af7651916cd43dd8448eb211c80319c",
+			rootAttributes: map[string]interface{}{"http.status_code": int64(200), "http.route": "/checkout"},
+		},
+		{
+			fixture:        "jaeger-trace.json",
+			decode:         DecodeJaegerTrace,
+			traceID:        "af7651916cd43dd8448eb211c80319c",
+			rootAttributes: map[string]interface{}{"http.status_code": float64(200), "http.route": "/checkout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			trace, err := tt.decode(tt.traceID, readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outline(trace.Roots, 0); got != checkoutTrace {
+				t.Errorf("span tree =\n%s\nwant\n%s", got, checkoutTrace)
+			}
+			if trace.TraceID != tt.traceID || trace.SpanCount != 3 || trace.DurationMs != 100 ||
+				trace.RootServiceName != "frontend" || trace.RootName != "GET /checkout" {
+				t.Errorf("trace = %s with %d spans of %gms, root %s %s, want %s with 3 spans of 100ms, root frontend GET /checkout",
+					trace.TraceID, trace.SpanCount, trace.DurationMs, trace.RootServiceName, trace.RootName, tt.traceID)
+			}
+			root := trace.Roots[0]
+			if !reflect.DeepEqual(root.Attributes, tt.rootAttributes) {
+				t.Errorf("attributes = %v, want %v", root.Attributes, tt.rootAttributes)
+			}
+			if root.ResourceAttributes["service.version"] != "1.2.0" {
+				t.Errorf("resource attributes = %v, want service.version 1.2.0", root.ResourceAttributes)
+			}
+			if escaped := root.Children[0].Events[0].Attributes["exception.escaped"]; escaped != true {
+				t.Errorf("event attribute exception.escaped = %v, want true", escaped)
+			}
+		})
+	}
+}
+
+func TestDecodeTempoTraceArrays(t *testing.T) {
+	trace, err := DecodeTempoTrace("0af7651916cd43dd8448eb211c80319c", readFixture(t, "tempo-trace.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{int64(1), 2.5}
+	if got := trace.Roots[0].Children[0].Attributes["db.rows"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("db.rows = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeTraceNotFound(t *testing.T) {
+	for name, decode := range map[string]func(string, []byte) (*models.Trace, error){
+		"tempo":  DecodeTempoTrace,
+		"jaeger": DecodeJaegerTrace,
+	} {
+		trace, err := decode("0AF7", []byte(`{"batches":[],"data":[]}`))
+		if err != nil || trace.SpanCount != 0 || trace.TraceID != "0af7" {
+			t.Errorf("%s: decoding an empty trace = %+v, %v, want trace 0af7 without spans", name, trace, err)
+		}
+	}
+}
+
+func TestDecodeJaegerErrors(t *testing.T) {
+	_, err := DecodeJaegerTrace("1", []byte(`{"data":null,"errors":[{"code":500,"msg":"storage unavailable"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "storage unavailable") {
+		t.Errorf("DecodeJaegerTrace() error = %v, want the error of Jaeger", err)
+	}
+}
+
+func TestDecodeSearch(t *testing.T) {
+	tests := []struct {
+		fixture string
+		decode  func([]byte) ([]*models.TraceSummary, error)
+		want    []string
+	}{
+		{
+			fixture: "tempo-search.json",
+			decode:  DecodeTempoSearch,
+			want: []string{
+				`0af7651916cd43dd8448eb211c80319c frontend "GET /checkout" 2023-11-14T22:13:20Z 100ms`,
+				`0af7651916cd43dd8448eb211c80319d frontend "GET /cart" 2023-11-14T22:14:20Z 12ms`,
+			},
+		},
+		{
+			fixture: "jaeger-search.json",
+			decode:  DecodeJaegerSearch,
+			// the duration covers the child span ending after its parent
+			want: []string{`1 frontend "GET /cart" 2023-11-14T22:14:20Z 16ms`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			summaries, err := tt.decode(readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, s := range summaries {
+				got = append(got, fmt.Sprintf("%s %s %q %s %gms", s.TraceID, s.RootServiceName, s.RootName, s.StartTime.Format(time.RFC3339Nano), s.DurationMs))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summaries =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		tags    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{tags: "", want: map[string]string{}},
+		{tags: `http.status_code=500  error=true`, want: map[string]string{"http.status_code": "500", "error": "true"}},
+		{tags: `db.statement="select \"a\" from b" x=`, want: map[string]string{"db.statement": `select "a" from b`, "x": ""}},
+		{tags: `error`, wantErr: true},
+		{tags: `"a"=b`, wantErr: true},
+		{tags: `a="b`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTags(tt.tags)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseTags(%q) = %v, want an error", tt.tags, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTags(%q) = %v, %v, want %v", tt.tags, got, err, tt.want)
+			continue
+		}
+		// formatted tags parse back to the same tags
+		if again, err := ParseTags(FormatTags(got)); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("ParseTags(FormatTags(%v)) = %v, %v", got, again, err)
+		}
+	}
+}
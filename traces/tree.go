@@ -0,0 +1,94 @@
+package traces
+
+import (
+	"proxy-api-server/models"
+	"sort"
+	"time"
+)
+
+// BuildTree links the spans of a trace to their parents and returns the
+// trace. Spans whose parent is missing from the trace, which happens with
+// partially ingested traces, become additional roots. Children are sorted by
+// start time.
+func BuildTree(traceID string, spans []*models.Span) *models.Trace {
+	trace := &models.Trace{
+		TraceID:   traceID,
+		SpanCount: len(spans),
+		Roots:     []*models.Span{},
+	}
+	if len(spans) == 0 {
+		return trace
+	}
+
+	byID := map[string]*models.Span{}
+	for _, s := range spans {
+		s.Children = []*models.Span{}
+		byID[s.SpanID] = s
+	}
+	for _, s := range spans {
+		parent, ok := byID[s.ParentSpanID]
+		if s.ParentSpanID == "" || !ok || parent == s || isAncestor(s, parent, byID) {
+			trace.Roots = append(trace.Roots, s)
+			continue
+		}
+		parent.Children = append(parent.Children, s)
+	}
+	sortSpans(trace.Roots)
+
+	start, end := spans[0].StartTime, spanEnd(spans[0])
+	for _, s := range spans[1:] {
+		if s.StartTime.Before(start) {
+			start = s.StartTime
+		}
+		if e := spanEnd(s); e.After(end) {
+			end = e
+		}
+	}
+	trace.StartTime = start
+	trace.DurationMs = durationMs(end.Sub(start))
+	trace.RootServiceName = trace.Roots[0].ServiceName
+	trace.RootName = trace.Roots[0].Name
+	return trace
+}
+
+// Summarize returns the search result describing a trace.
+func Summarize(trace *models.Trace) *models.TraceSummary {
+	return &models.TraceSummary{
+		TraceID:         trace.TraceID,
+		RootServiceName: trace.RootServiceName,
+		RootName:        trace.RootName,
+		StartTime:       trace.StartTime,
+		DurationMs:      trace.DurationMs,
+	}
+}
+
+// isAncestor reports whether s is an ancestor of span, which would make
+// linking span to s a cycle.
+func isAncestor(s, span *models.Span, byID map[string]*models.Span) bool {
+	seen := map[*models.Span]bool{}
+	for span != nil && !seen[span] {
+		if span.ParentSpanID == s.SpanID {
+			return true
+		}
+		seen[span] = true
+		span = byID[span.ParentSpanID]
+	}
+	return false
+}
+
+func sortSpans(spans []*models.Span) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].StartTime.Before(spans[j].StartTime)
+	})
+	for _, s := range spans {
+		sortSpans(s.Children)
+	}
+}
+
+func spanEnd(s *models.Span) time.Time {
+	return s.StartTime.Add(time.Duration(s.DurationMs * float64(time.Millisecond)))
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
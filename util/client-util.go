@@ -1,7 +1,6 @@
 package util
 
 import (
-	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
@@ -47,7 +46,7 @@ func HandleHttpRequest(httpMethod string, url string, apiKey string, payload io.
 	}
 	if httpResponse.StatusCode != http.StatusOK && httpResponse.StatusCode != http.StatusCreated {
 		logrus.Errorf("Unable to get data from URL: %s due to status code: %d", url, httpResponse.StatusCode)
-		return nil, httpResponse.StatusCode, fmt.Errorf("unable to fetch data from url: %s", url)
+		return nil, httpResponse.StatusCode, models.NewUpstreamError(httpResponse.StatusCode, httpRequest.URL.Path, data)
 	}
 	return data, httpResponse.StatusCode, nil
 }
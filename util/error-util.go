@@ -1,18 +1,240 @@
 package util
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"proxy-api-server/limits"
 	"proxy-api-server/log"
+	"proxy-api-server/models"
+	"proxy-api-server/promql"
+	"proxy-api-server/requestid"
+	"proxy-api-server/tenancy"
+	"proxy-api-server/timerange"
+	"proxy-api-server/variable"
+	"regexp"
+	"strconv"
 )
 
-func CommonError(err error) error {
+// ErrorCode identifies the kind of an error in error responses.
+type ErrorCode string
+
+const (
+	CodeBadRequest          ErrorCode = "bad_request"
+	CodeMissingParameter    ErrorCode = "missing_parameter"
+	CodeInvalidParameter    ErrorCode = "invalid_parameter"
+	CodeInvalidQuery        ErrorCode = "invalid_query"
+	CodeValidationFailed    ErrorCode = "validation_failed"
+	CodeForbidden           ErrorCode = "forbidden"
+	CodeNotFound            ErrorCode = "not_found"
+	CodeConflict            ErrorCode = "conflict"
+	CodeLimitExceeded       ErrorCode = "limit_exceeded"
+	CodeUpstreamError       ErrorCode = "upstream_error"
+	CodeUpstreamUnavailable ErrorCode = "upstream_unavailable"
+	CodeTimeout             ErrorCode = "timeout"
+	CodeInternal            ErrorCode = "internal_error"
+)
+
+// ErrorCatalogEntry documents an error code and the status it is returned with.
+type ErrorCatalogEntry struct {
+	Code        ErrorCode `json:"code"`
+	Status      int       `json:"status"`
+	Description string    `json:"description"`
+}
+
+// ErrorCatalog lists every error code of the API. Upstream errors the caller
+// can fix, such as a rejected credential, keep the status of the upstream.
+var ErrorCatalog = []ErrorCatalogEntry{
+	{CodeBadRequest, http.StatusBadRequest, "The request body or parameters are malformed"},
+	{CodeMissingParameter, http.StatusBadRequest, "A required parameter is missing"},
+	{CodeInvalidParameter, http.StatusBadRequest, "A parameter has an invalid value"},
+	{CodeInvalidQuery, http.StatusBadRequest, "The query does not parse; details hold its position"},
+	{CodeValidationFailed, http.StatusBadRequest, "The request does not match the specification of the route; details list the invalid fields"},
+	{CodeForbidden, http.StatusForbidden, "The caller may not run the request, such as a query outside of its tenant"},
+	{CodeNotFound, http.StatusNotFound, "The requested resource does not exist"},
+	{CodeConflict, http.StatusConflict, "The resource was changed or already exists"},
+	{CodeLimitExceeded, http.StatusUnprocessableEntity, "The query exceeds a configured limit; details hold the limit"},
+	{CodeUpstreamError, http.StatusBadGateway, "Grafana or the backend failed the request; upstream holds its status and message"},
+	{CodeUpstreamUnavailable, http.StatusBadGateway, "Grafana or the backend could not be reached"},
+	{CodeTimeout, http.StatusGatewayTimeout, "The request timed out"},
+	{CodeInternal, http.StatusInternalServerError, "An unexpected error"},
+}
+
+// StatusOf returns the status of an error code in the catalog.
+func StatusOf(code ErrorCode) int {
+	for _, e := range ErrorCatalog {
+		if e.Code == code {
+			return e.Status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// APIError is an error with a code of the ErrorCatalog, as returned to the caller.
+type APIError struct {
+	Code     ErrorCode
+	Status   int
+	Message  string
+	Details  interface{}
+	Upstream *models.UpstreamError
+	// Err is the cause of the error, if any
+	Err error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns an error of the given code with the status of the catalog.
+func NewError(code ErrorCode, format string, args ...interface{}) *APIError {
+	return &APIError{Code: code, Status: StatusOf(code), Message: fmt.Sprintf(format, args...)}
+}
+
+// BadRequestError is returned for a malformed request.
+func BadRequestError(format string, args ...interface{}) *APIError {
+	return NewError(CodeBadRequest, format, args...)
+}
+
+// MissingParameterError is returned when a required parameter is not set.
+func MissingParameterError(param, message string) *APIError {
+	e := NewError(CodeMissingParameter, "%s", message)
+	e.Details = map[string]string{"parameter": param}
+	return e
+}
+
+// InvalidParameterError is returned when a parameter is set to an invalid value.
+func InvalidParameterError(param, value, format string, args ...interface{}) *APIError {
+	e := NewError(CodeInvalidParameter, "invalid %s %q: %s", param, value, fmt.Sprintf(format, args...))
+	e.Details = map[string]string{"parameter": param, "value": value}
+	return e
+}
+
+// FieldError is a parameter or body field of a request that does not match
+// the specification of its route.
+type FieldError struct {
+	Field  string `json:"field"`
+	In     string `json:"in"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError is returned when fields of a request are invalid.
+func ValidationError(fields []FieldError) *APIError {
+	message := fmt.Sprintf("invalid %s %s: %s", fields[0].In, fields[0].Field, fields[0].Reason)
+	if fields[0].Field == "" {
+		message = fmt.Sprintf("invalid %s: %s", fields[0].In, fields[0].Reason)
+	}
+	if len(fields) > 1 {
+		message += fmt.Sprintf(" (and %d more)", len(fields)-1)
+	}
+	e := NewError(CodeValidationFailed, "%s", message)
+	e.Details = map[string]interface{}{"fields": fields}
+	return e
+}
+
+// NotFoundError is returned when the requested resource does not exist.
+func NotFoundError(format string, args ...interface{}) *APIError {
+	return NewError(CodeNotFound, format, args...)
+}
+
+// sdkErrorRegex matches the errors of the grafana-tools sdk for error statuses.
+var sdkErrorRegex = regexp.MustCompile(`(?s)HTTP error (\d{3}): returns (.*)`)
+
+// UpstreamError logs an error of a call to Grafana and converts the errors
+// the grafana-tools sdk returns for error statuses to *models.UpstreamError.
+func UpstreamError(err error) error {
 	log.Error(err)
+	var upstreamErr *models.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return err
+	}
+	if m := sdkErrorRegex.FindStringSubmatch(err.Error()); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return models.NewUpstreamError(status, "", []byte(m[2]))
+	}
 	return err
 }
+
+// ResourceError is UpstreamError for the call reading a resource, such as a
+// dashboard by uid. A missing resource is reported as not found.
+func ResourceError(err error, resource, id string) error {
+	log.Errorf("Error: %s %s: %s", resource, id, err)
+	err = UpstreamError(err)
+	var upstreamErr *models.UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.Status == http.StatusNotFound {
+		notFound := NotFoundError("%s %q not found", resource, id)
+		notFound.Upstream, notFound.Err = upstreamErr, err
+		return notFound
+	}
+	return err
+}
+
 func Error(message string, err error) error {
 	log.Error("%s. Error: %s ", message, err)
 	return err
 }
-func DashboardError(err error, UID string) error {
-	log.Error("Error: UID:\n%s\n%s", UID, err)
-	return err
+
+// ToAPIError classifies an error returned by the handlers and the packages they use.
+func ToAPIError(err error) *APIError {
+	var apiErr *APIError
+	var upstreamErr *models.UpstreamError
+	var limitErr *limits.Error
+	var tenantErr *tenancy.Error
+	var paramErr *timerange.ParamError
+	var queryErr *variable.QueryError
+	var parseErr *promql.ParseError
+	var transportErr *url.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &limitErr):
+		return &APIError{Code: CodeLimitExceeded, Status: StatusOf(CodeLimitExceeded), Message: err.Error(), Details: limitErr, Err: err}
+	case errors.As(err, &tenantErr):
+		return &APIError{Code: CodeForbidden, Status: StatusOf(CodeForbidden), Message: err.Error(), Err: err}
+	case errors.As(err, &paramErr):
+		return &APIError{Code: CodeInvalidParameter, Status: StatusOf(CodeInvalidParameter), Message: err.Error(), Details: map[string]string{"parameter": paramErr.Param, "value": paramErr.Value}, Err: err}
+	case errors.As(err, &parseErr):
+		line, column := parseErr.LineAndColumn()
+		details := map[string]interface{}{"position": parseErr.PositionRange, "line": line, "column": column}
+		return &APIError{Code: CodeInvalidQuery, Status: StatusOf(CodeInvalidQuery), Message: err.Error(), Details: details, Err: err}
+	case errors.As(err, &queryErr):
+		return &APIError{Code: CodeInvalidQuery, Status: StatusOf(CodeInvalidQuery), Message: err.Error(), Err: err}
+	case errors.As(err, &upstreamErr):
+		code := CodeUpstreamError
+		if upstreamErr.HTTPStatus() == http.StatusGatewayTimeout {
+			code = CodeTimeout
+		}
+		return &APIError{Code: code, Status: upstreamErr.HTTPStatus(), Message: err.Error(), Upstream: upstreamErr, Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &APIError{Code: CodeTimeout, Status: StatusOf(CodeTimeout), Message: err.Error(), Err: err}
+	case errors.As(err, &transportErr):
+		return &APIError{Code: CodeUpstreamUnavailable, Status: StatusOf(CodeUpstreamUnavailable), Message: err.Error(), Err: err}
+	}
+	return &APIError{Code: CodeInternal, Status: StatusOf(CodeInternal), Message: err.Error(), Err: err}
+}
+
+// WriteError replies with the JSON error body of err, the same for every route.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := ToAPIError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Errorf("%s %s failed: %s", r.Method, r.URL.Path, err)
+	} else {
+		log.Debugf("%s %s rejected: %s", r.Method, r.URL.Path, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	_ = json.NewEncoder(w).Encode(&models.ErrorResponse{Error: &models.ErrorDetail{
+		Code:      string(apiErr.Code),
+		Status:    apiErr.Status,
+		Message:   apiErr.Message,
+		Details:   apiErr.Details,
+		RequestID: requestid.FromContext(r.Context()),
+		Upstream:  apiErr.Upstream,
+	}})
 }
@@ -0,0 +1,177 @@
+package variable
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SortOrder values match the "sort" setting of a Grafana query variable.
+type SortOrder int
+
+const (
+	SortDisabled SortOrder = iota
+	SortAlphabeticalAsc
+	SortAlphabeticalDesc
+	SortNumericalAsc
+	SortNumericalDesc
+	SortAlphabeticalCaseInsensitiveAsc
+	SortAlphabeticalCaseInsensitiveDesc
+	SortNaturalAsc
+	SortNaturalDesc
+)
+
+var numberRegex = regexp.MustCompile(`^.*?(\d+).*`)
+
+// ParseSortOrder parses the numeric sort setting used by Grafana. An empty
+// value disables sorting.
+func ParseSortOrder(s string) (SortOrder, error) {
+	if s == "" {
+		return SortDisabled, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < int(SortDisabled) || n > int(SortNaturalDesc) {
+		return SortDisabled, fmt.Errorf("invalid sort %q, expected a number between 0 and 8", s)
+	}
+	return SortOrder(n), nil
+}
+
+// CompileRegex compiles a variable regex. Like Grafana it accepts both a
+// plain pattern and the /pattern/flags form; only the "i" flag is honoured.
+func CompileRegex(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(pattern, "/") {
+		if end := strings.LastIndex(pattern, "/"); end > 0 {
+			flags := pattern[end+1:]
+			pattern = pattern[1:end]
+			if strings.Contains(flags, "i") {
+				pattern = "(?i)" + pattern
+			}
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// Filter keeps the values matching re and removes duplicates. When re has a
+// capture group the first group becomes the value; a named group "value"
+// takes precedence.
+func Filter(values []string, re *regexp.Regexp) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, v := range values {
+		if re != nil {
+			m := re.FindStringSubmatch(v)
+			if m == nil {
+				continue
+			}
+			if i := re.SubexpIndex("value"); i > 0 && m[i] != "" {
+				v = m[i]
+			} else if len(m) > 1 && m[1] != "" {
+				v = m[1]
+			}
+		}
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// Match keeps the values matching re, unchanged, as Grafana does for the
+// regex of metrics(regex).
+func Match(values []string, re *regexp.Regexp) []string {
+	out := []string{}
+	for _, v := range values {
+		if re.MatchString(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Sort orders values in place following Grafana's variable sort settings.
+func Sort(values []string, order SortOrder) {
+	var less func(a, b string) bool
+	switch order {
+	case SortAlphabeticalAsc, SortAlphabeticalDesc:
+		less = func(a, b string) bool { return a < b }
+	case SortNumericalAsc, SortNumericalDesc:
+		less = func(a, b string) bool { return leadingNumber(a) < leadingNumber(b) }
+	case SortAlphabeticalCaseInsensitiveAsc, SortAlphabeticalCaseInsensitiveDesc:
+		less = func(a, b string) bool { return strings.ToLower(a) < strings.ToLower(b) }
+	case SortNaturalAsc, SortNaturalDesc:
+		less = naturalLess
+	default:
+		return
+	}
+	desc := order == SortAlphabeticalDesc || order == SortNumericalDesc ||
+		order == SortAlphabeticalCaseInsensitiveDesc || order == SortNaturalDesc
+	sort.SliceStable(values, func(i, j int) bool {
+		if desc {
+			return less(values[j], values[i])
+		}
+		return less(values[i], values[j])
+	})
+}
+
+// leadingNumber returns the first number found in s, or -1 when there is none.
+func leadingNumber(s string) int {
+	m := numberRegex.FindStringSubmatch(s)
+	if m == nil {
+		return -1
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// naturalLess compares strings treating runs of digits as numbers, so that
+// "pod-2" sorts before "pod-10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ca, cb := a[0], b[0]
+		if isDigit(ca) && isDigit(cb) {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			a, b = ra, rb
+			continue
+		}
+		la, lb := strings.ToLower(a[:1]), strings.ToLower(b[:1])
+		if la != lb {
+			return la < lb
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
@@ -0,0 +1,42 @@
+package variable
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	values := []string{"node_cpu_seconds_total", "node_memory_bytes", "up"}
+	got := Match(values, regexp.MustCompile(`node_(cpu|mem).*`))
+	want := []string{"node_cpu_seconds_total", "node_memory_bytes"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Match() = %v, want %v", got, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		regex  string
+		want   []string
+	}{
+		{"no regex", []string{"a", "b", "a"}, "", []string{"a", "b"}},
+		{"match", []string{"api-1", "db-1", "api-2"}, "api-.*", []string{"api-1", "api-2"}},
+		{"capture group", []string{"node_cpu", "node_mem", "up"}, "node_(cpu|mem)", []string{"cpu", "mem"}},
+		{"named group", []string{"a=1", "b=2"}, `(\w)=(?P<value>\d)`, []string{"1", "2"}},
+		{"flags", []string{"API", "db"}, "/api/i", []string{"API"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := CompileRegex(tt.regex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Filter(tt.values, re); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter(%v, %q) = %v, want %v", tt.values, tt.regex, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package variable
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Function is a Grafana Prometheus variable query function.
+type Function string
+
+const (
+	LabelNames  Function = "label_names"
+	LabelValues Function = "label_values"
+	Metrics     Function = "metrics"
+	QueryResult Function = "query_result"
+)
+
+// Query is a parsed Prometheus variable query such as label_values(up{job="api"}, instance).
+type Query struct {
+	Function Function
+	// Metric is the optional series selector of label_names and label_values.
+	Metric string
+	// Label is the label whose values are requested by label_values.
+	Label string
+	// Regex is the metric name filter of metrics(regex).
+	Regex string
+	// Expr is the PromQL expression evaluated by query_result.
+	Expr string
+}
+
+// QueryError is returned when a variable query, its regex or its sort setting
+// cannot be parsed or is not supported.
+type QueryError struct {
+	Query  string
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid variable query %q: %s", e.Query, e.Reason)
+}
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ParseQuery parses the variable query functions supported by Grafana's
+// Prometheus datasource: label_names(), label_values(label),
+// label_values(metric, label), metrics(regex) and query_result(query).
+func ParseQuery(query string) (*Query, error) {
+	query = strings.TrimSpace(query)
+	open := strings.Index(query, "(")
+	if open < 0 || !strings.HasSuffix(query, ")") {
+		return nil, &QueryError{Query: query, Reason: "expected a function call such as label_values(label)"}
+	}
+	name := Function(strings.TrimSpace(query[:open]))
+	body := query[open+1 : len(query)-1]
+	args, err := splitArgs(body)
+	if err != nil {
+		return nil, &QueryError{Query: query, Reason: err.Error()}
+	}
+
+	switch name {
+	case LabelNames:
+		if len(args) > 1 {
+			return nil, &QueryError{Query: query, Reason: "label_names accepts at most one series selector"}
+		}
+		q := &Query{Function: LabelNames}
+		if len(args) == 1 {
+			q.Metric = args[0]
+		}
+		return q, nil
+	case LabelValues:
+		if len(args) == 0 || len(args) > 2 {
+			return nil, &QueryError{Query: query, Reason: "label_values expects (label) or (metric, label)"}
+		}
+		q := &Query{Function: LabelValues, Label: args[len(args)-1]}
+		if len(args) == 2 {
+			q.Metric = args[0]
+		}
+		if !labelNameRegex.MatchString(q.Label) {
+			return nil, &QueryError{Query: query, Reason: fmt.Sprintf("invalid label name %q", q.Label)}
+		}
+		return q, nil
+	case Metrics:
+		if len(args) != 1 {
+			return nil, &QueryError{Query: query, Reason: "metrics expects a single regex argument"}
+		}
+		if _, err := regexp.Compile(args[0]); err != nil {
+			return nil, &QueryError{Query: query, Reason: fmt.Sprintf("invalid metrics regex: %s", err)}
+		}
+		return &Query{Function: Metrics, Regex: args[0]}, nil
+	case QueryResult:
+		expr := strings.TrimSpace(body)
+		if expr == "" {
+			return nil, &QueryError{Query: query, Reason: "query_result expects a PromQL expression"}
+		}
+		return &Query{Function: QueryResult, Expr: expr}, nil
+	}
+	return nil, &QueryError{Query: query, Reason: fmt.Sprintf("unknown function %q", name)}
+}
+
+// splitArgs splits a function body on top level commas, ignoring commas that
+// appear inside label matchers, nested calls or quoted strings.
+func splitArgs(body string) ([]string, error) {
+	args := []string{}
+	depth := 0
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range body {
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch r {
+		case '"', '\'', '`':
+			quote = r
+		case '(', '{', '[':
+			depth++
+		case ')', '}', ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced %q at offset %d", r, i)
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(body[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string")
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets")
+	}
+	if last := strings.TrimSpace(body[start:]); last != "" || len(args) > 0 {
+		args = append(args, last)
+	}
+	for _, a := range args {
+		if a == "" {
+			return nil, fmt.Errorf("empty argument")
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,96 @@
+package variable
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type promResponse struct {
+	Status string          `json:"status"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type promQueryData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type promSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+// DecodeValues decodes the string list returned by the Prometheus
+// labels and label values APIs.
+func DecodeValues(data []byte) ([]string, error) {
+	resp := promResponse{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode prometheus response: %v", err)
+	}
+	values := []string{}
+	if len(resp.Data) == 0 || string(resp.Data) == "null" {
+		return values, nil
+	}
+	if err := json.Unmarshal(resp.Data, &values); err != nil {
+		return nil, fmt.Errorf("unable to decode prometheus response data: %v", err)
+	}
+	return values, nil
+}
+
+// DecodeQueryResult turns an instant query response into variable values the
+// same way Grafana does for query_result: metric{labels} value timestamp_ms.
+func DecodeQueryResult(data []byte) ([]string, error) {
+	resp := promResponse{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode prometheus response: %v", err)
+	}
+	qd := promQueryData{}
+	if err := json.Unmarshal(resp.Data, &qd); err != nil {
+		return nil, fmt.Errorf("unable to decode prometheus response data: %v", err)
+	}
+	values := []string{}
+	switch qd.ResultType {
+	case "vector":
+		samples := []promSample{}
+		if err := json.Unmarshal(qd.Result, &samples); err != nil {
+			return nil, fmt.Errorf("unable to decode prometheus vector: %v", err)
+		}
+		for _, s := range samples {
+			values = append(values, formatSample(s.Metric, s.Value))
+		}
+	case "scalar", "string":
+		sample := []interface{}{}
+		if err := json.Unmarshal(qd.Result, &sample); err != nil {
+			return nil, fmt.Errorf("unable to decode prometheus %s: %v", qd.ResultType, err)
+		}
+		values = append(values, formatSample(nil, sample))
+	default:
+		return nil, fmt.Errorf("unsupported result type %q for query_result", qd.ResultType)
+	}
+	return values, nil
+}
+
+func formatSample(metric map[string]string, value []interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(metric["__name__"])
+	names := make([]string, 0, len(metric))
+	for name := range metric {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, fmt.Sprintf("%s=%q", name, metric[name]))
+	}
+	sb.WriteString("{" + strings.Join(labels, ", ") + "}")
+	if len(value) == 2 {
+		ts, _ := value[0].(float64)
+		sb.WriteString(fmt.Sprintf(" %v %s", value[1], strconv.FormatFloat(ts*1000, 'f', -1, 64)))
+	}
+	return sb.String()
+}